@@ -66,7 +66,7 @@ export CGO_ENABLED=0
 export GOOS="$GOOS"
 export GOARCH="$BUILD_ARCH"
 # 执行交叉编译
-go build -o "$BIN_DIR/$APP_NAME" ./cmd/api/main.go
+go build -o "$BIN_DIR/$APP_NAME" ./cmd/api
 
 if [ $? -ne 0 ]; then
     echo "Error: Build failed"
@@ -75,6 +75,16 @@ fi
 
 echo "✓ Binary built successfully: $BIN_DIR/$APP_NAME"
 
+echo "Building awctl for $GOOS-$ARCH..."
+go build -o "$BIN_DIR/awctl" ./cmd/awctl
+
+if [ $? -ne 0 ]; then
+    echo "Error: awctl build failed"
+    exit 1
+fi
+
+echo "✓ awctl built successfully: $BIN_DIR/awctl"
+
 # 复制配置文件示例
 echo "Copying configuration files..."
 cp -f conf/conf.yaml.example "$CONF_DIR/conf.yaml.example"
@@ -100,7 +110,7 @@ chmod +x "$BASE_DIR/install.sh"
 echo "✓ Installation script copied"
 
 # 设置执行权限
-chmod +x "$BIN_DIR/$APP_NAME"
+chmod +x "$BIN_DIR/$APP_NAME" "$BIN_DIR/awctl"
 
 echo "✓ Execution permissions set"
 
@@ -110,6 +120,7 @@ echo
 echo "=== Build Summary ==="
 echo "✓ Build completed successfully!"
 echo "Binary: $BIN_DIR/$APP_NAME"
+echo "CLI: $BIN_DIR/awctl"
 echo "Version: $VERSION"
 echo "Architecture: $ARCH"
 echo "Configuration: $CONF_DIR/conf.yaml"
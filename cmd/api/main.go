@@ -4,132 +4,191 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
+	"sort"
+	"strings"
 
-	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
-	"github.com/yuhai94/anywhere_backend/internal/api/handlers"
-	"github.com/yuhai94/anywhere_backend/internal/api/routes"
 	"github.com/yuhai94/anywhere_backend/internal/aws"
 	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/cost"
+	"github.com/yuhai94/anywhere_backend/internal/events"
 	"github.com/yuhai94/anywhere_backend/internal/logging"
 	"github.com/yuhai94/anywhere_backend/internal/repository"
-	"github.com/yuhai94/anywhere_backend/internal/scheduler"
 	"github.com/yuhai94/anywhere_backend/internal/service"
+	"github.com/yuhai94/anywhere_backend/internal/webhook"
 )
 
-func main() {
-	// Parse command line arguments
-	configPath := flag.String("config", "conf/conf.yaml", "Path to configuration file")
-	logDir := flag.String("log-dir", "./logs", "Path to log directory")
-	flag.Parse()
+// command 子命令定义
+type command struct {
+	usage   string
+	summary string
+	// flags 注册子命令特有的选项，可以为 nil
+	flags func(fs *flag.FlagSet)
+	// run 在配置和日志初始化完成后执行
+	run func(ctx context.Context, args []string) error
+	// standalone 为 true 时不加载配置也不初始化日志，例如管理保险库时配置本身可能还无法解析
+	standalone bool
+}
 
-	// Load configuration
-	fmt.Println("Loading configuration...")
-	fmt.Printf("Using config file: %s\n", *configPath)
-	if err := config.LoadConfig(*configPath); err != nil {
-		fmt.Printf("Failed to load config: %v\n", err)
-		os.Exit(1)
-	}
-	fmt.Println("Configuration loaded successfully")
+var commands = map[string]*command{
+	"serve":           serveCommand(),
+	"migrate":         migrateCommand(),
+	"sync-once":       syncOnceCommand(),
+	"validate-config": validateConfigCommand(),
+	"render-userdata": renderUserDataCommand(),
+	"relay-config":    relayConfigCommand(),
+	"gc":              gcCommand(),
+	"secrets":         secretsCommand(),
+}
 
-	// Initialize logging
-	fmt.Printf("Initializing logging... log dir: %s\n", *logDir)
-	if err := logging.Init(*logDir); err != nil {
-		fmt.Printf("Failed to initialize logging: %v\n", err)
-		os.Exit(1)
+func main() {
+	// 兼容旧的启动方式：没有子命令或直接以选项开头时启动服务
+	name := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
-	fmt.Println("Logging initialized successfully")
 
-	ctx := context.Background()
-	logging.Info(ctx, "Starting V2Ray backend service")
+	if name == "help" {
+		printUsage()
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		printUsage()
+		os.Exit(2)
+	}
 
-	// Connect to database
-	fmt.Println("Connecting to database...")
-	dsn := config.GetDSN()
-	fmt.Printf("Database DSN: %s\n", dsn)
-	db, err := sqlx.Connect("mysql", dsn)
-	if err != nil {
-		fmt.Printf("Failed to connect to database: %v\n", err)
+	if err := runCommand(name, cmd, args); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", name, logging.Redact(err.Error()))
 		os.Exit(1)
 	}
-	defer db.Close()
-	fmt.Println("Connected to database successfully")
-
-	// Initialize repository
-	repo := repository.New(db)
+}
 
-	// Create database schema
-	if err := repo.InitSchema(ctx); err != nil {
-		logging.Fatal(ctx, "Failed to initialize schema: %v", err)
+// printUsage 打印所有子命令
+func printUsage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	// Initialize AWS EC2 client
-	ec2Client, err := aws.NewEC2Client()
-	if err != nil {
-		logging.Fatal(ctx, "Failed to initialize EC2 client: %v", err)
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [--config path] [--log-dir dir] [args]\n\nCommands:\n", os.Args[0])
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-40s %s\n", commands[name].usage, commands[name].summary)
 	}
+	fmt.Fprintln(os.Stderr, "\nRunning without a command starts the server.")
+}
 
-	// Initialize service
-	v2rayService := service.NewV2RayService(repo, ec2Client)
-
-	// Initialize scheduler and start AWS instance sync task
-	s := scheduler.NewScheduler()
-	awsSyncTask := scheduler.NewAWSInstanceSyncTask(ec2Client, repo)
-	s.Register(awsSyncTask)
-
-	// Start all tasks
-	s.Start()
-
-	// Initialize handler
-	v2rayHandler := handlers.NewV2RayHandler(v2rayService)
-
-	// Setup Gin router
-	router := gin.Default()
-
-	// Setup routes
-	routes.SetupRoutes(router, v2rayHandler)
-
-	// Create HTTP server
-	var addr = fmt.Sprintf("%s:%d", config.AppConfig.Server.Host, config.AppConfig.Server.Port)
-	srv := &http.Server{
-		Addr:    addr,
-		Handler: router,
+// runCommand 解析选项，加载配置并初始化日志后执行子命令
+// 参数:
+//   - name: 子命令名称
+//   - cmd: 子命令定义
+//   - args: 子命令参数
+//
+// 返回值:
+//   - error: 错误信息，如果初始化或执行失败
+//
+// 功能:
+//  1. 所有子命令共用 --config 和 --log-dir 选项，standalone 子命令除外
+//  2. 加载配置文件并初始化日志系统
+//  3. 执行子命令
+func runCommand(name string, cmd *command, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	var configPath, logDir *string
+	if !cmd.standalone {
+		configPath = fs.String("config", "conf/conf.yaml", "Path to configuration file")
+		logDir = fs.String("log-dir", "./logs", "Path to log directory")
 	}
-
-	// Start server in a goroutine
-	go func() {
-		logging.Info(ctx, "Server starting on addr %s", addr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logging.Fatal(ctx, "Failed to start server: %v", err)
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s\n\n", os.Args[0], cmd.usage)
+		fs.PrintDefaults()
+	}
+	// 允许选项出现在位置参数之后，例如 relay-config diff --json
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
 		}
-	}()
-
-	// Wait for interrupt signal to gracefully shutdown the server
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	logging.Info(ctx, "Shutting down server...")
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if cmd.standalone {
+		return cmd.run(context.Background(), positional)
+	}
 
-	// Create a deadline for server shutdown
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
+	// Load configuration
+	fmt.Fprintf(os.Stderr, "Using config file: %s\n", *configPath)
+	if err := config.LoadConfig(*configPath); err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
 
-	// Shutdown server
-	if err := srv.Shutdown(ctx); err != nil {
-		logging.Fatal(ctx, "Server forced to shutdown: %v", err)
+	// Initialize logging
+	if err := logging.Init(*logDir); err != nil {
+		return fmt.Errorf("failed to initialize logging: %v", err)
 	}
 
-	// Stop scheduler
-	s.Stop()
+	return cmd.run(context.Background(), positional)
+}
 
-	// Wait for async operations to complete
-	v2rayService.Wait()
+// openRepository 连接数据库并创建 Repository
+// 返回值:
+//   - *repository.Repository: 新创建的 Repository
+//   - func(): 关闭数据库连接的函数
+//   - error: 错误信息，如果连接失败
+func openRepository() (*repository.Repository, func(), error) {
+	db, err := sqlx.Connect("mysql", config.GetDSN())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+	return repository.New(db), func() { db.Close() }, nil
+}
 
-	logging.Info(ctx, "Server exited")
+// newBus 创建事件总线，并将事件转发给 webhook 和运行时间记录
+// 功能:
+//  1. 运维子命令产生的事件同样写入事件表并生成 webhook 投递记录，由服务进程负责投递
+//  2. 状态变化同时用于记录实例的运行时间，作为费用统计的依据
+func newBus(repo *repository.Repository) (*events.Bus, *webhook.Dispatcher) {
+	bus := events.NewBus(repo)
+	dispatcher := webhook.NewDispatcher(repo)
+	bus.AddListener(dispatcher.HandleEvent)
+	bus.AddListener(cost.NewRecorder(repo).HandleEvent)
+	return bus, dispatcher
+}
+
+// newService 创建运维子命令使用的 V2RayService
+// 返回值:
+//   - *service.V2RayService: 新创建的服务
+//   - func(): 释放资源的函数
+//   - error: 错误信息，如果初始化失败
+func newService() (*service.V2RayService, func(), error) {
+	repo, closeDB, err := openRepository()
+	if err != nil {
+		return nil, nil, err
+	}
+	ec2Client, err := aws.NewEC2Client()
+	if err != nil {
+		closeDB()
+		return nil, nil, fmt.Errorf("failed to initialize EC2 client: %v", err)
+	}
+	bus, _ := newBus(repo)
+	svc, err := service.NewV2RayService(repo, ec2Client, bus)
+	if err != nil {
+		closeDB()
+		return nil, nil, fmt.Errorf("failed to initialize service: %v", err)
+	}
+	return svc, func() {
+		svc.Close()
+		closeDB()
+	}, nil
 }
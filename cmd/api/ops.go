@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yuhai94/anywhere_backend/internal/aws"
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/localv2ray"
+	"github.com/yuhai94/anywhere_backend/internal/metrics"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+	"github.com/yuhai94/anywhere_backend/internal/scheduler"
+	"github.com/yuhai94/anywhere_backend/internal/service"
+)
+
+func migrateCommand() *command {
+	return &command{
+		usage:   "migrate",
+		summary: "Create or upgrade the database schema",
+		run: func(ctx context.Context, args []string) error {
+			repo, closeDB, err := openRepository()
+			if err != nil {
+				return err
+			}
+			defer closeDB()
+
+			if err := repo.InitSchema(ctx); err != nil {
+				return err
+			}
+			fmt.Println("Database schema is up to date")
+			return nil
+		},
+	}
+}
+
+func syncOnceCommand() *command {
+	var dryRun, asJSON bool
+	return &command{
+		usage:   "sync-once [--dry-run] [--json]",
+		summary: "Sync AWS instances into the database once and print the diff",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&dryRun, "dry-run", false, "Only print the diff, do not write to the database")
+			fs.BoolVar(&asJSON, "json", false, "Print the diff as JSON")
+		},
+		run: func(ctx context.Context, args []string) error {
+			repo, closeDB, err := openRepository()
+			if err != nil {
+				return err
+			}
+			defer closeDB()
+
+			ec2Client, err := aws.NewEC2Client()
+			if err != nil {
+				return fmt.Errorf("failed to initialize EC2 client: %v", err)
+			}
+			bus, _ := newBus(repo)
+
+			changes := scheduler.NewAWSInstanceSyncTask(ec2Client, repo, bus).SyncOnce(ctx, dryRun)
+			if asJSON {
+				return printJSON(changes)
+			}
+			if len(changes) == 0 {
+				fmt.Println("No differences between AWS and the database")
+				return nil
+			}
+
+			symbols := map[string]string{
+				metrics.DriftUntracked:     "+",
+				metrics.DriftMissing:       "-",
+				metrics.DriftStatusChanged: "~",
+				metrics.DriftIPChanged:     "~",
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			for _, change := range changes {
+				fmt.Fprintf(tw, "%s %s\t%s\t%s\t%s\t%s -> %s\n",
+					symbols[change.Kind], change.Kind, change.Region, change.EC2ID, change.InstanceUUID, dash(change.Old), dash(change.New))
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+			if dryRun {
+				fmt.Printf("%d differences (dry run, nothing was written)\n", len(changes))
+			} else {
+				fmt.Printf("%d differences applied\n", len(changes))
+			}
+			return nil
+		},
+	}
+}
+
+func validateConfigCommand() *command {
+	var show bool
+	return &command{
+		usage:   "validate-config [--show]",
+		summary: "Check the configuration file and exit",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&show, "show", false, "Print the effective configuration with secrets redacted")
+		},
+		run: func(ctx context.Context, args []string) error {
+			// LoadConfig 已经完成校验，这里再校验一次以防配置在加载后被修改
+			if err := config.Validate(config.Get()); err != nil {
+				return err
+			}
+			if show {
+				for _, line := range config.Summary(config.Get()) {
+					fmt.Println(line)
+				}
+			}
+			fmt.Println("Configuration is valid")
+			return nil
+		},
+	}
+}
+
+func renderUserDataCommand() *command {
+	var instanceUUID, protocol string
+	return &command{
+		usage:   "render-userdata [--uuid id] [--protocol vmess|vless-reality] <region>",
+		summary: "Print the EC2 user data script for a region",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&instanceUUID, "uuid", "", "Instance UUID to render into the script (default: random)")
+			fs.StringVar(&protocol, "protocol", "", "Node protocol (default: v2ray.protocol); vless-reality renders freshly generated keys")
+		},
+		run: func(ctx context.Context, args []string) error {
+			if len(args) != 1 {
+				return errors.New("usage: render-userdata [--uuid id] [--protocol vmess|vless-reality] <region>")
+			}
+			region, err := config.ResolveRegion(args[0])
+			if err != nil {
+				return err
+			}
+			cfg := config.Get()
+			if protocol == "" {
+				protocol = cfg.V2Ray.Protocol
+			}
+			if !config.ValidProtocol(protocol) {
+				return fmt.Errorf("unsupported protocol %q, expected vmess or vless-reality", protocol)
+			}
+			if instanceUUID == "" {
+				instanceUUID = uuid.New().String()
+				fmt.Fprintf(os.Stderr, "Using random UUID %s\n", instanceUUID)
+			}
+			instance := &models.V2RayInstance{UUID: instanceUUID, EC2Region: region, Protocol: protocol}
+			if instance.IsReality() {
+				if err := service.GenerateRealityKeys(instance, cfg.V2Ray.Reality); err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "Using REALITY public key %s and short IDs %s\n", instance.RealityPublicKey, instance.RealityShortIDs)
+			}
+			fmt.Println(service.BuildUserData(instance))
+			return nil
+		},
+	}
+}
+
+func relayConfigCommand() *command {
+	var asJSON bool
+	var relay string
+	return &command{
+		usage:   "relay-config show|diff|repair|backups|rollback [--relay name] [backup] [--json]",
+		summary: "Inspect, repair or roll back relay outbounds in the relay V2Ray configs",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&asJSON, "json", false, "Print the result as JSON")
+			fs.StringVar(&relay, "relay", "", "Relay to roll back (required when several relays are configured)")
+		},
+		run: func(ctx context.Context, args []string) error {
+			if len(args) == 0 || len(args) > 2 || (len(args) == 2 && args[0] != "rollback") {
+				return errors.New("usage: relay-config show|diff|repair|backups|rollback [--relay name] [backup]")
+			}
+
+			svc, closeDB, err := newService()
+			if err != nil {
+				return err
+			}
+			defer closeDB()
+
+			switch args[0] {
+			case "show":
+				relays, err := svc.RelayOutbounds(ctx)
+				if err != nil {
+					return err
+				}
+				if asJSON {
+					return printJSON(relays)
+				}
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(tw, "RELAY\tTAG\tADDRESS\tPORT\tUUID")
+				for _, relay := range relays {
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", relay.Relay, relay.Tag, relay.Address, relay.Port, relay.UUID)
+				}
+				return tw.Flush()
+			case "diff":
+				changes, err := svc.DiffRelayConfig(ctx)
+				if err != nil {
+					return err
+				}
+				return printRelayChanges(changes, asJSON, false)
+			case "repair":
+				changes, err := svc.RepairRelayConfig(ctx)
+				if len(changes) > 0 || err == nil {
+					if printErr := printRelayChanges(changes, asJSON, true); printErr != nil {
+						return printErr
+					}
+				}
+				return err
+			case "backups":
+				backups, err := svc.RelayBackups(ctx)
+				if err != nil {
+					return err
+				}
+				if asJSON {
+					return printJSON(backups)
+				}
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(tw, "RELAY\tNAME\tTIME\tSIZE")
+				for _, backup := range backups {
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", backup.Relay, backup.Name, backup.Time.Local().Format(time.RFC3339), backup.Size)
+				}
+				return tw.Flush()
+			case "rollback":
+				var name string
+				if len(args) == 2 {
+					name = args[1]
+				}
+				backup, err := svc.RollbackRelayConfig(ctx, relay, name)
+				if err != nil {
+					return err
+				}
+				if asJSON {
+					return printJSON(backup)
+				}
+				fmt.Printf("Restored %s on relay %s\n", backup.Name, backup.Relay)
+				return nil
+			default:
+				return fmt.Errorf("unknown relay-config action %q, expected show, diff, repair, backups or rollback", args[0])
+			}
+		},
+	}
+}
+
+func gcCommand() *command {
+	var dryRun, asJSON bool
+	return &command{
+		usage:   "gc [--dry-run] [--json]",
+		summary: "Clean up orphaned instance records, EC2 instances and relay outbounds",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&dryRun, "dry-run", false, "Only print what would be cleaned up")
+			fs.BoolVar(&asJSON, "json", false, "Print the actions as JSON")
+		},
+		run: func(ctx context.Context, args []string) error {
+			svc, closeDB, err := newService()
+			if err != nil {
+				return err
+			}
+			defer closeDB()
+
+			actions, err := svc.CollectGarbage(ctx, dryRun)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				return printJSON(actions)
+			}
+			if len(actions) == 0 {
+				fmt.Println("Nothing to clean up")
+				return nil
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			for _, action := range actions {
+				target := action.InstanceUUID
+				if action.Tag != "" {
+					target = action.Relay + ":" + action.Tag
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", action.Action, dash(action.Region), dash(action.EC2ID), target, action.Reason)
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+			if dryRun {
+				fmt.Printf("%d actions (dry run, nothing was changed)\n", len(actions))
+			}
+			return nil
+		},
+	}
+}
+
+// printRelayChanges 以 diff 形式打印中转出站变更，每行以中转名称开头
+func printRelayChanges(changes []localv2ray.RelayChange, asJSON, applied bool) error {
+	if asJSON {
+		return printJSON(changes)
+	}
+	if len(changes) == 0 {
+		fmt.Println("Relay config matches running instances")
+		return nil
+	}
+
+	format := func(relay *localv2ray.RelayOutbound) string {
+		return fmt.Sprintf("%s:%d %s", relay.Address, relay.Port, relay.UUID)
+	}
+	for _, change := range changes {
+		switch change.Action {
+		case localv2ray.RelayAdd:
+			fmt.Printf("%s: + %s %s\n", change.Relay, change.Tag, format(change.Desired))
+		case localv2ray.RelayRemove:
+			fmt.Printf("%s: - %s %s\n", change.Relay, change.Tag, format(change.Current))
+		case localv2ray.RelayUpdate:
+			fmt.Printf("%s: ~ %s %s -> %s\n", change.Relay, change.Tag, format(change.Current), format(change.Desired))
+		case localv2ray.RelayAddRule:
+			fmt.Printf("%s: + rule %s\n", change.Relay, change.Tag)
+		case localv2ray.RelayRemoveRule:
+			fmt.Printf("%s: - rule %s\n", change.Relay, change.Tag)
+		case localv2ray.RelayAddUser:
+			fmt.Printf("%s: + user %s\n", change.Relay, change.Tag)
+		case localv2ray.RelayRemoveUser:
+			fmt.Printf("%s: - user %s\n", change.Relay, change.Tag)
+		}
+	}
+	if applied {
+		fmt.Printf("%d changes applied\n", len(changes))
+	}
+	return nil
+}
+
+// printJSON 以缩进格式输出 JSON
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// dash 空字符串显示为 "-"
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/vault"
+)
+
+func secretsCommand() *command {
+	var vaultFile, vaultKey string
+	return &command{
+		usage:   "secrets keygen|list|set <name>|delete <name> [--vault path] [--key ref]",
+		summary: "Manage the encrypted secrets vault",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&vaultFile, "vault", "conf/secrets.vault", "Path to the vault file")
+			fs.StringVar(&vaultKey, "key", "file:conf/vault.key", "Vault key, as base64 or an env:/file: reference")
+		},
+		standalone: true,
+		run: func(ctx context.Context, args []string) error {
+			if len(args) == 0 {
+				return errors.New("usage: secrets keygen|list|set <name>|delete <name>")
+			}
+
+			if args[0] == "keygen" {
+				key, err := vault.GenerateKey()
+				if err != nil {
+					return err
+				}
+				fmt.Println(key)
+				return nil
+			}
+
+			v, err := config.OpenVault(config.SecretsConfig{VaultFile: vaultFile, VaultKey: vaultKey})
+			if err != nil {
+				return err
+			}
+
+			switch args[0] {
+			case "list":
+				for _, name := range v.Names() {
+					fmt.Println(name)
+				}
+				return nil
+			case "set":
+				if len(args) != 2 {
+					return errors.New("usage: secrets set <name>, the value is read from stdin")
+				}
+				value, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					return fmt.Errorf("failed to read value from stdin: %v", err)
+				}
+				if err := v.Set(args[1], strings.TrimRight(string(value), "\r\n")); err != nil {
+					return err
+				}
+				if err := v.Save(); err != nil {
+					return err
+				}
+				fmt.Printf("Stored %s, reference it as vault:%s\n", args[1], args[1])
+				return nil
+			case "delete":
+				if len(args) != 2 {
+					return errors.New("usage: secrets delete <name>")
+				}
+				if !v.Delete(args[1]) {
+					return fmt.Errorf("secret %s not found", args[1])
+				}
+				return v.Save()
+			default:
+				return fmt.Errorf("unknown secrets action %q, expected keygen, list, set or delete", args[0])
+			}
+		},
+	}
+}
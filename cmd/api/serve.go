@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuhai94/anywhere_backend/internal/api/handlers"
+	"github.com/yuhai94/anywhere_backend/internal/api/middleware"
+	"github.com/yuhai94/anywhere_backend/internal/api/routes"
+	"github.com/yuhai94/anywhere_backend/internal/aws"
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/cost"
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/metrics"
+	"github.com/yuhai94/anywhere_backend/internal/scheduler"
+	"github.com/yuhai94/anywhere_backend/internal/service"
+	"github.com/yuhai94/anywhere_backend/internal/telegram"
+	"github.com/yuhai94/anywhere_backend/internal/tracing"
+)
+
+func serveCommand() *command {
+	return &command{
+		usage:   "serve",
+		summary: "Start the API server and background tasks (default)",
+		run:     runServe,
+	}
+}
+
+// runServe 启动 API 服务器和后台任务，收到 SIGINT/SIGTERM 后优雅退出
+func runServe(ctx context.Context, args []string) error {
+	logging.Info(ctx, "Starting V2Ray backend service")
+	for _, line := range config.Summary(config.Get()) {
+		logging.Info(ctx, "Config %s", line)
+	}
+
+	// Initialize tracing
+	shutdownTracing, err := tracing.Init(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to initialize tracing: %v", err)
+	}
+
+	// Connect to database
+	db := config.Get().Database
+	fmt.Printf("Connecting to database %s@%s:%d/%s...\n", db.User, db.Host, db.Port, db.DBName)
+	repo, closeDB, err := openRepository()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+	fmt.Println("Connected to database successfully")
+
+	// Create database schema
+	if err := repo.InitSchema(ctx); err != nil {
+		return fmt.Errorf("failed to initialize schema: %v", err)
+	}
+
+	// Register instance count metrics
+	if err := metrics.RegisterInstanceCollector(repo); err != nil {
+		return fmt.Errorf("failed to register instance metrics: %v", err)
+	}
+
+	// Initialize AWS EC2 client
+	ec2Client, err := aws.NewEC2Client()
+	if err != nil {
+		return fmt.Errorf("failed to initialize EC2 client: %v", err)
+	}
+
+	// Initialize event bus and deliver lifecycle events to registered webhooks
+	bus, dispatcher := newBus(repo)
+
+	// Start recording run time for instances that were already running before usage tracking
+	if instances, err := repo.List(ctx); err != nil {
+		logging.Error(ctx, "Failed to list instances for usage backfill: %v", err)
+	} else {
+		cost.NewRecorder(repo).Backfill(ctx, instances)
+	}
+
+	// Initialize service
+	v2rayService, err := service.NewV2RayService(repo, ec2Client, bus)
+	if err != nil {
+		return fmt.Errorf("failed to initialize service: %v", err)
+	}
+	defer v2rayService.Close()
+
+	// Initialize scheduler and start AWS instance sync task
+	s := scheduler.NewScheduler()
+	awsSyncTask := scheduler.NewAWSInstanceSyncTask(ec2Client, repo, bus)
+	s.Register(awsSyncTask)
+	s.Register(scheduler.NewWebhookDeliveryTask(dispatcher))
+	s.Register(scheduler.NewConfigReloadTask())
+	s.Register(scheduler.NewTrafficStatsTask(repo, v2rayService))
+	s.Register(scheduler.NewRelayReconcileTask(v2rayService))
+	if config.Get().Telegram.Enabled {
+		if config.Get().Telegram.Token == "" {
+			return fmt.Errorf("telegram bot is enabled but no token is configured")
+		}
+		s.Register(telegram.NewBot(v2rayService, bus))
+	}
+
+	// Start all tasks
+	s.Start()
+
+	// Initialize handlers
+	v2rayHandler := handlers.NewV2RayHandler(v2rayService)
+	eventsHandler := handlers.NewEventsHandler(bus)
+	webhookHandler := handlers.NewWebhookHandler(service.NewWebhookService(repo))
+	costHandler := handlers.NewCostHandler(service.NewCostService(repo))
+	trafficHandler := handlers.NewTrafficHandler(service.NewTrafficService(repo))
+
+	// Setup Gin router
+	router := gin.New()
+	router.Use(middleware.AccessLog(), gin.Recovery())
+	router.Use(tracing.GinMiddleware())
+	router.Use(metrics.GinMiddleware())
+
+	// Setup routes
+	routes.SetupRoutes(router, v2rayHandler, eventsHandler, webhookHandler, costHandler, trafficHandler)
+
+	// Create HTTP server
+	var addr = fmt.Sprintf("%s:%d", config.Get().Server.Host, config.Get().Server.Port)
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: router,
+	}
+	// Close event streams when shutdown begins so they do not block it
+	srv.RegisterOnShutdown(eventsHandler.Close)
+
+	// Start server in a goroutine
+	go func() {
+		logging.Info(ctx, "Server starting on addr %s", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logging.Fatal(ctx, "Failed to start server: %v", err)
+		}
+	}()
+
+	// Wait for interrupt signal to gracefully shutdown the server
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	logging.Info(ctx, "Shutting down server...")
+
+	// Create a deadline for server shutdown
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// Shutdown server
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logging.Error(ctx, "Server forced to shutdown: %v", err)
+	}
+
+	// Stop scheduler
+	s.Stop()
+
+	// Wait for async operations to complete
+	v2rayService.Wait()
+
+	// Flush pending spans
+	tracingCtx, tracingCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer tracingCancel()
+	if err := shutdownTracing(tracingCtx); err != nil {
+		logging.Error(ctx, "Failed to shutdown tracing: %v", err)
+	}
+
+	logging.Info(ctx, "Server exited")
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultEndpoint = "http://localhost:8000"
+
+// cliConfig awctl 配置文件内容
+type cliConfig struct {
+	Endpoint string `yaml:"endpoint"`
+	APIKey   string `yaml:"api_key"`
+	Output   string `yaml:"output"`
+}
+
+// globalOptions 所有子命令共用的选项
+type globalOptions struct {
+	configPath string
+	endpoint   string
+	apiKey     string
+	output     string
+}
+
+// defaultConfigPath 返回默认配置文件路径 ~/.config/awctl/config.yaml
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "awctl.yaml"
+	}
+	return filepath.Join(dir, "awctl", "config.yaml")
+}
+
+// addGlobalFlags 为子命令注册共用选项
+func addGlobalFlags(fs *flag.FlagSet, opts *globalOptions) {
+	fs.StringVar(&opts.configPath, "config", os.Getenv("AWCTL_CONFIG"), "Path to awctl config file (default "+defaultConfigPath()+")")
+	fs.StringVar(&opts.endpoint, "endpoint", "", "Backend endpoint, overrides config file and AWCTL_ENDPOINT")
+	fs.StringVar(&opts.apiKey, "api-key", "", "API key, overrides config file and AWCTL_API_KEY")
+	fs.StringVar(&opts.output, "o", "", "Output format: table, json or yaml")
+}
+
+// resolve 合并命令行、环境变量和配置文件，优先级依次降低
+// 返回值:
+//   - cliConfig: 最终生效的配置
+//   - error: 错误信息，如果配置文件格式错误或输出格式不支持
+func (opts *globalOptions) resolve() (cliConfig, error) {
+	var cfg cliConfig
+
+	path := opts.configPath
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("failed to parse config file %s: %v", path, err)
+		}
+	case errors.Is(err, fs.ErrNotExist) && !explicit:
+		// 默认配置文件可以不存在
+	default:
+		return cfg, fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+
+	if env := os.Getenv("AWCTL_ENDPOINT"); env != "" {
+		cfg.Endpoint = env
+	}
+	if env := os.Getenv("AWCTL_API_KEY"); env != "" {
+		cfg.APIKey = env
+	}
+	if opts.endpoint != "" {
+		cfg.Endpoint = opts.endpoint
+	}
+	if opts.apiKey != "" {
+		cfg.APIKey = opts.apiKey
+	}
+	if opts.output != "" {
+		cfg.Output = opts.output
+	}
+
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = defaultEndpoint
+	}
+	if cfg.Output == "" {
+		cfg.Output = outputTable
+	}
+	switch cfg.Output {
+	case outputTable, outputJSON, outputYAML:
+	default:
+		return cfg, fmt.Errorf("unsupported output format %q", cfg.Output)
+	}
+	return cfg, nil
+}
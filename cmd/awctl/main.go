@@ -0,0 +1,408 @@
+// awctl 是 anywhere_backend API 的命令行客户端
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/pkg/client"
+)
+
+const usage = `Usage: awctl <command> [flags] [args]
+
+Commands:
+  regions              List supported regions
+  ls                   List instances (--status, --region, --owner, --created-after, --sort,
+                       --include-deleted, --deleted-only)
+  get <uuid>           Show instance details
+  timeline <uuid>      Show every status change of an instance, including deleted ones
+  up <region>          Create an instance (--wait to follow it until running)
+  down <uuid>          Delete an instance
+  links [uuid]         Print share links of running instances (--qr for terminal QR codes)
+  watch [uuid]         Stream lifecycle events
+  costs                Show the monthly spend report (--month YYYY-MM)
+  traffic [name]       Show relay traffic totals, or the series of one instance or user
+                       (--kind instance|user|outbound, --from, --to, --granularity hour|day)
+
+Global flags (accepted by every command):
+  --config <path>      Config file (default ~/.config/awctl/config.yaml, env AWCTL_CONFIG)
+  --endpoint <url>     Backend endpoint (env AWCTL_ENDPOINT)
+  --api-key <key>      API key (env AWCTL_API_KEY)
+  -o table|json|yaml   Output format
+
+Config file:
+  endpoint: http://localhost:8000
+  api_key: xxx
+  output: table
+`
+
+// command 子命令定义
+type command struct {
+	// flags 注册子命令特有的选项
+	flags func(fs *flag.FlagSet)
+	// run 执行子命令
+	run func(ctx context.Context, env *cmdEnv, args []string) error
+}
+
+// cmdEnv 子命令的运行环境
+type cmdEnv struct {
+	client *client.Client
+	out    *printer
+	stderr io.Writer
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "help" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, os.Args[1], os.Args[2:]); err != nil {
+		if errors.Is(err, context.Canceled) {
+			os.Exit(130)
+		}
+		fmt.Fprintf(os.Stderr, "awctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run 解析选项并执行子命令
+func run(ctx context.Context, name string, args []string) error {
+	commands := map[string]*command{
+		"regions":  {run: runRegions},
+		"ls":       listCommand(),
+		"get":      {run: runGet},
+		"timeline": {run: runTimeline},
+		"up":       upCommand(),
+		"down":     {run: runDown},
+		"links":    linksCommand(),
+		"watch":    watchCommand(),
+		"costs":    costsCommand(),
+		"traffic":  trafficCommand(),
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", name)
+	}
+
+	fs := flag.NewFlagSet("awctl "+name, flag.ContinueOnError)
+	var opts globalOptions
+	addGlobalFlags(fs, &opts)
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := opts.resolve()
+	if err != nil {
+		return err
+	}
+
+	env := &cmdEnv{
+		client: client.New(cfg.Endpoint, client.WithAPIKey(cfg.APIKey)),
+		out:    &printer{w: os.Stdout, format: cfg.Output},
+		stderr: os.Stderr,
+	}
+	return cmd.run(ctx, env, positional)
+}
+
+// parseInterspersed 解析选项，允许选项出现在位置参数之后，例如 "up hk --wait"
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func runRegions(ctx context.Context, env *cmdEnv, args []string) error {
+	regions, err := env.client.ListRegions(ctx)
+	if err != nil {
+		return err
+	}
+	return env.out.regions(regions)
+}
+
+func listCommand() *command {
+	var q client.ListInstancesQuery
+	var status string
+	return &command{
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&status, "status", "", "Only list instances in these comma-separated statuses")
+			fs.StringVar(&q.Region, "region", "", "Only list instances in this region")
+			fs.StringVar(&q.Owner, "owner", "", "Only list instances created by this owner")
+			fs.StringVar(&q.CreatedAfter, "created-after", "", "Only list instances created after this time (RFC 3339 or YYYY-MM-DD)")
+			fs.StringVar(&q.Sort, "sort", "", "Sort by created_at, updated_at, region or status, prefix with - for descending (default -created_at)")
+			fs.BoolVar(&q.IncludeDeleted, "include-deleted", false, "Also list deleted instances")
+			fs.BoolVar(&q.DeletedOnly, "deleted-only", false, "Only list deleted instances")
+		},
+		run: func(ctx context.Context, env *cmdEnv, args []string) error {
+			if status != "" {
+				q.Statuses = strings.Split(status, ",")
+			}
+			if q.Region != "" {
+				region, err := resolveRegion(ctx, env.client, q.Region)
+				if err != nil {
+					return err
+				}
+				q.Region = region
+			}
+			instances, err := env.client.ListAllInstances(ctx, q)
+			if err != nil {
+				return err
+			}
+			return env.out.instances(instances)
+		},
+	}
+}
+
+func runGet(ctx context.Context, env *cmdEnv, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: awctl get <uuid>")
+	}
+	instance, err := env.client.GetInstance(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return env.out.instance(instance)
+}
+
+func runTimeline(ctx context.Context, env *cmdEnv, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: awctl timeline <uuid>")
+	}
+	timeline, err := env.client.Timeline(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return env.out.timeline(timeline)
+}
+
+func upCommand() *command {
+	var wait bool
+	var timeout time.Duration
+	var owner, protocol string
+	return &command{
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&wait, "wait", false, "Wait until the instance is running and print its links")
+			fs.DurationVar(&timeout, "timeout", 10*time.Minute, "Maximum time to wait with --wait")
+			fs.StringVar(&owner, "owner", "", "Owner recorded for cost reports and budgets")
+			fs.StringVar(&protocol, "protocol", "", "Node protocol, vmess or vless-reality (default: server v2ray.protocol)")
+		},
+		run: func(ctx context.Context, env *cmdEnv, args []string) error {
+			if len(args) != 1 {
+				return errors.New("usage: awctl up <region> [--wait]")
+			}
+			region, err := resolveRegion(ctx, env.client, args[0])
+			if err != nil {
+				return err
+			}
+
+			resp, err := env.client.CreateInstanceWithOptions(ctx, region, client.CreateInstanceOptions{Owner: owner, Protocol: protocol})
+			if err != nil {
+				return err
+			}
+			if !wait {
+				if ok, err := env.out.structured(resp); ok {
+					return err
+				}
+				fmt.Fprintf(env.out.w, "%s %s\n", resp.UUID, resp.Status)
+				return nil
+			}
+
+			fmt.Fprintf(env.stderr, "Instance %s created in %s, waiting for it to be running...\n", resp.UUID, region)
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			instance, err := env.client.WaitForRunning(ctx, resp.UUID, client.DefaultPollInterval, func(i *client.Instance) {
+				fmt.Fprintf(env.stderr, "%s  %s\n", time.Now().Format("15:04:05"), i.Status)
+			})
+			if err != nil {
+				return err
+			}
+			return env.out.links([]client.Instance{*instance}, "", false)
+		},
+	}
+}
+
+func runDown(ctx context.Context, env *cmdEnv, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: awctl down <uuid>")
+	}
+	if err := env.client.DeleteInstance(ctx, args[0]); err != nil {
+		return err
+	}
+	fmt.Fprintf(env.stderr, "Instance %s is being deleted\n", args[0])
+	return nil
+}
+
+func linksCommand() *command {
+	var kind string
+	var qr bool
+	return &command{
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&kind, "kind", "", "Only print direct or relay links")
+			fs.BoolVar(&qr, "qr", false, "Render a QR code for each link in the terminal (table output only)")
+		},
+		run: func(ctx context.Context, env *cmdEnv, args []string) error {
+			if kind != "" && kind != client.LinkKindDirect && kind != client.LinkKindRelay {
+				return fmt.Errorf("invalid kind %q", kind)
+			}
+
+			var instances []client.Instance
+			switch len(args) {
+			case 0:
+				running, err := env.client.ListAllInstances(ctx, client.ListInstancesQuery{Statuses: []string{client.StatusRunning}})
+				if err != nil {
+					return err
+				}
+				instances = running
+			case 1:
+				instance, err := env.client.GetInstance(ctx, args[0])
+				if err != nil {
+					return err
+				}
+				instances = append(instances, *instance)
+			default:
+				return errors.New("usage: awctl links [uuid] [--kind direct|relay] [--qr]")
+			}
+			return env.out.links(instances, kind, qr)
+		},
+	}
+}
+
+func watchCommand() *command {
+	var since int64
+	return &command{
+		flags: func(fs *flag.FlagSet) {
+			fs.Int64Var(&since, "since", 0, "Replay events after this event ID")
+		},
+		run: func(ctx context.Context, env *cmdEnv, args []string) error {
+			if len(args) > 1 {
+				return errors.New("usage: awctl watch [uuid] [--since id]")
+			}
+			uuid := ""
+			if len(args) == 1 {
+				uuid = args[0]
+			}
+
+			// 连接断开时从最后收到的事件继续
+			lastID := since
+			for {
+				err := env.client.Watch(ctx, uuid, lastID, func(e *client.Event) error {
+					if e.ID > 0 {
+						lastID = e.ID
+					}
+					return env.out.event(e)
+				})
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				var apiErr *client.APIError
+				if errors.As(err, &apiErr) {
+					return err
+				}
+				fmt.Fprintf(env.stderr, "Event stream interrupted (%v), reconnecting...\n", err)
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(2 * time.Second):
+				}
+			}
+		},
+	}
+}
+
+func costsCommand() *command {
+	var month string
+	return &command{
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&month, "month", "", "Month to report in YYYY-MM format (default current month)")
+		},
+		run: func(ctx context.Context, env *cmdEnv, args []string) error {
+			if len(args) != 0 {
+				return errors.New("usage: awctl costs [--month YYYY-MM]")
+			}
+			report, err := env.client.Costs(ctx, month)
+			if err != nil {
+				return err
+			}
+			return env.out.costs(report)
+		},
+	}
+}
+
+func trafficCommand() *command {
+	var kind string
+	var q client.TrafficQuery
+	return &command{
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&kind, "kind", client.TrafficInstance, "What to report: instance, user or outbound")
+			fs.StringVar(&q.From, "from", "", "Start time, RFC 3339 or YYYY-MM-DD (default 24 hours before --to)")
+			fs.StringVar(&q.To, "to", "", "End time, RFC 3339 or YYYY-MM-DD (default now)")
+			fs.StringVar(&q.Granularity, "granularity", "", "Series granularity: hour or day (default hour)")
+		},
+		run: func(ctx context.Context, env *cmdEnv, args []string) error {
+			switch len(args) {
+			case 0:
+				totals, err := env.client.TrafficTotals(ctx, kind, q)
+				if err != nil {
+					return err
+				}
+				return env.out.trafficTotals(totals)
+			case 1:
+				var usage *client.TrafficUsage
+				var err error
+				switch kind {
+				case client.TrafficInstance:
+					usage, err = env.client.InstanceTraffic(ctx, args[0], q)
+				case client.TrafficUser:
+					usage, err = env.client.UserTraffic(ctx, args[0], q)
+				default:
+					return fmt.Errorf("a series is only available for --kind instance or user")
+				}
+				if err != nil {
+					return err
+				}
+				return env.out.trafficUsage(usage)
+			default:
+				return errors.New("usage: awctl traffic [uuid|email] [--kind instance|user|outbound] [--from] [--to] [--granularity hour|day]")
+			}
+		},
+	}
+}
+
+// resolveRegion 将区域代码、别名或名称解析为区域代码
+func resolveRegion(ctx context.Context, c *client.Client, input string) (string, error) {
+	regions, err := c.ListRegions(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, r := range regions {
+		if r.Region == input || strings.EqualFold(r.Alias, input) || r.Name == input {
+			return r.Region, nil
+		}
+	}
+	return "", fmt.Errorf("unknown region %q, see `awctl regions`", input)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/qrcode"
+	"github.com/yuhai94/anywhere_backend/pkg/client"
+	"gopkg.in/yaml.v3"
+)
+
+// 输出格式
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// printer 按输出格式打印结果
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+// structured 以 JSON 或 YAML 打印任意对象，返回 false 表示需要按表格打印
+func (p *printer) structured(v interface{}) (bool, error) {
+	switch p.format {
+	case outputJSON:
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return true, enc.Encode(v)
+	case outputYAML:
+		enc := yaml.NewEncoder(p.w)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return true, err
+		}
+		return true, enc.Close()
+	}
+	return false, nil
+}
+
+// regions 打印区域列表
+func (p *printer) regions(regions []client.Region) error {
+	if ok, err := p.structured(regions); ok {
+		return err
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "REGION\tALIAS\tNAME")
+	for _, r := range regions {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Region, dash(r.Alias), r.Name)
+	}
+	return tw.Flush()
+}
+
+// instances 打印实例列表
+func (p *printer) instances(instances []client.Instance) error {
+	if ok, err := p.structured(instances); ok {
+		return err
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "UUID\tREGION\tSTATUS\tPUBLIC IP\tCREATED")
+	for _, i := range instances {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", i.UUID, i.EC2Region, i.Status, dash(i.EC2PublicIP), i.CreatedAt)
+	}
+	return tw.Flush()
+}
+
+// instance 打印单个实例详情
+func (p *printer) instance(i *client.Instance) error {
+	if ok, err := p.structured(i); ok {
+		return err
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	rows := [][2]string{
+		{"UUID", i.UUID},
+		{"Region", fmt.Sprintf("%s %s", i.EC2Region, i.EC2RegionName)},
+		{"Status", i.Status},
+		{"Protocol", dash(i.Protocol)},
+		{"EC2 ID", dash(i.EC2ID)},
+		{"Public IP", dash(i.EC2PublicIP)},
+		{"Direct link", dash(i.DirectLink)},
+		{"Relay link", dash(strings.ReplaceAll(i.RelayLink, "\n", "\n\t"))},
+		{"Created", i.CreatedAt},
+		{"Updated", i.UpdatedAt},
+	}
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%s:\t%s\n", row[0], row[1])
+	}
+	return tw.Flush()
+}
+
+// timeline 打印实例的历史
+func (p *printer) timeline(t *client.InstanceTimeline) error {
+	if ok, err := p.structured(t); ok {
+		return err
+	}
+	fmt.Fprintf(p.w, "%s %s %s\n\n", t.Instance.UUID, t.Instance.EC2Region, t.Instance.Status)
+
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tEVENT\tSTATUS\tPUBLIC IP\tDURATION\tCAUSE")
+	for _, e := range t.Events {
+		status := e.ToStatus
+		if e.FromStatus != "" && e.FromStatus != e.ToStatus {
+			status = e.FromStatus + " -> " + e.ToStatus
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", e.CreatedAt, e.Type, status, dash(e.PublicIP),
+			time.Duration(e.DurationSeconds)*time.Second, dash(e.Cause))
+	}
+	return tw.Flush()
+}
+
+// linkRow 一条分享链接
+type linkRow struct {
+	UUID   string `json:"uuid" yaml:"uuid"`
+	Region string `json:"region" yaml:"region"`
+	Kind   string `json:"kind" yaml:"kind"`
+	Link   string `json:"link" yaml:"link"`
+}
+
+// links 打印实例的分享链接，表格模式下可附带终端二维码
+func (p *printer) links(instances []client.Instance, kind string, withQR bool) error {
+	var rows []linkRow
+	for _, i := range instances {
+		if (kind == "" || kind == client.LinkKindDirect) && i.DirectLink != "" {
+			rows = append(rows, linkRow{UUID: i.UUID, Region: i.EC2Region, Kind: client.LinkKindDirect, Link: i.DirectLink})
+		}
+		if (kind == "" || kind == client.LinkKindRelay) && i.RelayLink != "" {
+			// One relay link per line when several relays are configured
+			for _, link := range strings.Split(i.RelayLink, "\n") {
+				rows = append(rows, linkRow{UUID: i.UUID, Region: i.EC2Region, Kind: client.LinkKindRelay, Link: link})
+			}
+		}
+	}
+
+	if ok, err := p.structured(rows); ok {
+		return err
+	}
+
+	if !withQR {
+		tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "UUID\tREGION\tKIND\tLINK")
+		for _, r := range rows {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.UUID, r.Region, r.Kind, r.Link)
+		}
+		return tw.Flush()
+	}
+
+	for _, r := range rows {
+		code, err := qrcode.ANSI(r.Link)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(p.w, "%s %s (%s)\n%s\n%s\n", r.Region, r.Kind, r.UUID, r.Link, code)
+	}
+	return nil
+}
+
+// event 打印一个生命周期事件，表格模式下每个事件一行
+func (p *printer) event(e *client.Event) error {
+	switch p.format {
+	case outputJSON:
+		return json.NewEncoder(p.w).Encode(e)
+	case outputYAML:
+		fmt.Fprintln(p.w, "---")
+		_, err := p.structured(e)
+		return err
+	}
+
+	detail := e.Status
+	switch e.Type {
+	case client.EventIPChanged:
+		detail = e.PublicIP
+	case client.EventLinksChanged:
+		detail = "links updated"
+	}
+	if e.Type == client.EventStatusChanged && e.PublicIP != "" {
+		detail += " " + e.PublicIP
+	}
+	_, err := fmt.Fprintf(p.w, "%-6d %s  %s  %-10s %-15s %s\n", e.ID, e.CreatedAt, e.InstanceUUID, e.Source, e.Type, detail)
+	return err
+}
+
+// costs 打印费用报告，表格模式下依次打印区域、创建者、实例和预算
+func (p *printer) costs(r *client.CostReport) error {
+	if ok, err := p.structured(r); ok {
+		return err
+	}
+	fmt.Fprintf(p.w, "Month %s (%s): spent %.2f, projected %.2f, %.1f run hours\n\n",
+		r.Month, r.Currency, r.Total.Total, r.Total.Projected, r.Total.RunHours)
+
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	for _, section := range []struct {
+		title  string
+		groups []client.GroupSpend
+	}{{"REGION", r.Regions}, {"OWNER", r.Owners}} {
+		fmt.Fprintf(tw, "%s\tINSTANCES\tHOURS\tSPENT\tPROJECTED\n", section.title)
+		for _, g := range section.groups {
+			fmt.Fprintf(tw, "%s\t%d\t%.1f\t%.2f\t%.2f\n", dash(g.Name), g.Instances, g.RunHours, g.Total, g.Projected)
+		}
+		fmt.Fprintln(tw)
+	}
+
+	fmt.Fprintln(tw, "INSTANCE\tREGION\tOWNER\tTYPE\tHOURS\tSPENT\tPROJECTED")
+	for _, i := range r.Instances {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%.1f\t%.2f\t%.2f\n",
+			i.InstanceUUID, i.Region, dash(i.Owner), dash(i.InstanceType), i.RunHours, i.Total, i.Projected)
+	}
+
+	if len(r.Budgets) > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "BUDGET\tLIMIT\tSPENT\tPROJECTED\tSTATUS")
+		for _, b := range r.Budgets {
+			name := b.Scope
+			if b.Name != "" {
+				name += " " + b.Name
+			}
+			status := "ok"
+			if b.Exceeded {
+				status = "exceeded"
+			}
+			fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t%.2f\t%s\n", name, b.Limit, b.Spent, b.Projected, status)
+		}
+	}
+	return tw.Flush()
+}
+
+// trafficTotals 打印各统计对象的总流量
+func (p *printer) trafficTotals(totals []client.TrafficRollup) error {
+	if ok, err := p.structured(totals); ok {
+		return err
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tUPLINK\tDOWNLINK\tTOTAL")
+	for _, t := range totals {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.Name, formatBytes(t.Uplink), formatBytes(t.Downlink), formatBytes(t.Uplink+t.Downlink))
+	}
+	return tw.Flush()
+}
+
+// trafficUsage 打印一个统计对象的流量和时间序列
+func (p *printer) trafficUsage(u *client.TrafficUsage) error {
+	if ok, err := p.structured(u); ok {
+		return err
+	}
+	fmt.Fprintf(p.w, "%s %s from %s to %s: up %s, down %s\n\n",
+		u.Kind, u.Name, u.From, u.To, formatBytes(u.Uplink), formatBytes(u.Downlink))
+
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PERIOD\tUPLINK\tDOWNLINK")
+	for _, r := range u.Series {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Period, formatBytes(r.Uplink), formatBytes(r.Downlink))
+	}
+	return tw.Flush()
+}
+
+// formatBytes 以 B、KiB、MiB、GiB 或 TiB 显示字节数
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n)
+	for _, suffix := range []string{"KiB", "MiB", "GiB", "TiB"} {
+		value /= unit
+		if value < unit || suffix == "TiB" {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// dash 空字符串显示为 "-"
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
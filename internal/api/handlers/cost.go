@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/service"
+)
+
+type CostHandler struct {
+	service *service.CostService
+}
+
+// NewCostHandler 创建一个新的 CostHandler 实例
+// 参数:
+//   - service: CostService 实例，用于生成费用报告
+//
+// 返回值:
+//   - *CostHandler: 新创建的 CostHandler 实例
+func NewCostHandler(service *service.CostService) *CostHandler {
+	return &CostHandler{
+		service: service,
+	}
+}
+
+// Report 处理获取月度费用报告的 HTTP 请求
+// 参数:
+//   - c: Gin 上下文，用于处理 HTTP 请求和响应
+//
+// 功能:
+//  1. 解析 month 查询参数（YYYY-MM），默认为当月
+//  2. 返回按实例、区域和创建者汇总的费用、月底预测和预算使用情况
+func (h *CostHandler) Report(c *gin.Context) {
+	ctx := logging.WithRequestID(c.Request.Context())
+
+	month := time.Now()
+	if value := c.Query("month"); value != "" {
+		parsed, err := time.ParseInLocation("2006-01", value, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "month must be in YYYY-MM format"})
+			return
+		}
+		month = parsed
+	}
+
+	report, err := h.service.Report(ctx, month)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
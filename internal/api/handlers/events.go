@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/yuhai94/anywhere_backend/internal/events"
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+)
+
+// heartbeatInterval 事件流心跳间隔，防止代理断开空闲连接
+const heartbeatInterval = 15 * time.Second
+
+type EventsHandler struct {
+	bus       *events.Bus
+	upgrader  websocket.Upgrader
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewEventsHandler 创建一个新的 EventsHandler 实例
+// 参数:
+//   - bus: 事件总线，用于订阅实例生命周期事件
+//
+// 返回值:
+//   - *EventsHandler: 新创建的 EventsHandler 实例
+func NewEventsHandler(bus *events.Bus) *EventsHandler {
+	return &EventsHandler{
+		bus: bus,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		done: make(chan struct{}),
+	}
+}
+
+// Close 结束所有正在进行的事件流
+// 功能:
+//  1. 通知所有 SSE 和 WebSocket 连接退出，避免长连接阻塞服务器优雅关闭
+func (h *EventsHandler) Close() {
+	h.closeOnce.Do(func() {
+		close(h.done)
+	})
+}
+
+// StreamEvents 处理 Server-Sent Events 事件流请求
+// 参数:
+//   - c: Gin 上下文，用于处理 HTTP 请求和响应
+//
+// 功能:
+//  1. 解析订阅范围：路径参数 uuid 或查询参数 uuid，均为空时订阅所有实例
+//  2. 解析 Last-Event-ID 请求头（或 last_event_id 查询参数），重放之后的历史事件
+//  3. 持续推送实时事件，并定期发送心跳注释
+func (h *EventsHandler) StreamEvents(c *gin.Context) {
+	ctx := logging.WithRequestID(c.Request.Context())
+
+	instanceUUID, lastEventID, err := parseSubscription(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sub, backlog, err := h.bus.Subscribe(ctx, instanceUUID, lastEventID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to replay events: %v", err)})
+		return
+	}
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	logging.Info(ctx, "SSE subscriber connected, instance filter %q, last event ID %d", instanceUUID, lastEventID)
+
+	err = h.pumpEvents(ctx, sub, backlog, func(event *models.LifecycleEvent) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if event.ID > 0 {
+			fmt.Fprintf(c.Writer, "id: %d\n", event.ID)
+		}
+		if _, err := fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}, func() error {
+		if _, err := fmt.Fprint(c.Writer, ": heartbeat\n\n"); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+
+	logging.Info(ctx, "SSE subscriber disconnected: %v", err)
+}
+
+// WebSocketEvents 处理 WebSocket 事件流请求
+// 参数:
+//   - c: Gin 上下文，用于处理 HTTP 请求和响应
+//
+// 功能:
+//  1. 与 StreamEvents 使用相同的订阅范围和 last_event_id 参数
+//  2. 升级为 WebSocket 连接，每个事件以一条 JSON 文本消息发送
+//  3. 定期发送 ping 帧，客户端关闭连接时结束订阅
+func (h *EventsHandler) WebSocketEvents(c *gin.Context) {
+	ctx := logging.WithRequestID(c.Request.Context())
+
+	instanceUUID, lastEventID, err := parseSubscription(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		logging.Error(ctx, "Failed to upgrade websocket connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	sub, backlog, err := h.bus.Subscribe(ctx, instanceUUID, lastEventID)
+	if err != nil {
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "failed to replay events"))
+		return
+	}
+	defer sub.Close()
+
+	// 读取并丢弃客户端消息，以便及时感知连接关闭
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	logging.Info(ctx, "WebSocket subscriber connected, instance filter %q, last event ID %d", instanceUUID, lastEventID)
+
+	err = h.pumpEvents(ctx, sub, backlog, func(event *models.LifecycleEvent) error {
+		return conn.WriteJSON(event)
+	}, func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(5*time.Second))
+	})
+
+	logging.Info(ctx, "WebSocket subscriber disconnected: %v", err)
+}
+
+// parseSubscription 解析事件订阅参数
+// 参数:
+//   - c: Gin 上下文
+//
+// 返回值:
+//   - string: 实例 UUID，为空表示订阅所有实例
+//   - int64: 最后收到的事件 ID
+//   - error: 错误信息，如果参数格式错误
+func parseSubscription(c *gin.Context) (string, int64, error) {
+	instanceUUID := c.Param("uuid")
+	if instanceUUID == "" {
+		instanceUUID = c.Query("uuid")
+	}
+
+	raw := c.GetHeader("Last-Event-ID")
+	if raw == "" {
+		raw = c.Query("last_event_id")
+	}
+	if raw == "" {
+		return instanceUUID, 0, nil
+	}
+
+	lastEventID, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || lastEventID < 0 {
+		return "", 0, fmt.Errorf("invalid last event id %q", raw)
+	}
+	return instanceUUID, lastEventID, nil
+}
+
+// pumpEvents 先发送历史事件，再持续发送实时事件直到连接结束
+// 参数:
+//   - ctx: 请求上下文，取消时结束推送
+//   - sub: 事件订阅
+//   - backlog: 需要重放的历史事件
+//   - send: 发送单个事件的函数
+//   - heartbeat: 发送心跳的函数
+//
+// 返回值:
+//   - error: 结束原因
+func (h *EventsHandler) pumpEvents(ctx context.Context, sub *events.Subscription, backlog []*models.LifecycleEvent, send func(*models.LifecycleEvent) error, heartbeat func() error) error {
+	var lastSent int64
+	for _, event := range backlog {
+		if err := send(event); err != nil {
+			return err
+		}
+		lastSent = event.ID
+	}
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-h.done:
+			return fmt.Errorf("server shutting down")
+		case event, ok := <-sub.C:
+			if !ok {
+				return fmt.Errorf("subscription closed")
+			}
+			// 跳过重放期间已经发送过的事件
+			if event.ID > 0 && event.ID <= lastSent {
+				continue
+			}
+			if err := send(event); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := heartbeat(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+	"github.com/yuhai94/anywhere_backend/internal/service"
+)
+
+// defaultTrafficRange 未指定 from 时查询的时间范围
+const defaultTrafficRange = 24 * time.Hour
+
+type TrafficHandler struct {
+	service *service.TrafficService
+}
+
+// NewTrafficHandler 创建一个新的 TrafficHandler 实例
+// 参数:
+//   - service: TrafficService 实例，用于读取流量统计
+//
+// 返回值:
+//   - *TrafficHandler: 新创建的 TrafficHandler 实例
+func NewTrafficHandler(service *service.TrafficService) *TrafficHandler {
+	return &TrafficHandler{
+		service: service,
+	}
+}
+
+// InstanceTraffic 处理获取实例流量的 HTTP 请求
+// 参数:
+//   - c: Gin 上下文，用于处理 HTTP 请求和响应
+//
+// 功能:
+//  1. 解析 from、to（RFC 3339 或 YYYY-MM-DD）和 granularity（hour 或 day）查询参数，默认为最近 24 小时按小时汇总
+//  2. 返回实例的上下行流量和时间序列
+func (h *TrafficHandler) InstanceTraffic(c *gin.Context) {
+	ctx := logging.WithRequestID(c.Request.Context())
+
+	from, to, err := parseTrafficRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	usage, err := h.service.InstanceUsage(ctx, c.Param("uuid"), from, to, c.DefaultQuery("granularity", service.GranularityHour))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, service.ErrInstanceNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+// UserTraffic 处理获取中转用户流量的 HTTP 请求
+// 参数:
+//   - c: Gin 上下文，用于处理 HTTP 请求和响应
+//
+// 功能:
+//  1. 路径参数为中转入站中用户的 email，查询参数与 InstanceTraffic 相同
+//  2. 返回用户的上下行流量和时间序列
+func (h *TrafficHandler) UserTraffic(c *gin.Context) {
+	ctx := logging.WithRequestID(c.Request.Context())
+
+	from, to, err := parseTrafficRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	usage, err := h.service.Usage(ctx, models.TrafficUser, c.Param("email"), from, to, c.DefaultQuery("granularity", service.GranularityHour))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+// ListTraffic 处理获取流量汇总的 HTTP 请求
+// 参数:
+//   - c: Gin 上下文，用于处理 HTTP 请求和响应
+//
+// 功能:
+//  1. 解析 kind（outbound、user、instance，默认 instance）和 from、to 查询参数
+//  2. 返回该类型每个统计对象在时间段内的总流量
+func (h *TrafficHandler) ListTraffic(c *gin.Context) {
+	ctx := logging.WithRequestID(c.Request.Context())
+
+	from, to, err := parseTrafficRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	totals, err := h.service.Totals(ctx, c.DefaultQuery("kind", models.TrafficInstance), from, to)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, totals)
+}
+
+// parseTrafficRange 解析 from 和 to 查询参数
+// 返回值:
+//   - time.Time: 开始时间，默认为 to 之前 24 小时
+//   - time.Time: 结束时间，默认为当前时间
+//   - error: 错误信息，如果时间格式无效
+func parseTrafficRange(c *gin.Context) (time.Time, time.Time, error) {
+	to := time.Now()
+	if value := c.Query("to"); value != "" {
+		parsed, err := parseTrafficTime(value)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("to: %v", err)
+		}
+		to = parsed
+	}
+
+	from := to.Add(-defaultTrafficRange)
+	if value := c.Query("from"); value != "" {
+		parsed, err := parseTrafficTime(value)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("from: %v", err)
+		}
+		from = parsed
+	}
+	return from, to, nil
+}
+
+// parseTrafficTime 解析 RFC 3339 时间或 YYYY-MM-DD 日期（服务所在时区的零点）
+func parseTrafficTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("must be RFC 3339 or YYYY-MM-DD")
+	}
+	return t, nil
+}
@@ -1,10 +1,21 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/yuhai94/anywhere_backend/internal/api/middleware"
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/localv2ray"
 	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+	"github.com/yuhai94/anywhere_backend/internal/qrcode"
 	"github.com/yuhai94/anywhere_backend/internal/service"
 )
 
@@ -26,6 +37,10 @@ func NewV2RayHandler(service *service.V2RayService) *V2RayHandler {
 
 type CreateInstanceRequest struct {
 	Region string `json:"region" binding:"required"`
+	// Owner 创建者，用于按创建者统计费用和预算；使用创建者的 API key 时由 key 决定，可以省略
+	Owner string `json:"owner"`
+	// Protocol 节点协议，vmess 或 vless-reality，为空时使用 v2ray.protocol
+	Protocol string `json:"protocol" binding:"omitempty,oneof=vmess vless-reality"`
 }
 
 type CreateInstanceResponse struct {
@@ -42,9 +57,10 @@ type DeleteInstanceResponse struct {
 //   - c: Gin 上下文，用于处理 HTTP 请求和响应
 //
 // 功能:
-//  1. 解析请求体中的区域信息
-//  2. 调用服务层创建实例
-//  3. 返回创建的实例 UUID 和状态
+//  1. 解析请求体中的区域、创建者和协议
+//  2. 确定创建者，不允许以其他创建者的身份创建时返回 403
+//  3. 调用服务层创建实例，超出预算时返回 403
+//  4. 返回创建的实例 UUID 和状态
 func (h *V2RayHandler) CreateInstance(c *gin.Context) {
 	ctx := logging.WithRequestID(c.Request.Context())
 
@@ -54,9 +70,19 @@ func (h *V2RayHandler) CreateInstance(c *gin.Context) {
 		return
 	}
 
+	owner, err := requestOwner(c, req.Owner)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
 	logging.Info(ctx, "Creating instance in region %s", req.Region)
 
-	uuid, err := h.service.CreateInstance(ctx, req.Region)
+	uuid, err := h.service.CreateInstance(ctx, req.Region, owner, req.Protocol)
+	if errors.Is(err, service.ErrBudgetExceeded) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -68,23 +94,104 @@ func (h *V2RayHandler) CreateInstance(c *gin.Context) {
 	})
 }
 
-// ListInstances 处理获取所有 V2Ray 实例列表的 HTTP 请求
+// requestOwner 确定创建请求的创建者
+// 参数:
+//   - c: Gin 上下文，包含 API key 认证的结果
+//   - requested: 请求体中的创建者
+//
+// 返回值:
+//   - string: 创建者，可以为空
+//   - error: 错误信息，如果请求以其他创建者的身份创建
+//
+// 功能:
+//  1. 使用创建者的 API key 时以该创建者创建，请求体中的创建者必须为空或与之相同
+//  2. 配置了 server.owners 时，创建者只能来自 API key，共享 key 不能指定创建者
+//  3. 只配置了 cost.budget.owners 时，请求体中的创建者必须是其中之一，避免使用其他名称绕过按创建者的预算
+func requestOwner(c *gin.Context, requested string) (string, error) {
+	if owner := middleware.Owner(c); owner != "" {
+		if requested != "" && requested != owner {
+			return "", fmt.Errorf("the api key belongs to owner %q, cannot create as %q", owner, requested)
+		}
+		return owner, nil
+	}
+	if requested == "" {
+		return "", nil
+	}
+
+	cfg := config.Get()
+	if len(cfg.Server.Owners) > 0 {
+		return "", fmt.Errorf("owner %q must authenticate with its own api key", requested)
+	}
+	if len(cfg.Cost.Budget.Owners) > 0 {
+		if _, ok := cfg.Cost.Budget.Owners[requested]; !ok {
+			return "", fmt.Errorf("unknown owner %q, expected one of cost.budget.owners", requested)
+		}
+	}
+	return requested, nil
+}
+
+// ListInstances 处理分页获取 V2Ray 实例列表的 HTTP 请求
 // 参数:
 //   - c: Gin 上下文，用于处理 HTTP 请求和响应
 //
 // 功能:
-//  1. 调用服务层获取实例列表
-//  2. 返回实例列表
+//  1. 解析查询参数 status（逗号分隔）、region、owner、created_after（RFC 3339 或 YYYY-MM-DD）、sort、limit 和 cursor
+//  2. 解析 include_deleted 和 deleted_only，默认只返回未删除的实例
+//  3. 调用服务层获取一页实例，参数无效时返回 400
+//  4. 返回包含 instances、next_cursor 和 total 的分页结果
 func (h *V2RayHandler) ListInstances(c *gin.Context) {
 	ctx := logging.WithRequestID(c.Request.Context())
 
-	instances, err := h.service.ListInstances(ctx)
+	query := models.InstanceQuery{
+		Region: c.Query("region"),
+		Owner:  c.Query("owner"),
+		Sort:   c.Query("sort"),
+		Cursor: c.Query("cursor"),
+	}
+	if value := c.Query("status"); value != "" {
+		query.Statuses = strings.Split(value, ",")
+	}
+	if value := c.Query("created_after"); value != "" {
+		createdAfter, err := parseTrafficTime(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("created_after: %v", err)})
+			return
+		}
+		query.CreatedAfter = createdAfter
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be an integer"})
+			return
+		}
+		query.Limit = limit
+	}
+	for name, target := range map[string]*bool{
+		"include_deleted": &query.IncludeDeleted,
+		"deleted_only":    &query.DeletedOnly,
+	} {
+		if value := c.Query(name); value != "" {
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be a boolean"})
+				return
+			}
+			*target = enabled
+		}
+	}
+
+	page, err := h.service.ListInstancesPage(ctx, query)
+	if errors.Is(err, service.ErrInvalidListQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, instances)
+	c.JSON(http.StatusOK, page)
 }
 
 // GetInstance 处理获取指定 V2Ray 实例详情的 HTTP 请求
@@ -108,6 +215,30 @@ func (h *V2RayHandler) GetInstance(c *gin.Context) {
 	c.JSON(http.StatusOK, instance)
 }
 
+// InstanceTimeline 处理获取实例历史的 HTTP 请求，已删除的实例同样可以查询
+// 参数:
+//   - c: Gin 上下文，用于处理 HTTP 请求和响应
+//
+// 功能:
+//  1. 解析路径参数中的实例 ID
+//  2. 调用服务层获取实例记录和每次状态变化的时间、原因
+//  3. 实例不存在时返回 404
+func (h *V2RayHandler) InstanceTimeline(c *gin.Context) {
+	ctx := logging.WithRequestID(c.Request.Context())
+
+	timeline, err := h.service.InstanceTimeline(ctx, c.Param("uuid"))
+	if errors.Is(err, service.ErrInstanceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, timeline)
+}
+
 // DeleteInstance 处理删除指定 V2Ray 实例的 HTTP 请求
 // 参数:
 //   - c: Gin 上下文，用于处理 HTTP 请求和响应
@@ -143,3 +274,181 @@ func (h *V2RayHandler) ListRegions(c *gin.Context) {
 	regions := h.service.ListRegions(ctx)
 	c.JSON(http.StatusOK, regions)
 }
+
+type RollbackRelayConfigRequest struct {
+	// Relay 中转名称，只配置了一个中转时可以为空
+	Relay string `json:"relay"`
+	// Backup 备份文件名，为空时恢复最新的备份
+	Backup string `json:"backup"`
+}
+
+// ListRelayBackups 处理获取中转 V2Ray 配置备份列表的 HTTP 请求
+// 参数:
+//   - c: Gin 上下文，用于处理 HTTP 请求和响应
+//
+// 功能:
+//  1. 返回每个中转按时间从新到旧排列的备份，未配置中转时返回 404
+func (h *V2RayHandler) ListRelayBackups(c *gin.Context) {
+	ctx := logging.WithRequestID(c.Request.Context())
+
+	backups, err := h.service.RelayBackups(ctx)
+	if errors.Is(err, service.ErrLocalV2RayDisabled) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, backups)
+}
+
+// RollbackRelayConfig 处理回滚中转 V2Ray 配置的 HTTP 请求
+// 参数:
+//   - c: Gin 上下文，用于处理 HTTP 请求和响应
+//
+// 功能:
+//  1. 解析请求体中的中转名称和备份文件名，请求体为空时恢复唯一中转的最新备份
+//  2. 调用服务层校验并恢复备份，重启该中转的 V2Ray
+//  3. 返回恢复的备份，中转或备份不存在、未配置中转时返回 404
+func (h *V2RayHandler) RollbackRelayConfig(c *gin.Context) {
+	ctx := logging.WithRequestID(c.Request.Context())
+
+	var req RollbackRelayConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	backup, err := h.service.RollbackRelayConfig(ctx, req.Relay, req.Backup)
+	if errors.Is(err, service.ErrLocalV2RayDisabled) || errors.Is(err, service.ErrRelayNotFound) || errors.Is(err, localv2ray.ErrBackupNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, backup)
+}
+
+// maxQRCodeSize PNG 二维码允许的最大边长（像素）
+const maxQRCodeSize = 2048
+
+// InstanceQRCode 处理获取实例分享链接二维码的 HTTP 请求
+// 参数:
+//   - c: Gin 上下文，用于处理 HTTP 请求和响应
+//
+// 功能:
+//  1. 解析查询参数 kind（direct 或 relay，默认 direct），配置了多个中转时查询参数 relay 选择第几个中转链接（从 0 开始）
+//  2. 获取实例对应的分享链接，链接尚未生成时返回 404
+//  3. 按查询参数 format（png、svg 或 ansi）渲染二维码
+func (h *V2RayHandler) InstanceQRCode(c *gin.Context) {
+	ctx := logging.WithRequestID(c.Request.Context())
+
+	uuid := c.Param("uuid")
+	kind := c.DefaultQuery("kind", service.LinkKindDirect)
+	if kind != service.LinkKindDirect && kind != service.LinkKindRelay {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid kind %q", kind)})
+		return
+	}
+
+	index := 0
+	if value := c.Query("relay"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid relay %q", value)})
+			return
+		}
+		index = parsed
+	}
+
+	link, err := h.service.GetInstanceLink(ctx, uuid, kind, index)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	writeQRCode(c, link)
+}
+
+// Subscription 处理获取订阅内容的 HTTP 请求
+// 参数:
+//   - c: Gin 上下文，用于处理 HTTP 请求和响应
+//
+// 功能:
+//  1. 解析查询参数 kind，为空时包含全部链接
+//  2. 返回 base64 编码的订阅内容，可直接作为客户端的订阅地址
+func (h *V2RayHandler) Subscription(c *gin.Context) {
+	ctx := logging.WithRequestID(c.Request.Context())
+
+	content, err := h.service.Subscription(ctx, c.Query("kind"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(content))
+}
+
+// SubscriptionQRCode 处理获取订阅地址二维码的 HTTP 请求
+// 参数:
+//   - c: Gin 上下文，用于处理 HTTP 请求和响应
+//
+// 功能:
+//  1. 根据当前请求的协议和主机拼出订阅地址，保留 kind 和 api_key 参数
+//  2. 按查询参数 format 渲染订阅地址的二维码
+func (h *V2RayHandler) SubscriptionQRCode(c *gin.Context) {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.Split(proto, ",")[0]
+	}
+
+	// 保留 kind 和 api_key，使扫码得到的地址可以直接访问
+	query := url.Values{}
+	for _, key := range []string{"kind", "api_key"} {
+		if value := c.Query(key); value != "" {
+			query.Set(key, value)
+		}
+	}
+	subscriptionURL := fmt.Sprintf("%s://%s%s", scheme, c.Request.Host, strings.TrimSuffix(c.Request.URL.Path, "/qr"))
+	if len(query) > 0 {
+		subscriptionURL += "?" + query.Encode()
+	}
+
+	writeQRCode(c, subscriptionURL)
+}
+
+// writeQRCode 按查询参数渲染二维码并写入响应
+// 参数:
+//   - c: Gin 上下文
+//   - content: 要编码的内容
+//
+// 功能:
+//  1. 查询参数 format 指定输出格式：png（默认）、svg 或 ansi（终端输出）
+//  2. 查询参数 size 指定 PNG 边长，默认 qrcode.DefaultSize
+func writeQRCode(c *gin.Context, content string) {
+	size := qrcode.DefaultSize
+	if raw := c.Query("size"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 || parsed > maxQRCodeSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid size %q", raw)})
+			return
+		}
+		size = parsed
+	}
+
+	data, contentType, err := qrcode.Render(content, c.Query("format"), size)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, contentType, data)
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuhai94/anywhere_backend/internal/config"
+)
+
+// TestRequestOwner 创建者来自 API key；没有创建者的 key 时不能冒用其他创建者或使用未知的创建者绕过预算
+func TestRequestOwner(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	previous := config.Get()
+	defer config.Set(previous)
+
+	ownerKeys := config.Config{Server: config.ServerConfig{Owners: map[string]config.ServerOwnerConfig{
+		"alice": {APIKeys: []string{"alice-key"}},
+	}}}
+	ownerBudgets := config.Config{Cost: config.CostConfig{Budget: config.BudgetConfig{Owners: map[string]float64{"alice": 10}}}}
+
+	tests := []struct {
+		name      string
+		cfg       config.Config
+		keyOwner  string
+		requested string
+		want      string
+		wantErr   bool
+	}{
+		{"no owner", config.Config{}, "", "", "", false},
+		{"free text without budgets", config.Config{}, "", "bob", "bob", false},
+		{"from api key", ownerKeys, "alice", "", "alice", false},
+		{"matches api key", ownerKeys, "alice", "alice", "alice", false},
+		{"other than api key", ownerKeys, "alice", "bob", "", true},
+		{"shared key with owner keys", ownerKeys, "", "alice", "", true},
+		{"shared key without owner", ownerKeys, "", "", "", false},
+		{"known budget owner", ownerBudgets, "", "alice", "alice", false},
+		{"unknown budget owner", ownerBudgets, "", "bob", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			config.Set(&cfg)
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			if tt.keyOwner != "" {
+				c.Set("auth.owner", tt.keyOwner)
+			}
+
+			got, err := requestOwner(c, tt.requested)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("requestOwner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("requestOwner() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/service"
+)
+
+type WebhookHandler struct {
+	service *service.WebhookService
+}
+
+// NewWebhookHandler 创建一个新的 WebhookHandler 实例
+// 参数:
+//   - service: WebhookService 实例，用于处理 webhook 相关的业务逻辑
+//
+// 返回值:
+//   - *WebhookHandler: 新创建的 WebhookHandler 实例
+func NewWebhookHandler(service *service.WebhookService) *WebhookHandler {
+	return &WebhookHandler{
+		service: service,
+	}
+}
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url" binding:"required"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// CreateWebhook 处理注册 webhook 的 HTTP 请求
+// 参数:
+//   - c: Gin 上下文，用于处理 HTTP 请求和响应
+//
+// 功能:
+//  1. 解析请求体中的回调地址、事件类型和密钥
+//  2. 调用服务层注册 webhook
+//  3. 返回创建的 webhook，包含签名密钥
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	ctx := logging.WithRequestID(c.Request.Context())
+
+	var req CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hook, err := h.service.CreateWebhook(ctx, req.URL, req.Events, req.Secret)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, hook)
+}
+
+// ListWebhooks 处理获取 webhook 列表的 HTTP 请求
+// 参数:
+//   - c: Gin 上下文，用于处理 HTTP 请求和响应
+func (h *WebhookHandler) ListWebhooks(c *gin.Context) {
+	ctx := logging.WithRequestID(c.Request.Context())
+
+	hooks, err := h.service.ListWebhooks(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, hooks)
+}
+
+// DeleteWebhook 处理删除 webhook 的 HTTP 请求
+// 参数:
+//   - c: Gin 上下文，用于处理 HTTP 请求和响应
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	ctx := logging.WithRequestID(c.Request.Context())
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+		return
+	}
+
+	if err := h.service.DeleteWebhook(ctx, id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// ListDeliveries 处理获取 webhook 投递日志的 HTTP 请求
+// 参数:
+//   - c: Gin 上下文，用于处理 HTTP 请求和响应
+//
+// 功能:
+//  1. 解析路径参数中的 webhook ID 和查询参数 limit
+//  2. 返回最近的投递记录
+func (h *WebhookHandler) ListDeliveries(c *gin.Context) {
+	ctx := logging.WithRequestID(c.Request.Context())
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	deliveries, err := h.service.ListDeliveries(ctx, id, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
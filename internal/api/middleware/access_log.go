@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+)
+
+// AccessLog 返回对敏感值脱敏的 Gin 访问日志中间件
+// 返回值:
+//   - gin.HandlerFunc: 中间件，输出格式与 gin.Logger 相同
+//
+// 功能:
+//  1. 请求路径中可能带有 api_key 等查询参数，输出前使用 logging.Redact 脱敏
+func AccessLog() gin.HandlerFunc {
+	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
+		return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+			param.StatusCode,
+			param.Latency,
+			param.ClientIP,
+			param.Method,
+			logging.Redact(param.Path),
+			logging.Redact(param.ErrorMessage),
+		)
+	})
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuhai94/anywhere_backend/internal/config"
+)
+
+// ownerContextKey Gin 上下文中通过 API key 认证得到的创建者的键
+const ownerContextKey = "auth.owner"
+
+// APIKeyAuth 返回校验 API key 的 Gin 中间件
+// 参数:
+//   - server: 返回服务配置的函数，每个请求调用一次以便配置重新加载后生效；没有配置任何 key 时不做校验
+//
+// 返回值:
+//   - gin.HandlerFunc: 中间件
+//
+// 功能:
+//  1. 依次从 Authorization: Bearer、X-API-Key 请求头和 api_key 查询参数中读取 key
+//  2. 查询参数用于无法设置请求头的场景，例如浏览器 EventSource 和客户端订阅地址
+//  3. 接受 server.api_keys 和 server.owners.*.api_keys 中的 key，使用创建者的 key 时记录创建者，通过 Owner 读取
+//  4. 使用常量时间比较，校验失败时返回 401
+func APIKeyAuth(server func() config.ServerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := server()
+		if !cfg.AuthEnabled() {
+			c.Next()
+			return
+		}
+
+		key := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if key == "" {
+			key = c.GetHeader("X-API-Key")
+		}
+		if key == "" {
+			key = c.Query("api_key")
+		}
+
+		if key != "" {
+			for owner, ownerConfig := range cfg.Owners {
+				if matchKey(key, ownerConfig.APIKeys) {
+					c.Set(ownerContextKey, owner)
+					c.Next()
+					return
+				}
+			}
+			if matchKey(key, cfg.APIKeys) {
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or missing api key"})
+	}
+}
+
+// matchKey 使用常量时间比较判断 key 是否在允许的列表中
+func matchKey(key string, allowed []string) bool {
+	for _, candidate := range allowed {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(candidate)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Owner 返回请求的 API key 所属的创建者
+// 参数:
+//   - c: Gin 上下文
+//
+// 返回值:
+//   - string: 创建者名称，使用 server.api_keys 中的共享 key 或未启用认证时为空
+func Owner(c *gin.Context) string {
+	return c.GetString(ownerContextKey)
+}
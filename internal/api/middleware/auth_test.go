@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuhai94/anywhere_backend/internal/config"
+)
+
+// TestAPIKeyAuth 共享 key 不带创建者，创建者的 key 记录创建者，其他 key 返回 401
+func TestAPIKeyAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := config.ServerConfig{
+		APIKeys: []string{"shared"},
+		Owners: map[string]config.ServerOwnerConfig{
+			"alice": {APIKeys: []string{"alice-key"}},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		server    config.ServerConfig
+		header    string
+		wantCode  int
+		wantOwner string
+	}{
+		{"auth disabled", config.ServerConfig{}, "", http.StatusOK, ""},
+		{"missing key", server, "", http.StatusUnauthorized, ""},
+		{"wrong key", server, "Bearer nope", http.StatusUnauthorized, ""},
+		{"shared key", server, "Bearer shared", http.StatusOK, ""},
+		{"owner key", server, "Bearer alice-key", http.StatusOK, "alice"},
+		{"owner keys only", config.ServerConfig{Owners: server.Owners}, "Bearer alice-key", http.StatusOK, "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			var owner string
+			router.Use(APIKeyAuth(func() config.ServerConfig { return tt.server }))
+			router.GET("/", func(c *gin.Context) {
+				owner = Owner(c)
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if owner != tt.wantOwner {
+				t.Errorf("owner = %q, want %q", owner, tt.wantOwner)
+			}
+		})
+	}
+}
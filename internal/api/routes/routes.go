@@ -3,23 +3,53 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/yuhai94/anywhere_backend/internal/api/handlers"
+	"github.com/yuhai94/anywhere_backend/internal/api/middleware"
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/metrics"
 )
 
 // SetupRoutes 设置 API 路由
 // 参数:
 //   - router: Gin 路由器实例
 //   - v2rayHandler: V2RayHandler 实例，用于处理 V2Ray 相关的请求
+//   - eventsHandler: EventsHandler 实例，用于处理实例生命周期事件流
+//   - webhookHandler: WebhookHandler 实例，用于处理 webhook 管理请求
+//   - costHandler: CostHandler 实例，用于处理费用报告请求
+//   - trafficHandler: TrafficHandler 实例，用于处理流量统计请求
 //
 // 功能:
-//  1. 创建 API 路由组
+//  1. 创建 API 路由组，配置了 server.api_keys 或 server.owners 时要求请求携带 API key
 //  2. 为 V2Ray 相关操作设置路由
 //     - GET /api/v2ray/regions: 获取支持的区域列表
 //     - POST /api/v2ray/instances: 创建实例
 //     - GET /api/v2ray/instances: 获取实例列表
 //     - GET /api/v2ray/instances/:id: 获取实例详情
 //     - DELETE /api/v2ray/instances/:id: 删除实例
-func SetupRoutes(router *gin.Engine, v2rayHandler *handlers.V2RayHandler) {
+//     - GET /api/v2ray/instances/:id/qr: 获取实例分享链接的二维码
+//     - GET /api/v2ray/instances/:id/events: 订阅单个实例的事件流（SSE）
+//     - GET /api/v2ray/instances/:id/traffic: 获取实例的中转流量
+//     - GET /api/v2ray/subscription: 获取订阅内容
+//     - GET /api/v2ray/subscription/qr: 获取订阅地址的二维码
+//     - GET /api/v2ray/events: 订阅所有实例的事件流（SSE）
+//     - GET /api/v2ray/events/ws: 通过 WebSocket 订阅事件流
+//     - GET /api/v2ray/relay/backups: 获取各个中转 V2Ray 配置的备份列表
+//     - POST /api/v2ray/relay/rollback: 将一个中转的 V2Ray 配置恢复为备份
+//  3. 为 webhook 管理设置路由
+//     - POST /api/webhooks: 注册 webhook
+//     - GET /api/webhooks: 获取 webhook 列表
+//     - DELETE /api/webhooks/:id: 删除 webhook
+//     - GET /api/webhooks/:id/deliveries: 获取投递日志
+//  4. 为费用报告设置路由
+//     - GET /api/costs: 获取月度费用报告
+//  5. 为流量统计设置路由
+//     - GET /api/traffic: 获取出站、用户或实例的流量汇总
+//     - GET /api/traffic/users/:email: 获取中转用户的流量
+//  6. 设置 Prometheus 指标路由 GET /metrics
+func SetupRoutes(router *gin.Engine, v2rayHandler *handlers.V2RayHandler, eventsHandler *handlers.EventsHandler, webhookHandler *handlers.WebhookHandler, costHandler *handlers.CostHandler, trafficHandler *handlers.TrafficHandler) {
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+
 	api := router.Group("/api")
+	api.Use(middleware.APIKeyAuth(func() config.ServerConfig { return config.Get().Server }))
 	{
 		v2ray := api.Group("/v2ray")
 		{
@@ -28,6 +58,32 @@ func SetupRoutes(router *gin.Engine, v2rayHandler *handlers.V2RayHandler) {
 			v2ray.GET("/instances", v2rayHandler.ListInstances)
 			v2ray.GET("/instances/:uuid", v2rayHandler.GetInstance)
 			v2ray.DELETE("/instances/:uuid", v2rayHandler.DeleteInstance)
+			v2ray.GET("/instances/:uuid/qr", v2rayHandler.InstanceQRCode)
+			v2ray.GET("/instances/:uuid/timeline", v2rayHandler.InstanceTimeline)
+			v2ray.GET("/instances/:uuid/events", eventsHandler.StreamEvents)
+			v2ray.GET("/instances/:uuid/traffic", trafficHandler.InstanceTraffic)
+			v2ray.GET("/subscription", v2rayHandler.Subscription)
+			v2ray.GET("/subscription/qr", v2rayHandler.SubscriptionQRCode)
+			v2ray.GET("/events", eventsHandler.StreamEvents)
+			v2ray.GET("/events/ws", eventsHandler.WebSocketEvents)
+			v2ray.GET("/relay/backups", v2rayHandler.ListRelayBackups)
+			v2ray.POST("/relay/rollback", v2rayHandler.RollbackRelayConfig)
+		}
+
+		webhooks := api.Group("/webhooks")
+		{
+			webhooks.POST("", webhookHandler.CreateWebhook)
+			webhooks.GET("", webhookHandler.ListWebhooks)
+			webhooks.DELETE("/:id", webhookHandler.DeleteWebhook)
+			webhooks.GET("/:id/deliveries", webhookHandler.ListDeliveries)
+		}
+
+		api.GET("/costs", costHandler.Report)
+
+		traffic := api.Group("/traffic")
+		{
+			traffic.GET("", trafficHandler.ListTraffic)
+			traffic.GET("/users/:email", trafficHandler.UserTraffic)
 		}
 	}
 }
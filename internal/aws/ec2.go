@@ -4,55 +4,149 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	appconfig "github.com/yuhai94/anywhere_backend/internal/config"
 	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/metrics"
 	"github.com/yuhai94/anywhere_backend/internal/models"
+	"github.com/yuhai94/anywhere_backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type EC2Client struct {
-	clients map[string]*ec2.Client
+	mu      sync.Mutex
+	clients map[string]*regionClient
 }
 
+// regionClient 单个账号和区域的 EC2 客户端及创建时使用的凭证
+type regionClient struct {
+	client  *ec2.Client
+	account appconfig.AWSAccountConfig
+}
+
+// roleSessionName 扮演角色时使用的会话名，便于在 CloudTrail 中识别
+const roleSessionName = "anywhere-backend"
+
 // NewEC2Client 创建一个新的 EC2Client 实例
 // 返回值:
 //   - *EC2Client: 新创建的 EC2Client 实例
 //   - error: 错误信息，如果创建失败
 //
 // 功能:
-//  1. 为配置文件中定义的每个 AWS 区域创建 EC2 客户端
-//  2. 返回包含所有区域客户端的 EC2Client 实例
+//  1. 为配置文件中定义的每个 AWS 区域及其账号创建 EC2 客户端，尽早发现配置问题
+//  2. 配置重新加载后新增的账号和区域在首次使用时再创建客户端
 func NewEC2Client() (*EC2Client, error) {
-	clients := make(map[string]*ec2.Client)
-
-	for region := range appconfig.AppConfig.AWS.Regions {
-		cfg, err := awsconfig.LoadDefaultConfig(context.Background(),
-			awsconfig.WithRegion(region),
-			awsconfig.WithCredentialsProvider(credentials.StaticCredentialsProvider{
-				Value: aws.Credentials{
-					AccessKeyID: appconfig.AppConfig.AWS.AccessKey, SecretAccessKey: appconfig.AppConfig.AWS.SecretKey,
-				},
-			}))
-
-		if err != nil {
-			return nil, fmt.Errorf("failed to load config for region %s: %v", region, err)
+	e := &EC2Client{clients: make(map[string]*regionClient)}
+	cfg := appconfig.Get()
+	for region := range cfg.AWS.Regions {
+		if _, err := e.client(cfg.AWS.RegionAccount(region), region); err != nil {
+			return nil, err
 		}
+	}
+	return e, nil
+}
 
-		clients[region] = ec2.NewFromConfig(cfg)
+// client 获取指定账号和区域的 EC2 客户端
+// 参数:
+//   - account: 账号名，为空时使用 default
+//   - region: AWS 区域
+//
+// 返回值:
+//   - *ec2.Client: 区域的 EC2 客户端
+//   - error: 错误信息，如果账号或区域未配置或创建客户端失败
+//
+// 功能:
+//  1. 只为当前配置中存在的账号和区域返回客户端，已移除的视为未配置
+//  2. 客户端不存在或账号凭证配置已变化时重新创建
+//  3. 配置了 access_key 时使用静态凭证，否则使用 SDK 默认凭证链
+//  4. 配置了 role_arn 时使用上述凭证扮演该角色，临时凭证过期前自动刷新
+func (e *EC2Client) client(account string, region string) (*ec2.Client, error) {
+	if account == "" {
+		account = appconfig.DefaultAccount
+	}
+	cfg := appconfig.Get()
+	if _, ok := cfg.AWS.Regions[region]; !ok {
+		return nil, fmt.Errorf("no client configured for region %s", region)
+	}
+	want, ok := cfg.AWS.Account(account)
+	if !ok {
+		return nil, fmt.Errorf("no AWS account %s configured", account)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	key := account + "/" + region
+	if c, ok := e.clients[key]; ok && c.account == want {
+		return c.client, nil
+	}
+
+	opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(region)}
+	if want.AccessKey != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(credentials.StaticCredentialsProvider{
+			Value: aws.Credentials{
+				AccessKeyID: want.AccessKey, SecretAccessKey: want.SecretKey,
+			},
+		}))
+	} else if want.Profile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(want.Profile))
+	}
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config for account %s region %s: %v", account, region, err)
 	}
 
-	return &EC2Client{clients: clients}, nil
+	if want.RoleARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), want.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = roleSessionName
+			if want.ExternalID != "" {
+				o.ExternalID = aws.String(want.ExternalID)
+			}
+		})
+		awsCfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+
+	e.clients[key] = &regionClient{client: ec2.NewFromConfig(awsCfg), account: want}
+	return e.clients[key].client, nil
+}
+
+// startCall 开始一次 EC2 API 调用的追踪和计时
+// 参数:
+//   - ctx: 上下文
+//   - operation: EC2 操作类型，与 logging.EC2Log 中的操作名一致
+//   - region: AWS 区域
+//
+// 返回值:
+//   - context.Context: 包含 EC2 调用 span 的上下文，应传给 SDK 调用
+//   - func(error): 调用结束后执行，记录耗时指标并结束 span
+func startCall(ctx context.Context, operation, region string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "ec2."+operation,
+		attribute.String("rpc.system", "aws-api"),
+		attribute.String("rpc.service", "EC2"),
+		attribute.String("cloud.region", region),
+	)
+	return ctx, func(err error) {
+		metrics.ObserveEC2Call(operation, region, start, err)
+		tracing.RecordError(span, err)
+		span.End()
+	}
 }
 
 // CreateInstance 创建 EC2 实例
 // 参数:
 //   - ctx: 上下文，用于传递请求范围的值和取消信号
+//   - account: AWS 账号名，为空时使用 default
 //   - region: AWS 区域
 //   - userData: 实例启动时执行的用户数据
 //
@@ -61,14 +155,14 @@ func NewEC2Client() (*EC2Client, error) {
 //   - error: 错误信息，如果创建失败
 //
 // 功能:
-//  1. 获取指定区域的 EC2 客户端
+//  1. 获取指定账号和区域的 EC2 客户端
 //  2. 获取区域配置信息
 //  3. 使用启动模板创建 EC2 实例
 //  4. 返回创建的实例 ID
-func (e *EC2Client) CreateInstance(ctx context.Context, region string, userData string, uuid string) (string, error) {
-	client, ok := e.clients[region]
-	if !ok {
-		return "", fmt.Errorf("no client configured for region %s", region)
+func (e *EC2Client) CreateInstance(ctx context.Context, account string, region string, userData string, uuid string) (string, error) {
+	client, err := e.client(account, region)
+	if err != nil {
+		return "", err
 	}
 
 	regionConfig, err := appconfig.GetRegionConfig(region)
@@ -98,7 +192,9 @@ func (e *EC2Client) CreateInstance(ctx context.Context, region string, userData
 		},
 	}
 
-	resp, err := client.RunInstances(ctx, input)
+	callCtx, finish := startCall(ctx, "run_instances", region)
+	resp, err := client.RunInstances(callCtx, input)
+	finish(err)
 	if err != nil {
 		logging.EC2Log(ctx, "run_instances", region, "", map[string]interface{}{
 			"launch_template_id": regionConfig.TemplateID,
@@ -122,6 +218,7 @@ func (e *EC2Client) CreateInstance(ctx context.Context, region string, userData
 // WaitForInstanceRunning 等待 EC2 实例变为运行状态
 // 参数:
 //   - ctx: 上下文，用于传递请求范围的值和取消信号
+//   - account: AWS 账号名，为空时使用 default
 //   - region: AWS 区域
 //   - instanceID: EC2 实例 ID
 //
@@ -129,15 +226,15 @@ func (e *EC2Client) CreateInstance(ctx context.Context, region string, userData
 //   - error: 错误信息，如果等待失败或超时
 //
 // 功能:
-//  1. 获取指定区域的 EC2 客户端
+//  1. 获取指定账号和区域的 EC2 客户端
 //  2. 循环检查实例状态
 //  3. 当实例变为运行状态时返回成功
 //  4. 当实例变为终止或关闭状态时返回错误
 //  5. 当等待超过 5 分钟时返回超时错误
-func (e *EC2Client) WaitForInstanceRunning(ctx context.Context, region string, instanceID string) error {
-	client, ok := e.clients[region]
-	if !ok {
-		return fmt.Errorf("no client configured for region %s", region)
+func (e *EC2Client) WaitForInstanceRunning(ctx context.Context, account string, region string, instanceID string) error {
+	client, err := e.client(account, region)
+	if err != nil {
+		return err
 	}
 
 	logging.Info(ctx, "Waiting for instance %s in region %s to be running", instanceID, region)
@@ -148,7 +245,9 @@ func (e *EC2Client) WaitForInstanceRunning(ctx context.Context, region string, i
 			InstanceIds: []string{instanceID},
 		}
 
-		resp, err := client.DescribeInstances(ctx, input)
+		callCtx, finish := startCall(ctx, "describe_instances", region)
+		resp, err := client.DescribeInstances(callCtx, input)
+		finish(err)
 		if err != nil {
 			logging.EC2Log(ctx, "describe_instances", region, instanceID, nil, err)
 		} else {
@@ -160,6 +259,7 @@ func (e *EC2Client) WaitForInstanceRunning(ctx context.Context, region string, i
 				logging.Info(ctx, "Instance %s status: %s", instanceID, status)
 
 				if status == ec2types.InstanceStateNameRunning {
+					metrics.ObserveEC2Call("wait_running", region, start, nil)
 					logging.EC2Log(ctx, "wait_running", region, instanceID, map[string]interface{}{
 						"elapsed_time": time.Since(start).String(),
 					}, nil)
@@ -173,7 +273,7 @@ func (e *EC2Client) WaitForInstanceRunning(ctx context.Context, region string, i
 		}
 
 		time.Sleep(5 * time.Second)
-		if time.Since(start) > time.Duration(appconfig.AppConfig.Scheduler.InstanceWaitTimeout)*time.Second {
+		if time.Since(start) > time.Duration(appconfig.Get().Scheduler.InstanceWaitTimeout)*time.Second {
 			return fmt.Errorf("timeout waiting for instance %s to be running", instanceID)
 		}
 	}
@@ -182,6 +282,7 @@ func (e *EC2Client) WaitForInstanceRunning(ctx context.Context, region string, i
 // GetInstancePublicIP 获取 EC2 实例的公网 IP 地址
 // 参数:
 //   - ctx: 上下文，用于传递请求范围的值和取消信号
+//   - account: AWS 账号名，为空时使用 default
 //   - region: AWS 区域
 //   - instanceID: EC2 实例 ID
 //
@@ -190,22 +291,24 @@ func (e *EC2Client) WaitForInstanceRunning(ctx context.Context, region string, i
 //   - error: 错误信息，如果获取失败
 //
 // 功能:
-//  1. 获取指定区域的 EC2 客户端
+//  1. 获取指定账号和区域的 EC2 客户端
 //  2. 描述实例详情
 //  3. 检查实例是否存在
 //  4. 检查实例是否有公网 IP 地址
 //  5. 返回实例的公网 IP 地址
-func (e *EC2Client) GetInstancePublicIP(ctx context.Context, region string, instanceID string) (string, error) {
-	client, ok := e.clients[region]
-	if !ok {
-		return "", fmt.Errorf("no client configured for region %s", region)
+func (e *EC2Client) GetInstancePublicIP(ctx context.Context, account string, region string, instanceID string) (string, error) {
+	client, err := e.client(account, region)
+	if err != nil {
+		return "", err
 	}
 
 	input := &ec2.DescribeInstancesInput{
 		InstanceIds: []string{instanceID},
 	}
 
-	resp, err := client.DescribeInstances(ctx, input)
+	callCtx, finish := startCall(ctx, "describe_instances", region)
+	resp, err := client.DescribeInstances(callCtx, input)
+	finish(err)
 	if err != nil {
 		logging.EC2Log(ctx, "describe_instances", region, instanceID, nil, err)
 		return "", fmt.Errorf("failed to describe instances: %v", err)
@@ -232,6 +335,7 @@ func (e *EC2Client) GetInstancePublicIP(ctx context.Context, region string, inst
 // TerminateInstance 终止 EC2 实例
 // 参数:
 //   - ctx: 上下文，用于传递请求范围的值和取消信号
+//   - account: AWS 账号名，为空时使用 default
 //   - region: AWS 区域
 //   - instanceID: EC2 实例 ID
 //
@@ -239,14 +343,14 @@ func (e *EC2Client) GetInstancePublicIP(ctx context.Context, region string, inst
 //   - error: 错误信息，如果终止失败
 //
 // 功能:
-//  1. 获取指定区域的 EC2 客户端
+//  1. 获取指定账号和区域的 EC2 客户端
 //  2. 发送终止实例请求
 //  3. 检查是否有实例被终止
 //  4. 记录终止操作的日志
-func (e *EC2Client) TerminateInstance(ctx context.Context, region string, instanceID string) error {
-	client, ok := e.clients[region]
-	if !ok {
-		return fmt.Errorf("no client configured for region %s", region)
+func (e *EC2Client) TerminateInstance(ctx context.Context, account string, region string, instanceID string) error {
+	client, err := e.client(account, region)
+	if err != nil {
+		return err
 	}
 
 	logging.Info(ctx, "Terminating instance %s in region %s", instanceID, region)
@@ -255,7 +359,9 @@ func (e *EC2Client) TerminateInstance(ctx context.Context, region string, instan
 		InstanceIds: []string{instanceID},
 	}
 
-	resp, err := client.TerminateInstances(ctx, input)
+	callCtx, finish := startCall(ctx, "terminate_instances", region)
+	resp, err := client.TerminateInstances(callCtx, input)
+	finish(err)
 	if err != nil {
 		logging.EC2Log(ctx, "terminate_instances", region, instanceID, nil, err)
 		return fmt.Errorf("failed to terminate instances: %v", err)
@@ -275,11 +381,13 @@ func (e *EC2Client) TerminateInstance(ctx context.Context, region string, instan
 
 // InstanceInfo 存储实例信息
 type InstanceInfo struct {
-	InstanceID string
-	Region     string
-	PublicIP   string
-	UUID       string
-	Status     string
+	InstanceID   string
+	Account      string
+	Region       string
+	InstanceType string
+	PublicIP     string
+	UUID         string
+	Status       string
 }
 
 // ConvertInstanceStateToModelStatus 将 AWS 实例状态转换为模型状态
@@ -310,6 +418,7 @@ func ConvertInstanceStateToModelStatus(state ec2types.InstanceStateName) string
 // DescribeInstances 获取指定区域的所有 EC2 实例信息
 // 参数:
 //   - ctx: 上下文，用于传递请求范围的值和取消信号
+//   - account: AWS 账号名，为空时使用 default
 //   - region: AWS 区域
 //
 // 返回值:
@@ -317,20 +426,22 @@ func ConvertInstanceStateToModelStatus(state ec2types.InstanceStateName) string
 //   - error: 错误信息，如果获取失败
 //
 // 功能:
-//  1. 获取指定区域的 EC2 客户端
+//  1. 获取指定账号和区域的 EC2 客户端
 //  2. 调用 DescribeInstances API 获取实例列表
-//  3. 从响应中提取实例的 ID、区域、公网 IP 和 UUID 标签
+//  3. 从响应中提取实例的 ID、区域、实例类型、公网 IP 和 UUID 标签
 //  4. 返回实例信息列表
-func (e *EC2Client) DescribeInstances(ctx context.Context, region string) ([]InstanceInfo, error) {
-	client, ok := e.clients[region]
-	if !ok {
-		return nil, fmt.Errorf("no client configured for region %s", region)
+func (e *EC2Client) DescribeInstances(ctx context.Context, account string, region string) ([]InstanceInfo, error) {
+	client, err := e.client(account, region)
+	if err != nil {
+		return nil, err
 	}
 
 	logging.Info(ctx, "Describing EC2 instances in region %s", region)
 
 	input := &ec2.DescribeInstancesInput{}
-	resp, err := client.DescribeInstances(ctx, input)
+	callCtx, finish := startCall(ctx, "describe_instances", region)
+	resp, err := client.DescribeInstances(callCtx, input)
+	finish(err)
 	if err != nil {
 		logging.EC2Log(ctx, "describe_instances", region, "", nil, err)
 		return nil, fmt.Errorf("failed to describe instances: %v", err)
@@ -363,11 +474,13 @@ func (e *EC2Client) DescribeInstances(ctx context.Context, region string) ([]Ins
 			modelStatus := ConvertInstanceStateToModelStatus(instance.State.Name)
 
 			instances = append(instances, InstanceInfo{
-				InstanceID: instanceID,
-				Region:     region,
-				PublicIP:   publicIP,
-				UUID:       uuid,
-				Status:     modelStatus,
+				InstanceID:   instanceID,
+				Account:      account,
+				Region:       region,
+				InstanceType: string(instance.InstanceType),
+				PublicIP:     publicIP,
+				UUID:         uuid,
+				Status:       modelStatus,
 			})
 		}
 	}
@@ -379,6 +492,7 @@ func (e *EC2Client) DescribeInstances(ctx context.Context, region string) ([]Ins
 // WaitForInstanceTerminated 等待 EC2 实例变为终止状态
 // 参数:
 //   - ctx: 上下文，用于传递请求范围的值和取消信号
+//   - account: AWS 账号名，为空时使用 default
 //   - region: AWS 区域
 //   - instanceID: EC2 实例 ID
 //
@@ -386,15 +500,15 @@ func (e *EC2Client) DescribeInstances(ctx context.Context, region string) ([]Ins
 //   - error: 错误信息，如果等待失败或超时
 //
 // 功能:
-//  1. 获取指定区域的 EC2 客户端
+//  1. 获取指定账号和区域的 EC2 客户端
 //  2. 循环检查实例状态
 //  3. 当实例不存在时返回成功
 //  4. 当实例变为终止状态时返回成功
 //  5. 当等待超过 5 分钟时返回超时错误
-func (e *EC2Client) WaitForInstanceTerminated(ctx context.Context, region string, instanceID string) error {
-	client, ok := e.clients[region]
-	if !ok {
-		return fmt.Errorf("no client configured for region %s", region)
+func (e *EC2Client) WaitForInstanceTerminated(ctx context.Context, account string, region string, instanceID string) error {
+	client, err := e.client(account, region)
+	if err != nil {
+		return err
 	}
 
 	logging.Info(ctx, "Waiting for instance %s in region %s to be terminated", instanceID, region)
@@ -405,13 +519,16 @@ func (e *EC2Client) WaitForInstanceTerminated(ctx context.Context, region string
 			InstanceIds: []string{instanceID},
 		}
 
-		resp, err := client.DescribeInstances(ctx, input)
+		callCtx, finish := startCall(ctx, "describe_instances", region)
+		resp, err := client.DescribeInstances(callCtx, input)
+		finish(err)
 		if err != nil {
 			logging.EC2Log(ctx, "describe_instances", region, instanceID, nil, err)
 			return fmt.Errorf("failed to describe instances: %v", err)
 		}
 
 		if len(resp.Reservations) == 0 || len(resp.Reservations[0].Instances) == 0 {
+			metrics.ObserveEC2Call("wait_terminated", region, start, nil)
 			logging.EC2Log(ctx, "wait_terminated", region, instanceID, map[string]interface{}{
 				"elapsed_time": time.Since(start).String(),
 			}, nil)
@@ -423,6 +540,7 @@ func (e *EC2Client) WaitForInstanceTerminated(ctx context.Context, region string
 		logging.Info(ctx, "Instance %s termination status: %s", instanceID, status)
 
 		if status == ec2types.InstanceStateNameTerminated {
+			metrics.ObserveEC2Call("wait_terminated", region, start, nil)
 			logging.EC2Log(ctx, "wait_terminated", region, instanceID, map[string]interface{}{
 				"elapsed_time": time.Since(start).String(),
 			}, nil)
@@ -430,7 +548,7 @@ func (e *EC2Client) WaitForInstanceTerminated(ctx context.Context, region string
 		}
 
 		time.Sleep(5 * time.Second)
-		if time.Since(start) > time.Duration(appconfig.AppConfig.Scheduler.InstanceWaitTimeout)*time.Second {
+		if time.Since(start) > time.Duration(appconfig.Get().Scheduler.InstanceWaitTimeout)*time.Second {
 			return fmt.Errorf("timeout waiting for instance %s to be terminated", instanceID)
 		}
 	}
@@ -0,0 +1,67 @@
+package aws
+
+import (
+	"sort"
+
+	appconfig "github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+)
+
+// Target 一个需要查询的账号和区域组合
+type Target struct {
+	Account string
+	Region  string
+}
+
+// InstanceTarget 返回实例所在的账号和区域
+// 参数:
+//   - instance: 实例记录，账号为空的旧记录视为 default
+//
+// 返回值:
+//   - Target: 实例所在的账号和区域
+func InstanceTarget(instance *models.V2RayInstance) Target {
+	account := instance.EC2Account
+	if account == "" {
+		account = appconfig.DefaultAccount
+	}
+	return Target{Account: account, Region: instance.EC2Region}
+}
+
+// Targets 返回需要查询 EC2 实例的账号和区域组合
+// 参数:
+//   - cfg: 当前配置
+//   - instances: 数据库中的实例记录
+//
+// 返回值:
+//   - []Target: 按账号和区域排序的组合
+//
+// 功能:
+//  1. 包含每个已配置区域及其当前引用的账号
+//  2. 区域改用其他账号后，仍包含实例记录所在的原账号，前提是该账号和区域都还在配置中
+func Targets(cfg *appconfig.Config, instances []*models.V2RayInstance) []Target {
+	seen := make(map[Target]bool)
+	for region := range cfg.AWS.Regions {
+		seen[Target{Account: cfg.AWS.RegionAccount(region), Region: region}] = true
+	}
+	for _, instance := range instances {
+		target := InstanceTarget(instance)
+		if _, ok := cfg.AWS.Regions[target.Region]; !ok {
+			continue
+		}
+		if _, ok := cfg.AWS.Account(target.Account); ok {
+			seen[target] = true
+		}
+	}
+
+	targets := make([]Target, 0, len(seen))
+	for target := range seen {
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].Account != targets[j].Account {
+			return targets[i].Account < targets[j].Account
+		}
+		return targets[i].Region < targets[j].Region
+	})
+	return targets
+}
@@ -1,9 +1,16 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -15,41 +22,312 @@ type Config struct {
 	V2Ray     V2RayConfig     `yaml:"v2ray"`
 	Logging   LoggingConfig   `yaml:"logging"`
 	Scheduler SchedulerConfig `yaml:"scheduler"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+	Webhook   WebhookConfig   `yaml:"webhook"`
+	Telegram  TelegramConfig  `yaml:"telegram"`
+	Secrets   SecretsConfig   `yaml:"secrets"`
+	Cost      CostConfig      `yaml:"cost"`
 }
 
 type ServerConfig struct {
-	Host string `yaml:"host"`
-	Port int    `yaml:"port"`
+	Host    string   `yaml:"host"`
+	Port    int      `yaml:"port"`
+	APIKeys []string `yaml:"api_keys" secret:"true"`
+	// Owners 按创建者名称配置的 API key，使用这些 key 的请求以该创建者的身份创建实例
+	Owners map[string]ServerOwnerConfig `yaml:"owners"`
+}
+
+// ServerOwnerConfig 创建者的 API key
+type ServerOwnerConfig struct {
+	APIKeys []string `yaml:"api_keys" secret:"true"`
+}
+
+// AuthEnabled 是否要求 /api 下的请求携带 API key
+// 返回值:
+//   - bool: 配置了 api_keys 或任一创建者的 api_keys 时为 true
+func (c ServerConfig) AuthEnabled() bool {
+	if len(c.APIKeys) > 0 {
+		return true
+	}
+	for _, owner := range c.Owners {
+		if len(owner.APIKeys) > 0 {
+			return true
+		}
+	}
+	return false
 }
 
 type DatabaseConfig struct {
 	Host     string `yaml:"host"`
 	Port     int    `yaml:"port"`
 	User     string `yaml:"user"`
-	Password string `yaml:"password"`
+	Password string `yaml:"password" secret:"true"`
 	DBName   string `yaml:"dbname"`
 }
 
+// DefaultAccount 默认账号名，区域未指定 account 时使用
+const DefaultAccount = "default"
+
+// AWSConfig AWS 配置
+// 顶层的 access_key、secret_key 和 profile 组成 default 账号（accounts 中显式定义了 default 时以其为准），
+// 其他账号在 accounts 中按名称配置，区域通过 account 引用
 type AWSConfig struct {
-	AccessKey string                     `yaml:"access_key"`
-	SecretKey string                     `yaml:"secret_key"`
-	Regions   map[string]AWSRegionConfig `yaml:"regions"`
+	AccessKey string                      `yaml:"access_key" secret:"true"`
+	SecretKey string                      `yaml:"secret_key" secret:"true"`
+	Profile   string                      `yaml:"profile"`
+	Accounts  map[string]AWSAccountConfig `yaml:"accounts"`
+	Regions   map[string]AWSRegionConfig  `yaml:"regions"`
+	// MaxInstancesPerRegion 每个区域同时存在的实例上限，中转按区域在这些实例之间负载均衡
+	MaxInstancesPerRegion int `yaml:"max_instances_per_region"`
+}
+
+// AWSAccountConfig AWS 账号凭证配置
+// access_key 和 secret_key 都为空时使用 SDK 默认凭证链（环境变量、共享配置文件、实例角色等），
+// 可通过 profile 指定共享配置文件中的配置；配置了 role_arn 时在此基础上扮演该角色
+type AWSAccountConfig struct {
+	AccessKey  string `yaml:"access_key" secret:"true"`
+	SecretKey  string `yaml:"secret_key" secret:"true"`
+	Profile    string `yaml:"profile"`
+	RoleARN    string `yaml:"role_arn"`
+	ExternalID string `yaml:"external_id" secret:"true"`
 }
 
 type AWSRegionConfig struct {
 	TemplateID string `yaml:"template_id"`
 	Name       string `yaml:"name"`
+	Alias      string `yaml:"alias"`
+	// Account 创建实例使用的账号名，为空时使用 default
+	Account string `yaml:"account"`
+	// MaxInstances 该区域的实例上限，为 0 时使用 max_instances_per_region
+	MaxInstances int `yaml:"max_instances"`
+}
+
+// Account 获取指定名称的账号配置
+// 参数:
+//   - name: 账号名，为空时视为 default
+//
+// 返回值:
+//   - AWSAccountConfig: 账号配置
+//   - bool: 账号是否存在，default 账号总是存在
+func (c AWSConfig) Account(name string) (AWSAccountConfig, bool) {
+	if name == "" {
+		name = DefaultAccount
+	}
+	if account, ok := c.Accounts[name]; ok {
+		return account, true
+	}
+	if name == DefaultAccount {
+		return AWSAccountConfig{AccessKey: c.AccessKey, SecretKey: c.SecretKey, Profile: c.Profile}, true
+	}
+	return AWSAccountConfig{}, false
+}
+
+// RegionAccount 返回区域配置的账号名
+// 参数:
+//   - region: AWS 区域代码
+//
+// 返回值:
+//   - string: 账号名，区域未指定或未配置时为 default
+func (c AWSConfig) RegionAccount(region string) string {
+	if account := c.Regions[region].Account; account != "" {
+		return account
+	}
+	return DefaultAccount
+}
+
+// RegionMaxInstances 获取区域同时存在的实例上限
+// 参数:
+//   - region: AWS 区域
+//
+// 返回值:
+//   - int: 区域配置了 max_instances 时使用该值，否则使用 max_instances_per_region
+func (c AWSConfig) RegionMaxInstances(region string) int {
+	if max := c.Regions[region].MaxInstances; max > 0 {
+		return max
+	}
+	return c.MaxInstancesPerRegion
 }
 
+// DefaultRelay 只配置了 local_config_path 时，本机中转的名称
+const DefaultRelay = "local"
+
+// 中转主机的访问方式
+const (
+	DriverLocal = "local"
+	DriverSSH   = "ssh"
+)
+
+// 节点协议
+const (
+	// ProtocolVMess 节点安装 V2Ray，使用 vmess over TCP
+	ProtocolVMess = "vmess"
+	// ProtocolVLESSReality 节点安装 Xray，使用 VLESS + REALITY，不需要域名和证书
+	ProtocolVLESSReality = "vless-reality"
+)
+
+// 中转使用的代理程序
+const (
+	EngineV2Ray   = "v2ray"
+	EngineXray    = "xray"
+	EngineSingBox = "sing-box"
+)
+
 type V2RayConfig struct {
+	// LocalConfigPath、PublicIP、APIAddress、Engine 本机上的中转，未配置 relays 时作为名为 local 的中转
 	LocalConfigPath string `yaml:"local_config_path"`
 	Port            int    `yaml:"port"`
 	PublicIP        string `yaml:"public_ip"`
+	// Protocol 新建节点默认使用的协议，vmess 或 vless-reality，创建请求可以单独指定
+	Protocol string `yaml:"protocol"`
+	// Reality vless-reality 节点的 REALITY 设置
+	Reality RealityConfig `yaml:"reality"`
+	// Engine 本机中转使用的代理程序，v2ray、xray 或 sing-box
+	Engine string `yaml:"engine"`
+	// APIAddress 中转 V2Ray API inbound 的地址，例如 127.0.0.1:10085，用于统计流量和不重启地修改出站，
+	// 为空时不统计流量，修改出站后重启 V2Ray
+	APIAddress string `yaml:"api_address"`
+	// RelayInboundTag 放置各区域中转用户的 vmess 入站标签，不存在时自动创建
+	RelayInboundTag string `yaml:"relay_inbound_tag"`
+	// RelayPort 自动创建中转入站时监听的端口
+	RelayPort int `yaml:"relay_port"`
+	// BalancerStrategy 各区域负载均衡器的策略，random 或 leastPing
+	BalancerStrategy string `yaml:"balancer_strategy"`
+	// ProbeURL leastPing 策略下 observatory 探测中转出站使用的地址
+	ProbeURL string `yaml:"probe_url"`
+	// ProbeInterval leastPing 策略下 observatory 的探测间隔，例如 1m
+	ProbeInterval string `yaml:"probe_interval"`
+	// Binary 用于校验配置的可执行文件，为空时使用中转代理程序的默认名称（v2ray、xray 或 sing-box）
+	Binary string `yaml:"binary"`
+	// SkipConfigTest 为 true 时写入配置前不执行校验，适用于本机没有 V2Ray 可执行文件的环境
+	SkipConfigTest bool `yaml:"skip_config_test"`
+	// BackupCount 保留的配置备份数量，每次写入前备份当前配置；未配置时为 DefaultV2RayBackupCount，为 0 时不备份
+	BackupCount *int `yaml:"backup_count"`
+	// Relays 中转主机，按名称配置，每个中转都添加实例出站并生成各自的中转链接
+	Relays map[string]RelayConfig `yaml:"relays"`
+}
+
+// RealityConfig vless-reality 节点的 REALITY 设置，创建节点时写入节点配置和实例记录，之后修改不影响已有节点
+type RealityConfig struct {
+	// Dest 节点将非 REALITY 流量转发到的网站，host:port，例如 www.microsoft.com:443
+	Dest string `yaml:"dest"`
+	// ServerName 客户端使用的 SNI，必须在 dest 的证书中，为空时使用 dest 的主机名
+	ServerName string `yaml:"server_name"`
+	// Fingerprint 客户端模拟的 TLS 指纹，例如 chrome、firefox、safari
+	Fingerprint string `yaml:"fingerprint"`
+	// ShortIDCount 每个节点生成的 short ID 数量，链接和中转出站使用第一个
+	ShortIDCount int `yaml:"short_id_count"`
+}
+
+// RelayConfig 一台中转主机
+type RelayConfig struct {
+	// Driver 访问中转主机的方式，local 或 ssh
+	Driver string `yaml:"driver"`
+	// Engine 中转使用的代理程序，v2ray、xray 或 sing-box，决定配置格式、校验命令和服务名
+	Engine string `yaml:"engine"`
+	// Binary 用于校验配置的可执行文件，为空时使用 v2ray.binary 或代理程序的默认名称
+	Binary string `yaml:"binary"`
+	// ConfigPath 中转主机上的配置文件路径
+	ConfigPath string `yaml:"config_path"`
+	// PublicIP 客户端连接该中转使用的公网 IP，为空时不生成该中转的链接
+	PublicIP string `yaml:"public_ip"`
+	// APIAddress 中转主机上 V2Ray API inbound 的地址，ssh 中转通过 SSH 连接访问
+	APIAddress string `yaml:"api_address"`
+	// ReloadCommand 重启中转代理程序的命令，在中转主机上执行，为空时重启代理程序的 systemd 服务
+	ReloadCommand string `yaml:"reload_command"`
+	// SSH driver 为 ssh 时的连接设置
+	SSH RelaySSHConfig `yaml:"ssh"`
+}
+
+// RelaySSHConfig 通过 SSH 访问中转主机的设置
+type RelaySSHConfig struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	User string `yaml:"user"`
+	// PrivateKey PEM 格式的私钥，通常使用 file:/path 引用
+	PrivateKey string `yaml:"private_key" secret:"true"`
+	// Passphrase 私钥的密码，私钥未加密时为空
+	Passphrase string `yaml:"passphrase" secret:"true"`
+	// KnownHosts 校验主机密钥使用的 known_hosts 文件
+	KnownHosts string `yaml:"known_hosts"`
+}
+
+// EffectiveRelays 返回生效的中转
+// 返回值:
+//   - map[string]RelayConfig: 配置了 relays 时为 relays；否则配置了 local_config_path 时，
+//     为使用 local_config_path、engine、public_ip 和 api_address 的本机中转 local；都未配置时为空
+func (c V2RayConfig) EffectiveRelays() map[string]RelayConfig {
+	if len(c.Relays) > 0 {
+		return c.Relays
+	}
+	if c.LocalConfigPath == "" {
+		return nil
+	}
+	return map[string]RelayConfig{
+		DefaultRelay: {
+			Driver:     DriverLocal,
+			Engine:     c.Engine,
+			ConfigPath: c.LocalConfigPath,
+			PublicIP:   c.PublicIP,
+			APIAddress: c.APIAddress,
+		},
+	}
+}
+
+// Backups 返回保留的配置备份数量
+// 返回值:
+//   - int: backup_count 的值，未配置时为 DefaultV2RayBackupCount，0 表示不备份
+func (c V2RayConfig) Backups() int {
+	if c.BackupCount == nil {
+		return DefaultV2RayBackupCount
+	}
+	return *c.BackupCount
+}
+
+// RelayNames 返回按名称排序的生效中转名称
+func (c V2RayConfig) RelayNames() []string {
+	relays := c.EffectiveRelays()
+	names := make([]string, 0, len(relays))
+	for name := range relays {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidProtocol 返回是否为支持的节点协议
+func ValidProtocol(protocol string) bool {
+	switch protocol {
+	case ProtocolVMess, ProtocolVLESSReality:
+		return true
+	}
+	return false
+}
+
+// ServerNameOrDest 返回客户端使用的 SNI，未配置 server_name 时为 dest 的主机名
+func (c RealityConfig) ServerNameOrDest() string {
+	if c.ServerName != "" {
+		return c.ServerName
+	}
+	host, _, err := net.SplitHostPort(c.Dest)
+	if err != nil {
+		return c.Dest
+	}
+	return host
+}
+
+// validEngine 返回是否为支持的中转代理程序
+func validEngine(engine string) bool {
+	switch engine {
+	case EngineV2Ray, EngineXray, EngineSingBox:
+		return true
+	}
+	return false
 }
 
 type SchedulerConfig struct {
 	InstanceSyncInterval int `yaml:"instance_sync_interval"`
 	InstanceWaitTimeout  int `yaml:"instance_wait_timeout"`
+	TrafficStatsInterval int `yaml:"traffic_stats_interval"`
 }
 
 type LoggingConfig struct {
@@ -57,21 +335,73 @@ type LoggingConfig struct {
 	Format string `yaml:"format"`
 }
 
-var AppConfig *Config
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter"`
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	File        string  `yaml:"file"`
+	ServiceName string  `yaml:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+type WebhookConfig struct {
+	PollInterval   int `yaml:"poll_interval"`
+	MaxAttempts    int `yaml:"max_attempts"`
+	InitialBackoff int `yaml:"initial_backoff"`
+	MaxBackoff     int `yaml:"max_backoff"`
+	Timeout        int `yaml:"timeout"`
+	ExpiringAfter  int `yaml:"expiring_after"`
+}
+
+// SecretsConfig 加密保险库配置，配置中的敏感字段可以使用 vault:name 引用其中的值
+type SecretsConfig struct {
+	VaultFile string `yaml:"vault_file"`
+	// VaultKey base64 编码的密钥，支持 env: 和 file: 引用
+	VaultKey string `yaml:"vault_key" secret:"true"`
+}
+
+type TelegramConfig struct {
+	Enabled        bool    `yaml:"enabled"`
+	Token          string  `yaml:"token" secret:"true"`
+	APIBaseURL     string  `yaml:"api_base_url"`
+	AllowedChatIDs []int64 `yaml:"allowed_chat_ids"`
+	PollTimeout    int     `yaml:"poll_timeout"`
+}
+
+// CostConfig 费用统计配置，价格的单位为 currency
+type CostConfig struct {
+	Currency string `yaml:"currency"`
+	// InstanceTypes 各实例类型每小时的价格
+	InstanceTypes map[string]float64 `yaml:"instance_types"`
+	// DefaultHourly 未在 instance_types 中列出的实例类型每小时的价格
+	DefaultHourly float64 `yaml:"default_hourly"`
+	// EIPHourly 每个公网 IP 每小时的价格
+	EIPHourly float64 `yaml:"eip_hourly"`
+	// TrafficPerGB 每 GB 出站流量的价格
+	TrafficPerGB float64      `yaml:"traffic_per_gb"`
+	Budget       BudgetConfig `yaml:"budget"`
+}
+
+// BudgetConfig 每月预算，为 0 或未配置时不限制，任一预算超出后拒绝创建新实例
+type BudgetConfig struct {
+	Monthly float64            `yaml:"monthly"`
+	Regions map[string]float64 `yaml:"regions"`
+	Owners  map[string]float64 `yaml:"owners"`
+}
 
 // LoadConfig 加载配置文件
 // 参数:
 //   - configPath: 配置文件路径，如果为空则使用默认路径 "conf/conf.yaml"
 //
 // 返回值:
-//   - error: 错误信息，如果加载失败
+//   - error: 错误信息，如果加载或校验失败
 //
 // 功能:
 //  1. 如果未指定配置路径，使用默认路径
-//  2. 获取配置文件的绝对路径
-//  3. 读取配置文件内容
-//  4. 解析 YAML 配置
-//  5. 将配置保存到全局变量 AppConfig
+//  2. 读取配置文件并展开其中的 ${ENV} 引用
+//  3. 严格解析 YAML，未知字段视为错误
+//  4. 应用 AW_* 环境变量覆盖，解析 env:、file:、vault: 密钥引用，填充默认值
+//  5. 校验配置，通过后作为当前配置，并记录路径供 Reload 使用
 func LoadConfig(configPath string) error {
 	if configPath == "" {
 		configPath = "conf/conf.yaml"
@@ -82,18 +412,63 @@ func LoadConfig(configPath string) error {
 		return fmt.Errorf("failed to get absolute path: %v", err)
 	}
 
-	data, err := os.ReadFile(absPath)
+	config, err := readConfig(absPath)
 	if err != nil {
-		return fmt.Errorf("failed to read config file: %v", err)
+		return err
+	}
+
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	loadedPath = absPath
+	Set(config)
+	return nil
+}
+
+// readConfig 读取、解析并校验配置文件
+func readConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	config, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := Validate(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Parse 解析配置内容
+// 参数:
+//   - data: YAML 格式的配置内容
+//
+// 返回值:
+//   - *Config: 展开环境变量、应用覆盖、解析密钥引用并填充默认值后的配置，未经校验
+//   - error: 错误信息，如果解析失败
+func Parse(data []byte) (*Config, error) {
+	expanded, err := expandEnv(data)
+	if err != nil {
+		return nil, err
 	}
 
 	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return fmt.Errorf("failed to unmarshal config: %v", err)
+	decoder := yaml.NewDecoder(bytes.NewReader(expanded))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to unmarshal config: %v", err)
 	}
 
-	AppConfig = &config
-	return nil
+	if err := applyEnvOverrides(&config); err != nil {
+		return nil, err
+	}
+	if err := resolveSecrets(&config); err != nil {
+		return nil, err
+	}
+	ApplyDefaults(&config)
+	return &config, nil
 }
 
 // GetDSN 生成数据库连接字符串
@@ -105,12 +480,13 @@ func LoadConfig(configPath string) error {
 //  2. 包含用户名、密码、主机、端口、数据库名等信息
 //  3. 设置字符集为 utf8mb4，启用时间解析，使用本地时区
 func GetDSN() string {
+	db := Get().Database
 	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		AppConfig.Database.User,
-		AppConfig.Database.Password,
-		AppConfig.Database.Host,
-		AppConfig.Database.Port,
-		AppConfig.Database.DBName,
+		db.User,
+		db.Password,
+		db.Host,
+		db.Port,
+		db.DBName,
 	)
 }
 
@@ -127,8 +503,326 @@ func GetDSN() string {
 //  2. 如果区域存在，返回其配置信息
 //  3. 如果区域不存在，返回错误
 func GetRegionConfig(region string) (*AWSRegionConfig, error) {
-	if config, ok := AppConfig.AWS.Regions[region]; ok {
+	if config, ok := Get().AWS.Regions[region]; ok {
 		return &config, nil
 	}
 	return nil, fmt.Errorf("region %s not configured", region)
 }
+
+// ResolveRegion 将用户输入解析为 AWS 区域代码
+// 参数:
+//   - input: 区域代码、别名或区域名称，例如 "ap-east-1"、"hk" 或 "香港"
+//
+// 返回值:
+//   - string: 配置中的区域代码
+//   - error: 错误信息，如果没有匹配的区域
+//
+// 功能:
+//  1. 优先按区域代码精确匹配
+//  2. 其次按别名匹配（忽略大小写）
+//  3. 最后按区域名称匹配
+func ResolveRegion(input string) (string, error) {
+	input = strings.TrimSpace(input)
+	regions := Get().AWS.Regions
+	if _, ok := regions[input]; ok {
+		return input, nil
+	}
+	for code, regionConfig := range regions {
+		if regionConfig.Alias != "" && strings.EqualFold(regionConfig.Alias, input) {
+			return code, nil
+		}
+	}
+	for code, regionConfig := range regions {
+		if regionConfig.Name != "" && regionConfig.Name == input {
+			return code, nil
+		}
+	}
+	return "", fmt.Errorf("region %s not configured", input)
+}
+
+// Validate 校验配置内容
+// 参数:
+//   - cfg: 要校验的配置
+//
+// 返回值:
+//   - error: 所有校验失败项合并后的错误，配置有效时为 nil
+//
+// 功能:
+//  1. 检查服务、数据库、AWS 区域和 V2Ray 的必填项
+//  2. 检查取值范围和枚举值，应在 ApplyDefaults 之后调用
+//  3. 检查已启用的可选模块（追踪、Telegram）的必填项
+//  4. 一次性返回所有问题，便于修改
+func Validate(cfg *Config) error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if cfg.Server.Port <= 0 || cfg.Server.Port > 65535 {
+		add("server.port must be between 1 and 65535")
+	}
+	for i, key := range cfg.Server.APIKeys {
+		if strings.TrimSpace(key) == "" {
+			add("server.api_keys[%d] must not be empty", i)
+		}
+	}
+	owners := make([]string, 0, len(cfg.Server.Owners))
+	for owner := range cfg.Server.Owners {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	keyOwners := make(map[string]string)
+	for _, owner := range owners {
+		if len(cfg.Server.Owners[owner].APIKeys) == 0 {
+			add("server.owners.%s.api_keys must not be empty", owner)
+		}
+		for i, key := range cfg.Server.Owners[owner].APIKeys {
+			switch {
+			case strings.TrimSpace(key) == "":
+				add("server.owners.%s.api_keys[%d] must not be empty", owner, i)
+			case keyOwners[key] != "" && keyOwners[key] != owner:
+				add("server.owners.%s.api_keys[%d] is also a key of owner %s", owner, i, keyOwners[key])
+			default:
+				keyOwners[key] = owner
+			}
+		}
+	}
+	for i, key := range cfg.Server.APIKeys {
+		if owner := keyOwners[key]; owner != "" {
+			add("server.api_keys[%d] is also a key of owner %s", i, owner)
+		}
+	}
+
+	if cfg.Database.Host == "" {
+		add("database.host is required")
+	}
+	if cfg.Database.User == "" {
+		add("database.user is required")
+	}
+	if cfg.Database.DBName == "" {
+		add("database.dbname is required")
+	}
+	if cfg.Database.Port <= 0 || cfg.Database.Port > 65535 {
+		add("database.port must be between 1 and 65535")
+	}
+
+	validateAccount := func(prefix string, account AWSAccountConfig) {
+		if (account.AccessKey == "") != (account.SecretKey == "") {
+			add("%saccess_key and %ssecret_key must be set together, leave both empty to use the default credential chain", prefix, prefix)
+		}
+		if account.Profile != "" && account.AccessKey != "" {
+			add("%sprofile cannot be combined with %saccess_key", prefix, prefix)
+		}
+		if account.RoleARN != "" && !strings.HasPrefix(account.RoleARN, "arn:") {
+			add("%srole_arn %q is not an ARN", prefix, account.RoleARN)
+		}
+		if account.ExternalID != "" && account.RoleARN == "" {
+			add("%sexternal_id requires %srole_arn", prefix, prefix)
+		}
+	}
+	validateAccount("aws.", AWSAccountConfig{AccessKey: cfg.AWS.AccessKey, SecretKey: cfg.AWS.SecretKey, Profile: cfg.AWS.Profile})
+	for name, account := range cfg.AWS.Accounts {
+		validateAccount(fmt.Sprintf("aws.accounts.%s.", name), account)
+	}
+	if len(cfg.AWS.Regions) == 0 {
+		add("aws.regions must contain at least one region")
+	}
+	aliases := make(map[string]string)
+	for region, regionConfig := range cfg.AWS.Regions {
+		if regionConfig.TemplateID == "" {
+			add("aws.regions.%s.template_id is required", region)
+		}
+		if _, ok := cfg.AWS.Account(regionConfig.Account); !ok {
+			add("aws.regions.%s.account %q is not defined in aws.accounts", region, regionConfig.Account)
+		}
+		if regionConfig.Alias != "" {
+			alias := strings.ToLower(regionConfig.Alias)
+			if other, ok := aliases[alias]; ok {
+				add("aws.regions.%s.alias %q is already used by %s", region, regionConfig.Alias, other)
+			}
+			aliases[alias] = region
+		}
+		if regionConfig.MaxInstances < 0 {
+			add("aws.regions.%s.max_instances must not be negative", region)
+		}
+	}
+
+	if cfg.V2Ray.Port <= 0 || cfg.V2Ray.Port > 65535 {
+		add("v2ray.port must be between 1 and 65535")
+	}
+	if cfg.V2Ray.LocalConfigPath != "" {
+		if _, err := os.Stat(cfg.V2Ray.LocalConfigPath); err != nil {
+			add("v2ray.local_config_path: %v", err)
+		}
+	}
+	if cfg.V2Ray.PublicIP != "" && net.ParseIP(cfg.V2Ray.PublicIP) == nil {
+		add("v2ray.public_ip %q is not a valid IP address", cfg.V2Ray.PublicIP)
+	}
+	if cfg.V2Ray.APIAddress != "" {
+		if _, _, err := net.SplitHostPort(cfg.V2Ray.APIAddress); err != nil {
+			add("v2ray.api_address %q must be host:port", cfg.V2Ray.APIAddress)
+		}
+	}
+	if cfg.V2Ray.RelayPort <= 0 || cfg.V2Ray.RelayPort > 65535 {
+		add("v2ray.relay_port must be between 1 and 65535")
+	}
+	switch cfg.V2Ray.BalancerStrategy {
+	case "random", "leastPing":
+	default:
+		add("v2ray.balancer_strategy must be random or leastPing")
+	}
+	if _, err := time.ParseDuration(cfg.V2Ray.ProbeInterval); err != nil {
+		add("v2ray.probe_interval %q is not a valid duration", cfg.V2Ray.ProbeInterval)
+	}
+	if cfg.V2Ray.Backups() < 0 {
+		add("v2ray.backup_count must not be negative")
+	}
+	if !validEngine(cfg.V2Ray.Engine) {
+		add("v2ray.engine must be v2ray, xray or sing-box")
+	}
+	if !ValidProtocol(cfg.V2Ray.Protocol) {
+		add("v2ray.protocol must be vmess or vless-reality")
+	}
+	if _, port, err := net.SplitHostPort(cfg.V2Ray.Reality.Dest); err != nil || port == "" {
+		add("v2ray.reality.dest %q must be host:port", cfg.V2Ray.Reality.Dest)
+	}
+	if cfg.V2Ray.Reality.ShortIDCount < 1 || cfg.V2Ray.Reality.ShortIDCount > 16 {
+		add("v2ray.reality.short_id_count must be between 1 and 16")
+	}
+	if len(cfg.V2Ray.Relays) > 0 && cfg.V2Ray.LocalConfigPath != "" {
+		add("v2ray.local_config_path cannot be combined with v2ray.relays, configure a relay with driver local instead")
+	}
+	for name, relay := range cfg.V2Ray.Relays {
+		prefix := fmt.Sprintf("v2ray.relays.%s.", name)
+		if relay.ConfigPath == "" {
+			add("%sconfig_path is required", prefix)
+		}
+		if !validEngine(relay.Engine) {
+			add("%sengine must be v2ray, xray or sing-box", prefix)
+		}
+		if relay.PublicIP != "" && net.ParseIP(relay.PublicIP) == nil {
+			add("%spublic_ip %q is not a valid IP address", prefix, relay.PublicIP)
+		}
+		if relay.APIAddress != "" {
+			if _, _, err := net.SplitHostPort(relay.APIAddress); err != nil {
+				add("%sapi_address %q must be host:port", prefix, relay.APIAddress)
+			}
+		}
+		switch relay.Driver {
+		case DriverLocal:
+			if relay.ConfigPath != "" {
+				if _, err := os.Stat(relay.ConfigPath); err != nil {
+					add("%sconfig_path: %v", prefix, err)
+				}
+			}
+		case DriverSSH:
+			if relay.SSH.Host == "" {
+				add("%sssh.host is required", prefix)
+			}
+			if relay.SSH.Port <= 0 || relay.SSH.Port > 65535 {
+				add("%sssh.port must be between 1 and 65535", prefix)
+			}
+			if relay.SSH.User == "" {
+				add("%sssh.user is required", prefix)
+			}
+			if relay.SSH.PrivateKey == "" {
+				add("%sssh.private_key is required", prefix)
+			}
+		default:
+			add("%sdriver must be local or ssh", prefix)
+		}
+	}
+	if cfg.AWS.MaxInstancesPerRegion <= 0 {
+		add("aws.max_instances_per_region must be positive")
+	}
+
+	switch cfg.Logging.Level {
+	case "debug", "info", "warn", "error", "fatal":
+	default:
+		add("logging.level must be one of debug, info, warn, error, fatal")
+	}
+	switch cfg.Logging.Format {
+	case "json", "console":
+	default:
+		add("logging.format must be json or console")
+	}
+
+	if cfg.Scheduler.InstanceSyncInterval <= 0 {
+		add("scheduler.instance_sync_interval must be positive")
+	}
+	if cfg.Scheduler.InstanceWaitTimeout <= 0 {
+		add("scheduler.instance_wait_timeout must be positive")
+	}
+	if cfg.Scheduler.TrafficStatsInterval <= 0 {
+		add("scheduler.traffic_stats_interval must be positive")
+	}
+
+	if cfg.Webhook.PollInterval <= 0 {
+		add("webhook.poll_interval must be positive")
+	}
+	if cfg.Webhook.MaxAttempts <= 0 {
+		add("webhook.max_attempts must be positive")
+	}
+	if cfg.Webhook.InitialBackoff <= 0 {
+		add("webhook.initial_backoff must be positive")
+	}
+	if cfg.Webhook.MaxBackoff < cfg.Webhook.InitialBackoff {
+		add("webhook.max_backoff must not be less than webhook.initial_backoff")
+	}
+	if cfg.Webhook.Timeout <= 0 {
+		add("webhook.timeout must be positive")
+	}
+	if cfg.Webhook.ExpiringAfter < 0 {
+		add("webhook.expiring_after must not be negative")
+	}
+
+	switch cfg.Tracing.Exporter {
+	case "", "none", "otlp", "stdout", "file":
+	default:
+		add("tracing.exporter must be one of none, otlp, stdout, file")
+	}
+	if cfg.Tracing.Exporter == "file" && cfg.Tracing.File == "" {
+		add("tracing.file is required for the file exporter")
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		add("tracing.sample_ratio must be between 0 and 1")
+	}
+
+	if cfg.Telegram.Enabled && cfg.Telegram.Token == "" {
+		add("telegram.token is required when telegram.enabled is true")
+	}
+	if u, err := url.Parse(cfg.Telegram.APIBaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		add("telegram.api_base_url %q is not a valid URL", cfg.Telegram.APIBaseURL)
+	}
+	if cfg.Telegram.PollTimeout <= 0 {
+		add("telegram.poll_timeout must be positive")
+	}
+
+	if cfg.Cost.DefaultHourly < 0 || cfg.Cost.EIPHourly < 0 || cfg.Cost.TrafficPerGB < 0 || cfg.Cost.Budget.Monthly < 0 {
+		add("cost prices and budgets must not be negative")
+	}
+	for instanceType, price := range cfg.Cost.InstanceTypes {
+		if price < 0 {
+			add("cost.instance_types.%s must not be negative", instanceType)
+		}
+	}
+	for region, limit := range cfg.Cost.Budget.Regions {
+		if _, ok := cfg.AWS.Regions[region]; !ok {
+			add("cost.budget.regions.%s is not a configured region", region)
+		}
+		if limit < 0 {
+			add("cost.budget.regions.%s must not be negative", region)
+		}
+	}
+	for owner, limit := range cfg.Cost.Budget.Owners {
+		if limit < 0 {
+			add("cost.budget.owners.%s must not be negative", owner)
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("invalid config:\n  - %s", strings.Join(problems, "\n  - "))
+}
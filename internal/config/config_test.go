@@ -0,0 +1,255 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// minimalConfig 只包含必填项的配置文件
+const minimalConfig = `
+database:
+  host: localhost
+  user: root
+  dbname: v2ray_manager
+aws:
+  regions:
+    ap-east-1:
+      template_id: lt-1
+      alias: hk
+`
+
+// validConfig 解析 minimalConfig 并填充默认值，可以通过 Validate
+func validConfig(t *testing.T) *Config {
+	t.Helper()
+	cfg, err := Parse([]byte(minimalConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// TestValidate 默认值可以通过校验，每个无效的配置项都报告对应的问题
+func TestValidate(t *testing.T) {
+	if err := Validate(validConfig(t)); err != nil {
+		t.Fatalf("Validate() with defaults = %v", err)
+	}
+
+	negative := -1
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+		want   []string
+	}{
+		{
+			"zero intervals",
+			func(cfg *Config) {
+				cfg.Scheduler.InstanceSyncInterval = 0
+				cfg.Scheduler.TrafficStatsInterval = 0
+				cfg.Webhook.PollInterval = 0
+				cfg.Telegram.PollTimeout = 0
+			},
+			[]string{
+				"scheduler.instance_sync_interval must be positive",
+				"scheduler.traffic_stats_interval must be positive",
+				"webhook.poll_interval must be positive",
+				"telegram.poll_timeout must be positive",
+			},
+		},
+		{
+			"negative interval",
+			func(cfg *Config) { cfg.Scheduler.InstanceWaitTimeout = -1 },
+			[]string{"scheduler.instance_wait_timeout must be positive"},
+		},
+		{
+			"ports",
+			func(cfg *Config) {
+				cfg.Server.Port = 65536
+				cfg.Database.Port = -1
+				cfg.V2Ray.RelayPort = 0
+			},
+			[]string{
+				"server.port must be between 1 and 65535",
+				"database.port must be between 1 and 65535",
+				"v2ray.relay_port must be between 1 and 65535",
+			},
+		},
+		{
+			"missing database settings",
+			func(cfg *Config) { cfg.Database = DatabaseConfig{Port: DefaultDatabasePort} },
+			[]string{"database.host is required", "database.user is required", "database.dbname is required"},
+		},
+		{
+			"enumerations",
+			func(cfg *Config) {
+				cfg.V2Ray.BalancerStrategy = "roundRobin"
+				cfg.V2Ray.Engine = "clash"
+				cfg.Logging.Level = "trace"
+				cfg.Tracing.Exporter = "jaeger"
+			},
+			[]string{
+				"v2ray.balancer_strategy must be random or leastPing",
+				"v2ray.engine must be v2ray, xray or sing-box",
+				"logging.level must be one of",
+				"tracing.exporter must be one of",
+			},
+		},
+		{
+			"durations and addresses",
+			func(cfg *Config) {
+				cfg.V2Ray.ProbeInterval = "60"
+				cfg.V2Ray.PublicIP = "relay.example.com"
+				cfg.V2Ray.APIAddress = "10085"
+			},
+			[]string{
+				`v2ray.probe_interval "60" is not a valid duration`,
+				`v2ray.public_ip "relay.example.com" is not a valid IP address`,
+				`v2ray.api_address "10085" must be host:port`,
+			},
+		},
+		{
+			"negative backup count",
+			func(cfg *Config) { cfg.V2Ray.BackupCount = &negative },
+			[]string{"v2ray.backup_count must not be negative"},
+		},
+		{
+			"regions",
+			func(cfg *Config) {
+				cfg.AWS.Regions["us-west-2"] = AWSRegionConfig{Alias: "HK", Account: "prod"}
+			},
+			[]string{
+				"aws.regions.us-west-2.template_id is required",
+				`aws.regions.us-west-2.account "prod" is not defined in aws.accounts`,
+				`alias "HK" is already used by`,
+			},
+		},
+		{
+			"no regions",
+			func(cfg *Config) { cfg.AWS.Regions = nil },
+			[]string{"aws.regions must contain at least one region"},
+		},
+		{
+			"credentials",
+			func(cfg *Config) {
+				cfg.AWS.AccessKey = "AKIA"
+				cfg.AWS.Accounts = map[string]AWSAccountConfig{"prod": {RoleARN: "role/anywhere", ExternalID: "id"}}
+			},
+			[]string{
+				"aws.access_key and aws.secret_key must be set together",
+				`aws.accounts.prod.role_arn "role/anywhere" is not an ARN`,
+			},
+		},
+		{
+			"owner keys",
+			func(cfg *Config) {
+				cfg.Server.APIKeys = []string{"shared", " "}
+				cfg.Server.Owners = map[string]ServerOwnerConfig{
+					"alice": {APIKeys: []string{"shared"}},
+					"bob":   {APIKeys: []string{"shared"}},
+					"carol": {},
+				}
+			},
+			[]string{
+				"server.api_keys[0] is also a key of owner alice",
+				"server.api_keys[1] must not be empty",
+				"server.owners.bob.api_keys[0] is also a key of owner alice",
+				"server.owners.carol.api_keys must not be empty",
+			},
+		},
+		{
+			"ssh relay",
+			func(cfg *Config) {
+				cfg.V2Ray.Relays = map[string]RelayConfig{"hk": {Driver: DriverSSH, Engine: EngineV2Ray, ConfigPath: "/etc/v2ray/config.json"}}
+			},
+			[]string{
+				"v2ray.relays.hk.ssh.host is required",
+				"v2ray.relays.hk.ssh.port must be between 1 and 65535",
+				"v2ray.relays.hk.ssh.user is required",
+				"v2ray.relays.hk.ssh.private_key is required",
+			},
+		},
+		{
+			"webhook backoff",
+			func(cfg *Config) { cfg.Webhook.MaxBackoff = cfg.Webhook.InitialBackoff - 1 },
+			[]string{"webhook.max_backoff must not be less than webhook.initial_backoff"},
+		},
+		{
+			"telegram",
+			func(cfg *Config) {
+				cfg.Telegram.Enabled = true
+				cfg.Telegram.APIBaseURL = "api.telegram.org"
+			},
+			[]string{
+				"telegram.token is required when telegram.enabled is true",
+				`telegram.api_base_url "api.telegram.org" is not a valid URL`,
+			},
+		},
+		{
+			"cost",
+			func(cfg *Config) {
+				cfg.Cost.EIPHourly = -0.005
+				cfg.Cost.Budget.Regions = map[string]float64{"eu-west-1": 10}
+			},
+			[]string{
+				"cost prices and budgets must not be negative",
+				"cost.budget.regions.eu-west-1 is not a configured region",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig(t)
+			tt.modify(cfg)
+			err := Validate(cfg)
+			if err == nil {
+				t.Fatal("Validate() succeeded")
+			}
+			// 所有问题一次性返回
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error does not contain %q:\n%v", want, err)
+				}
+			}
+			if got := strings.Count(err.Error(), "\n  - "); got != len(tt.want) {
+				t.Errorf("got %d problems, want %d:\n%v", got, len(tt.want), err)
+			}
+		})
+	}
+}
+
+// TestParseIntervals 配置文件中未配置或为 0 的间隔使用默认值，负数在校验时报错
+func TestParseIntervals(t *testing.T) {
+	t.Setenv("AW_TEST_SYNC_INTERVAL", "30")
+
+	tests := []struct {
+		name    string
+		yaml    string
+		want    int
+		wantErr string
+	}{
+		{"not configured", "", DefaultInstanceSyncInterval, ""},
+		{"zero", "scheduler:\n  instance_sync_interval: 0\n", DefaultInstanceSyncInterval, ""},
+		{"from environment", "scheduler:\n  instance_sync_interval: ${AW_TEST_SYNC_INTERVAL}\n", 30, ""},
+		{"negative", "scheduler:\n  instance_sync_interval: -5\n", 0, "scheduler.instance_sync_interval must be positive"},
+		{"quoted", "scheduler:\n  instance_sync_interval: \"${AW_TEST_SYNC_INTERVAL}\"\n", 0, "cannot unmarshal !!str `30` into int"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Parse([]byte(minimalConfig + tt.yaml))
+			if err == nil {
+				err = Validate(cfg)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Scheduler.InstanceSyncInterval != tt.want {
+				t.Errorf("instance_sync_interval = %d, want %d", cfg.Scheduler.InstanceSyncInterval, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package config
+
+// 配置项默认值，未配置（零值）时生效
+const (
+	DefaultServerPort            = 8000
+	DefaultDatabasePort          = 3306
+	DefaultV2RayPort             = 11994
+	DefaultRelayInboundTag       = "in_relay"
+	DefaultRelayPort             = 10086
+	DefaultBalancerStrategy      = "leastPing"
+	DefaultProbeURL              = "https://www.google.com/generate_204"
+	DefaultProbeInterval         = "1m"
+	DefaultV2RayBackupCount      = 5
+	DefaultRealityDest           = "www.microsoft.com:443"
+	DefaultRealityFingerprint    = "chrome"
+	DefaultRealityShortIDCount   = 1
+	DefaultRelaySSHPort          = 22
+	DefaultRelayKnownHosts       = "~/.ssh/known_hosts"
+	DefaultMaxInstancesPerRegion = 1
+	DefaultLogLevel              = "info"
+	DefaultLogFormat             = "json"
+	DefaultInstanceSyncInterval  = 60  // 秒
+	DefaultInstanceWaitTimeout   = 300 // 秒
+	DefaultTrafficStatsInterval  = 60  // 秒
+	DefaultTracingExporter       = "none"
+	DefaultTracingServiceName    = "aw_backend"
+	DefaultTracingSampleRatio    = 1.0
+	DefaultWebhookPollInterval   = 5 // 秒
+	DefaultWebhookMaxAttempts    = 8
+	DefaultWebhookInitialBackoff = 10   // 秒
+	DefaultWebhookMaxBackoff     = 3600 // 秒
+	DefaultWebhookTimeout        = 10   // 秒
+	DefaultTelegramAPIBaseURL    = "https://api.telegram.org"
+	DefaultTelegramPollTimeout   = 30 // 秒
+	DefaultCostCurrency          = "USD"
+)
+
+// ApplyDefaults 为未配置的配置项填充默认值
+// 参数:
+//   - cfg: 要填充的配置
+//
+// 功能:
+//  1. 只填充零值字段，已配置的值保持不变
+//  2. 追踪采样率为 0 时视为未配置，需要关闭追踪时使用 exporter: none
+//  3. v2ray.backup_count 为 0 表示不备份，未配置（nil）时才使用默认值
+func ApplyDefaults(cfg *Config) {
+	setInt(&cfg.Server.Port, DefaultServerPort)
+	setInt(&cfg.Database.Port, DefaultDatabasePort)
+	setInt(&cfg.V2Ray.Port, DefaultV2RayPort)
+	setString(&cfg.V2Ray.RelayInboundTag, DefaultRelayInboundTag)
+	setInt(&cfg.V2Ray.RelayPort, DefaultRelayPort)
+	setString(&cfg.V2Ray.BalancerStrategy, DefaultBalancerStrategy)
+	setString(&cfg.V2Ray.ProbeURL, DefaultProbeURL)
+	setString(&cfg.V2Ray.ProbeInterval, DefaultProbeInterval)
+	setString(&cfg.V2Ray.Engine, EngineV2Ray)
+	setString(&cfg.V2Ray.Protocol, ProtocolVMess)
+	setString(&cfg.V2Ray.Reality.Dest, DefaultRealityDest)
+	setString(&cfg.V2Ray.Reality.Fingerprint, DefaultRealityFingerprint)
+	setInt(&cfg.V2Ray.Reality.ShortIDCount, DefaultRealityShortIDCount)
+	if cfg.V2Ray.BackupCount == nil {
+		// 0 表示不备份，只有未配置时使用默认值
+		backups := DefaultV2RayBackupCount
+		cfg.V2Ray.BackupCount = &backups
+	}
+	for name, relay := range cfg.V2Ray.Relays {
+		setString(&relay.Driver, DriverLocal)
+		setString(&relay.Engine, EngineV2Ray)
+		setInt(&relay.SSH.Port, DefaultRelaySSHPort)
+		setString(&relay.SSH.KnownHosts, DefaultRelayKnownHosts)
+		cfg.V2Ray.Relays[name] = relay
+	}
+	setInt(&cfg.AWS.MaxInstancesPerRegion, DefaultMaxInstancesPerRegion)
+
+	setString(&cfg.Logging.Level, DefaultLogLevel)
+	setString(&cfg.Logging.Format, DefaultLogFormat)
+
+	setInt(&cfg.Scheduler.InstanceSyncInterval, DefaultInstanceSyncInterval)
+	setInt(&cfg.Scheduler.InstanceWaitTimeout, DefaultInstanceWaitTimeout)
+	setInt(&cfg.Scheduler.TrafficStatsInterval, DefaultTrafficStatsInterval)
+
+	setString(&cfg.Tracing.Exporter, DefaultTracingExporter)
+	setString(&cfg.Tracing.ServiceName, DefaultTracingServiceName)
+	if cfg.Tracing.SampleRatio == 0 {
+		cfg.Tracing.SampleRatio = DefaultTracingSampleRatio
+	}
+
+	setInt(&cfg.Webhook.PollInterval, DefaultWebhookPollInterval)
+	setInt(&cfg.Webhook.MaxAttempts, DefaultWebhookMaxAttempts)
+	setInt(&cfg.Webhook.InitialBackoff, DefaultWebhookInitialBackoff)
+	setInt(&cfg.Webhook.MaxBackoff, DefaultWebhookMaxBackoff)
+	setInt(&cfg.Webhook.Timeout, DefaultWebhookTimeout)
+
+	setString(&cfg.Telegram.APIBaseURL, DefaultTelegramAPIBaseURL)
+	setInt(&cfg.Telegram.PollTimeout, DefaultTelegramPollTimeout)
+
+	setString(&cfg.Cost.Currency, DefaultCostCurrency)
+}
+
+func setInt(field *int, value int) {
+	if *field == 0 {
+		*field = value
+	}
+}
+
+func setString(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
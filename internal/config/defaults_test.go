@@ -0,0 +1,52 @@
+package config
+
+import (
+	"testing"
+)
+
+// TestBackupCount backup_count 未配置时为默认值，配置为 0 时不备份，环境变量同样可以设置为 0
+func TestBackupCount(t *testing.T) {
+	count := func(n int) *int { return &n }
+
+	tests := []struct {
+		name        string
+		configured  *int
+		env         string
+		want        int
+		wantSummary string
+	}{
+		{"not configured", nil, "", DefaultV2RayBackupCount, "v2ray.backup_count = 5"},
+		{"disabled", count(0), "", 0, "v2ray.backup_count = 0"},
+		{"configured", count(3), "", 3, "v2ray.backup_count = 3"},
+		{"disabled by env", nil, "0", 0, "v2ray.backup_count = 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("AW_V2RAY_BACKUP_COUNT", tt.env)
+			}
+			cfg := &Config{V2Ray: V2RayConfig{BackupCount: tt.configured}}
+			if err := applyEnvOverrides(cfg); err != nil {
+				t.Fatal(err)
+			}
+			ApplyDefaults(cfg)
+
+			if got := cfg.V2Ray.Backups(); got != tt.want {
+				t.Errorf("Backups() = %d, want %d", got, tt.want)
+			}
+			found := false
+			for _, line := range Summary(cfg) {
+				if line == tt.wantSummary {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("summary does not contain %q", tt.wantSummary)
+			}
+		})
+	}
+
+	if got := (V2RayConfig{}).Backups(); got != DefaultV2RayBackupCount {
+		t.Errorf("Backups() without defaults = %d, want %d", got, DefaultV2RayBackupCount)
+	}
+}
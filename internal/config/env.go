@@ -0,0 +1,232 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix 环境变量覆盖的前缀
+const EnvPrefix = "AW_"
+
+// envRefPattern 匹配 ${VAR}、${VAR:-default} 以及转义写法 $${VAR}
+var envRefPattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv 展开配置中的 ${ENV} 引用
+// 参数:
+//   - data: YAML 格式的配置内容
+//
+// 返回值:
+//   - []byte: 展开后的配置内容
+//   - error: 错误信息，如果 YAML 格式错误或引用了未设置且没有默认值的环境变量
+//
+// 功能:
+//  1. 只展开标量值，注释中的引用不受影响
+//  2. ${VAR:-default} 在变量未设置或为空时使用默认值
+//  3. $${VAR} 保留为字面量 ${VAR}
+func expandEnv(data []byte) ([]byte, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %v", err)
+	}
+	if root.Kind == 0 {
+		return data, nil
+	}
+
+	var problems []string
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		if node.Kind == yaml.ScalarNode && strings.Contains(node.Value, "${") {
+			node.Value = envRefPattern.ReplaceAllStringFunc(node.Value, func(ref string) string {
+				if strings.HasPrefix(ref, "$$") {
+					return ref[1:]
+				}
+				match := envRefPattern.FindStringSubmatch(ref)
+				if value, ok := os.LookupEnv(match[1]); ok && value != "" {
+					return value
+				}
+				if match[2] != "" {
+					return match[3]
+				}
+				problems = append(problems, fmt.Sprintf("line %d: environment variable %s is not set", node.Line, match[1]))
+				return ""
+			})
+			// 未加引号的值重新推断类型，使 port: ${PORT} 可以解析为整数；
+			// 加引号或显式标注类型的值保持原样
+			if node.Style == 0 {
+				node.Tag = ""
+			}
+		}
+		for _, child := range node.Content {
+			walk(child)
+		}
+	}
+	walk(&root)
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("failed to expand config:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return yaml.Marshal(&root)
+}
+
+// applyEnvOverrides 使用 AW_* 环境变量覆盖配置项
+// 参数:
+//   - cfg: 要覆盖的配置
+//
+// 返回值:
+//   - error: 错误信息，如果环境变量的值无法转换为配置项的类型
+//
+// 功能:
+//  1. 环境变量名为 AW_ 加上大写的 YAML 路径，以下划线连接，例如 AW_DATABASE_PASSWORD
+//  2. 区域等映射类配置使用大写的键名，"-" 替换为 "_"，
+//     例如 AW_AWS_REGIONS_AP_EAST_1_TEMPLATE_ID，只能覆盖配置文件中已存在的键
+//  3. 列表类配置使用逗号分隔，例如 AW_SERVER_API_KEYS=key1,key2
+func applyEnvOverrides(cfg *Config) error {
+	var problems []string
+	walkFields(reflect.ValueOf(cfg).Elem(), nil, func(path []string, field reflect.Value, _ reflect.StructField) {
+		name := EnvName(path)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if err := setFromString(field, value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	})
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("invalid environment overrides:\n  - %s", strings.Join(problems, "\n  - "))
+}
+
+// EnvName 返回配置路径对应的覆盖环境变量名
+// 参数:
+//   - path: YAML 路径，例如 ["aws", "regions", "ap-east-1", "template_id"]
+//
+// 返回值:
+//   - string: 环境变量名，例如 AW_AWS_REGIONS_AP_EAST_1_TEMPLATE_ID
+func EnvName(path []string) string {
+	name := EnvPrefix + strings.ToUpper(strings.Join(path, "_"))
+	return strings.NewReplacer("-", "_", ".", "_").Replace(name)
+}
+
+// copyMaps 返回配置的浅拷贝，其中的映射重新分配
+// 功能:
+//  1. walkFields 会写回映射，在共享的快照上遍历前先复制，避免修改调用方的配置
+func copyMaps(cfg *Config) Config {
+	copied := *cfg
+	copied.Server.Owners = make(map[string]ServerOwnerConfig, len(cfg.Server.Owners))
+	for name, owner := range cfg.Server.Owners {
+		copied.Server.Owners[name] = owner
+	}
+	copied.AWS.Accounts = make(map[string]AWSAccountConfig, len(cfg.AWS.Accounts))
+	for name, account := range cfg.AWS.Accounts {
+		copied.AWS.Accounts[name] = account
+	}
+	copied.AWS.Regions = make(map[string]AWSRegionConfig, len(cfg.AWS.Regions))
+	for region, regionConfig := range cfg.AWS.Regions {
+		copied.AWS.Regions[region] = regionConfig
+	}
+	copied.V2Ray.Relays = make(map[string]RelayConfig, len(cfg.V2Ray.Relays))
+	for name, relay := range cfg.V2Ray.Relays {
+		copied.V2Ray.Relays[name] = relay
+	}
+	return copied
+}
+
+// walkFields 遍历配置中的所有叶子字段
+// 参数:
+//   - v: 结构体值，必须可寻址
+//   - path: 当前 YAML 路径
+//   - fn: 对每个叶子字段调用，映射中的结构体会在调用后写回映射
+func walkFields(v reflect.Value, path []string, fn func(path []string, field reflect.Value, sf reflect.StructField)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fieldPath := append(append([]string{}, path...), name)
+		field := v.Field(i)
+
+		switch field.Kind() {
+		case reflect.Struct:
+			walkFields(field, fieldPath, fn)
+		case reflect.Map:
+			if field.Type().Elem().Kind() != reflect.Struct {
+				fn(fieldPath, field, sf)
+				continue
+			}
+			keys := field.MapKeys()
+			sort.Slice(keys, func(a, b int) bool { return keys[a].String() < keys[b].String() })
+			for _, key := range keys {
+				// 映射中的值不可寻址，复制后修改再写回
+				elem := reflect.New(field.Type().Elem()).Elem()
+				elem.Set(field.MapIndex(key))
+				walkFields(elem, append(append([]string{}, fieldPath...), key.String()), fn)
+				field.SetMapIndex(key, elem)
+			}
+		default:
+			fn(fieldPath, field, sf)
+		}
+	}
+}
+
+// setFromString 将字符串转换为字段类型并赋值
+func setFromString(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		field.SetFloat(f)
+	case reflect.Ptr:
+		// 可选的配置项，例如 v2ray.backup_count，设置后不再使用默认值
+		elem := reflect.New(field.Type().Elem())
+		if err := setFromString(elem.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(elem)
+	case reflect.Slice:
+		var parts []string
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				parts = append(parts, part)
+			}
+		}
+		slice := reflect.MakeSlice(field.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := setFromString(slice.Index(i), part); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
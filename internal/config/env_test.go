@@ -0,0 +1,183 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestExpandEnv 展开标量中的 ${VAR} 引用，未加引号的值重新推断类型，$${VAR} 保留为字面量
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("AW_TEST_PORT", "8080")
+	t.Setenv("AW_TEST_FLAG", "true")
+	t.Setenv("AW_TEST_NAME", "relay")
+	t.Setenv("AW_TEST_EMPTY", "")
+
+	tests := []struct {
+		name    string
+		yaml    string
+		want    interface{}
+		wantErr string
+	}{
+		{"unquoted integer", "value: ${AW_TEST_PORT}", 8080, ""},
+		{"unquoted boolean", "value: ${AW_TEST_FLAG}", true, ""},
+		{"double quoted", `value: "${AW_TEST_PORT}"`, "8080", ""},
+		{"single quoted", `value: '${AW_TEST_PORT}'`, "8080", ""},
+		{"explicit tag", "value: !!str ${AW_TEST_PORT}", "8080", ""},
+		{"inside a string", "value: prefix-${AW_TEST_NAME}-suffix", "prefix-relay-suffix", ""},
+		{"default", "value: ${AW_TEST_MISSING:-fallback}", "fallback", ""},
+		{"default for an empty variable", "value: ${AW_TEST_EMPTY:-fallback}", "fallback", ""},
+		{"empty default", `value: "${AW_TEST_MISSING:-}"`, "", ""},
+		{"set variable ignores the default", "value: ${AW_TEST_NAME:-fallback}", "relay", ""},
+		{"escaped", "value: $${AW_TEST_NAME}", "${AW_TEST_NAME}", ""},
+		{"escaped unset variable", "value: $${AW_TEST_MISSING}", "${AW_TEST_MISSING}", ""},
+		{"escaped next to a reference", "value: $${AW_TEST_NAME}-${AW_TEST_NAME}", "${AW_TEST_NAME}-relay", ""},
+		{"list item", "value:\n  - a\n  - ${AW_TEST_NAME}", []interface{}{"a", "relay"}, ""},
+		{"comment is not expanded", "value: plain # ${AW_TEST_MISSING}", "plain", ""},
+		{"missing variable", "other: 1\nvalue: ${AW_TEST_MISSING}", nil, "line 2: environment variable AW_TEST_MISSING is not set"},
+		{"empty variable without default", "value: ${AW_TEST_EMPTY}", nil, "environment variable AW_TEST_EMPTY is not set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expanded, err := expandEnv([]byte(tt.yaml))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expandEnv() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]interface{}
+			if err := yaml.Unmarshal(expanded, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got["value"], tt.want) {
+				t.Errorf("value = %#v, want %#v", got["value"], tt.want)
+			}
+		})
+	}
+
+	if expanded, err := expandEnv(nil); err != nil || len(expanded) != 0 {
+		t.Errorf("expandEnv(empty) = %q, %v", expanded, err)
+	}
+}
+
+// TestApplyEnvOverrides AW_<大写路径> 覆盖配置项，映射使用已存在的键，列表以逗号分隔
+func TestApplyEnvOverrides(t *testing.T) {
+	base := func() *Config {
+		return &Config{
+			Server: ServerConfig{Port: 8000, APIKeys: []string{"from-file"}},
+			AWS: AWSConfig{Regions: map[string]AWSRegionConfig{
+				"ap-east-1": {TemplateID: "lt-file", Name: "香港"},
+			}},
+			V2Ray: V2RayConfig{Relays: map[string]RelayConfig{
+				"hk-1": {Driver: DriverSSH, SSH: RelaySSHConfig{Host: "file.example.com"}},
+			}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		got     func(cfg *Config) interface{}
+		want    interface{}
+		wantErr string
+	}{
+		{
+			"integer",
+			map[string]string{"AW_SERVER_PORT": "9000"},
+			func(cfg *Config) interface{} { return cfg.Server.Port },
+			9000, "",
+		},
+		{
+			"boolean and float",
+			map[string]string{"AW_TELEGRAM_ENABLED": "true", "AW_TRACING_SAMPLE_RATIO": "0.25"},
+			func(cfg *Config) interface{} { return []interface{}{cfg.Telegram.Enabled, cfg.Tracing.SampleRatio} },
+			[]interface{}{true, 0.25}, "",
+		},
+		{
+			"comma-separated list",
+			map[string]string{"AW_SERVER_API_KEYS": "key1, key2,,key3 "},
+			func(cfg *Config) interface{} { return cfg.Server.APIKeys },
+			[]string{"key1", "key2", "key3"}, "",
+		},
+		{
+			"empty list",
+			map[string]string{"AW_SERVER_API_KEYS": ""},
+			func(cfg *Config) interface{} { return cfg.Server.APIKeys },
+			[]string{}, "",
+		},
+		{
+			"integer list",
+			map[string]string{"AW_TELEGRAM_ALLOWED_CHAT_IDS": "1,-1002"},
+			func(cfg *Config) interface{} { return cfg.Telegram.AllowedChatIDs },
+			[]int64{1, -1002}, "",
+		},
+		{
+			"map key",
+			map[string]string{"AW_AWS_REGIONS_AP_EAST_1_TEMPLATE_ID": "lt-env"},
+			func(cfg *Config) interface{} { return cfg.AWS.Regions["ap-east-1"] },
+			AWSRegionConfig{TemplateID: "lt-env", Name: "香港"}, "",
+		},
+		{
+			"nested map key",
+			map[string]string{"AW_V2RAY_RELAYS_HK_1_SSH_HOST": "env.example.com"},
+			func(cfg *Config) interface{} { return cfg.V2Ray.Relays["hk-1"].SSH.Host },
+			"env.example.com", "",
+		},
+		{
+			"map key not in the file",
+			map[string]string{"AW_AWS_REGIONS_US_WEST_2_TEMPLATE_ID": "lt-env"},
+			func(cfg *Config) interface{} { return len(cfg.AWS.Regions) },
+			1, "",
+		},
+		{
+			"invalid values",
+			map[string]string{"AW_SERVER_PORT": "http", "AW_TELEGRAM_ALLOWED_CHAT_IDS": "1,two"},
+			nil, nil,
+			"invalid environment overrides:\n  - AW_SERVER_PORT: \"http\" is not an integer\n  - AW_TELEGRAM_ALLOWED_CHAT_IDS: \"two\" is not an integer",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			cfg := base()
+			err := applyEnvOverrides(cfg)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("applyEnvOverrides() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.got(cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestEnvName 路径以下划线连接并转为大写，"-" 和 "." 替换为 "_"
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		path []string
+		want string
+	}{
+		{[]string{"database", "password"}, "AW_DATABASE_PASSWORD"},
+		{[]string{"aws", "regions", "ap-east-1", "template_id"}, "AW_AWS_REGIONS_AP_EAST_1_TEMPLATE_ID"},
+		{[]string{"cost", "instance_types", "t3.micro"}, "AW_COST_INSTANCE_TYPES_T3_MICRO"},
+	}
+	for _, tt := range tests {
+		if got := EnvName(tt.path); got != tt.want {
+			t.Errorf("EnvName(%v) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
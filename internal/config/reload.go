@@ -0,0 +1,129 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// current 当前生效的配置，重新加载时整体替换
+	current atomic.Pointer[Config]
+
+	// reloadMu 保护 loadedPath 和 listeners，并串行化重新加载
+	reloadMu   sync.Mutex
+	loadedPath string
+	listeners  []func(old, new *Config)
+)
+
+// restartOnlyFields 修改后需要重启服务才能生效的配置项
+var restartOnlyFields = []string{
+	"server.host",
+	"server.port",
+	"database",
+	"logging.format",
+	"tracing",
+	"v2ray.local_config_path",
+	"v2ray.engine",
+	"v2ray.relays",
+	"webhook.timeout",
+	"telegram.enabled",
+	"telegram.token",
+	"telegram.api_base_url",
+}
+
+// Get 返回当前生效的配置
+// 返回值:
+//   - *Config: 配置快照，调用方不能修改；需要读取多个字段时应保存同一个快照，
+//     避免在两次读取之间配置被重新加载
+func Get() *Config {
+	return current.Load()
+}
+
+// Set 替换当前生效的配置，不会通知 OnReload 注册的监听函数
+// 参数:
+//   - cfg: 新配置，替换后不能再修改
+func Set(cfg *Config) {
+	current.Store(cfg)
+}
+
+// Path 返回 LoadConfig 加载的配置文件绝对路径
+func Path() string {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	return loadedPath
+}
+
+// OnReload 注册配置重新加载后的监听函数
+// 参数:
+//   - fn: 监听函数，参数为重新加载前后的配置，在 Reload 所在的 goroutine 中同步调用
+func OnReload(fn func(old, new *Config)) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	listeners = append(listeners, fn)
+}
+
+// Reload 重新加载配置文件
+// 返回值:
+//   - *Config: 重新加载前的配置
+//   - *Config: 重新加载后的配置
+//   - error: 错误信息，如果读取或校验失败，此时当前配置保持不变
+//
+// 功能:
+//  1. 按 LoadConfig 相同的流程读取、解析并校验配置文件
+//  2. 校验通过后原子替换当前配置
+//  3. 依次调用 OnReload 注册的监听函数
+func Reload() (*Config, *Config, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	if loadedPath == "" {
+		return nil, nil, fmt.Errorf("config has not been loaded from a file")
+	}
+	config, err := readConfig(loadedPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	old := current.Swap(config)
+	for _, fn := range listeners {
+		fn(old, config)
+	}
+	return old, config, nil
+}
+
+// RestartRequired 返回两份配置之间需要重启才能生效的差异
+// 参数:
+//   - old: 重新加载前的配置
+//   - new: 重新加载后的配置
+//
+// 返回值:
+//   - []string: 发生变化且需要重启的配置项路径
+func RestartRequired(old, new *Config) []string {
+	if old == nil || new == nil {
+		return nil
+	}
+	var changed []string
+	for _, path := range restartOnlyFields {
+		if !reflect.DeepEqual(lookupField(old, path), lookupField(new, path)) {
+			changed = append(changed, path)
+		}
+	}
+	return changed
+}
+
+// lookupField 按 YAML 路径读取配置字段，path 为分组时返回分组下的所有字段
+func lookupField(cfg *Config, path string) []interface{} {
+	copied := copyMaps(cfg)
+
+	var values []interface{}
+	walkFields(reflect.ValueOf(&copied).Elem(), nil, func(fieldPath []string, field reflect.Value, _ reflect.StructField) {
+		joined := strings.Join(fieldPath, ".")
+		if joined == path || strings.HasPrefix(joined, path+".") {
+			values = append(values, field.Interface())
+		}
+	})
+	return values
+}
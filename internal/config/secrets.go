@@ -0,0 +1,168 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/yuhai94/anywhere_backend/internal/vault"
+)
+
+// 密钥引用前缀
+const (
+	SecretEnvPrefix   = "env:"
+	SecretFilePrefix  = "file:"
+	SecretVaultPrefix = "vault:"
+)
+
+// ResolveSecret 解析密钥引用
+// 参数:
+//   - value: 配置值，可以是明文或以下引用之一：
+//     env:NAME 读取环境变量，file:/path 读取文件内容（去掉首尾空白），vault:name 读取加密保险库
+//   - v: 保险库，为 nil 时不支持 vault: 引用
+//
+// 返回值:
+//   - string: 解析后的明文值，不是引用时原样返回
+//   - error: 错误信息，如果引用的环境变量、文件或保险库条目不存在
+func ResolveSecret(value string, v *vault.Vault) (string, error) {
+	switch {
+	case strings.HasPrefix(value, SecretEnvPrefix):
+		name := strings.TrimPrefix(value, SecretEnvPrefix)
+		resolved, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return resolved, nil
+	case strings.HasPrefix(value, SecretFilePrefix):
+		path := strings.TrimPrefix(value, SecretFilePrefix)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %v", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	case strings.HasPrefix(value, SecretVaultPrefix):
+		if v == nil {
+			return "", fmt.Errorf("vault references require secrets.vault_file and secrets.vault_key")
+		}
+		return v.Get(strings.TrimPrefix(value, SecretVaultPrefix))
+	default:
+		return value, nil
+	}
+}
+
+// OpenVault 按配置打开加密保险库
+// 参数:
+//   - cfg: 密钥配置，vault_key 支持 env: 和 file: 引用
+//
+// 返回值:
+//   - *vault.Vault: 保险库，未配置 vault_file 时为 nil
+//   - error: 错误信息，如果密钥无效或读取保险库失败
+func OpenVault(cfg SecretsConfig) (*vault.Vault, error) {
+	if cfg.VaultFile == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(cfg.VaultKey, SecretVaultPrefix) {
+		return nil, fmt.Errorf("secrets.vault_key cannot reference the vault itself")
+	}
+	encoded, err := ResolveSecret(cfg.VaultKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("secrets.vault_key: %v", err)
+	}
+	if encoded == "" {
+		return nil, fmt.Errorf("secrets.vault_key is required when secrets.vault_file is set")
+	}
+	key, err := vault.ParseKey(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("secrets.vault_key: %v", err)
+	}
+	return vault.Open(cfg.VaultFile, key)
+}
+
+// resolveSecrets 解析配置中所有标记为 secret 的字段的引用
+// 功能:
+//  1. 先解析 secrets.vault_key，只在有 vault: 引用时才打开保险库
+//  2. 列表中的每个元素单独解析
+//  3. 一次性返回所有无法解析的引用
+func resolveSecrets(cfg *Config) error {
+	var v *vault.Vault
+	var vaultErr error
+	vaultOpened := false
+	getVault := func() (*vault.Vault, error) {
+		if !vaultOpened {
+			v, vaultErr = OpenVault(cfg.Secrets)
+			vaultOpened = true
+		}
+		return v, vaultErr
+	}
+
+	var problems []string
+	resolve := func(path string, value string) string {
+		var opened *vault.Vault
+		if strings.HasPrefix(value, SecretVaultPrefix) {
+			var err error
+			if opened, err = getVault(); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+				return value
+			}
+		}
+		resolved, err := ResolveSecret(value, opened)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+			return value
+		}
+		return resolved
+	}
+
+	walkFields(reflect.ValueOf(cfg).Elem(), nil, func(path []string, field reflect.Value, sf reflect.StructField) {
+		if sf.Tag.Get("secret") != "true" || strings.Join(path, ".") == "secrets.vault_key" {
+			return
+		}
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(resolve(strings.Join(path, "."), field.String()))
+		case reflect.Slice:
+			for i := 0; i < field.Len(); i++ {
+				elem := field.Index(i)
+				elem.SetString(resolve(fmt.Sprintf("%s[%d]", strings.Join(path, "."), i), elem.String()))
+			}
+		}
+	})
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("failed to resolve secrets:\n  - %s", strings.Join(problems, "\n  - "))
+}
+
+// SecretValues 返回配置中所有敏感字段的值
+// 参数:
+//   - cfg: 已解析引用的配置
+//
+// 返回值:
+//   - []string: 非空的敏感值，用于在日志中脱敏
+func SecretValues(cfg *Config) []string {
+	copied := copyMaps(cfg)
+
+	var values []string
+	walkFields(reflect.ValueOf(&copied).Elem(), nil, func(path []string, field reflect.Value, sf reflect.StructField) {
+		if sf.Tag.Get("secret") != "true" {
+			return
+		}
+		switch field.Kind() {
+		case reflect.String:
+			if field.String() != "" {
+				values = append(values, field.String())
+			}
+		case reflect.Slice:
+			for i := 0; i < field.Len(); i++ {
+				if s := field.Index(i).String(); s != "" {
+					values = append(values, s)
+				}
+			}
+		}
+	})
+	return values
+}
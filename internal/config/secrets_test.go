@@ -0,0 +1,235 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/yuhai94/anywhere_backend/internal/vault"
+)
+
+// newTestVault 创建包含指定值的保险库文件，返回对应的密钥配置，密钥通过环境变量引用
+func newTestVault(t *testing.T, secrets map[string]string) SecretsConfig {
+	t.Helper()
+	encoded, err := vault.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := vault.ParseKey(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "secrets.vault")
+	v, err := vault.Open(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range secrets {
+		if err := v.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AW_TEST_VAULT_KEY", encoded)
+	return SecretsConfig{VaultFile: path, VaultKey: "env:AW_TEST_VAULT_KEY"}
+}
+
+// TestResolveSecret 明文原样返回，env:、file: 和 vault: 引用读取对应的值
+func TestResolveSecret(t *testing.T) {
+	secretsConfig := newTestVault(t, map[string]string{"db_password": "from vault"})
+	v, err := OpenVault(secretsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(file, []byte("  from file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AW_TEST_SECRET", "from env")
+
+	tests := []struct {
+		name    string
+		value   string
+		vault   *vault.Vault
+		want    string
+		wantErr string
+	}{
+		{"plain", "plain text", nil, "plain text", ""},
+		{"env", "env:AW_TEST_SECRET", nil, "from env", ""},
+		{"missing env", "env:AW_TEST_MISSING", nil, "", "environment variable AW_TEST_MISSING is not set"},
+		{"file", "file:" + file, nil, "from file", ""},
+		{"missing file", "file:" + file + ".missing", nil, "", "failed to read secret file"},
+		{"vault", "vault:db_password", v, "from vault", ""},
+		{"missing vault entry", "vault:missing", v, "", "secret not found in vault"},
+		{"vault not configured", "vault:db_password", nil, "", "require secrets.vault_file and secrets.vault_key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveSecret(tt.value, tt.vault)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ResolveSecret() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveSecret() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestOpenVault 未配置 vault_file 时不打开保险库，vault_key 不能引用保险库本身，也不能为空或无效
+func TestOpenVault(t *testing.T) {
+	secretsConfig := newTestVault(t, nil)
+	t.Setenv("AW_TEST_BAD_KEY", "c2hvcnQ=")
+
+	tests := []struct {
+		name    string
+		cfg     SecretsConfig
+		wantErr string
+	}{
+		{"env key", secretsConfig, ""},
+		{"no vault file", SecretsConfig{VaultKey: "vault:key"}, ""},
+		{"key in the vault", SecretsConfig{VaultFile: secretsConfig.VaultFile, VaultKey: "vault:key"}, "cannot reference the vault itself"},
+		{"no key", SecretsConfig{VaultFile: secretsConfig.VaultFile}, "secrets.vault_key is required"},
+		{"missing env key", SecretsConfig{VaultFile: secretsConfig.VaultFile, VaultKey: "env:AW_TEST_MISSING"}, "secrets.vault_key: environment variable"},
+		{"invalid key", SecretsConfig{VaultFile: secretsConfig.VaultFile, VaultKey: "env:AW_TEST_BAD_KEY"}, "must be 32 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := OpenVault(tt.cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("OpenVault() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (v != nil) != (tt.cfg.VaultFile != "") {
+				t.Errorf("OpenVault() = %v, want a vault only when vault_file is set", v)
+			}
+		})
+	}
+}
+
+// TestResolveSecrets 解析所有敏感字段的引用，包括映射和列表中的值，无法解析的引用一次性返回
+func TestResolveSecrets(t *testing.T) {
+	secretsConfig := newTestVault(t, map[string]string{"db_password": "from vault", "owner_key": "owner key"})
+	t.Setenv("AW_TEST_SECRET", "from env")
+
+	cfg := &Config{
+		Server: ServerConfig{
+			APIKeys: []string{"plain", "env:AW_TEST_SECRET"},
+			Owners:  map[string]ServerOwnerConfig{"alice": {APIKeys: []string{"vault:owner_key"}}},
+		},
+		Database: DatabaseConfig{Password: "vault:db_password"},
+		Secrets:  secretsConfig,
+	}
+	if err := resolveSecrets(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"plain", "from env"}; !reflect.DeepEqual(cfg.Server.APIKeys, want) {
+		t.Errorf("server.api_keys = %v, want %v", cfg.Server.APIKeys, want)
+	}
+	if got := cfg.Server.Owners["alice"].APIKeys; !reflect.DeepEqual(got, []string{"owner key"}) {
+		t.Errorf("server.owners.alice.api_keys = %v, want [owner key]", got)
+	}
+	if cfg.Database.Password != "from vault" {
+		t.Errorf("database.password = %q, want from vault", cfg.Database.Password)
+	}
+	if cfg.Secrets.VaultKey != secretsConfig.VaultKey {
+		t.Errorf("secrets.vault_key was resolved to %q", cfg.Secrets.VaultKey)
+	}
+
+	broken := &Config{
+		Database: DatabaseConfig{Password: "vault:missing"},
+		Telegram: TelegramConfig{Token: "env:AW_TEST_MISSING"},
+		Secrets:  secretsConfig,
+	}
+	err := resolveSecrets(broken)
+	if err == nil {
+		t.Fatal("resolveSecrets() succeeded with missing references")
+	}
+	for _, want := range []string{"database.password: secret not found in vault: missing", "telegram.token: environment variable AW_TEST_MISSING is not set"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+}
+
+// secretFieldCount 按类型统计配置中标记了 secret:"true" 的字段数量，映射中的结构体按一个元素计算
+func secretFieldCount(t reflect.Type) int {
+	count := 0
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		switch {
+		case sf.Type.Kind() == reflect.Struct:
+			count += secretFieldCount(sf.Type)
+		case sf.Type.Kind() == reflect.Map && sf.Type.Elem().Kind() == reflect.Struct:
+			count += secretFieldCount(sf.Type.Elem())
+		case sf.Tag.Get("secret") == "true":
+			count++
+		}
+	}
+	return count
+}
+
+// TestSecretValues 返回所有敏感字段的非空值，包括映射中的结构体和列表中的每个元素
+func TestSecretValues(t *testing.T) {
+	cfg := &Config{
+		Server: ServerConfig{
+			APIKeys: []string{"server-key-1", "", "server-key-2"},
+			Owners:  map[string]ServerOwnerConfig{"alice": {APIKeys: []string{"owner-key"}}},
+		},
+		Database: DatabaseConfig{User: "anywhere", Password: "db-password"},
+		AWS: AWSConfig{
+			AccessKey: "aws-access-key",
+			SecretKey: "aws-secret-key",
+			Profile:   "default",
+			Accounts: map[string]AWSAccountConfig{
+				"prod": {AccessKey: "prod-access-key", SecretKey: "prod-secret-key", ExternalID: "prod-external-id"},
+			},
+			Regions: map[string]AWSRegionConfig{"us-east-1": {Name: "Virginia"}},
+		},
+		V2Ray: V2RayConfig{
+			Relays: map[string]RelayConfig{
+				"hk": {SSH: RelaySSHConfig{User: "root", PrivateKey: "ssh-private-key", Passphrase: "ssh-passphrase"}},
+			},
+		},
+		Telegram: TelegramConfig{Token: "telegram-token"},
+		Secrets:  SecretsConfig{VaultFile: "conf/secrets.vault", VaultKey: "vault-key"},
+	}
+	want := []string{
+		"aws-access-key", "aws-secret-key", "db-password", "owner-key",
+		"prod-access-key", "prod-external-id", "prod-secret-key",
+		"server-key-1", "server-key-2", "ssh-passphrase", "ssh-private-key",
+		"telegram-token", "vault-key",
+	}
+
+	// 每个敏感字段都在 cfg 中设置了值（server.api_keys 有两个），新增敏感字段时需要同时更新本测试
+	if fields := secretFieldCount(reflect.TypeOf(Config{})); fields != len(want)-1 {
+		t.Fatalf("config has %d secret fields, the test sets %d", fields, len(want)-1)
+	}
+
+	got := SecretValues(cfg)
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SecretValues() = %v, want %v", got, want)
+	}
+
+	// 不修改原配置中的映射
+	if cfg.AWS.Accounts["prod"].AccessKey != "prod-access-key" || cfg.V2Ray.Relays["hk"].SSH.PrivateKey != "ssh-private-key" {
+		t.Error("SecretValues() modified the config")
+	}
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// redacted 敏感配置项在摘要中的显示值
+const redacted = "******"
+
+// Summary 生成配置摘要，敏感信息已脱敏
+// 参数:
+//   - cfg: 要输出的配置
+//
+// 返回值:
+//   - []string: 每个配置项一行，格式为 "路径 = 值"，按配置结构顺序排列
+//
+// 功能:
+//  1. 标记了 secret:"true" 的字段只显示是否已设置，不输出内容
+//  2. 用于启动日志和 validate-config 的输出
+func Summary(cfg *Config) []string {
+	copied := copyMaps(cfg)
+
+	var lines []string
+	walkFields(reflect.ValueOf(&copied).Elem(), nil, func(path []string, field reflect.Value, sf reflect.StructField) {
+		value := formatValue(field)
+		if sf.Tag.Get("secret") == "true" {
+			value = redactValue(field)
+		}
+		lines = append(lines, fmt.Sprintf("%s = %s", strings.Join(path, "."), value))
+	})
+	return lines
+}
+
+// formatValue 返回字段的显示值，可选配置项未设置时为 "(default)"
+func formatValue(field reflect.Value) string {
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return "(default)"
+		}
+		field = field.Elem()
+	}
+	return fmt.Sprint(field.Interface())
+}
+
+// redactValue 返回敏感字段的脱敏表示
+func redactValue(field reflect.Value) string {
+	if field.Kind() == reflect.Slice {
+		return fmt.Sprintf("[%d items %s]", field.Len(), redacted)
+	}
+	if field.IsZero() {
+		return "(not set)"
+	}
+	return redacted
+}
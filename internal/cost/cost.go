@@ -0,0 +1,335 @@
+// Package cost 根据实例的运行时间和价格表计算费用
+package cost
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+)
+
+// 预算范围
+const (
+	ScopeTotal  = "total"
+	ScopeRegion = "region"
+	ScopeOwner  = "owner"
+)
+
+// bytesPerGB 流量计费使用的 GB 换算
+const bytesPerGB = 1 << 30
+
+// Spend 一组实例在时间段内的费用
+type Spend struct {
+	RunHours     float64 `json:"run_hours"`
+	InstanceCost float64 `json:"instance_cost"`
+	EIPCost      float64 `json:"eip_cost"`
+	TrafficGB    float64 `json:"traffic_gb"`
+	TrafficCost  float64 `json:"traffic_cost"`
+	Total        float64 `json:"total"`
+	// Projected 按当前运行中实例的费率推算到时间段结束时的总费用
+	Projected float64 `json:"projected"`
+}
+
+// add 累加另一组费用
+func (s *Spend) add(other Spend) {
+	s.RunHours += other.RunHours
+	s.InstanceCost += other.InstanceCost
+	s.EIPCost += other.EIPCost
+	s.TrafficGB += other.TrafficGB
+	s.TrafficCost += other.TrafficCost
+	s.Total += other.Total
+	s.Projected += other.Projected
+}
+
+// round 将费用保留四位小数，运行时长保留两位小数
+func (s *Spend) round() {
+	s.RunHours = roundTo(s.RunHours, 2)
+	s.InstanceCost = roundTo(s.InstanceCost, 4)
+	s.EIPCost = roundTo(s.EIPCost, 4)
+	s.TrafficGB = roundTo(s.TrafficGB, 4)
+	s.TrafficCost = roundTo(s.TrafficCost, 4)
+	s.Total = roundTo(s.Total, 4)
+	s.Projected = roundTo(s.Projected, 4)
+}
+
+// InstanceSpend 单个实例的费用
+type InstanceSpend struct {
+	InstanceUUID string `json:"instance_uuid"`
+	Region       string `json:"region"`
+	Owner        string `json:"owner"`
+	InstanceType string `json:"instance_type"`
+	// Running 实例当前是否仍在运行
+	Running bool `json:"running"`
+	Spend
+}
+
+// GroupSpend 按区域或创建者汇总的费用
+type GroupSpend struct {
+	Name      string `json:"name"`
+	Instances int    `json:"instances"`
+	Spend
+}
+
+// BudgetStatus 一项预算的使用情况
+type BudgetStatus struct {
+	Scope     string  `json:"scope"`
+	Name      string  `json:"name,omitempty"`
+	Limit     float64 `json:"limit"`
+	Spent     float64 `json:"spent"`
+	Projected float64 `json:"projected"`
+	Exceeded  bool    `json:"exceeded"`
+}
+
+// Report 一个月的费用报告
+type Report struct {
+	Month       string          `json:"month"`
+	From        time.Time       `json:"from"`
+	To          time.Time       `json:"to"`
+	GeneratedAt time.Time       `json:"generated_at"`
+	Currency    string          `json:"currency"`
+	Total       Spend           `json:"total"`
+	Instances   []InstanceSpend `json:"instances"`
+	Regions     []GroupSpend    `json:"regions"`
+	Owners      []GroupSpend    `json:"owners"`
+	Budgets     []BudgetStatus  `json:"budgets"`
+}
+
+// MonthRange 返回时间所在月份的起止时间
+// 参数:
+//   - t: 月份中的任意时间，使用其所在时区
+//
+// 返回值:
+//   - time.Time: 月初（包含）
+//   - time.Time: 下月初（不包含）
+func MonthRange(t time.Time) (time.Time, time.Time) {
+	from := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	return from, from.AddDate(0, 1, 0)
+}
+
+// HourlyRate 返回实例类型每小时的费用，包括实例和公网 IP
+// 参数:
+//   - cfg: 费用配置
+//   - instanceType: EC2 实例类型，未知时使用 default_hourly
+func HourlyRate(cfg config.CostConfig, instanceType string) float64 {
+	price, ok := cfg.InstanceTypes[instanceType]
+	if !ok {
+		price = cfg.DefaultHourly
+	}
+	return price + cfg.EIPHourly
+}
+
+// Compute 根据运行记录计算一个月的费用报告
+// 参数:
+//   - cfg: 费用配置
+//   - month: 报告月份中的任意时间
+//   - now: 当前时间，用于计算未结束的运行记录和月底预测
+//   - records: 与该月有重叠的运行记录
+//   - trafficBytes: 每个实例在该月的流量（字节），未统计时为 nil
+//
+// 返回值:
+//   - *Report: 费用报告，实例、区域和创建者按费用从高到低排序
+//
+// 功能:
+//  1. 运行记录截取到月份范围内，未结束的记录计算到当前时间
+//  2. 实例费用为运行小时数乘以实例类型价格，公网 IP 费用按运行小时数计算
+//  3. 当月报告中，运行中的实例按当前费率推算到月底，流量费用按已过去的时间线性推算
+//  4. 计算每项预算的使用情况
+func Compute(cfg config.CostConfig, month, now time.Time, records []*models.UsageRecord, trafficBytes map[string]int64) *Report {
+	from, to := MonthRange(month)
+	report := &Report{
+		Month:       from.Format("2006-01"),
+		From:        from,
+		To:          to,
+		GeneratedAt: now,
+		Currency:    cfg.Currency,
+	}
+
+	// 月份中已经过去的部分
+	end := to
+	if now.Before(end) {
+		end = now
+	}
+	remainingHours := to.Sub(end).Hours()
+	elapsedHours := end.Sub(from).Hours()
+
+	byInstance := make(map[string]*InstanceSpend)
+	instanceOf := func(uuid string) *InstanceSpend {
+		if _, ok := byInstance[uuid]; !ok {
+			byInstance[uuid] = &InstanceSpend{InstanceUUID: uuid}
+		}
+		return byInstance[uuid]
+	}
+
+	for _, record := range records {
+		start := record.StartedAt
+		if start.Before(from) {
+			start = from
+		}
+		stop := end
+		running := !record.StoppedAt.Valid
+		if record.StoppedAt.Valid && record.StoppedAt.Time.Before(stop) {
+			stop = record.StoppedAt.Time
+		}
+
+		instance := instanceOf(record.InstanceUUID)
+		instance.Region = record.Region
+		instance.Owner = record.Owner
+		instance.InstanceType = record.InstanceType
+		instance.Running = instance.Running || running
+		if stop.After(start) {
+			hours := stop.Sub(start).Hours()
+			instance.RunHours += hours
+			instance.InstanceCost += hours * (HourlyRate(cfg, record.InstanceType) - cfg.EIPHourly)
+			instance.EIPCost += hours * cfg.EIPHourly
+		}
+	}
+
+	for uuid, bytes := range trafficBytes {
+		if _, ok := byInstance[uuid]; !ok {
+			continue
+		}
+		instance := byInstance[uuid]
+		instance.TrafficGB = float64(bytes) / bytesPerGB
+		instance.TrafficCost = instance.TrafficGB * cfg.TrafficPerGB
+	}
+
+	regions := make(map[string]*GroupSpend)
+	owners := make(map[string]*GroupSpend)
+	for _, instance := range byInstance {
+		instance.Total = instance.InstanceCost + instance.EIPCost + instance.TrafficCost
+		instance.Projected = instance.Total
+		if instance.Running {
+			instance.Projected += remainingHours * HourlyRate(cfg, instance.InstanceType)
+		}
+		if elapsedHours > 0 && remainingHours > 0 {
+			instance.Projected += instance.TrafficCost * remainingHours / elapsedHours
+		}
+
+		for _, group := range []*GroupSpend{groupOf(regions, instance.Region), groupOf(owners, instance.Owner)} {
+			group.Instances++
+			group.add(instance.Spend)
+		}
+		report.Total.add(instance.Spend)
+	}
+
+	report.Instances = make([]InstanceSpend, 0, len(byInstance))
+	for _, instance := range byInstance {
+		instance.round()
+		report.Instances = append(report.Instances, *instance)
+	}
+	sort.Slice(report.Instances, func(i, j int) bool {
+		if report.Instances[i].Total != report.Instances[j].Total {
+			return report.Instances[i].Total > report.Instances[j].Total
+		}
+		return report.Instances[i].InstanceUUID < report.Instances[j].InstanceUUID
+	})
+	report.Regions = sortedGroups(regions)
+	report.Owners = sortedGroups(owners)
+	report.Budgets = budgets(cfg.Budget, report.Total, regions, owners)
+	report.Total.round()
+	return report
+}
+
+// groupOf 返回指定名称的汇总项，不存在时创建
+func groupOf(groups map[string]*GroupSpend, name string) *GroupSpend {
+	if _, ok := groups[name]; !ok {
+		groups[name] = &GroupSpend{Name: name}
+	}
+	return groups[name]
+}
+
+// sortedGroups 返回按费用从高到低排序的汇总项
+func sortedGroups(groups map[string]*GroupSpend) []GroupSpend {
+	sorted := make([]GroupSpend, 0, len(groups))
+	for _, group := range groups {
+		copied := *group
+		copied.round()
+		sorted = append(sorted, copied)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Total != sorted[j].Total {
+			return sorted[i].Total > sorted[j].Total
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// budgets 计算每项已配置预算的使用情况
+func budgets(cfg config.BudgetConfig, total Spend, regions, owners map[string]*GroupSpend) []BudgetStatus {
+	var statuses []BudgetStatus
+	status := func(scope, name string, limit float64, spend Spend) {
+		if limit <= 0 {
+			return
+		}
+		statuses = append(statuses, BudgetStatus{
+			Scope:     scope,
+			Name:      name,
+			Limit:     limit,
+			Spent:     roundTo(spend.Total, 4),
+			Projected: roundTo(spend.Projected, 4),
+			Exceeded:  spend.Total >= limit,
+		})
+	}
+
+	status(ScopeTotal, "", cfg.Monthly, total)
+	for _, name := range sortedKeys(cfg.Regions) {
+		status(ScopeRegion, name, cfg.Regions[name], spendOf(regions, name))
+	}
+	for _, name := range sortedKeys(cfg.Owners) {
+		status(ScopeOwner, name, cfg.Owners[name], spendOf(owners, name))
+	}
+	return statuses
+}
+
+// spendOf 返回汇总项的费用，不存在时为零
+func spendOf(groups map[string]*GroupSpend, name string) Spend {
+	if group, ok := groups[name]; ok {
+		return group.Spend
+	}
+	return Spend{}
+}
+
+// CheckBudget 检查在指定区域为指定创建者创建实例是否会超出预算
+// 参数:
+//   - report: 当月的费用报告
+//   - region: AWS 区域
+//   - owner: 创建者
+//
+// 返回值:
+//   - error: 适用于该区域或创建者的预算已用完时返回错误，否则为 nil
+func CheckBudget(report *Report, region, owner string) error {
+	for _, budget := range report.Budgets {
+		if !budget.Exceeded {
+			continue
+		}
+		switch {
+		case budget.Scope == ScopeTotal:
+			return fmt.Errorf("monthly budget of %.2f %s exceeded (spent %.2f)", budget.Limit, report.Currency, budget.Spent)
+		case budget.Scope == ScopeRegion && budget.Name == region:
+			return fmt.Errorf("monthly budget of %.2f %s for region %s exceeded (spent %.2f)", budget.Limit, report.Currency, region, budget.Spent)
+		case budget.Scope == ScopeOwner && budget.Name == owner:
+			return fmt.Errorf("monthly budget of %.2f %s for owner %s exceeded (spent %.2f)", budget.Limit, report.Currency, owner, budget.Spent)
+		}
+	}
+	return nil
+}
+
+// sortedKeys 返回按字母排序的映射键
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// roundTo 四舍五入到指定的小数位数
+func roundTo(value float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(value*scale) / scale
+}
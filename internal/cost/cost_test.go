@@ -0,0 +1,202 @@
+package cost
+
+import (
+	"database/sql"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+)
+
+var testCost = config.CostConfig{
+	Currency:      "USD",
+	InstanceTypes: map[string]float64{"t3.micro": 0.01},
+	DefaultHourly: 0.02,
+	EIPHourly:     0.005,
+	TrafficPerGB:  0.1,
+}
+
+// at 返回 2026 年 1 月中的时间
+func at(day, hour int) time.Time {
+	return time.Date(2026, 1, day, hour, 0, 0, 0, time.UTC)
+}
+
+// usage 构造一条运行记录，stopped 为零值时表示仍在运行
+func usage(uuid, region, owner, instanceType string, started, stopped time.Time) *models.UsageRecord {
+	return &models.UsageRecord{
+		InstanceUUID: uuid,
+		Region:       region,
+		Owner:        owner,
+		InstanceType: instanceType,
+		StartedAt:    started,
+		StoppedAt:    sql.NullTime{Time: stopped, Valid: !stopped.IsZero()},
+	}
+}
+
+// TestCompute 运行时间截取到月份范围内，运行中的实例和流量按剩余时间推算到月底
+func TestCompute(t *testing.T) {
+	// 1 月共 744 小时，1 月 11 日 0 点已过去 240 小时，剩余 504 小时
+	now := at(11, 0)
+
+	type want struct {
+		runHours  float64
+		total     float64
+		projected float64
+		running   bool
+	}
+	tests := []struct {
+		name    string
+		month   time.Time
+		records []*models.UsageRecord
+		traffic map[string]int64
+		want    map[string]want
+	}{
+		{
+			name:    "clipped to the start of the month",
+			month:   now,
+			records: []*models.UsageRecord{usage("a", "r1", "alice", "t3.micro", time.Date(2025, 12, 31, 12, 0, 0, 0, time.UTC), at(2, 0))},
+			// 24 小时 × (0.01 + 0.005)
+			want: map[string]want{"a": {runHours: 24, total: 0.36, projected: 0.36}},
+		},
+		{
+			name:    "running instance projected to the end of the month",
+			month:   now,
+			records: []*models.UsageRecord{usage("b", "r1", "bob", "unknown", at(10, 0), time.Time{})},
+			// 未知类型使用 default_hourly：24 × 0.025 = 0.6，再加剩余 504 × 0.025 = 12.6
+			want: map[string]want{"b": {runHours: 24, total: 0.6, projected: 13.2, running: true}},
+		},
+		{
+			name:    "traffic projected linearly",
+			month:   now,
+			records: []*models.UsageRecord{usage("b", "r1", "bob", "unknown", at(10, 0), time.Time{})},
+			traffic: map[string]int64{"b": 1 << 30, "unknown-instance": 1 << 30},
+			// 流量 0.1，按 504 / 240 推算再加 0.21
+			want: map[string]want{"b": {runHours: 24, total: 0.7, projected: 13.51, running: true}},
+		},
+		{
+			name:  "several runs of one instance",
+			month: now,
+			records: []*models.UsageRecord{
+				usage("a", "r1", "alice", "t3.micro", at(1, 0), at(1, 10)),
+				usage("a", "r1", "alice", "t3.micro", at(2, 0), at(2, 10)),
+			},
+			want: map[string]want{"a": {runHours: 20, total: 0.3, projected: 0.3}},
+		},
+		{
+			name:    "past month is not projected",
+			month:   time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC),
+			records: []*models.UsageRecord{usage("b", "r1", "bob", "t3.micro", time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC), time.Time{})},
+			// 截取到 12 月底，共 24 小时
+			want: map[string]want{"b": {runHours: 24, total: 0.36, projected: 0.36, running: true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := Compute(testCost, tt.month, now, tt.records, tt.traffic)
+			if len(report.Instances) != len(tt.want) {
+				t.Fatalf("got %d instances, want %d", len(report.Instances), len(tt.want))
+			}
+			for _, instance := range report.Instances {
+				w := tt.want[instance.InstanceUUID]
+				if !approx(instance.RunHours, w.runHours) || !approx(instance.Total, w.total) || !approx(instance.Projected, w.projected) || instance.Running != w.running {
+					t.Errorf("instance %s = {run_hours %v, total %v, projected %v, running %v}, want %+v",
+						instance.InstanceUUID, instance.RunHours, instance.Total, instance.Projected, instance.Running, w)
+				}
+			}
+		})
+	}
+}
+
+// TestComputeGroups 区域和创建者的汇总等于其实例费用之和，按费用从高到低排序
+func TestComputeGroups(t *testing.T) {
+	now := at(11, 0)
+	records := []*models.UsageRecord{
+		usage("a", "r1", "alice", "t3.micro", at(1, 0), at(2, 0)),
+		usage("b", "r1", "bob", "unknown", at(1, 0), at(2, 0)),
+		usage("c", "r2", "alice", "unknown", at(1, 0), at(3, 0)),
+	}
+	report := Compute(testCost, now, now, records, nil)
+
+	if !approx(report.Total.Total, 0.36+0.6+1.2) {
+		t.Errorf("total = %v, want %v", report.Total.Total, 0.36+0.6+1.2)
+	}
+	wantRegions := []GroupSpend{{Name: "r2", Instances: 1}, {Name: "r1", Instances: 2}}
+	for i, region := range report.Regions {
+		if region.Name != wantRegions[i].Name || region.Instances != wantRegions[i].Instances {
+			t.Errorf("region %d = %s with %d instances, want %s with %d", i, region.Name, region.Instances, wantRegions[i].Name, wantRegions[i].Instances)
+		}
+	}
+	if len(report.Owners) != 2 || report.Owners[0].Name != "alice" || !approx(report.Owners[0].Total, 1.56) {
+		t.Errorf("owners = %+v, want alice first with 1.56", report.Owners)
+	}
+}
+
+// TestComputeBudgets 未配置或为 0 的预算不计入，费用达到预算时为超出
+func TestComputeBudgets(t *testing.T) {
+	now := at(11, 0)
+	cfg := testCost
+	cfg.Budget = config.BudgetConfig{
+		Monthly: 100,
+		Regions: map[string]float64{"r1": 0.36, "r2": 0},
+		Owners:  map[string]float64{"alice": 1, "carol": 1},
+	}
+	records := []*models.UsageRecord{usage("a", "r1", "alice", "t3.micro", at(1, 0), at(2, 0))}
+	report := Compute(cfg, now, now, records, nil)
+
+	want := []BudgetStatus{
+		{Scope: ScopeTotal, Limit: 100, Spent: 0.36, Projected: 0.36},
+		{Scope: ScopeRegion, Name: "r1", Limit: 0.36, Spent: 0.36, Projected: 0.36, Exceeded: true},
+		{Scope: ScopeOwner, Name: "alice", Limit: 1, Spent: 0.36, Projected: 0.36},
+		{Scope: ScopeOwner, Name: "carol", Limit: 1},
+	}
+	if len(report.Budgets) != len(want) {
+		t.Fatalf("got %d budgets, want %d: %+v", len(report.Budgets), len(want), report.Budgets)
+	}
+	for i := range want {
+		if report.Budgets[i] != want[i] {
+			t.Errorf("budget %d = %+v, want %+v", i, report.Budgets[i], want[i])
+		}
+	}
+}
+
+// TestCheckBudget 只有适用于该区域或创建者的已超出预算才拒绝创建
+func TestCheckBudget(t *testing.T) {
+	report := func(budgets ...BudgetStatus) *Report {
+		return &Report{Currency: "USD", Budgets: budgets}
+	}
+	exceeded := func(scope, name string) BudgetStatus {
+		return BudgetStatus{Scope: scope, Name: name, Limit: 1, Spent: 1, Exceeded: true}
+	}
+
+	tests := []struct {
+		name    string
+		report  *Report
+		region  string
+		owner   string
+		wantErr bool
+	}{
+		{"no budgets", report(), "r1", "alice", false},
+		{"within budget", report(BudgetStatus{Scope: ScopeTotal, Limit: 10, Spent: 1}), "r1", "alice", false},
+		{"total exceeded", report(exceeded(ScopeTotal, "")), "r1", "alice", true},
+		{"region exceeded", report(exceeded(ScopeRegion, "r1")), "r1", "alice", true},
+		{"other region exceeded", report(exceeded(ScopeRegion, "r2")), "r1", "alice", false},
+		{"owner exceeded", report(exceeded(ScopeOwner, "alice")), "r1", "alice", true},
+		{"other owner exceeded", report(exceeded(ScopeOwner, "bob")), "r1", "alice", false},
+		{"no owner", report(exceeded(ScopeOwner, "bob")), "r1", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckBudget(tt.report, tt.region, tt.owner)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckBudget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// approx 比较四舍五入后的费用
+func approx(got, want float64) bool {
+	return math.Abs(got-want) < 1e-6
+}
@@ -0,0 +1,75 @@
+package cost
+
+import (
+	"context"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+)
+
+// Store 定义记录运行时间所需的存储接口
+type Store interface {
+	StartUsage(ctx context.Context, instanceUUID string, at time.Time) error
+	StopUsage(ctx context.Context, instanceUUID string, at time.Time) error
+}
+
+// Recorder 根据实例状态变化记录运行时间
+type Recorder struct {
+	store Store
+}
+
+// NewRecorder 创建一个新的 Recorder 实例
+// 参数:
+//   - store: 运行时间存储，通常为 Repository
+//
+// 返回值:
+//   - *Recorder: 新创建的 Recorder 实例
+func NewRecorder(store Store) *Recorder {
+	return &Recorder{store: store}
+}
+
+// HandleEvent 处理事件总线上的生命周期事件
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - event: 生命周期事件
+//
+// 功能:
+//  1. 实例进入 running 时开始一段运行记录
+//  2. 实例进入其他状态时结束当前的运行记录
+func (r *Recorder) HandleEvent(ctx context.Context, event *models.LifecycleEvent) {
+	if event.Type != models.EventStatusChanged {
+		return
+	}
+
+	now := time.Now()
+	if event.Status == models.StatusRunning {
+		if err := r.store.StartUsage(ctx, event.InstanceUUID, now); err != nil {
+			logging.Error(ctx, "Failed to record start of usage for instance %s: %v", event.InstanceUUID, err)
+		}
+		return
+	}
+	if err := r.store.StopUsage(ctx, event.InstanceUUID, now); err != nil {
+		logging.Error(ctx, "Failed to record end of usage for instance %s: %v", event.InstanceUUID, err)
+	}
+}
+
+// Backfill 为运行中但没有未结束运行记录的实例补充记录
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - instances: 当前的实例列表
+//
+// 功能:
+//  1. 用于升级前已在运行的实例，从调用时开始计算运行时间
+//  2. 已有未结束记录的实例不受影响
+func (r *Recorder) Backfill(ctx context.Context, instances []*models.V2RayInstance) {
+	now := time.Now()
+	for _, instance := range instances {
+		if instance.Status != models.StatusRunning {
+			continue
+		}
+		if err := r.store.StartUsage(ctx, instance.UUID, now); err != nil {
+			logging.Error(ctx, "Failed to backfill usage for instance %s: %v", instance.UUID, err)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+)
+
+const (
+	// subscriberBuffer 每个订阅者的缓冲区大小
+	subscriberBuffer = 64
+	// replayPageSize Last-Event-ID 重放时每次从存储读取的事件数量
+	replayPageSize = 1000
+)
+
+// Store 定义事件持久化所需的存储接口
+type Store interface {
+	AppendEvent(ctx context.Context, event *models.LifecycleEvent) error
+	ListEventsAfter(ctx context.Context, afterID int64, instanceUUID string, limit int) ([]*models.LifecycleEvent, error)
+}
+
+// Listener 同步接收每个已发布事件的回调，用于需要可靠处理事件的组件
+type Listener func(ctx context.Context, event *models.LifecycleEvent)
+
+// Subscription 表示一个事件订阅
+type Subscription struct {
+	C            <-chan *models.LifecycleEvent
+	ch           chan *models.LifecycleEvent
+	instanceUUID string
+	bus          *Bus
+	once         sync.Once
+}
+
+// Close 取消订阅并关闭事件通道
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// Bus 进程内事件总线，负责持久化事件并分发给订阅者
+type Bus struct {
+	store     Store
+	mu        sync.RWMutex
+	subs      map[*Subscription]struct{}
+	listeners []Listener
+}
+
+// NewBus 创建一个新的事件总线
+// 参数:
+//   - store: 事件存储，用于持久化事件和重放
+//
+// 返回值:
+//   - *Bus: 新创建的事件总线
+func NewBus(store Store) *Bus {
+	return &Bus{
+		store: store,
+		subs:  make(map[*Subscription]struct{}),
+	}
+}
+
+// AddListener 注册一个同步事件监听器
+// 参数:
+//   - listener: 监听器，在事件持久化之后、分发给订阅者之前调用
+//
+// 功能:
+//  1. 与 Subscribe 不同，监听器不会因为处理缓慢而被丢弃
+//  2. 监听器应尽快返回，耗时操作需要自行异步处理
+func (b *Bus) AddListener(listener Listener) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, listener)
+}
+
+// Publish 发布一个生命周期事件
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - event: 要发布的事件
+//
+// 功能:
+//  1. 将事件写入持久化存储，获得自增 ID
+//  2. 依次调用所有同步监听器
+//  3. 将事件分发给所有匹配的订阅者
+//  4. 订阅者缓冲区已满时关闭该订阅，客户端可通过 Last-Event-ID 重连补齐
+//  5. 持久化和监听器会写入其他表，不要在持有区域创建锁时调用，以免延长其他创建请求的等待
+func (b *Bus) Publish(ctx context.Context, event *models.LifecycleEvent) {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = models.CustomTime{Time: time.Now()}
+	}
+
+	if err := b.store.AppendEvent(ctx, event); err != nil {
+		logging.Error(ctx, "Failed to persist %s event for instance %s: %v", event.Type, event.InstanceUUID, err)
+	}
+
+	b.mu.RLock()
+	listeners := b.listeners
+	b.mu.RUnlock()
+	for _, listener := range listeners {
+		listener(ctx, event)
+	}
+
+	var slow []*Subscription
+	b.mu.RLock()
+	for sub := range b.subs {
+		if sub.instanceUUID != "" && sub.instanceUUID != event.InstanceUUID {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			slow = append(slow, sub)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range slow {
+		logging.Warn(ctx, "Dropping slow event subscriber for instance filter %q", sub.instanceUUID)
+		b.unsubscribe(sub)
+	}
+}
+
+// Subscribe 订阅生命周期事件，并返回 lastEventID 之后的历史事件
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - instanceUUID: 只接收该实例的事件，为空时接收所有实例的事件
+//   - lastEventID: 客户端最后收到的事件 ID，为 0 时不重放历史事件
+//
+// 返回值:
+//   - *Subscription: 新的订阅，调用方负责调用 Close
+//   - []*models.LifecycleEvent: 需要先发送给客户端的历史事件
+//   - error: 错误信息，如果读取历史事件失败
+//
+// 功能:
+//  1. 先注册订阅，保证重放期间产生的新事件不会丢失
+//  2. 从持久化存储中按 replayPageSize 分页读取 lastEventID 之后的所有历史事件，直到读完为止
+//  3. 调用方需跳过实时事件中 ID 不大于最后一条历史事件的重复事件
+func (b *Bus) Subscribe(ctx context.Context, instanceUUID string, lastEventID int64) (*Subscription, []*models.LifecycleEvent, error) {
+	ch := make(chan *models.LifecycleEvent, subscriberBuffer)
+	sub := &Subscription{
+		C:            ch,
+		ch:           ch,
+		instanceUUID: instanceUUID,
+		bus:          b,
+	}
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+
+	if lastEventID <= 0 {
+		return sub, nil, nil
+	}
+
+	var backlog []*models.LifecycleEvent
+	for afterID := lastEventID; ; {
+		page, err := b.store.ListEventsAfter(ctx, afterID, instanceUUID, replayPageSize)
+		if err != nil {
+			sub.Close()
+			return nil, nil, err
+		}
+		backlog = append(backlog, page...)
+		if len(page) < replayPageSize {
+			return sub, backlog, nil
+		}
+		afterID = page[len(page)-1].ID
+	}
+}
+
+// unsubscribe 移除订阅并关闭其事件通道
+func (b *Bus) unsubscribe(sub *Subscription) {
+	sub.once.Do(func() {
+		b.mu.Lock()
+		delete(b.subs, sub)
+		b.mu.Unlock()
+		close(sub.ch)
+	})
+}
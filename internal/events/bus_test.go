@@ -0,0 +1,112 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/yuhai94/anywhere_backend/internal/models"
+)
+
+// fakeStore 内存中的事件存储，按 ID 升序保存，记录每次 ListEventsAfter 的参数
+type fakeStore struct {
+	events []*models.LifecycleEvent
+	err    error
+	pages  []int64
+}
+
+func (s *fakeStore) AppendEvent(ctx context.Context, event *models.LifecycleEvent) error {
+	event.ID = int64(len(s.events) + 1)
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *fakeStore) ListEventsAfter(ctx context.Context, afterID int64, instanceUUID string, limit int) ([]*models.LifecycleEvent, error) {
+	s.pages = append(s.pages, afterID)
+	if s.err != nil {
+		return nil, s.err
+	}
+	var events []*models.LifecycleEvent
+	for _, event := range s.events {
+		if event.ID <= afterID || (instanceUUID != "" && event.InstanceUUID != instanceUUID) {
+			continue
+		}
+		if len(events) == limit {
+			break
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// TestSubscribeReplay 重放按页读取 lastEventID 之后的全部历史事件，不受单页数量限制
+func TestSubscribeReplay(t *testing.T) {
+	store := &fakeStore{}
+	for i := 0; i < 2*replayPageSize+10; i++ {
+		uuid := "a"
+		if i%2 == 1 {
+			uuid = "b"
+		}
+		store.AppendEvent(context.Background(), &models.LifecycleEvent{InstanceUUID: uuid})
+	}
+
+	tests := []struct {
+		name         string
+		instanceUUID string
+		lastEventID  int64
+		wantFirst    int64
+		wantCount    int
+		wantPages    int
+	}{
+		{"no replay", "", 0, 0, 0, 0},
+		{"several pages", "", 5, 6, 2*replayPageSize + 5, 3},
+		{"exactly one page", "", replayPageSize + 10, replayPageSize + 11, replayPageSize, 2},
+		{"nothing after the last event", "", 2*replayPageSize + 10, 0, 0, 1},
+		{"one instance", "b", 1, 2, replayPageSize + 5, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.pages = nil
+			bus := NewBus(store)
+			sub, backlog, err := bus.Subscribe(context.Background(), tt.instanceUUID, tt.lastEventID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Close()
+
+			if len(backlog) != tt.wantCount {
+				t.Fatalf("got %d events, want %d", len(backlog), tt.wantCount)
+			}
+			if len(store.pages) != tt.wantPages {
+				t.Errorf("read %d pages, want %d", len(store.pages), tt.wantPages)
+			}
+			if len(backlog) == 0 {
+				return
+			}
+			if backlog[0].ID != tt.wantFirst {
+				t.Errorf("first event = %d, want %d", backlog[0].ID, tt.wantFirst)
+			}
+			for i := 1; i < len(backlog); i++ {
+				if backlog[i].ID <= backlog[i-1].ID {
+					t.Fatalf("event %d has ID %d after %d", i, backlog[i].ID, backlog[i-1].ID)
+				}
+				if tt.instanceUUID != "" && backlog[i].InstanceUUID != tt.instanceUUID {
+					t.Fatalf("event %d belongs to instance %s", backlog[i].ID, backlog[i].InstanceUUID)
+				}
+			}
+		})
+	}
+}
+
+// TestSubscribeReplayError 读取历史事件失败时关闭订阅并返回错误
+func TestSubscribeReplayError(t *testing.T) {
+	store := &fakeStore{err: errors.New("database is down")}
+	bus := NewBus(store)
+	sub, _, err := bus.Subscribe(context.Background(), "", 1)
+	if err == nil || sub != nil {
+		t.Fatalf("Subscribe() = %v, %v, want an error", sub, err)
+	}
+	if len(bus.subs) != 0 {
+		t.Errorf("%d subscriptions left after a failed replay", len(bus.subs))
+	}
+}
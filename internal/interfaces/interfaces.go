@@ -8,12 +8,12 @@ import (
 )
 
 type EC2ClientInterface interface {
-	CreateInstance(ctx context.Context, region string, userData string, uuid string) (string, error)
-	WaitForInstanceRunning(ctx context.Context, region string, instanceID string) error
-	GetInstancePublicIP(ctx context.Context, region string, instanceID string) (string, error)
-	TerminateInstance(ctx context.Context, region string, instanceID string) error
-	DescribeInstances(ctx context.Context, region string) ([]aws.InstanceInfo, error)
-	WaitForInstanceTerminated(ctx context.Context, region string, instanceID string) error
+	CreateInstance(ctx context.Context, account string, region string, userData string, uuid string) (string, error)
+	WaitForInstanceRunning(ctx context.Context, account string, region string, instanceID string) error
+	GetInstancePublicIP(ctx context.Context, account string, region string, instanceID string) (string, error)
+	TerminateInstance(ctx context.Context, account string, region string, instanceID string) error
+	DescribeInstances(ctx context.Context, account string, region string) ([]aws.InstanceInfo, error)
+	WaitForInstanceTerminated(ctx context.Context, account string, region string, instanceID string) error
 }
 
 type RepositoryInterface interface {
@@ -24,13 +24,22 @@ type RepositoryInterface interface {
 	UpdateStatus(ctx context.Context, uuid string, status string) error
 	UpdateStatusAndIP(ctx context.Context, uuid string, status string, publicIP string) error
 	Delete(ctx context.Context, uuid string) error
-	CheckRegionHasActiveInstance(ctx context.Context, region string) (bool, error)
+	CountRegionActiveInstances(ctx context.Context, region string) (int, error)
 	GetRegionActiveInstance(ctx context.Context, region string) (*models.V2RayInstance, error)
-	LockTable(ctx context.Context) error
-	UnlockTable(ctx context.Context) error
+	LockRegion(ctx context.Context, region string) (func(), error)
+	HasEvent(ctx context.Context, instanceUUID, eventType string) (bool, error)
 	InitSchema(ctx context.Context) error
 }
 
+// V2RayServiceInterface Telegram 机器人使用的实例操作
+type V2RayServiceInterface interface {
+	CreateInstance(ctx context.Context, region, owner, protocol string) (string, error)
+	GetInstance(ctx context.Context, uuid string) (*models.V2RayInstance, error)
+	ListInstances(ctx context.Context) ([]*models.V2RayInstance, error)
+	DeleteInstance(ctx context.Context, uuid string) error
+	ListRegions(ctx context.Context) []*models.Region
+}
+
 type V2RayManagerInterface interface {
 	AddInstance(ctx context.Context, instanceTag, address string, port int, uuid string) error
 }
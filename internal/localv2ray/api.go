@@ -0,0 +1,216 @@
+package localv2ray
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// APIClient 通过 gRPC 调用中转 V2Ray 的 API（StatsService、HandlerService）
+type APIClient struct {
+	address string
+	// schema 代理程序 API 的服务名和消息类型名
+	schema apiSchema
+	// dial 建立到 API 的连接，为 nil 时直接连接
+	dial func(ctx context.Context, network, address string) (net.Conn, error)
+
+	mu   sync.Mutex
+	conn *grpc.ClientConn
+}
+
+// NewAPIClient 创建一个新的 APIClient 实例
+// 参数:
+//   - address: V2Ray API inbound 的地址，例如 "127.0.0.1:10085"
+//
+// 返回值:
+//   - *APIClient: 新创建的 APIClient 实例，使用 V2Ray 的 API，第一次调用时才建立连接
+func NewAPIClient(address string) *APIClient {
+	return &APIClient{address: address, schema: v2rayEngine{}.api()}
+}
+
+// NewAPIClientWithDialer 创建一个通过指定方式建立连接的 APIClient 实例
+// 参数:
+//   - address: API inbound 的地址，由 dial 解析，例如远程中转主机上的 "127.0.0.1:10085"
+//   - engine: 中转使用的代理程序，决定 API 的服务名和消息类型名
+//   - dial: 建立连接的函数，例如通过 SSH 连接转发
+//
+// 返回值:
+//   - *APIClient: 新创建的 APIClient 实例，第一次调用时才建立连接
+func NewAPIClientWithDialer(address string, engine Engine, dial func(ctx context.Context, network, address string) (net.Conn, error)) *APIClient {
+	return &APIClient{address: address, schema: engine.api(), dial: dial}
+}
+
+// Address 返回 V2Ray API 的地址
+func (c *APIClient) Address() string {
+	return c.address
+}
+
+// Close 关闭与 V2Ray API 的连接
+func (c *APIClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// invoke 调用 V2Ray API 的一个方法
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值和取消信号
+//   - method: 完整的方法名，例如 "/v2ray.core.app.stats.command.StatsService/QueryStats"
+//   - request: 请求消息
+//   - response: 响应消息
+//
+// 返回值:
+//   - error: 错误信息，如果连接或调用失败
+func (c *APIClient) invoke(ctx context.Context, method string, request, response apiMessage) error {
+	conn, err := c.connection()
+	if err != nil {
+		return err
+	}
+	if err := conn.Invoke(ctx, method, request, response, grpc.ForceCodec(apiCodec{})); err != nil {
+		return fmt.Errorf("failed to call %s at %s: %v", method, c.address, err)
+	}
+	return nil
+}
+
+// connection 返回与 V2Ray API 的连接，不存在时创建
+func (c *APIClient) connection() (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil {
+		return c.conn, nil
+	}
+	target := c.address
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if c.dial != nil {
+		// The address is resolved on the relay host, not locally
+		target = "passthrough:///" + c.address
+		opts = append(opts, grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			return c.dial(ctx, "tcp", address)
+		}))
+	}
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to V2Ray API at %s: %v", c.address, err)
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+// apiMessage V2Ray API 的请求或响应消息
+type apiMessage interface {
+	marshal() []byte
+	unmarshal(data []byte) error
+}
+
+// emptyMessage 没有字段的响应消息，例如 AddOutboundResponse
+type emptyMessage struct{}
+
+func (emptyMessage) marshal() []byte {
+	return nil
+}
+
+func (emptyMessage) unmarshal(data []byte) error {
+	return nil
+}
+
+// apiCodec V2Ray API 消息的 protobuf 编解码，每个消息只处理用到的字段，
+// 避免为几个方法引入 V2Ray 的生成代码
+type apiCodec struct{}
+
+func (apiCodec) Name() string {
+	return "proto"
+}
+
+func (apiCodec) Marshal(v interface{}) ([]byte, error) {
+	message, ok := v.(apiMessage)
+	if !ok {
+		return nil, fmt.Errorf("unexpected V2Ray API message type %T", v)
+	}
+	return message.marshal(), nil
+}
+
+func (apiCodec) Unmarshal(data []byte, v interface{}) error {
+	message, ok := v.(apiMessage)
+	if !ok {
+		return fmt.Errorf("unexpected V2Ray API message type %T", v)
+	}
+	return message.unmarshal(data)
+}
+
+// appendString 追加一个字符串字段，空字符串不编码
+func appendString(data []byte, num protowire.Number, value string) []byte {
+	if value == "" {
+		return data
+	}
+	data = protowire.AppendTag(data, num, protowire.BytesType)
+	return protowire.AppendString(data, value)
+}
+
+// appendBytes 追加一个字节或嵌套消息字段
+func appendBytes(data []byte, num protowire.Number, value []byte) []byte {
+	data = protowire.AppendTag(data, num, protowire.BytesType)
+	return protowire.AppendBytes(data, value)
+}
+
+// appendVarint 追加一个整数或布尔字段，0 不编码
+func appendVarint(data []byte, num protowire.Number, value uint64) []byte {
+	if value == 0 {
+		return data
+	}
+	data = protowire.AppendTag(data, num, protowire.VarintType)
+	return protowire.AppendVarint(data, value)
+}
+
+// appendTypedMessage 追加一个 v2ray.core.common.serial.TypedMessage 字段
+func appendTypedMessage(data []byte, num protowire.Number, typeName string, value []byte) []byte {
+	var message []byte
+	message = appendString(message, 1, typeName)
+	message = appendBytes(message, 2, value)
+	return appendBytes(data, num, message)
+}
+
+// consumeFields 依次读取消息中的字段
+// 参数:
+//   - data: 编码后的消息
+//   - fn: 对每个字段调用，BytesType 字段的 value 为内容，其他类型的 value 为编码后的值
+func consumeFields(data []byte, fn func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var value []byte
+		if typ == protowire.BytesType {
+			bytes, m := protowire.ConsumeBytes(data)
+			if m < 0 {
+				return protowire.ParseError(m)
+			}
+			value, n = bytes, m
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			value = data[:n]
+		}
+		if err := fn(num, typ, value); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package localv2ray
+
+// 配置结构体的 JSON 编解码，未建模的字段和未修改的字段按原文写回，见 rawObject
+
+func (c *V2RayConfig) UnmarshalJSON(data []byte) error {
+	type alias V2RayConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c V2RayConfig) MarshalJSON() ([]byte, error) {
+	type alias V2RayConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *LogConfig) UnmarshalJSON(data []byte) error {
+	type alias LogConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c LogConfig) MarshalJSON() ([]byte, error) {
+	type alias LogConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *StatsConfig) UnmarshalJSON(data []byte) error {
+	type alias StatsConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c StatsConfig) MarshalJSON() ([]byte, error) {
+	type alias StatsConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *APIConfig) UnmarshalJSON(data []byte) error {
+	type alias APIConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c APIConfig) MarshalJSON() ([]byte, error) {
+	type alias APIConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *PolicyConfig) UnmarshalJSON(data []byte) error {
+	type alias PolicyConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c PolicyConfig) MarshalJSON() ([]byte, error) {
+	type alias PolicyConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *LevelConfig) UnmarshalJSON(data []byte) error {
+	type alias LevelConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c LevelConfig) MarshalJSON() ([]byte, error) {
+	type alias LevelConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *SystemPolicyConfig) UnmarshalJSON(data []byte) error {
+	type alias SystemPolicyConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c SystemPolicyConfig) MarshalJSON() ([]byte, error) {
+	type alias SystemPolicyConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *InboundConfig) UnmarshalJSON(data []byte) error {
+	type alias InboundConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c InboundConfig) MarshalJSON() ([]byte, error) {
+	type alias InboundConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *VmessInboundSettings) UnmarshalJSON(data []byte) error {
+	type alias VmessInboundSettings
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c VmessInboundSettings) MarshalJSON() ([]byte, error) {
+	type alias VmessInboundSettings
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *VmessClientConfig) UnmarshalJSON(data []byte) error {
+	type alias VmessClientConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c VmessClientConfig) MarshalJSON() ([]byte, error) {
+	type alias VmessClientConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *OutboundConfig) UnmarshalJSON(data []byte) error {
+	type alias OutboundConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c OutboundConfig) MarshalJSON() ([]byte, error) {
+	type alias OutboundConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *RoutingConfig) UnmarshalJSON(data []byte) error {
+	type alias RoutingConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c RoutingConfig) MarshalJSON() ([]byte, error) {
+	type alias RoutingConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *BalancerConfig) UnmarshalJSON(data []byte) error {
+	type alias BalancerConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c BalancerConfig) MarshalJSON() ([]byte, error) {
+	type alias BalancerConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *RuleConfig) UnmarshalJSON(data []byte) error {
+	type alias RuleConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c RuleConfig) MarshalJSON() ([]byte, error) {
+	type alias RuleConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *StreamSettingsConfig) UnmarshalJSON(data []byte) error {
+	type alias StreamSettingsConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c StreamSettingsConfig) MarshalJSON() ([]byte, error) {
+	type alias StreamSettingsConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *RealityClientConfig) UnmarshalJSON(data []byte) error {
+	type alias RealityClientConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c RealityClientConfig) MarshalJSON() ([]byte, error) {
+	type alias RealityClientConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *VNextConfig) UnmarshalJSON(data []byte) error {
+	type alias VNextConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c VNextConfig) MarshalJSON() ([]byte, error) {
+	type alias VNextConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *UserConfig) UnmarshalJSON(data []byte) error {
+	type alias UserConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c UserConfig) MarshalJSON() ([]byte, error) {
+	type alias UserConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *VmessOutboundSettings) UnmarshalJSON(data []byte) error {
+	type alias VmessOutboundSettings
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c VmessOutboundSettings) MarshalJSON() ([]byte, error) {
+	type alias VmessOutboundSettings
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *BalancerStrategyConfig) UnmarshalJSON(data []byte) error {
+	type alias BalancerStrategyConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c BalancerStrategyConfig) MarshalJSON() ([]byte, error) {
+	type alias BalancerStrategyConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *ObservatoryConfig) UnmarshalJSON(data []byte) error {
+	type alias ObservatoryConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c ObservatoryConfig) MarshalJSON() ([]byte, error) {
+	type alias ObservatoryConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *SingBoxConfig) UnmarshalJSON(data []byte) error {
+	type alias SingBoxConfig
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c SingBoxConfig) MarshalJSON() ([]byte, error) {
+	type alias SingBoxConfig
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *SingBoxInbound) UnmarshalJSON(data []byte) error {
+	type alias SingBoxInbound
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c SingBoxInbound) MarshalJSON() ([]byte, error) {
+	type alias SingBoxInbound
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *SingBoxUser) UnmarshalJSON(data []byte) error {
+	type alias SingBoxUser
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c SingBoxUser) MarshalJSON() ([]byte, error) {
+	type alias SingBoxUser
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *SingBoxOutbound) UnmarshalJSON(data []byte) error {
+	type alias SingBoxOutbound
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c SingBoxOutbound) MarshalJSON() ([]byte, error) {
+	type alias SingBoxOutbound
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *SingBoxRoute) UnmarshalJSON(data []byte) error {
+	type alias SingBoxRoute
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c SingBoxRoute) MarshalJSON() ([]byte, error) {
+	type alias SingBoxRoute
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *SingBoxRule) UnmarshalJSON(data []byte) error {
+	type alias SingBoxRule
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c SingBoxRule) MarshalJSON() ([]byte, error) {
+	type alias SingBoxRule
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *SingBoxTLS) UnmarshalJSON(data []byte) error {
+	type alias SingBoxTLS
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c SingBoxTLS) MarshalJSON() ([]byte, error) {
+	type alias SingBoxTLS
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *SingBoxUTLS) UnmarshalJSON(data []byte) error {
+	type alias SingBoxUTLS
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c SingBoxUTLS) MarshalJSON() ([]byte, error) {
+	type alias SingBoxUTLS
+	return encodeObject((*alias)(&c), &c.raw)
+}
+
+func (c *SingBoxReality) UnmarshalJSON(data []byte) error {
+	type alias SingBoxReality
+	return decodeObject(data, (*alias)(c), &c.raw)
+}
+
+func (c SingBoxReality) MarshalJSON() ([]byte, error) {
+	type alias SingBoxReality
+	return encodeObject((*alias)(&c), &c.raw)
+}
@@ -0,0 +1,297 @@
+package localv2ray
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// configTestTimeout 校验一次配置的超时时间
+const configTestTimeout = 30 * time.Second
+
+// backupTimeFormat 备份文件名中的时间格式，按字典序排序即按时间排序
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// rollbackMarker 回滚前备份的文件名中，标记回滚恢复的备份时间的部分
+const rollbackMarker = ".rollback-"
+
+// ErrBackupNotFound 指定的配置备份不存在
+var ErrBackupNotFound = errors.New("config backup not found")
+
+// Backup 一份中转配置的备份
+type Backup struct {
+	// Relay 备份所属的中转
+	Relay string `json:"relay"`
+	// Name 备份文件名，位于配置文件所在目录
+	Name string `json:"name"`
+	// Time 备份时间，即被替换的配置最后一次生效的时间
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+	// RollbackOf 回滚前的备份所对应的回滚恢复的备份时间，普通写入的备份为 nil
+	RollbackOf *time.Time `json:"rollback_of,omitempty"`
+}
+
+// lockConfig 获取配置文件的写锁
+// 参数:
+//   - ctx: 上下文，用于传递取消信号
+//
+// 返回值:
+//   - func(): 释放锁的函数
+//   - error: 错误信息，如果无法锁定锁文件
+//
+// 功能:
+//  1. 先获取进程内的互斥锁，避免多个创建、删除任务同时读取-修改-写入时丢失变更
+//  2. 再通过 Driver 对配置文件旁的 .lock 文件加锁，与同时运行的运维命令（例如 relay-config repair）互斥
+func (m *LocalV2RayManager) lockConfig(ctx context.Context) (func(), error) {
+	m.writeMu.Lock()
+
+	unlock, err := m.driver.Lock(ctx, m.configPath+".lock")
+	if err != nil {
+		m.writeMu.Unlock()
+		return nil, err
+	}
+
+	return func() {
+		unlock()
+		m.writeMu.Unlock()
+	}, nil
+}
+
+// writeFile 原子地替换配置文件，调用方需要持有 lockConfig 返回的锁
+// 参数:
+//   - ctx: 上下文，用于传递取消信号
+//   - data: 新的配置内容
+//   - rollbackOf: 回滚时为恢复的备份，记录在被替换配置的备份名中；普通写入为 nil
+//
+// 返回值:
+//   - error: 错误信息，如果写入、校验或替换失败，此时配置文件保持不变
+//
+// 功能:
+//  1. 在配置文件所在目录写入临时文件并 fsync，权限与现有配置文件相同
+//  2. 未设置 SkipConfigTest 时用代理程序的校验命令校验临时文件，例如 v2ray test -c <临时文件>，失败时放弃写入
+//  3. 将现有配置复制为带时间戳的备份，只保留最新的 BackupCount 份
+//  4. 将临时文件重命名为配置文件，任何时刻配置文件都是完整的旧版本或新版本
+func (m *LocalV2RayManager) writeFile(ctx context.Context, data []byte, rollbackOf *Backup) error {
+	options := m.currentOptions()
+	dir, base := filepath.Split(m.configPath)
+
+	mode := os.FileMode(0644)
+	if info, err := m.driver.Stat(m.configPath); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	// Keep the original extension so the engine detects the format of the temp file
+	tmpPath := filepath.Join(dir, fmt.Sprintf(".tmp-%d-%s", time.Now().UnixNano(), base))
+	if err := m.driver.WriteFile(tmpPath, data, mode); err != nil {
+		m.driver.Remove(tmpPath)
+		return fmt.Errorf("failed to write temp file: %v", err)
+	}
+	defer m.driver.Remove(tmpPath)
+
+	if !options.SkipConfigTest {
+		if err := m.testConfig(ctx, options.Binary, tmpPath); err != nil {
+			return err
+		}
+	}
+
+	if err := m.backup(options.BackupCount, rollbackOf); err != nil {
+		return err
+	}
+
+	if err := m.driver.Replace(tmpPath, m.configPath); err != nil {
+		return fmt.Errorf("failed to replace config: %v", err)
+	}
+	return nil
+}
+
+// testConfig 使用代理程序校验配置文件
+// 参数:
+//   - ctx: 上下文，用于传递取消信号
+//   - binary: 代理程序的可执行文件，为空时使用代理程序的默认名称
+//   - path: 要校验的配置文件
+//
+// 返回值:
+//   - error: 错误信息，包含代理程序的输出，如果配置无效或无法执行校验
+func (m *LocalV2RayManager) testConfig(ctx context.Context, binary, path string) error {
+	if binary == "" {
+		binary = m.engine.DefaultBinary()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, configTestTimeout)
+	defer cancel()
+
+	output, err := m.driver.Run(ctx, m.engine.TestCommand(binary, path))
+	if err != nil {
+		return fmt.Errorf("config test failed: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// backup 将当前配置复制为带时间戳的备份，并删除超出数量的旧备份
+// 参数:
+//   - keep: 保留的备份数量，为 0 时不备份
+//   - rollbackOf: 回滚时为恢复的备份，备份名为 <文件名>.<时间>.rollback-<恢复的备份时间>.bak
+//
+// 返回值:
+//   - error: 错误信息，如果读取当前配置或写入备份失败；清理旧备份失败不影响写入
+func (m *LocalV2RayManager) backup(keep int, rollbackOf *Backup) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	data, err := m.driver.ReadFile(m.configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config for backup: %v", err)
+	}
+
+	stamp := time.Now().UTC().Format(backupTimeFormat)
+	if rollbackOf != nil {
+		stamp += rollbackMarker + rollbackOf.Time.UTC().Format(backupTimeFormat)
+	}
+	name := filepath.Base(m.configPath) + "." + stamp + ".bak"
+	if err := m.driver.WriteFile(filepath.Join(filepath.Dir(m.configPath), name), data, 0600); err != nil {
+		return fmt.Errorf("failed to create backup: %v", err)
+	}
+
+	backups, err := m.ListBackups()
+	if err != nil {
+		return nil
+	}
+	for _, old := range backups[min(keep, len(backups)):] {
+		m.driver.Remove(filepath.Join(filepath.Dir(m.configPath), old.Name))
+	}
+	return nil
+}
+
+// ListBackups 列出中转配置的备份
+// 返回值:
+//   - []Backup: 按时间从新到旧排列的备份
+//   - error: 错误信息，如果读取配置文件所在目录失败
+func (m *LocalV2RayManager) ListBackups() ([]Backup, error) {
+	dir := filepath.Dir(m.configPath)
+	entries, err := m.driver.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config directory: %v", err)
+	}
+
+	prefix := filepath.Base(m.configPath) + "."
+	var backups []Backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".bak") {
+			continue
+		}
+		stamp, restored, isRollback := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".bak"), rollbackMarker)
+		t, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		backup := Backup{Relay: m.name, Name: name, Time: t, Size: entry.Size()}
+		if isRollback {
+			restoredTime, err := time.Parse(backupTimeFormat, restored)
+			if err != nil {
+				continue
+			}
+			backup.RollbackOf = &restoredTime
+		}
+		backups = append(backups, backup)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Time.After(backups[j].Time)
+	})
+	return backups, nil
+}
+
+// Rollback 将中转配置恢复为指定的备份
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值和取消信号
+//   - name: 备份文件名，为空时见 previousBackup
+//
+// 返回值:
+//   - Backup: 恢复的备份
+//   - error: 错误信息，如果备份不存在、校验失败或重启服务失败
+//
+// 功能:
+//  1. 与其他写入相同，先校验备份内容，再原子地替换配置文件，被替换的配置也会备份，
+//     备份名记录恢复的备份，指定该备份名可以撤销回滚
+//  2. 重启 V2Ray 服务加载恢复的配置，HandlerService 不能修改路由和入站
+func (m *LocalV2RayManager) Rollback(ctx context.Context, name string) (Backup, error) {
+	unlock, err := m.lockConfig(ctx)
+	if err != nil {
+		return Backup{}, err
+	}
+	defer unlock()
+
+	backups, err := m.ListBackups()
+	if err != nil {
+		return Backup{}, err
+	}
+
+	var target *Backup
+	if name == "" {
+		target = previousBackup(backups)
+	}
+	for i := range backups {
+		if name != "" && backups[i].Name == name {
+			target = &backups[i]
+			break
+		}
+	}
+	if target == nil {
+		if name == "" {
+			return Backup{}, ErrBackupNotFound
+		}
+		return Backup{}, fmt.Errorf("%w: %s", ErrBackupNotFound, name)
+	}
+
+	data, err := m.driver.ReadFile(filepath.Join(filepath.Dir(m.configPath), target.Name))
+	if err != nil {
+		return Backup{}, fmt.Errorf("failed to read backup: %v", err)
+	}
+	if err := m.writeFile(ctx, data, target); err != nil {
+		return Backup{}, err
+	}
+
+	if err := m.RestartService(ctx); err != nil {
+		return *target, err
+	}
+	return *target, nil
+}
+
+// previousBackup 选择未指定备份名时回滚恢复的备份
+// 参数:
+//   - backups: 按时间从新到旧排列的备份
+//
+// 返回值:
+//   - *Backup: 要恢复的备份，没有可恢复的备份时为 nil
+//
+// 功能:
+//  1. 跳过回滚前的备份，它们保存的是回滚撤销的配置
+//  2. 跳过最近的回滚已经恢复的备份及更新的备份，连续回滚时逐个恢复更早的备份，而不是在两个版本之间来回切换
+//  3. 回滚之后有新的写入时，恢复最新一次写入之前的配置
+func previousBackup(backups []Backup) *Backup {
+	var bound *time.Time
+	for i := range backups {
+		backup := &backups[i]
+		if backup.RollbackOf != nil {
+			if bound == nil || backup.RollbackOf.Before(*bound) {
+				bound = backup.RollbackOf
+			}
+			continue
+		}
+		if bound != nil && !backup.Time.Before(*bound) {
+			continue
+		}
+		return backup
+	}
+	return nil
+}
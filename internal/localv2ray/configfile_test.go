@@ -0,0 +1,46 @@
+package localv2ray
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPreviousBackup 未指定备份名的连续回滚逐个恢复更早的备份
+func TestPreviousBackup(t *testing.T) {
+	at := func(minute int) time.Time {
+		return time.Date(2026, 1, 1, 0, minute, 0, 0, time.UTC)
+	}
+	write := func(minute int) Backup {
+		return Backup{Name: at(minute).Format(backupTimeFormat), Time: at(minute)}
+	}
+	rollback := func(minute, restored int) Backup {
+		backup := write(minute)
+		restoredTime := at(restored)
+		backup.RollbackOf = &restoredTime
+		return backup
+	}
+
+	tests := []struct {
+		name    string
+		backups []Backup
+		want    string
+	}{
+		{"no backups", nil, ""},
+		{"latest write", []Backup{write(2), write(1)}, write(2).Name},
+		{"after one rollback", []Backup{rollback(3, 2), write(2), write(1)}, write(1).Name},
+		{"after two rollbacks", []Backup{rollback(4, 1), rollback(3, 2), write(2), write(1)}, ""},
+		{"write after rollback", []Backup{write(4), rollback(3, 2), write(2), write(1)}, write(4).Name},
+		{"rollback after a later write", []Backup{rollback(5, 4), write(4), rollback(3, 2), write(2), write(1)}, write(1).Name},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := previousBackup(tt.backups)
+			switch {
+			case got == nil && tt.want != "":
+				t.Errorf("got no backup, want %s", tt.want)
+			case got != nil && got.Name != tt.want:
+				t.Errorf("got %s, want %q", got.Name, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package localv2ray
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// lockPollInterval 本机锁文件被占用时重试加锁的间隔
+const lockPollInterval = 100 * time.Millisecond
+
+// Driver 访问中转 V2Ray 所在主机的方式
+// 功能:
+//  1. 管理器通过 Driver 读写配置文件、执行校验和重启命令、连接 V2Ray API，
+//     同一套加锁、校验、备份和回滚逻辑同时适用于本机和远程主机
+//  2. 路径均为中转主机上的路径
+type Driver interface {
+	// ReadFile 读取文件内容
+	ReadFile(path string) ([]byte, error)
+	// WriteFile 创建或覆盖文件，写入后 fsync
+	WriteFile(path string, data []byte, perm os.FileMode) error
+	// Replace 将 src 重命名为 dst，dst 已存在时原子地替换
+	Replace(src, dst string) error
+	// Remove 删除文件
+	Remove(path string) error
+	// Stat 返回文件信息
+	Stat(path string) (os.FileInfo, error)
+	// ReadDir 列出目录中的文件
+	ReadDir(dir string) ([]os.FileInfo, error)
+	// Lock 对 path 加排他锁，与其他进程中的管理器互斥，返回释放锁的函数
+	Lock(ctx context.Context, path string) (func(), error)
+	// Run 通过 shell 执行命令，返回合并的标准输出和标准错误
+	Run(ctx context.Context, command string) ([]byte, error)
+	// DialContext 从中转主机发起连接，用于访问只监听在中转主机本地的 V2Ray API
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+	// Close 释放 Driver 持有的连接
+	Close() error
+}
+
+// LocalDriver 访问本机上的中转 V2Ray
+type LocalDriver struct {
+	dialer net.Dialer
+}
+
+// NewLocalDriver 创建一个新的 LocalDriver 实例
+func NewLocalDriver() *LocalDriver {
+	return &LocalDriver{}
+}
+
+// ReadFile 读取本机文件
+func (d *LocalDriver) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+// WriteFile 写入本机文件并 fsync
+func (d *LocalDriver) WriteFile(path string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	// OpenFile 受 umask 影响，显式设置权限
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Replace 重命名文件并 fsync 所在目录，确保替换在断电后仍然生效
+func (d *LocalDriver) Replace(src, dst string) error {
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(dst))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// Remove 删除本机文件
+func (d *LocalDriver) Remove(path string) error {
+	return os.Remove(path)
+}
+
+// Stat 返回本机文件信息
+func (d *LocalDriver) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+// ReadDir 列出本机目录中的文件
+func (d *LocalDriver) ReadDir(dir string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			// File removed after listing
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Lock 对本机文件加 flock
+// 功能:
+//  1. 以非阻塞方式每 lockPollInterval 重试一次，直到加锁成功或 ctx 结束，
+//     持有锁的进程卡住时请求不会一直等待
+func (d *LocalDriver) Lock(ctx context.Context, path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %v", err)
+	}
+
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) && !errors.Is(err, syscall.EINTR) {
+			file.Close()
+			return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+		}
+		select {
+		case <-ctx.Done():
+			file.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+// Run 通过 sh -c 在本机执行命令
+func (d *LocalDriver) Run(ctx context.Context, command string) ([]byte, error) {
+	return exec.CommandContext(ctx, "sh", "-c", command).CombinedOutput()
+}
+
+// DialContext 从本机发起连接
+func (d *LocalDriver) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return d.dialer.DialContext(ctx, network, address)
+}
+
+// Close 本机访问不持有连接
+func (d *LocalDriver) Close() error {
+	return nil
+}
+
+// shellQuote 将参数用单引号括起来，作为 shell 命令中的一个单词
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
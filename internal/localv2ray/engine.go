@@ -0,0 +1,217 @@
+package localv2ray
+
+import (
+	"fmt"
+)
+
+// 中转使用的代理程序
+const (
+	EngineV2Ray   = "v2ray"
+	EngineXray    = "xray"
+	EngineSingBox = "sing-box"
+)
+
+// Engine 中转使用的代理程序
+// 功能:
+//  1. 决定配置文件的格式、校验配置的命令、systemd 服务名和 gRPC API
+//  2. 管理器的加锁、校验、备份和重启流程对所有代理程序相同，读取和修改配置由 parse 返回的 document 完成
+type Engine interface {
+	// Name 代理程序名称，v2ray、xray 或 sing-box
+	Name() string
+	// ServiceName systemd 服务名，未配置重启命令时执行 sudo systemctl restart <ServiceName>
+	ServiceName() string
+	// DefaultBinary 未配置 binary 时校验配置使用的可执行文件
+	DefaultBinary() string
+	// TestCommand 返回校验配置文件的 shell 命令
+	TestCommand(binary, path string) string
+	// SupportsReality 是否支持 VLESS + REALITY 出站，不支持时中转不添加 REALITY 实例的出站
+	SupportsReality() bool
+
+	// parse 解析配置文件内容
+	parse(data []byte) (document, error)
+	// api 返回 gRPC API 的服务名和消息类型名
+	api() apiSchema
+}
+
+// NewEngine 按名称返回代理程序
+// 参数:
+//   - name: v2ray、xray 或 sing-box，为空时为 v2ray
+//
+// 返回值:
+//   - Engine: 代理程序
+//   - error: 错误信息，如果名称不支持
+func NewEngine(name string) (Engine, error) {
+	switch name {
+	case "", EngineV2Ray:
+		return v2rayEngine{}, nil
+	case EngineXray:
+		return xrayEngine{}, nil
+	case EngineSingBox:
+		return singBoxEngine{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported relay engine %q, expected v2ray, xray or sing-box", name)
+	}
+}
+
+// document 读取后的中转配置文件，按代理程序的格式读取和修改中转出站、路由和用户
+// 功能:
+//  1. 修改方法同时在 changes 中记录需要应用到运行中服务的变更，不能通过 API 应用的变更设置 changes.restart
+//  2. 未建模的字段按原文写回
+type document interface {
+	// marshal 序列化为配置文件内容
+	marshal() ([]byte, error)
+	// relayOutbounds 返回所有指向 AWS 实例的中转出站，按配置中的顺序排列
+	relayOutbounds() ([]RelayOutbound, error)
+	// relayRoutes 返回各区域的中转路由，按区域排序
+	relayRoutes(options Options) []RelayRoute
+	// relayUsers 返回已有中转用户的区域，按区域排序
+	relayUsers() []string
+	// relayConfig 返回区域中转用户所在入站的端口和用户的 UUID
+	relayConfig(region string) (int, string, error)
+	// upsertOutbound 添加中转出站，已存在相同标签的出站时只替换地址、端口和 UUID
+	upsertOutbound(relay RelayOutbound, changes *runtimeChanges)
+	// removeOutbound 删除中转出站及直接指向它的路由，返回是否删除了出站
+	removeOutbound(tag string, changes *runtimeChanges) bool
+	// hasRegionOutbounds 返回是否还有该区域的中转出站
+	hasRegionOutbounds(region string) bool
+	// ensureRoute 确保区域用户经区域负载均衡器路由到该区域的实例出站
+	ensureRoute(region string, options Options, changes *runtimeChanges)
+	// removeRoute 删除区域的路由规则和负载均衡器，返回是否删除了内容
+	removeRoute(region string, changes *runtimeChanges) bool
+	// ensureUser 区域还没有中转用户时在中转入站中创建
+	ensureUser(region string, options Options, changes *runtimeChanges) error
+	// removeUser 删除区域的中转用户
+	removeUser(region string, changes *runtimeChanges)
+}
+
+// apiSchema 代理程序 gRPC API 的服务名和消息类型名
+type apiSchema struct {
+	// prefix 服务名和消息类型名的包前缀，V2Ray 为 "v2ray.core."，Xray 为 "xray."，其余部分相同
+	prefix string
+	// statsService StatsService 的完整服务名
+	statsService string
+	// handler 是否提供 HandlerService，不提供时修改出站和用户后重启服务
+	handler bool
+}
+
+// method 返回 HandlerService 方法的完整名称
+func (s apiSchema) method(name string) string {
+	return "/" + s.prefix + "app.proxyman.command.HandlerService/" + name
+}
+
+// typeName 返回配置消息的完整类型名，例如 typeName("proxy.vmess.Account")
+func (s apiSchema) typeName(name string) string {
+	return s.prefix + name
+}
+
+// v2rayEngine V2Ray，配置为 v4 JSON 格式
+type v2rayEngine struct{}
+
+// Name 返回 v2ray
+func (v2rayEngine) Name() string {
+	return EngineV2Ray
+}
+
+// ServiceName 返回 v2ray
+func (v2rayEngine) ServiceName() string {
+	return "v2ray"
+}
+
+// DefaultBinary 返回 v2ray
+func (v2rayEngine) DefaultBinary() string {
+	return "v2ray"
+}
+
+// TestCommand 返回 <binary> test -c <path>
+func (v2rayEngine) TestCommand(binary, path string) string {
+	return shellQuote(binary) + " test -c " + shellQuote(path)
+}
+
+// SupportsReality 返回 false，V2Ray 没有实现 REALITY
+func (v2rayEngine) SupportsReality() bool {
+	return false
+}
+
+func (v2rayEngine) parse(data []byte) (document, error) {
+	return parseV4Document(data)
+}
+
+func (v2rayEngine) api() apiSchema {
+	return apiSchema{prefix: "v2ray.core.", statsService: "v2ray.core.app.stats.command.StatsService", handler: true}
+}
+
+// xrayEngine Xray-core，配置格式与 V2Ray v4 JSON 相同（inbounds、outbounds、routing.balancers、observatory），
+// API 的包名为 xray
+type xrayEngine struct{}
+
+// Name 返回 xray
+func (xrayEngine) Name() string {
+	return EngineXray
+}
+
+// ServiceName 返回 xray
+func (xrayEngine) ServiceName() string {
+	return "xray"
+}
+
+// DefaultBinary 返回 xray
+func (xrayEngine) DefaultBinary() string {
+	return "xray"
+}
+
+// TestCommand 返回 <binary> run -test -c <path>
+func (xrayEngine) TestCommand(binary, path string) string {
+	return shellQuote(binary) + " run -test -c " + shellQuote(path)
+}
+
+// SupportsReality 返回 true
+func (xrayEngine) SupportsReality() bool {
+	return true
+}
+
+func (xrayEngine) parse(data []byte) (document, error) {
+	return parseV4Document(data)
+}
+
+func (xrayEngine) api() apiSchema {
+	return apiSchema{prefix: "xray.", statsService: "xray.app.stats.command.StatsService", handler: true}
+}
+
+// singBoxEngine sing-box，配置格式见 SingBoxConfig
+// 功能:
+//  1. 没有 HandlerService，修改配置后总是重启服务
+//  2. 编译时启用 with_v2ray_api 并配置 experimental.v2ray_api 后可以统计流量
+type singBoxEngine struct{}
+
+// Name 返回 sing-box
+func (singBoxEngine) Name() string {
+	return EngineSingBox
+}
+
+// ServiceName 返回 sing-box
+func (singBoxEngine) ServiceName() string {
+	return "sing-box"
+}
+
+// DefaultBinary 返回 sing-box
+func (singBoxEngine) DefaultBinary() string {
+	return "sing-box"
+}
+
+// TestCommand 返回 <binary> check -c <path>
+func (singBoxEngine) TestCommand(binary, path string) string {
+	return shellQuote(binary) + " check -c " + shellQuote(path)
+}
+
+// SupportsReality 返回 true，sing-box 需要使用 with_utls 构建标签编译
+func (singBoxEngine) SupportsReality() bool {
+	return true
+}
+
+func (singBoxEngine) parse(data []byte) (document, error) {
+	return parseSingBoxDocument(data)
+}
+
+func (singBoxEngine) api() apiSchema {
+	return apiSchema{statsService: "experimental.v2rayapi.StatsService"}
+}
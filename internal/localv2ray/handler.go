@@ -0,0 +1,204 @@
+package localv2ray
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+)
+
+// HandlerService 的方法，完整名称由 apiSchema.method 加上包前缀
+const (
+	addOutboundMethod    = "AddOutbound"
+	removeOutboundMethod = "RemoveOutbound"
+	alterInboundMethod   = "AlterInbound"
+)
+
+// 内部配置消息的类型名，完整类型名由 apiSchema.typeName 加上包前缀
+const (
+	vmessOutboundConfigType = "proxy.vmess.outbound.Config"
+	vmessAccountType        = "proxy.vmess.Account"
+	addUserOperationType    = "app.proxyman.command.AddUserOperation"
+	removeUserOperationType = "app.proxyman.command.RemoveUserOperation"
+)
+
+// vmessSecurityAuto v2ray.core.common.protocol.SecurityType.AUTO，与 JSON 配置中省略 security 时相同
+const vmessSecurityAuto = 2
+
+// AddOutbound 通过 HandlerService 在运行中的 V2Ray 上添加出站
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值和取消信号
+//   - outbound: 出站配置，目前只支持 vmess 协议
+//
+// 返回值:
+//   - error: 错误信息，如果协议不支持、标签已存在或调用失败
+func (c *APIClient) AddOutbound(ctx context.Context, outbound OutboundConfig) error {
+	handlerConfig, err := marshalOutboundHandlerConfig(c.schema, outbound)
+	if err != nil {
+		return err
+	}
+	request := &addOutboundRequest{outbound: handlerConfig}
+	return c.invoke(ctx, c.schema.method(addOutboundMethod), request, &emptyMessage{})
+}
+
+// RemoveOutbound 通过 HandlerService 从运行中的 V2Ray 上移除出站
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值和取消信号
+//   - tag: 出站标签
+//
+// 返回值:
+//   - error: 错误信息，如果出站不存在或调用失败
+func (c *APIClient) RemoveOutbound(ctx context.Context, tag string) error {
+	return c.invoke(ctx, c.schema.method(removeOutboundMethod), &removeOutboundRequest{tag: tag}, &emptyMessage{})
+}
+
+// addOutboundRequest v2ray.core.app.proxyman.command.AddOutboundRequest
+type addOutboundRequest struct {
+	// outbound 编码后的 v2ray.core.OutboundHandlerConfig
+	outbound []byte
+}
+
+func (r *addOutboundRequest) marshal() []byte {
+	return appendBytes(nil, 1, r.outbound)
+}
+
+func (r *addOutboundRequest) unmarshal(data []byte) error {
+	return nil
+}
+
+// removeOutboundRequest v2ray.core.app.proxyman.command.RemoveOutboundRequest
+type removeOutboundRequest struct {
+	tag string
+}
+
+func (r *removeOutboundRequest) marshal() []byte {
+	return appendString(nil, 1, r.tag)
+}
+
+func (r *removeOutboundRequest) unmarshal(data []byte) error {
+	return nil
+}
+
+// marshalOutboundHandlerConfig 将 JSON 出站配置编码为 v2ray.core.OutboundHandlerConfig
+// 参数:
+//   - schema: 代理程序 API 的消息类型名，V2Ray 和 Xray 的消息字段相同、类型名不同
+//   - outbound: 出站配置
+//
+// 功能:
+//  1. 只编码标签和代理设置，发送设置使用 V2Ray 的默认值
+//  2. vmess 的每个用户使用 0 级和自动加密方式，与 JSON 配置的默认值相同
+func marshalOutboundHandlerConfig(schema apiSchema, outbound OutboundConfig) ([]byte, error) {
+	if outbound.Protocol != "vmess" {
+		return nil, fmt.Errorf("outbound %s: protocol %q cannot be added through the API", outbound.Tag, outbound.Protocol)
+	}
+	settings, err := vmessSettings(outbound)
+	if err != nil {
+		return nil, err
+	}
+
+	var proxy []byte
+	for _, vnext := range settings.VNext {
+		var endpoint []byte
+		endpoint = appendBytes(endpoint, 1, marshalIPOrDomain(vnext.Address))
+		endpoint = appendVarint(endpoint, 2, uint64(vnext.Port))
+		for _, user := range vnext.Users {
+			var security []byte
+			security = appendVarint(security, 1, vmessSecurityAuto)
+
+			var account []byte
+			account = appendString(account, 1, user.ID)
+			account = appendVarint(account, 2, uint64(user.AlterId))
+			account = appendBytes(account, 3, security)
+
+			var protocolUser []byte
+			protocolUser = appendTypedMessage(protocolUser, 3, schema.typeName(vmessAccountType), account)
+			endpoint = appendBytes(endpoint, 3, protocolUser)
+		}
+		proxy = appendBytes(proxy, 1, endpoint)
+	}
+
+	var handlerConfig []byte
+	handlerConfig = appendString(handlerConfig, 1, outbound.Tag)
+	handlerConfig = appendTypedMessage(handlerConfig, 3, schema.typeName(vmessOutboundConfigType), proxy)
+	return handlerConfig, nil
+}
+
+// marshalIPOrDomain 编码 v2ray.core.common.net.IPOrDomain
+func marshalIPOrDomain(address string) []byte {
+	if ip := net.ParseIP(address); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		return appendBytes(nil, 1, ip)
+	}
+	return appendString(nil, 2, address)
+}
+
+// vmessSettings 将出站的 Settings 解析为 vmess 出站设置
+// 功能:
+//  1. 读取配置后 Settings 是通用的 map，新建的出站则是 VmessOutboundSettings，两者都重新序列化后解析
+func vmessSettings(outbound OutboundConfig) (VmessOutboundSettings, error) {
+	var settings VmessOutboundSettings
+	data, err := json.Marshal(outbound.Settings)
+	if err != nil {
+		return settings, fmt.Errorf("failed to marshal settings of outbound %s: %v", outbound.Tag, err)
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return settings, fmt.Errorf("failed to parse settings of outbound %s: %v", outbound.Tag, err)
+	}
+	return settings, nil
+}
+
+// AddInboundUser 通过 HandlerService 在运行中的 V2Ray 入站上添加 vmess 用户
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值和取消信号
+//   - inboundTag: 入站标签
+//   - client: 用户配置，使用 0 级
+//
+// 返回值:
+//   - error: 错误信息，如果入站不存在、用户已存在或调用失败
+func (c *APIClient) AddInboundUser(ctx context.Context, inboundTag string, client VmessClientConfig) error {
+	var account []byte
+	account = appendString(account, 1, client.ID)
+	account = appendVarint(account, 2, uint64(client.AlterId))
+
+	var protocolUser []byte
+	protocolUser = appendString(protocolUser, 2, client.Email)
+	protocolUser = appendTypedMessage(protocolUser, 3, c.schema.typeName(vmessAccountType), account)
+
+	operation := appendBytes(nil, 1, protocolUser)
+	request := &alterInboundRequest{tag: inboundTag, operationType: c.schema.typeName(addUserOperationType), operation: operation}
+	return c.invoke(ctx, c.schema.method(alterInboundMethod), request, &emptyMessage{})
+}
+
+// RemoveInboundUser 通过 HandlerService 从运行中的 V2Ray 入站上删除用户
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值和取消信号
+//   - inboundTag: 入站标签
+//   - email: 用户 email
+//
+// 返回值:
+//   - error: 错误信息，如果入站或用户不存在、调用失败
+func (c *APIClient) RemoveInboundUser(ctx context.Context, inboundTag, email string) error {
+	operation := appendString(nil, 1, email)
+	request := &alterInboundRequest{tag: inboundTag, operationType: c.schema.typeName(removeUserOperationType), operation: operation}
+	return c.invoke(ctx, c.schema.method(alterInboundMethod), request, &emptyMessage{})
+}
+
+// alterInboundRequest v2ray.core.app.proxyman.command.AlterInboundRequest
+type alterInboundRequest struct {
+	tag string
+	// operationType 和 operation 编码为 TypedMessage
+	operationType string
+	operation     []byte
+}
+
+func (r *alterInboundRequest) marshal() []byte {
+	var data []byte
+	data = appendString(data, 1, r.tag)
+	return appendTypedMessage(data, 2, r.operationType, r.operation)
+}
+
+func (r *alterInboundRequest) unmarshal(data []byte) error {
+	return nil
+}
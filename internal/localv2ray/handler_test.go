@@ -0,0 +1,422 @@
+package localv2ray
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// rawMessage 未解析的 API 消息，假 HandlerService 用它记录收到的请求
+type rawMessage []byte
+
+func (m *rawMessage) marshal() []byte {
+	return *m
+}
+
+func (m *rawMessage) unmarshal(data []byte) error {
+	*m = append(rawMessage(nil), data...)
+	return nil
+}
+
+// handlerCall 假 HandlerService 收到的一次调用
+type handlerCall struct {
+	method  string
+	request []byte
+}
+
+// fakeHandlerService 进程内的 HandlerService，记录每次调用的请求，failMethod 的调用返回错误
+type fakeHandlerService struct {
+	server     *grpc.Server
+	failMethod string
+
+	mu    sync.Mutex
+	calls []handlerCall
+}
+
+// newFakeHandlerService 按代理程序的服务名注册 AddOutbound、RemoveOutbound 和 AlterInbound
+func newFakeHandlerService(engine Engine) *fakeHandlerService {
+	s := &fakeHandlerService{server: grpc.NewServer(grpc.ForceServerCodec(apiCodec{}))}
+	var methods []grpc.MethodDesc
+	for _, name := range []string{addOutboundMethod, removeOutboundMethod, alterInboundMethod} {
+		name := name
+		methods = append(methods, grpc.MethodDesc{
+			MethodName: name,
+			Handler: func(_ interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				var request rawMessage
+				if err := dec(&request); err != nil {
+					return nil, err
+				}
+				return s.call(name, request)
+			},
+		})
+	}
+	// 服务名取自 method 返回的 "/<服务名>/<方法名>"
+	method := engine.api().method(addOutboundMethod)
+	serviceName := method[1 : len(method)-len(addOutboundMethod)-1]
+	s.server.RegisterService(&grpc.ServiceDesc{ServiceName: serviceName, HandlerType: (*interface{})(nil), Methods: methods}, s)
+	return s
+}
+
+func (s *fakeHandlerService) call(method string, request rawMessage) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, handlerCall{method: method, request: request})
+	if method == s.failMethod {
+		return nil, status.Error(codes.Unknown, "handler not found")
+	}
+	return &emptyMessage{}, nil
+}
+
+func (s *fakeHandlerService) Serve(listener net.Listener) error {
+	return s.server.Serve(listener)
+}
+
+func (s *fakeHandlerService) Stop() {
+	s.server.Stop()
+}
+
+// methods 返回收到的调用的方法名
+func (s *fakeHandlerService) methods() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var methods []string
+	for _, call := range s.calls {
+		methods = append(methods, call.method)
+	}
+	return methods
+}
+
+// handlerProto V2Ray 中 HandlerService 请求用到的消息，字段编号和类型与以下 .proto 文件相同：
+// common/serial/typed_message.proto、common/net/address.proto、common/protocol/{user,server_spec,headers}.proto、
+// proxy/vmess/{account,outbound/config}.proto、config.proto、app/proxyman/command/command.proto。
+// 编码结果与包名无关，因此放在同一个文件中
+func handlerProto(t *testing.T) protoreflect.FileDescriptor {
+	const (
+		str     = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		byts    = descriptorpb.FieldDescriptorProto_TYPE_BYTES
+		uint32_ = descriptorpb.FieldDescriptorProto_TYPE_UINT32
+		int64_  = descriptorpb.FieldDescriptorProto_TYPE_INT64
+		// SecurityType 是枚举，编码与 int32 相同
+		enum    = descriptorpb.FieldDescriptorProto_TYPE_INT32
+		message = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	)
+	return protoFile(t, "handler.proto", "v2ray.core", map[string][]protoField{
+		"TypedMessage": {{name: "type", number: 1, kind: str}, {name: "value", number: 2, kind: byts}},
+		"IPOrDomain":   {{name: "ip", number: 1, kind: byts}, {name: "domain", number: 2, kind: str}},
+		"User": {
+			{name: "level", number: 1, kind: uint32_},
+			{name: "email", number: 2, kind: str},
+			{name: "account", number: 3, kind: message, message: "v2ray.core.TypedMessage"},
+		},
+		"ServerEndpoint": {
+			{name: "address", number: 1, kind: message, message: "v2ray.core.IPOrDomain"},
+			{name: "port", number: 2, kind: uint32_},
+			{name: "user", number: 3, kind: message, message: "v2ray.core.User", repeated: true},
+		},
+		"SecurityConfig": {{name: "type", number: 1, kind: enum}},
+		"VmessAccount": {
+			{name: "id", number: 1, kind: str},
+			{name: "alter_id", number: 2, kind: uint32_},
+			{name: "security_settings", number: 3, kind: message, message: "v2ray.core.SecurityConfig"},
+			{name: "tests_enabled", number: 4, kind: str},
+		},
+		"VmessOutboundConfig": {{name: "Receiver", number: 1, kind: message, message: "v2ray.core.ServerEndpoint", repeated: true}},
+		"OutboundHandlerConfig": {
+			{name: "tag", number: 1, kind: str},
+			{name: "sender_settings", number: 2, kind: message, message: "v2ray.core.TypedMessage"},
+			{name: "proxy_settings", number: 3, kind: message, message: "v2ray.core.TypedMessage"},
+			{name: "expire", number: 4, kind: int64_},
+			{name: "comment", number: 6, kind: str},
+		},
+		"AddOutboundRequest":    {{name: "outbound", number: 1, kind: message, message: "v2ray.core.OutboundHandlerConfig"}},
+		"RemoveOutboundRequest": {{name: "tag", number: 1, kind: str}},
+		"AlterInboundRequest": {
+			{name: "tag", number: 1, kind: str},
+			{name: "operation", number: 2, kind: message, message: "v2ray.core.TypedMessage"},
+		},
+		"AddUserOperation":    {{name: "user", number: 1, kind: message, message: "v2ray.core.User"}},
+		"RemoveUserOperation": {{name: "email", number: 1, kind: str}},
+	})
+}
+
+// typedMessage 返回 protojson 格式的 TypedMessage，value 为官方实现编码的 name 消息
+func typedMessage(t *testing.T, file protoreflect.FileDescriptor, typeName, name, value string) string {
+	t.Helper()
+	data := protoMarshal(t, file, name, value)
+	return fmt.Sprintf(`{"type": %q, "value": %q}`, typeName, base64.StdEncoding.EncodeToString(data))
+}
+
+// vmessAccountJSON 返回 protojson 格式、TypedMessage 包装的 vmess 账号，加密方式为 SecurityType.AUTO (2)
+func vmessAccountJSON(t *testing.T, file protoreflect.FileDescriptor, schema apiSchema, id string, alterID int) string {
+	t.Helper()
+	return typedMessage(t, file, schema.typeName(vmessAccountType), "VmessAccount",
+		fmt.Sprintf(`{"id": %q, "alter_id": %d, "security_settings": {"type": 2}}`, id, alterID))
+}
+
+// outboundHandlerConfigJSON 返回 protojson 格式的 OutboundHandlerConfig，receivers 为 vmess 出站设置中的 Receiver 列表
+func outboundHandlerConfigJSON(t *testing.T, file protoreflect.FileDescriptor, schema apiSchema, tag, receivers string) string {
+	t.Helper()
+	proxy := typedMessage(t, file, schema.typeName(vmessOutboundConfigType), "VmessOutboundConfig", `{"Receiver": `+receivers+`}`)
+	return fmt.Sprintf(`{"tag": %q, "proxy_settings": %s}`, tag, proxy)
+}
+
+// vmessOutbound 返回一个 vmess 出站
+func vmessOutbound(tag, address string, port int, users ...UserConfig) OutboundConfig {
+	return OutboundConfig{
+		Protocol: "vmess",
+		Tag:      tag,
+		Settings: VmessOutboundSettings{VNext: []VNextConfig{{Address: address, Port: port, Users: users}}},
+	}
+}
+
+// TestOutboundHandlerConfigEncoding 手写的 OutboundHandlerConfig 和 vmess 账号编码与 .proto 定义的官方编码逐字节相同
+func TestOutboundHandlerConfigEncoding(t *testing.T) {
+	file := handlerProto(t)
+	ipv4 := base64.StdEncoding.EncodeToString([]byte{203, 0, 113, 7})
+	ipv6 := base64.StdEncoding.EncodeToString(net.ParseIP("2001:db8::1"))
+
+	tests := []struct {
+		name     string
+		engine   Engine
+		outbound OutboundConfig
+		// receivers protojson 格式的 Receiver 列表，account 生成用户的账号
+		receivers func(account func(id string, alterID int) string) string
+	}{
+		{
+			name:     "domain",
+			engine:   v2rayEngine{},
+			outbound: vmessOutbound("out_aws_ap_east_1-a", "ec2.example.com", 443, UserConfig{ID: "11111111-1111-1111-1111-111111111111"}),
+			receivers: func(account func(string, int) string) string {
+				return `[{"address": {"domain": "ec2.example.com"}, "port": 443, "user": [{"account": ` +
+					account("11111111-1111-1111-1111-111111111111", 0) + `}]}]`
+			},
+		},
+		{
+			name:     "ipv4",
+			engine:   v2rayEngine{},
+			outbound: vmessOutbound("out_aws_ap_east_1-b", "203.0.113.7", 10086, UserConfig{ID: "22222222-2222-2222-2222-222222222222", AlterId: 64}),
+			receivers: func(account func(string, int) string) string {
+				return `[{"address": {"ip": "` + ipv4 + `"}, "port": 10086, "user": [{"account": ` +
+					account("22222222-2222-2222-2222-222222222222", 64) + `}]}]`
+			},
+		},
+		{
+			name:     "ipv6 with two users",
+			engine:   v2rayEngine{},
+			outbound: vmessOutbound("out_aws_us_west_2", "2001:db8::1", 443, UserConfig{ID: "a"}, UserConfig{ID: "b", AlterId: 1}),
+			receivers: func(account func(string, int) string) string {
+				return `[{"address": {"ip": "` + ipv6 + `"}, "port": 443, "user": [{"account": ` +
+					account("a", 0) + `}, {"account": ` + account("b", 1) + `}]}]`
+			},
+		},
+		{
+			name:     "xray type names",
+			engine:   xrayEngine{},
+			outbound: vmessOutbound("out_aws_ap_east_1-a", "ec2.example.com", 443, UserConfig{ID: "11111111-1111-1111-1111-111111111111"}),
+			receivers: func(account func(string, int) string) string {
+				return `[{"address": {"domain": "ec2.example.com"}, "port": 443, "user": [{"account": ` +
+					account("11111111-1111-1111-1111-111111111111", 0) + `}]}]`
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := tt.engine.api()
+			account := func(id string, alterID int) string {
+				return vmessAccountJSON(t, file, schema, id, alterID)
+			}
+			handlerConfig := outboundHandlerConfigJSON(t, file, schema, tt.outbound.Tag, tt.receivers(account))
+			want := protoMarshal(t, file, "OutboundHandlerConfig", handlerConfig)
+
+			got, err := marshalOutboundHandlerConfig(schema, tt.outbound)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("marshalOutboundHandlerConfig() = %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+// TestOutboundHandlerConfigUnsupported vless（REALITY）等非 vmess 出站不能通过 API 添加
+func TestOutboundHandlerConfigUnsupported(t *testing.T) {
+	outbound := newRealityOutbound("out_aws_ap_east_1-r", "203.0.113.7", 443, "33333333-3333-3333-3333-333333333333", Reality{PublicKey: "key"})
+	if _, err := marshalOutboundHandlerConfig(xrayEngine{}.api(), outbound); err == nil {
+		t.Error("marshalOutboundHandlerConfig() of a vless outbound succeeded")
+	}
+}
+
+// TestHandlerRequests 通过 gRPC 调用进程内的 HandlerService，AddOutbound、RemoveOutbound 和 AlterInbound
+// 的请求与 .proto 定义的官方编码逐字节相同
+func TestHandlerRequests(t *testing.T) {
+	file := handlerProto(t)
+	for _, engine := range []Engine{v2rayEngine{}, xrayEngine{}} {
+		t.Run(engine.Name(), func(t *testing.T) {
+			schema := engine.api()
+			service := newFakeHandlerService(engine)
+			client := serveBufconn(t, engine, service.Serve, service.Stop)
+			ctx := context.Background()
+
+			outbound := vmessOutbound("out_aws_ap_east_1-a", "ec2.example.com", 443, UserConfig{ID: "11111111-1111-1111-1111-111111111111"})
+			if err := client.AddOutbound(ctx, outbound); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.RemoveOutbound(ctx, "out_aws_ap_east_1-a"); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.AddInboundUser(ctx, "relay-in", VmessClientConfig{ID: "44444444-4444-4444-4444-444444444444", AlterId: 4, Email: "user_aws_ap-east-1"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.RemoveInboundUser(ctx, "relay-in", "user_aws_ap-east-1"); err != nil {
+				t.Fatal(err)
+			}
+
+			handlerConfig := outboundHandlerConfigJSON(t, file, schema, outbound.Tag, `[{"address": {"domain": "ec2.example.com"}, "port": 443, "user": [{"account": `+
+				vmessAccountJSON(t, file, schema, "11111111-1111-1111-1111-111111111111", 0)+`}]}]`)
+			user := fmt.Sprintf(`{"email": "user_aws_ap-east-1", "account": %s}`, typedMessage(t, file, schema.typeName(vmessAccountType), "VmessAccount",
+				`{"id": "44444444-4444-4444-4444-444444444444", "alter_id": 4}`))
+			want := []handlerCall{
+				{addOutboundMethod, protoMarshal(t, file, "AddOutboundRequest", `{"outbound": `+handlerConfig+`}`)},
+				{removeOutboundMethod, protoMarshal(t, file, "RemoveOutboundRequest", `{"tag": "out_aws_ap_east_1-a"}`)},
+				{alterInboundMethod, protoMarshal(t, file, "AlterInboundRequest", fmt.Sprintf(`{"tag": "relay-in", "operation": %s}`,
+					typedMessage(t, file, schema.typeName(addUserOperationType), "AddUserOperation", `{"user": `+user+`}`)))},
+				{alterInboundMethod, protoMarshal(t, file, "AlterInboundRequest", fmt.Sprintf(`{"tag": "relay-in", "operation": %s}`,
+					typedMessage(t, file, schema.typeName(removeUserOperationType), "RemoveUserOperation", `{"email": "user_aws_ap-east-1"}`)))},
+			}
+
+			if len(service.calls) != len(want) {
+				t.Fatalf("got calls %v, want %d calls", service.methods(), len(want))
+			}
+			for i := range want {
+				if service.calls[i].method != want[i].method || !bytes.Equal(service.calls[i].request, want[i].request) {
+					t.Errorf("call %d = %s %x, want %s %x", i, service.calls[i].method, service.calls[i].request, want[i].method, want[i].request)
+				}
+			}
+		})
+	}
+}
+
+// fakeDriver 本机读写文件，记录执行的命令，API 连接到内存中的假服务
+type fakeDriver struct {
+	*LocalDriver
+	listener *bufconn.Listener
+	commands []string
+}
+
+func (d *fakeDriver) Run(ctx context.Context, command string) ([]byte, error) {
+	d.commands = append(d.commands, command)
+	return nil, nil
+}
+
+func (d *fakeDriver) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if d.listener == nil {
+		return nil, errors.New("connection refused")
+	}
+	return d.listener.DialContext(ctx)
+}
+
+// TestApply 有 API 且代理程序提供 HandlerService 时通过 API 应用变更，否则、需要重启或 API 调用失败时重启服务
+func TestApply(t *testing.T) {
+	vmess := vmessOutbound("out_aws_ap_east_1-a", "203.0.113.7", 443, UserConfig{ID: "11111111-1111-1111-1111-111111111111"})
+	reality := newRealityOutbound("out_aws_ap_east_1-r", "203.0.113.8", 443, "33333333-3333-3333-3333-333333333333", Reality{PublicKey: "key"})
+	user := inboundUser{inboundTag: "relay-in", client: VmessClientConfig{ID: "44444444-4444-4444-4444-444444444444", Email: "user_aws_ap-east-1"}}
+
+	tests := []struct {
+		name        string
+		engine      Engine
+		apiAddress  string
+		failMethod  string
+		changes     runtimeChanges
+		wantCalls   []string
+		wantRestart bool
+	}{
+		{
+			name:       "no changes",
+			engine:     v2rayEngine{},
+			apiAddress: "127.0.0.1:10085",
+		},
+		{
+			name:       "through the api",
+			engine:     v2rayEngine{},
+			apiAddress: "127.0.0.1:10085",
+			changes:    runtimeChanges{upserts: []OutboundConfig{vmess}, removals: []string{"out_aws_us_west_2-b"}, addUsers: []inboundUser{user}, removeUsers: []inboundUser{user}},
+			// 先删除用户和出站，修改的出站先删除再添加，最后添加用户
+			wantCalls: []string{alterInboundMethod, removeOutboundMethod, removeOutboundMethod, addOutboundMethod, alterInboundMethod},
+		},
+		{
+			name:        "no api address",
+			engine:      v2rayEngine{},
+			changes:     runtimeChanges{upserts: []OutboundConfig{vmess}},
+			wantRestart: true,
+		},
+		{
+			name:        "restart required",
+			engine:      v2rayEngine{},
+			apiAddress:  "127.0.0.1:10085",
+			changes:     runtimeChanges{upserts: []OutboundConfig{vmess}, restart: true},
+			wantRestart: true,
+		},
+		{
+			name:        "sing-box has no handler service",
+			engine:      singBoxEngine{},
+			apiAddress:  "127.0.0.1:10085",
+			changes:     runtimeChanges{removals: []string{"out_aws_ap_east_1-a"}},
+			wantRestart: true,
+		},
+		{
+			name:        "api call fails",
+			engine:      xrayEngine{},
+			apiAddress:  "127.0.0.1:10085",
+			failMethod:  addOutboundMethod,
+			changes:     runtimeChanges{upserts: []OutboundConfig{vmess}, addUsers: []inboundUser{user}},
+			wantCalls:   []string{removeOutboundMethod, addOutboundMethod},
+			wantRestart: true,
+		},
+		{
+			name:        "vless outbound cannot be added",
+			engine:      xrayEngine{},
+			apiAddress:  "127.0.0.1:10085",
+			changes:     runtimeChanges{upserts: []OutboundConfig{reality}},
+			wantCalls:   []string{removeOutboundMethod},
+			wantRestart: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := newFakeHandlerService(tt.engine)
+			service.failMethod = tt.failMethod
+			listener := bufconn.Listen(1 << 20)
+			go service.Serve(listener)
+			defer service.Stop()
+
+			driver := &fakeDriver{LocalDriver: NewLocalDriver(), listener: listener}
+			options := Options{APIAddress: tt.apiAddress, ReloadCommand: "restart-relay"}
+			manager := NewLocalV2RayManager("test", "", tt.engine, driver, func() Options { return options })
+			defer manager.Close()
+
+			manager.apply(context.Background(), tt.changes)
+
+			if got := service.methods(); !reflect.DeepEqual(got, tt.wantCalls) {
+				t.Errorf("API calls = %v, want %v", got, tt.wantCalls)
+			}
+			restarted := reflect.DeepEqual(driver.commands, []string{"restart-relay"})
+			if restarted != tt.wantRestart || (!tt.wantRestart && len(driver.commands) > 0) {
+				t.Errorf("commands = %v, want restart %v", driver.commands, tt.wantRestart)
+			}
+		})
+	}
+}
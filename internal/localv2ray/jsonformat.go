@@ -0,0 +1,449 @@
+package localv2ray
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// jsonNode 带格式信息的 JSON 值，用于按原文件的格式写回配置
+type jsonNode struct {
+	// text 值的原文，容器包含内部的空白
+	text []byte
+	// kind 容器的起始字符 '{' 或 '['，标量为 0
+	kind byte
+	// indent 值所在行的缩进
+	indent []byte
+	// inline 值是否位于单行中：有成员的容器看自身是否跨行，其他值与所在的容器相同
+	inline bool
+	// members 对象的字段或数组的元素
+	members []jsonMember
+	// close 最后一个成员之后、结束括号之前的空白
+	close []byte
+}
+
+// jsonMember 对象的字段或数组的元素
+type jsonMember struct {
+	// pre 成员之前的空白
+	pre []byte
+	// key 字段名，数组元素为空
+	key string
+	// keyText 字段名的原文
+	keyText []byte
+	// sep 字段名与值之间的冒号及空白
+	sep   []byte
+	value *jsonNode
+	// post 值之后、逗号之前的空白
+	post []byte
+}
+
+// jsonStyle 新增内容的格式，从原文件中推断
+type jsonStyle struct {
+	// unit 每一级的缩进
+	unit []byte
+	// spaced 单行的对象和数组是否在冒号和逗号后加空格
+	spaced bool
+}
+
+// preserveFormat 按原文件的格式输出更新后的配置
+// 参数:
+//   - source: 读取的配置文件原文，为空时按两个空格缩进输出
+//   - updated: 更新后的配置，紧凑的 JSON
+//
+// 返回值:
+//   - []byte: 配置文件内容
+//   - error: 错误信息，如果任一输入不是有效的 JSON
+//
+// 功能:
+//  1. 与原文相同的值（紧凑形式相同）逐字节输出原文，包括其中的空白、缩进和单行的对象
+//  2. 修改过的对象和数组保留原有成员之间的空白，只重新输出变化的成员；
+//     数组按元素内容对齐，中间删除或插入元素不影响其他元素
+//  3. 新增的内容按原文件检测到的缩进单位（空格或制表符）输出，位于单行的对象或数组中时保持单行
+//  4. 文件开头和结尾的空白（包括结尾的换行）保持不变
+func preserveFormat(source, updated []byte) ([]byte, error) {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, updated); err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(source)) == 0 {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, compact.Bytes(), "", "  "); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+		return buf.Bytes(), nil
+	}
+
+	p := &jsonParser{data: source}
+	p.skipSpace()
+	lead := source[:p.pos]
+	before, err := p.parseValue(!bytes.ContainsRune(source, '\n'))
+	if err != nil {
+		return nil, fmt.Errorf("invalid source: %v", err)
+	}
+	start := p.pos
+	p.skipSpace()
+	if p.pos != len(source) {
+		return nil, fmt.Errorf("invalid source: unexpected data at offset %d", p.pos)
+	}
+	trail := source[start:]
+
+	q := &jsonParser{data: compact.Bytes()}
+	after, err := q.parseValue(false)
+	if err != nil {
+		return nil, err
+	}
+
+	style := jsonStyle{unit: detectIndent(source), spaced: p.spaced}
+	var buf bytes.Buffer
+	buf.Write(lead)
+	style.merge(&buf, before, after)
+	buf.Write(trail)
+	return buf.Bytes(), nil
+}
+
+// merge 输出 after，与 before 相同的部分使用 before 的原文
+func (s jsonStyle) merge(buf *bytes.Buffer, before, after *jsonNode) {
+	switch {
+	case compactEqual(before.text, after.text):
+		buf.Write(before.text)
+	case before.kind != 0 && before.kind == after.kind:
+		if before.kind == '{' {
+			s.mergeObject(buf, before, after)
+		} else {
+			s.mergeArray(buf, before, after)
+		}
+	default:
+		s.write(buf, after, before.indent, before.inline)
+	}
+}
+
+// mergeObject 按字段名对应原有字段，字段顺序以 after 为准
+func (s jsonStyle) mergeObject(buf *bytes.Buffer, before, after *jsonNode) {
+	existing := make(map[string]int, len(before.members))
+	for i, member := range before.members {
+		existing[member.key] = i
+	}
+
+	members := make([]memberOutput, 0, len(after.members))
+	for k := range after.members {
+		member := &after.members[k]
+		if i, ok := existing[member.key]; ok {
+			members = append(members, memberOutput{before: &before.members[i], after: member})
+		} else {
+			members = append(members, memberOutput{after: member})
+		}
+	}
+	s.writeMembers(buf, before, members)
+}
+
+// mergeArray 按内容对齐原有元素：相同的元素原样输出，
+// 在后面能找到相同元素时视为删除或插入，否则视为同一元素被修改
+func (s jsonStyle) mergeArray(buf *bytes.Buffer, before, after *jsonNode) {
+	find := func(members []jsonMember, text []byte) bool {
+		for _, member := range members {
+			if compactEqual(member.value.text, text) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var members []memberOutput
+	i, j := 0, 0
+	for j < len(after.members) {
+		next := &after.members[j]
+		switch {
+		case i >= len(before.members):
+			members = append(members, memberOutput{after: next})
+			j++
+		case compactEqual(before.members[i].value.text, next.value.text):
+			members = append(members, memberOutput{before: &before.members[i], after: next})
+			i++
+			j++
+		case find(before.members[i+1:], next.value.text):
+			i++
+		case find(after.members[j+1:], before.members[i].value.text):
+			members = append(members, memberOutput{after: next})
+			j++
+		default:
+			members = append(members, memberOutput{before: &before.members[i], after: next})
+			i++
+			j++
+		}
+	}
+	s.writeMembers(buf, before, members)
+}
+
+// memberOutput 要输出的成员，before 为空时是新增的成员
+type memberOutput struct {
+	before *jsonMember
+	after  *jsonMember
+}
+
+// writeMembers 输出容器，原有成员使用原来的空白，新增成员使用相邻成员的空白
+func (s jsonStyle) writeMembers(buf *bytes.Buffer, container *jsonNode, members []memberOutput) {
+	if len(members) == 0 {
+		buf.WriteByte(container.kind)
+		buf.WriteByte(container.kind + 2)
+		return
+	}
+
+	inline := container.inline
+	childIndent := append(append([]byte{}, container.indent...), s.unit...)
+
+	// 新增成员的空白模板
+	pre, sep := []byte("\n"+string(childIndent)), []byte(": ")
+	if inline {
+		pre, sep = nil, []byte(":")
+	}
+	if len(container.members) > 0 {
+		pre = container.members[len(container.members)-1].pre
+		sep = container.members[0].sep
+	}
+	closing := container.close
+	if len(container.members) == 0 && !inline {
+		closing = []byte("\n" + string(container.indent))
+	}
+
+	buf.WriteByte(container.kind)
+	for k, member := range members {
+		if k > 0 {
+			if prev := members[k-1].before; prev != nil {
+				buf.Write(prev.post)
+			}
+			buf.WriteByte(',')
+		}
+		if member.before != nil {
+			buf.Write(member.before.pre)
+		} else {
+			buf.Write(pre)
+		}
+		if container.kind == '{' {
+			if member.before != nil {
+				buf.Write(member.before.keyText)
+				buf.Write(member.before.sep)
+			} else {
+				buf.Write(member.after.keyText)
+				buf.Write(sep)
+			}
+		}
+		if member.before != nil {
+			s.merge(buf, member.before.value, member.after.value)
+		} else {
+			s.write(buf, member.after.value, childIndent, inline)
+		}
+	}
+	buf.Write(closing)
+	buf.WriteByte(container.kind + 2)
+}
+
+// write 输出新增的值
+// 参数:
+//   - node: 紧凑的值
+//   - indent: 值所在行的缩进
+//   - inline: 是否位于单行的容器中，此时输出为单行
+func (s jsonStyle) write(buf *bytes.Buffer, node *jsonNode, indent []byte, inline bool) {
+	if node.kind == 0 || (inline && !s.spaced) {
+		buf.Write(node.text)
+		return
+	}
+	if inline {
+		writeSpaced(buf, node.text)
+		return
+	}
+	// json.Indent 只在第一行之后添加 prefix，第一行的缩进已由调用方输出
+	json.Indent(buf, node.text, string(indent), string(s.unit))
+}
+
+// writeSpaced 输出单行的 JSON，在字符串之外的冒号和逗号后加空格
+func writeSpaced(buf *bytes.Buffer, compact []byte) {
+	inString, escaped := false, false
+	for _, c := range compact {
+		buf.WriteByte(c)
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case !inString && (c == ':' || c == ','):
+			buf.WriteByte(' ')
+		}
+	}
+}
+
+// compactEqual 比较两个 JSON 值去掉空白后是否相同
+func compactEqual(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	var x, y bytes.Buffer
+	if json.Compact(&x, a) != nil || json.Compact(&y, b) != nil {
+		return false
+	}
+	return bytes.Equal(x.Bytes(), y.Bytes())
+}
+
+// detectIndent 检测文件的缩进单位，取第一个有缩进的行，检测不到时为两个空格
+func detectIndent(data []byte) []byte {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		trimmed := bytes.TrimLeft(line, " \t")
+		if len(trimmed) == 0 || len(trimmed) == len(line) {
+			continue
+		}
+		return line[:len(line)-len(trimmed)]
+	}
+	return []byte("  ")
+}
+
+// jsonParser 解析 JSON 并记录每个值的原文和周围的空白，输入需要是有效的 JSON
+type jsonParser struct {
+	data []byte
+	pos  int
+	// spaced 是否遇到过冒号或逗号后有空格的单行对象或数组
+	spaced bool
+}
+
+func (p *jsonParser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+// lineIndent 返回 pos 所在行的缩进
+func (p *jsonParser) lineIndent(pos int) []byte {
+	start := bytes.LastIndexByte(p.data[:pos], '\n') + 1
+	end := start
+	for end < pos && (p.data[end] == ' ' || p.data[end] == '\t') {
+		end++
+	}
+	return p.data[start:end]
+}
+
+// parseValue 解析 pos 处的值
+// 参数:
+//   - inline: 所在的容器是否位于单行中
+func (p *jsonParser) parseValue(inline bool) (*jsonNode, error) {
+	if p.pos >= len(p.data) {
+		return nil, fmt.Errorf("unexpected end of JSON")
+	}
+	start := p.pos
+	node := &jsonNode{indent: p.lineIndent(start), inline: inline}
+	switch c := p.data[p.pos]; c {
+	case '{', '[':
+		node.kind = c
+		if err := p.parseMembers(node); err != nil {
+			return nil, err
+		}
+	case '"':
+		if err := p.skipString(); err != nil {
+			return nil, err
+		}
+	default:
+		for p.pos < len(p.data) && !bytes.ContainsRune([]byte(" \t\r\n,:]}"), rune(p.data[p.pos])) {
+			p.pos++
+		}
+		if p.pos == start {
+			return nil, fmt.Errorf("unexpected %q at offset %d", c, start)
+		}
+	}
+	node.text = p.data[start:p.pos]
+	if len(node.members) > 0 {
+		node.inline = !bytes.ContainsRune(node.text, '\n')
+		if node.inline && (bytes.HasSuffix(node.members[0].sep, []byte(" ")) ||
+			len(node.members) > 1 && len(node.members[1].pre) > 0) {
+			p.spaced = true
+		}
+		for _, member := range node.members {
+			if len(member.value.members) == 0 {
+				member.value.inline = node.inline
+			}
+		}
+	}
+	return node, nil
+}
+
+func (p *jsonParser) parseMembers(node *jsonNode) error {
+	end := node.kind + 2 // '}' 或 ']'
+	p.pos++
+	for {
+		spaceStart := p.pos
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return fmt.Errorf("unexpected end of JSON")
+		}
+		if p.data[p.pos] == end && len(node.members) == 0 {
+			node.close = p.data[spaceStart:p.pos]
+			p.pos++
+			return nil
+		}
+
+		member := jsonMember{pre: p.data[spaceStart:p.pos]}
+		if node.kind == '{' {
+			keyStart := p.pos
+			if err := p.skipString(); err != nil {
+				return err
+			}
+			member.keyText = p.data[keyStart:p.pos]
+			if err := json.Unmarshal(member.keyText, &member.key); err != nil {
+				return err
+			}
+			sepStart := p.pos
+			p.skipSpace()
+			if p.pos >= len(p.data) || p.data[p.pos] != ':' {
+				return fmt.Errorf("expected ':' at offset %d", p.pos)
+			}
+			p.pos++
+			p.skipSpace()
+			member.sep = p.data[sepStart:p.pos]
+		}
+
+		// 容器是否单行要解析完才知道，成员的 inline 在 parseValue 返回前设置
+		value, err := p.parseValue(false)
+		if err != nil {
+			return err
+		}
+		member.value = value
+		spaceStart = p.pos
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return fmt.Errorf("unexpected end of JSON")
+		}
+		switch p.data[p.pos] {
+		case ',':
+			member.post = p.data[spaceStart:p.pos]
+			node.members = append(node.members, member)
+			p.pos++
+		case end:
+			node.close = p.data[spaceStart:p.pos]
+			node.members = append(node.members, member)
+			p.pos++
+			return nil
+		default:
+			return fmt.Errorf("unexpected %q at offset %d", p.data[p.pos], p.pos)
+		}
+	}
+}
+
+func (p *jsonParser) skipString() error {
+	if p.pos >= len(p.data) || p.data[p.pos] != '"' {
+		return fmt.Errorf("expected string at offset %d", p.pos)
+	}
+	for p.pos++; p.pos < len(p.data); p.pos++ {
+		switch p.data[p.pos] {
+		case '\\':
+			p.pos++
+		case '"':
+			p.pos++
+			return nil
+		}
+	}
+	return fmt.Errorf("unterminated string")
+}
@@ -2,12 +2,15 @@ package localv2ray
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/metrics"
 )
 
 type V2RayConfig struct {
@@ -18,30 +21,45 @@ type V2RayConfig struct {
 	Inbounds  []InboundConfig  `json:"inbounds,omitempty"`
 	Outbounds []OutboundConfig `json:"outbounds,omitempty"`
 	Routing   RoutingConfig    `json:"routing,omitempty"`
+	// Observatory 探测出站连通性，供 leastPing 策略的负载均衡器剔除不可用的节点
+	Observatory *ObservatoryConfig `json:"observatory,omitempty"`
+
+	raw rawObject
+	// source 读取的文件原文，写回时按原文的格式输出
+	source []byte
 }
 
 type LogConfig struct {
 	Access   string `json:"access,omitempty"`
 	Error    string `json:"error,omitempty"`
 	LogLevel string `json:"loglevel,omitempty"`
+
+	raw rawObject
 }
 
 type StatsConfig struct {
+	raw rawObject
 }
 
 type APIConfig struct {
 	Tag      string   `json:"tag,omitempty"`
 	Services []string `json:"services,omitempty"`
+
+	raw rawObject
 }
 
 type PolicyConfig struct {
 	Levels map[string]LevelConfig `json:"levels,omitempty"`
 	System SystemPolicyConfig     `json:"system,omitempty"`
+
+	raw rawObject
 }
 
 type LevelConfig struct {
 	StatsUserUplink   bool `json:"statsUserUplink,omitempty"`
 	StatsUserDownlink bool `json:"statsUserDownlink,omitempty"`
+
+	raw rawObject
 }
 
 type SystemPolicyConfig struct {
@@ -49,6 +67,8 @@ type SystemPolicyConfig struct {
 	StatsInboundDownlink  bool `json:"statsInboundDownlink,omitempty"`
 	StatsOutboundUplink   bool `json:"statsOutboundUplink,omitempty"`
 	StatsOutboundDownlink bool `json:"statsOutboundDownlink,omitempty"`
+
+	raw rawObject
 }
 
 type InboundConfig struct {
@@ -57,22 +77,50 @@ type InboundConfig struct {
 	Protocol string                `json:"protocol,omitempty"`
 	Listen   string                `json:"listen,omitempty"`
 	Settings *VmessInboundSettings `json:"settings,omitempty"`
+
+	raw rawObject
 }
 
 type VmessInboundSettings struct {
 	Clients []VmessClientConfig `json:"clients,omitempty"`
+
+	raw rawObject
 }
 
 type VmessClientConfig struct {
 	AlterId int    `json:"alterId,omitempty"`
 	Email   string `json:"email,omitempty"`
 	ID      string `json:"id,omitempty"`
+
+	raw rawObject
 }
 
 type OutboundConfig struct {
 	Protocol string      `json:"protocol,omitempty"`
 	Tag      string      `json:"tag,omitempty"`
 	Settings interface{} `json:"settings,omitempty"`
+	// StreamSettings 传输设置，管理器只修改 REALITY 出站的 network、security 和 realitySettings
+	StreamSettings *StreamSettingsConfig `json:"streamSettings,omitempty"`
+
+	raw rawObject
+}
+
+type StreamSettingsConfig struct {
+	Network         string               `json:"network,omitempty"`
+	Security        string               `json:"security,omitempty"`
+	RealitySettings *RealityClientConfig `json:"realitySettings,omitempty"`
+
+	raw rawObject
+}
+
+// RealityClientConfig 出站的 REALITY 客户端设置，V2Ray v4 JSON 格式中只有 Xray 支持
+type RealityClientConfig struct {
+	ServerName  string `json:"serverName,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	PublicKey   string `json:"publicKey,omitempty"`
+	ShortID     string `json:"shortId,omitempty"`
+
+	raw rawObject
 }
 
 type RoutingConfig struct {
@@ -80,11 +128,30 @@ type RoutingConfig struct {
 	Balancers      []BalancerConfig `json:"balancers,omitempty"`
 	Strategy       string           `json:"strategy,omitempty"`
 	Rules          []RuleConfig     `json:"rules,omitempty"`
+
+	raw rawObject
 }
 
 type BalancerConfig struct {
-	Tag      string   `json:"tag,omitempty"`
-	Selector []string `json:"selector,omitempty"`
+	Tag      string                  `json:"tag,omitempty"`
+	Selector []string                `json:"selector,omitempty"`
+	Strategy *BalancerStrategyConfig `json:"strategy,omitempty"`
+
+	raw rawObject
+}
+
+type BalancerStrategyConfig struct {
+	Type string `json:"type,omitempty"`
+
+	raw rawObject
+}
+
+type ObservatoryConfig struct {
+	SubjectSelector []string `json:"subjectSelector,omitempty"`
+	ProbeURL        string   `json:"probeURL,omitempty"`
+	ProbeInterval   string   `json:"probeInterval,omitempty"`
+
+	raw rawObject
 }
 
 type RuleConfig struct {
@@ -96,181 +163,253 @@ type RuleConfig struct {
 	User        []string `json:"user,omitempty"`
 	BalancerTag string   `json:"balancerTag,omitempty"`
 	Network     string   `json:"network,omitempty"`
+
+	raw rawObject
 }
 
 type VNextConfig struct {
 	Address string       `json:"address,omitempty"`
 	Port    int          `json:"port,omitempty"`
 	Users   []UserConfig `json:"users,omitempty"`
+
+	raw rawObject
 }
 
 type UserConfig struct {
 	ID      string `json:"id,omitempty"`
 	AlterId int    `json:"alterId,omitempty"`
+	// Encryption、Flow VLESS 用户的加密方式和流控
+	Encryption string `json:"encryption,omitempty"`
+	Flow       string `json:"flow,omitempty"`
+
+	raw rawObject
 }
 
 type VmessOutboundSettings struct {
 	VNext []VNextConfig `json:"vnext,omitempty"`
+
+	raw rawObject
 }
 
+// apiTimeout 通过 V2Ray API 应用一次出站变更的超时时间
+const apiTimeout = 10 * time.Second
+
+// Options 管理本地 V2Ray 配置时使用的设置
+type Options struct {
+	// APIAddress 中转主机上 V2Ray API 的地址，为空时修改出站后重启服务
+	APIAddress string
+	// ReloadCommand 重启中转代理程序的命令，在中转主机上通过 shell 执行，为空时执行 sudo systemctl restart <服务名>
+	ReloadCommand string
+	// RelayInboundTag 放置各区域中转用户的 vmess 入站标签
+	RelayInboundTag string
+	// RelayPort 中转入站不存在时，新建入站监听的端口
+	RelayPort int
+	// BalancerStrategy 区域负载均衡器的策略，random 或 leastPing
+	BalancerStrategy string
+	// ProbeURL、ProbeInterval leastPing 策略下 observatory 探测出站使用的地址和间隔
+	ProbeURL      string
+	ProbeInterval string
+	// Binary 校验配置使用的可执行文件，为空时使用代理程序的默认名称
+	Binary string
+	// SkipConfigTest 为 true 时写入配置前不校验
+	SkipConfigTest bool
+	// BackupCount 保留的配置备份数量，为 0 时不备份
+	BackupCount int
+}
+
+// LocalV2RayManager 管理一台中转主机上的代理配置，通过 Driver 访问本机或远程主机，
+// 通过 Engine 读写 V2Ray、Xray 或 sing-box 的配置格式
 type LocalV2RayManager struct {
+	// name 中转名称
+	name       string
 	configPath string
+	engine     Engine
+	driver     Driver
+	// options 返回当前的设置，每次修改配置时调用
+	options func() Options
+
+	mu  sync.Mutex
+	api *APIClient
+
+	// writeMu 串行化本进程内对配置文件的读取-修改-写入，跨进程由配置文件旁的锁文件保证
+	writeMu sync.Mutex
 }
 
 // NewLocalV2RayManager 创建一个新的 LocalV2RayManager 实例
 // 参数:
-//   - configPath: 本地 V2Ray 配置文件路径
+//   - name: 中转名称，用于日志、变更和备份
+//   - configPath: 中转主机上的配置文件路径
+//   - engine: 中转使用的代理程序，为 nil 时使用 V2Ray
+//   - driver: 访问中转主机的方式，为 nil 时使用本机
+//   - options: 返回当前设置的函数，每次修改配置时调用，以便配置重新加载后使用新的设置；可以为 nil
 //
 // 返回值:
 //   - *LocalV2RayManager: 新创建的 LocalV2RayManager 实例
 //
 // 功能:
 //  1. 初始化 LocalV2RayManager 结构体
-//  2. 设置配置文件路径
-func NewLocalV2RayManager(configPath string) *LocalV2RayManager {
+//  2. 设置中转名称、配置文件路径、代理程序和访问方式
+func NewLocalV2RayManager(name, configPath string, engine Engine, driver Driver, options func() Options) *LocalV2RayManager {
+	if engine == nil {
+		engine = v2rayEngine{}
+	}
+	if driver == nil {
+		driver = NewLocalDriver()
+	}
 	return &LocalV2RayManager{
+		name:       name,
 		configPath: configPath,
+		engine:     engine,
+		driver:     driver,
+		options:    options,
 	}
 }
 
+// Name 返回中转名称
+func (m *LocalV2RayManager) Name() string {
+	return m.name
+}
+
+// Engine 返回中转使用的代理程序
+func (m *LocalV2RayManager) Engine() Engine {
+	return m.engine
+}
+
+// Close 关闭 V2Ray API 连接和 Driver 持有的连接
+func (m *LocalV2RayManager) Close() error {
+	m.mu.Lock()
+	if m.api != nil {
+		m.api.Close()
+		m.api = nil
+	}
+	m.mu.Unlock()
+	return m.driver.Close()
+}
+
+// currentOptions 返回当前的设置
+func (m *LocalV2RayManager) currentOptions() Options {
+	if m.options == nil {
+		return Options{}
+	}
+	return m.options()
+}
+
 // AddInstance 向本地 V2Ray 配置添加一个实例
 // 参数:
 //   - ctx: 上下文，用于传递请求范围的值和取消信号
-//   - instanceTag: 实例标签
+//   - region: AWS 区域
 //   - address: 实例地址
 //   - port: 实例端口
 //   - uuid: 实例 UUID
+//   - reality: 实例使用 VLESS + REALITY 时的连接参数，为 nil 时实例使用 vmess
 //
 // 返回值:
-//   - error: 错误信息，如果添加失败
+//   - error: 错误信息，如果添加失败，代理程序不支持 REALITY 时为 ErrRealityUnsupported
 //
 // 功能:
-//  1. 读取当前 V2Ray 配置
-//  2. 创建新的出站配置
+//  1. 锁定配置文件后读取当前 V2Ray 配置，并发的添加和删除依次执行，不会丢失彼此的变更
+//  2. 创建实例的出站配置，标签为 InstanceTag(region, uuid)
 //  3. 检查是否已存在相同标签的出站配置
-//  4. 如果存在，更新配置；如果不存在，添加新配置
-//  5. 写回配置文件
-//  6. 重启 V2Ray 服务
-func (m *LocalV2RayManager) AddInstance(ctx context.Context, instanceTag, address string, port int, uuid string) error {
+//  4. 如果存在，只更新地址、端口和 UUID，保留其他字段；如果不存在，添加新配置
+//  5. 区域还没有中转用户时，在中转入站中创建用户，中转入站不存在时一并创建
+//  6. 确保区域用户经区域负载均衡器路由到该区域的实例出站，同一区域的多个实例分担流量
+//  7. 写回配置文件，保证服务重启后仍然生效
+//  8. 通过 API 在运行中的服务上替换该出站、添加用户，API 不可用、代理程序没有 HandlerService、
+//     新建了入站或修改了路由时重启服务
+func (m *LocalV2RayManager) AddInstance(ctx context.Context, region, address string, port int, uuid string, reality *Reality) error {
+	if reality != nil && !m.engine.SupportsReality() {
+		return fmt.Errorf("%w: relay %s uses %s", ErrRealityUnsupported, m.name, m.engine.Name())
+	}
+
+	unlock, err := m.lockConfig(ctx)
+	if err != nil {
+		logging.Error(ctx, "Failed to lock V2Ray config on relay %s: %v", m.name, err)
+		return err
+	}
+	defer unlock()
+
 	// Read current config
-	config, err := m.ReadConfig()
+	config, err := m.readConfig()
 	if err != nil {
 		logging.Error(ctx, "Failed to read local V2Ray config: %v", err)
 		return fmt.Errorf("failed to read local V2Ray config: %v", err)
 	}
 
-	// Create new outbound
-	newOutbound := OutboundConfig{
-		Protocol: "vmess",
-		Tag:      instanceTag,
-		Settings: VmessOutboundSettings{
-			VNext: []VNextConfig{
-				{
-					Address: address,
-					Port:    port,
-					Users: []UserConfig{
-						{
-							ID:      uuid,
-							AlterId: 0,
-						},
-					},
-				},
-			},
-		},
-	}
+	// Create the outbound, or update it in place if it already exists
+	instanceTag := InstanceTag(region, uuid)
+	var changes runtimeChanges
+	config.upsertOutbound(RelayOutbound{Tag: instanceTag, Region: region, Address: address, Port: port, UUID: uuid, Reality: reality}, &changes)
 
-	// Check if outbound already exists
-	found := false
-	for i, outbound := range config.Outbounds {
-		if outbound.Tag == instanceTag {
-			config.Outbounds[i] = newOutbound
-			found = true
-			break
-		}
-	}
-
-	// Add new outbound if not exists
-	if !found {
-		config.Outbounds = append(config.Outbounds, newOutbound)
+	// Provision the region's relay user and route it through the region's balancer
+	options := m.currentOptions()
+	if err := config.ensureUser(region, options, &changes); err != nil {
+		logging.Error(ctx, "Failed to provision relay user for %s: %v", region, err)
+		return err
 	}
+	config.ensureRoute(region, options, &changes)
 
 	// Write config back
-	if err := m.WriteConfig(config); err != nil {
+	if err := m.writeConfig(ctx, config); err != nil {
 		logging.Error(ctx, "Failed to write local V2Ray config: %v", err)
 		return fmt.Errorf("failed to write local V2Ray config: %v", err)
 	}
 
-	// Restart V2Ray service
-	if err := m.RestartService(ctx); err != nil {
-		logging.Error(ctx, "Failed to restart V2Ray service: %v", err)
-		// Continue even if service restart fails
-	}
+	// Apply to the running service, restarting it if the API is unavailable
+	m.apply(ctx, changes)
 
 	logging.Info(ctx, "Added V2Ray instance %s to local config", instanceTag)
 	return nil
 }
 
-// ReadConfig 读取本地 V2Ray 配置文件
+// readConfig 读取中转的配置文件
 // 返回值:
-//   - *V2RayConfig: 解析后的 V2Ray 配置
+//   - document: 按代理程序的格式解析后的配置
 //   - error: 错误信息，如果读取或解析失败
-//
-// 功能:
-//  1. 读取配置文件内容
-//  2. 解析 JSON 格式的配置
-//  3. 返回解析后的配置对象
-func (m *LocalV2RayManager) ReadConfig() (*V2RayConfig, error) {
-	data, err := os.ReadFile(m.configPath)
+func (m *LocalV2RayManager) readConfig() (document, error) {
+	data, err := m.driver.ReadFile(m.configPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %v", err)
 	}
 
-	var config V2RayConfig
-	if err := json.Unmarshal(data, &config); err != nil {
+	config, err := m.engine.parse(data)
+	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %v", err)
 	}
-
-	return &config, nil
+	return config, nil
 }
 
-// WriteConfig 写入本地 V2Ray 配置文件
+// writeConfig 写入中转的配置文件，调用方需要持有 lockConfig 返回的锁
 // 参数:
-//   - config: 要写入的 V2Ray 配置
+//   - ctx: 上下文，用于传递取消信号
+//   - config: 要写入的配置
 //
 // 返回值:
-//   - error: 错误信息，如果写入失败
+//   - error: 错误信息，如果序列化、校验或写入失败，此时配置文件保持不变
 //
 // 功能:
-//  1. 将配置对象序列化为 JSON 格式
-//  2. 创建配置文件的备份
-//  3. 写入新的配置文件
-//  4. 如果写入失败，恢复备份
-//  5. 如果写入成功，删除备份
-func (m *LocalV2RayManager) WriteConfig(config *V2RayConfig) error {
-	data, err := json.MarshalIndent(config, "", "  ")
+//  1. 按原文件的格式序列化配置，未建模和未修改的部分逐字节不变，新增的部分使用原文件的缩进
+//  2. 通过临时文件校验并原子地替换配置文件，替换前备份当前配置
+func (m *LocalV2RayManager) writeConfig(ctx context.Context, config document) error {
+	data, err := config.marshal()
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %v", err)
 	}
+	return m.writeFile(ctx, data, nil)
+}
 
-	// Create backup
-	backupPath := m.configPath + ".bak"
-	if err := os.Rename(m.configPath, backupPath); err != nil {
-		return fmt.Errorf("failed to create backup: %v", err)
-	}
-
-	// Write new config
-	if err := os.WriteFile(m.configPath, data, 0644); err != nil {
-		// Restore backup
-		os.Rename(backupPath, m.configPath)
-		return fmt.Errorf("failed to write config: %v", err)
+// marshalIndented 将配置序列化为 JSON，不转义 HTML 字符，格式见 preserveFormat
+// 参数:
+//   - config: 配置结构体
+//   - source: 读取的文件原文，为空时按两个空格缩进输出
+func marshalIndented(config interface{}, source []byte) ([]byte, error) {
+	data, err := encodeJSON(config)
+	if err != nil {
+		return nil, err
 	}
-
-	// Remove backup
-	os.Remove(backupPath)
-	return nil
+	return preserveFormat(source, data)
 }
 
-// RestartService 重启本地 V2Ray 服务
+// RestartService 重启中转的代理服务
 // 参数:
 //   - ctx: 上下文，用于传递请求范围的值和取消信号
 //
@@ -278,42 +417,133 @@ func (m *LocalV2RayManager) WriteConfig(config *V2RayConfig) error {
 //   - error: 错误信息，如果重启失败
 //
 // 功能:
-//  1. 执行系统命令重启 V2Ray 服务
+//  1. 在中转主机上执行 ReloadCommand，未设置时执行 sudo systemctl restart <代理程序的服务名>
 //  2. 记录重启过程和结果
 //  3. 返回重启操作的错误信息
 func (m *LocalV2RayManager) RestartService(ctx context.Context) error {
-	logging.Info(ctx, "Restarting V2Ray service...")
+	service := m.engine.ServiceName()
+	logging.Info(ctx, "Restarting %s service on relay %s...", service, m.name)
 
-	cmd := exec.Command("sudo", "systemctl", "restart", "v2ray")
-	output, err := cmd.CombinedOutput()
+	command := m.currentOptions().ReloadCommand
+	if command == "" {
+		command = "sudo systemctl restart " + service
+	}
+	output, err := m.driver.Run(ctx, command)
+	metrics.IncV2RayRestart(err)
 	if err != nil {
-		logging.Error(ctx, "Failed to restart V2Ray service: %v, output: %s", err, string(output))
-		return fmt.Errorf("failed to restart V2Ray service: %v", err)
+		logging.Error(ctx, "Failed to restart %s service on relay %s: %v, output: %s", service, m.name, err, string(output))
+		return fmt.Errorf("failed to restart %s service on relay %s: %v", service, m.name, err)
 	}
 
-	logging.Info(ctx, "V2Ray service restarted successfully")
+	logging.Info(ctx, "%s service on relay %s restarted successfully", service, m.name)
 	return nil
 }
 
-// ReloadConfig 重新加载本地 V2Ray 配置
+// runtimeChanges 已写入配置文件、需要应用到运行中 V2Ray 的变更
+type runtimeChanges struct {
+	// upserts 新增或修改的出站
+	upserts []OutboundConfig
+	// removals 删除的出站标签
+	removals []string
+	// addUsers 中转入站中新增的用户
+	addUsers []inboundUser
+	// removeUsers 中转入站中删除的用户
+	removeUsers []inboundUser
+	// restart 有 HandlerService 不能应用的变更，例如路由规则或新建的入站，需要重启服务
+	restart bool
+}
+
+// empty 返回是否没有需要应用的变更
+func (c runtimeChanges) empty() bool {
+	return len(c.upserts) == 0 && len(c.removals) == 0 && len(c.addUsers) == 0 && len(c.removeUsers) == 0 && !c.restart
+}
+
+// apply 将已写入配置文件的变更应用到运行中的 V2Ray
 // 参数:
 //   - ctx: 上下文，用于传递请求范围的值和取消信号
+//   - changes: 需要应用的变更
 //
-// 返回值:
-//   - error: 错误信息，如果重新加载失败
-//
 // 功能:
-//  1. 记录配置已更新的信息
-//  2. 提示需要重新加载 V2Ray 服务
-//  3. 注意：在生产环境中，应该使用 V2Ray API 来重新加载配置
-func (m *LocalV2RayManager) ReloadConfig(ctx context.Context) error {
-	// In production, you would use V2Ray API to reload config
-	// For now, we'll just log that a reload is needed
-	logging.Info(ctx, "Local V2Ray config updated. Please reload V2Ray service.")
+//  1. 配置了 API 且代理程序提供 HandlerService 时逐个替换和删除出站、添加和删除入站用户，不中断其他连接
+//  2. 未配置 API、代理程序没有 HandlerService（sing-box）、需要重启或任一调用失败时重启服务，由服务重新读取配置文件
+//  3. 没有变更时不做任何操作
+func (m *LocalV2RayManager) apply(ctx context.Context, changes runtimeChanges) {
+	if changes.empty() {
+		return
+	}
+
+	api := m.apiClient()
+	if api == nil || changes.restart || !m.engine.api().handler {
+		if err := m.RestartService(ctx); err != nil {
+			logging.Error(ctx, "Failed to restart V2Ray service: %v", err)
+		}
+		return
+	}
+
+	err := hotApply(ctx, api, changes)
+	metrics.IncV2RayHotApply(err)
+	if err == nil {
+		logging.Info(ctx, "Applied %d outbound and %d user changes through the V2Ray API",
+			len(changes.upserts)+len(changes.removals), len(changes.addUsers)+len(changes.removeUsers))
+		return
+	}
+
+	logging.Warn(ctx, "Failed to apply changes through the V2Ray API, restarting V2Ray: %v", err)
+	if err := m.RestartService(ctx); err != nil {
+		logging.Error(ctx, "Failed to restart V2Ray service: %v", err)
+	}
+}
+
+// hotApply 通过 HandlerService 修改运行中的出站和入站用户
+// 功能:
+//  1. 先删除用户和出站，再添加出站和用户
+//  2. 修改已有出站时先删除再添加，出站不存在时删除返回的错误被忽略
+//  3. 遇到第一个错误时停止，剩余的变更由调用方重启服务后从配置文件加载
+func hotApply(ctx context.Context, api *APIClient, changes runtimeChanges) error {
+	ctx, cancel := context.WithTimeout(ctx, apiTimeout)
+	defer cancel()
+
+	for _, user := range changes.removeUsers {
+		if err := api.RemoveInboundUser(ctx, user.inboundTag, user.client.Email); err != nil {
+			return err
+		}
+	}
+	for _, tag := range changes.removals {
+		if err := api.RemoveOutbound(ctx, tag); err != nil {
+			return err
+		}
+	}
+	for _, outbound := range changes.upserts {
+		api.RemoveOutbound(ctx, outbound.Tag)
+		if err := api.AddOutbound(ctx, outbound); err != nil {
+			return err
+		}
+	}
+	for _, user := range changes.addUsers {
+		if err := api.AddInboundUser(ctx, user.inboundTag, user.client); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// GetRelayConfig 获取本地 V2Ray 的中转配置
+// apiClient 返回当前配置地址的 V2Ray API 客户端，未配置时返回 nil
+func (m *LocalV2RayManager) apiClient() *APIClient {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	address := m.currentOptions().APIAddress
+	if m.api != nil && m.api.Address() != address {
+		m.api.Close()
+		m.api = nil
+	}
+	if m.api == nil && address != "" {
+		m.api = NewAPIClientWithDialer(address, m.engine, m.driver.DialContext)
+	}
+	return m.api
+}
+
+// GetRelayConfig 获取中转上区域用户的连接信息
 // 参数:
 //   - region: AWS 区域名称
 //
@@ -323,27 +553,787 @@ func (m *LocalV2RayManager) ReloadConfig(ctx context.Context) error {
 //   - error: 错误信息
 //
 // 功能:
-//  1. 读取本地 V2Ray 配置文件
-//  2. 查找 protocol="vmess" 的 inbound
-//  3. 匹配 email 为 "user_aws_"+region 的 client
-//  4. 返回找到的端口和 UUID
+//  1. 读取中转的配置文件
+//  2. 查找 vmess 入站中名称（V2Ray、Xray 为 email，sing-box 为 name）为 "user_aws_"+region 的用户
+//  3. 返回找到的入站端口和用户 UUID
 func (m *LocalV2RayManager) GetRelayConfig(region string) (int, string, error) {
-	config, err := m.ReadConfig()
+	config, err := m.readConfig()
 	if err != nil {
 		return 0, "", fmt.Errorf("failed to read config: %v", err)
 	}
+	return config.relayConfig(region)
+}
+
+// instanceTagPrefix AWS 实例中转出站的标签前缀
+const instanceTagPrefix = "out_aws_"
+
+// balancerTagPrefix 各区域负载均衡器的标签前缀
+const balancerTagPrefix = "balancer_aws_"
+
+// relayUserPrefix 中转入站中各区域用户 email 的前缀
+const relayUserPrefix = "user_aws_"
+
+// realityFlow REALITY 出站使用的 VLESS 流控
+const realityFlow = "xtls-rprx-vision"
+
+// 负载均衡策略
+const (
+	BalancerRandom    = "random"
+	BalancerLeastPing = "leastPing"
+)
+
+// 中转出站变更类型
+const (
+	RelayAdd    = "add"
+	RelayUpdate = "update"
+	RelayRemove = "remove"
+	// RelayAddRule 添加或修复将区域用户路由到区域负载均衡器的规则和负载均衡器
+	RelayAddRule = "add_rule"
+	// RelayRemoveRule 删除已没有运行中实例的区域的路由规则和负载均衡器
+	RelayRemoveRule = "remove_rule"
+	// RelayAddUser 在中转入站中添加区域用户
+	RelayAddUser = "add_user"
+	// RelayRemoveUser 删除已没有运行中实例的区域用户
+	RelayRemoveUser = "remove_user"
+)
+
+// RelayOutbound 指向 AWS 实例的中转出站
+type RelayOutbound struct {
+	// Relay 出站所在的中转
+	Relay   string `json:"relay,omitempty"`
+	Tag     string `json:"tag"`
+	Region  string `json:"region"`
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	UUID    string `json:"uuid"`
+	// Reality 实例使用 VLESS + REALITY 时的连接参数，为 nil 时为 vmess 出站
+	Reality *Reality `json:"reality,omitempty"`
+}
+
+// Reality 连接 VLESS + REALITY 实例的客户端参数
+type Reality struct {
+	PublicKey   string `json:"public_key"`
+	ShortID     string `json:"short_id"`
+	ServerName  string `json:"server_name"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// ErrRealityUnsupported 中转的代理程序不支持 REALITY 出站，V2Ray 没有实现 REALITY
+var ErrRealityUnsupported = errors.New("relay engine does not support REALITY")
+
+// sameRelayOutbound 返回两个中转出站的地址、端口、UUID 和 REALITY 参数是否相同
+func sameRelayOutbound(a, b RelayOutbound) bool {
+	ra, rb := a.Reality, b.Reality
+	a.Reality, b.Reality = nil, nil
+	if a != b || (ra == nil) != (rb == nil) {
+		return false
+	}
+	return ra == nil || *ra == *rb
+}
+
+// RelayChange 中转配置的一项变更
+type RelayChange struct {
+	// Relay 变更所在的中转
+	Relay  string `json:"relay,omitempty"`
+	Action string `json:"action"`
+	// Tag 出站变更为实例的出站标签，区域级的变更（路由、用户）为区域的出站标签前缀
+	Tag     string         `json:"tag"`
+	Region  string         `json:"region"`
+	Current *RelayOutbound `json:"current,omitempty"`
+	Desired *RelayOutbound `json:"desired,omitempty"`
+}
+
+// RelayRoute 本地配置中一个区域的中转路由
+type RelayRoute struct {
+	Region string `json:"region"`
+	// Complete 负载均衡器和路由规则都存在且与当前设置一致
+	Complete bool `json:"complete"`
+}
+
+// RegionTag 返回区域的中转出站标签前缀，也是旧版每个区域一个出站时的出站标签
+// 参数:
+//   - region: AWS 区域，例如 "ap-east-1"
+//
+// 返回值:
+//   - string: 标签，例如 "out_aws_ap_east_1"
+func RegionTag(region string) string {
+	return instanceTagPrefix + strings.ReplaceAll(region, "-", "_")
+}
+
+// InstanceTag 返回实例的中转出站标签
+// 参数:
+//   - region: AWS 区域，例如 "ap-east-1"
+//   - uuid: 实例 UUID
+//
+// 返回值:
+//   - string: 出站标签，例如 "out_aws_ap_east_1-<uuid>"，区域的负载均衡器按 "out_aws_ap_east_1-" 前缀选择出站
+func InstanceTag(region, uuid string) string {
+	return RegionTag(region) + "-" + uuid
+}
+
+// BalancerTag 返回区域的负载均衡器标签
+// 参数:
+//   - region: AWS 区域，例如 "ap-east-1"
+//
+// 返回值:
+//   - string: 负载均衡器标签，例如 "balancer_aws_ap_east_1"
+func BalancerTag(region string) string {
+	return balancerTagPrefix + strings.ReplaceAll(region, "-", "_")
+}
+
+// ParseRelayTag 解析中转出站标签
+// 参数:
+//   - tag: 出站标签
+//
+// 返回值:
+//   - string: AWS 区域，AWS 区域代码中不含下划线
+//   - string: 实例 UUID，旧版每个区域一个出站的标签为空
+//   - bool: 是否为中转出站标签
+func ParseRelayTag(tag string) (string, string, bool) {
+	rest := strings.TrimPrefix(tag, instanceTagPrefix)
+	if rest == tag || rest == "" {
+		return "", "", false
+	}
+	regionPart, uuid, _ := strings.Cut(rest, "-")
+	return strings.ReplaceAll(regionPart, "_", "-"), uuid, true
+}
+
+// RelayUser 返回区域在中转入站中的用户 email
+// 参数:
+//   - region: AWS 区域，例如 "ap-east-1"
+//
+// 返回值:
+//   - string: 用户 email，例如 "user_aws_ap-east-1"
+func RelayUser(region string) string {
+	return relayUserPrefix + region
+}
+
+// RelayRegions 返回中转出站所在的区域
+// 参数:
+//   - relays: 中转出站
+//
+// 返回值:
+//   - []string: 去重并排序后的区域
+func RelayRegions(relays []RelayOutbound) []string {
+	seen := make(map[string]bool)
+	var regions []string
+	for _, relay := range relays {
+		if !seen[relay.Region] {
+			seen[relay.Region] = true
+			regions = append(regions, relay.Region)
+		}
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+// balancerRegion 从负载均衡器标签还原 AWS 区域
+func balancerRegion(tag string) (string, bool) {
+	rest := strings.TrimPrefix(tag, balancerTagPrefix)
+	if rest == tag || rest == "" {
+		return "", false
+	}
+	return strings.ReplaceAll(rest, "_", "-"), true
+}
+
+// ruleRegion 返回中转路由规则所属的区域
+// 功能:
+//  1. 指向区域负载均衡器的规则，以及旧版直接指向中转出站的规则都属于中转路由
+func ruleRegion(rule RuleConfig) (string, bool) {
+	if region, ok := balancerRegion(rule.BalancerTag); ok {
+		return region, true
+	}
+	if region, _, ok := ParseRelayTag(rule.OutboundTag); ok {
+		return region, true
+	}
+	return "", false
+}
+
+// newRelayRule 创建将区域用户路由到区域负载均衡器的规则
+func newRelayRule(region string) RuleConfig {
+	return RuleConfig{
+		Type:        "field",
+		User:        []string{RelayUser(region)},
+		BalancerTag: BalancerTag(region),
+	}
+}
+
+// ListRelayRoutes 读取中转配置中各区域的中转路由
+// 返回值:
+//   - []RelayRoute: 按区域排序，包括只有部分规则或负载均衡器、以及旧版直接指向出站的区域
+//   - error: 错误信息，如果读取配置失败
+func (m *LocalV2RayManager) ListRelayRoutes() ([]RelayRoute, error) {
+	config, err := m.readConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.relayRoutes(m.currentOptions()), nil
+}
+
+// relayRoutes 返回各区域的中转路由
+func (d *v4Document) relayRoutes(options Options) []RelayRoute {
+	config := d.config
+
+	present := make(map[string]bool)
+	for _, balancer := range config.Routing.Balancers {
+		if region, ok := balancerRegion(balancer.Tag); ok {
+			present[region] = true
+		}
+	}
+	for _, rule := range config.Routing.Rules {
+		if region, ok := ruleRegion(rule); ok {
+			present[region] = true
+		}
+	}
+
+	routes := make([]RelayRoute, 0, len(present))
+	for region := range present {
+		routes = append(routes, RelayRoute{Region: region, Complete: relayRouteComplete(config, region, options)})
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Region < routes[j].Region })
+	return routes
+}
+
+// DiffRelayRoutes 计算使中转路由与期望的区域一致所需的变更
+// 参数:
+//   - routes: 本地配置中现有的中转路由
+//   - regions: 有运行中实例的区域
+//
+// 返回值:
+//   - []RelayChange: 按标签排序的变更，期望的区域路由不完整时添加或修复，不再需要的区域删除
+func DiffRelayRoutes(routes []RelayRoute, regions []string) []RelayChange {
+	current := make(map[string]RelayRoute, len(routes))
+	for _, route := range routes {
+		current[route.Region] = route
+	}
+	wanted := make(map[string]bool, len(regions))
+
+	var changes []RelayChange
+	for _, region := range regions {
+		wanted[region] = true
+		if !current[region].Complete {
+			changes = append(changes, RelayChange{Action: RelayAddRule, Tag: RegionTag(region), Region: region})
+		}
+	}
+	for _, route := range routes {
+		if !wanted[route.Region] {
+			changes = append(changes, RelayChange{Action: RelayRemoveRule, Tag: RegionTag(route.Region), Region: route.Region})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Tag < changes[j].Tag })
+	return changes
+}
+
+// relayRouteComplete 返回区域的负载均衡器、路由规则和探测配置是否都与当前设置一致
+func relayRouteComplete(config *V2RayConfig, region string, options Options) bool {
+	balancer := findBalancer(config, BalancerTag(region))
+	if balancer == nil || !balancerMatches(*balancer, region, options) {
+		return false
+	}
+	if options.BalancerStrategy == BalancerLeastPing && !observatoryMatches(config.Observatory, options) {
+		return false
+	}
+
+	routed := false
+	for _, rule := range config.Routing.Rules {
+		if rule.BalancerTag == BalancerTag(region) {
+			routed = true
+		} else if owner, ok := ruleRegion(rule); ok && owner == region {
+			// Legacy rule pointing at a single outbound
+			return false
+		}
+	}
+	return routed
+}
+
+// ensureRelayRoute 确保区域用户经区域负载均衡器路由到该区域的实例出站
+// 参数:
+//   - config: 本地 V2Ray 配置
+//   - region: AWS 区域
+//   - options: 负载均衡策略和探测设置
+//
+// 返回值:
+//   - bool: 是否修改了路由或探测配置，修改后需要重启 V2Ray
+//
+// 功能:
+//  1. 创建或修正负载均衡器，按 "out_aws_<region>-" 前缀选择出站，新增的实例出站无需修改负载均衡器
+//  2. 旧版直接指向出站的规则改为指向负载均衡器，保留规则的其他字段
+//  3. 没有指向负载均衡器的规则时添加一条，放在最后一条中转规则之后，没有中转规则时放在最前面，避免被其他兜底规则覆盖
+//  4. 策略为 leastPing 时确保 observatory 探测所有中转出站，探测失败的节点不再被选择
+func ensureRelayRoute(config *V2RayConfig, region string, options Options) bool {
+	changed := false
+
+	balancer := findBalancer(config, BalancerTag(region))
+	if balancer == nil {
+		config.Routing.Balancers = append(config.Routing.Balancers, BalancerConfig{Tag: BalancerTag(region)})
+		balancer = &config.Routing.Balancers[len(config.Routing.Balancers)-1]
+	}
+	if !balancerMatches(*balancer, region, options) {
+		balancer.Selector = []string{RegionTag(region) + "-"}
+		if options.BalancerStrategy == "" {
+			balancer.Strategy = nil
+		} else {
+			if balancer.Strategy == nil {
+				balancer.Strategy = &BalancerStrategyConfig{}
+			}
+			balancer.Strategy.Type = options.BalancerStrategy
+		}
+		changed = true
+	}
+
+	if options.BalancerStrategy == BalancerLeastPing && !observatoryMatches(config.Observatory, options) {
+		if config.Observatory == nil {
+			config.Observatory = &ObservatoryConfig{}
+		}
+		if !containsString(config.Observatory.SubjectSelector, instanceTagPrefix) {
+			config.Observatory.SubjectSelector = append(config.Observatory.SubjectSelector, instanceTagPrefix)
+		}
+		if options.ProbeURL != "" {
+			config.Observatory.ProbeURL = options.ProbeURL
+		}
+		if options.ProbeInterval != "" {
+			config.Observatory.ProbeInterval = options.ProbeInterval
+		}
+		changed = true
+	}
+
+	routed := false
+	insertAt := 0
+	for i := range config.Routing.Rules {
+		rule := &config.Routing.Rules[i]
+		owner, ok := ruleRegion(*rule)
+		if !ok {
+			continue
+		}
+		insertAt = i + 1
+		if owner != region {
+			continue
+		}
+		if rule.BalancerTag != BalancerTag(region) {
+			rule.OutboundTag = ""
+			rule.BalancerTag = BalancerTag(region)
+			changed = true
+		}
+		routed = true
+	}
+	if routed {
+		return changed
+	}
+
+	rules := make([]RuleConfig, 0, len(config.Routing.Rules)+1)
+	rules = append(rules, config.Routing.Rules[:insertAt]...)
+	rules = append(rules, newRelayRule(region))
+	rules = append(rules, config.Routing.Rules[insertAt:]...)
+	config.Routing.Rules = rules
+	return true
+}
+
+// removeRelayRoute 从配置中删除区域的路由规则和负载均衡器
+// 功能:
+//  1. observatory 由所有区域共用，保留不变
+//
+// 返回值:
+//   - bool: 是否删除了规则或负载均衡器
+func removeRelayRoute(config *V2RayConfig, region string) bool {
+	removed := false
+
+	rules := config.Routing.Rules[:0]
+	for _, rule := range config.Routing.Rules {
+		if owner, ok := ruleRegion(rule); ok && owner == region {
+			removed = true
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	config.Routing.Rules = rules
+
+	balancers := config.Routing.Balancers[:0]
+	for _, balancer := range config.Routing.Balancers {
+		if balancer.Tag == BalancerTag(region) {
+			removed = true
+			continue
+		}
+		balancers = append(balancers, balancer)
+	}
+	config.Routing.Balancers = balancers
+	return removed
+}
+
+// findBalancer 返回指定标签的负载均衡器，不存在时返回 nil
+func findBalancer(config *V2RayConfig, tag string) *BalancerConfig {
+	for i := range config.Routing.Balancers {
+		if config.Routing.Balancers[i].Tag == tag {
+			return &config.Routing.Balancers[i]
+		}
+	}
+	return nil
+}
+
+// balancerMatches 返回负载均衡器的选择器和策略是否与当前设置一致
+func balancerMatches(balancer BalancerConfig, region string, options Options) bool {
+	if len(balancer.Selector) != 1 || balancer.Selector[0] != RegionTag(region)+"-" {
+		return false
+	}
+	strategy := ""
+	if balancer.Strategy != nil {
+		strategy = balancer.Strategy.Type
+	}
+	return strategy == options.BalancerStrategy
+}
+
+// observatoryMatches 返回 observatory 是否探测所有中转出站，且探测设置与当前设置一致
+func observatoryMatches(observatory *ObservatoryConfig, options Options) bool {
+	if observatory == nil || !containsString(observatory.SubjectSelector, instanceTagPrefix) {
+		return false
+	}
+	if options.ProbeURL != "" && observatory.ProbeURL != options.ProbeURL {
+		return false
+	}
+	if options.ProbeInterval != "" && observatory.ProbeInterval != options.ProbeInterval {
+		return false
+	}
+	return true
+}
+
+// containsString 返回切片中是否包含指定字符串
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// removeRelayOutbound 从配置中删除中转出站，以及旧版直接指向它的路由规则
+// 返回值:
+//   - bool: 是否删除了出站
+func removeRelayOutbound(config *V2RayConfig, tag string) bool {
+	outboundRemoved := false
+	outbounds := config.Outbounds[:0]
+	for _, outbound := range config.Outbounds {
+		if outbound.Tag == tag {
+			outboundRemoved = true
+			continue
+		}
+		outbounds = append(outbounds, outbound)
+	}
+	config.Outbounds = outbounds
+
+	rules := config.Routing.Rules[:0]
+	for _, rule := range config.Routing.Rules {
+		if rule.OutboundTag != tag {
+			rules = append(rules, rule)
+		}
+	}
+	config.Routing.Rules = rules
+	return outboundRemoved
+}
+
+// hasRegionOutbounds 返回配置中是否还有该区域的中转出站
+func hasRegionOutbounds(config *V2RayConfig, region string) bool {
+	for _, outbound := range config.Outbounds {
+		if outboundRegion, _, ok := ParseRelayTag(outbound.Tag); ok && outboundRegion == region {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveInstance 从本地 V2Ray 配置删除一个实例的中转出站
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值和取消信号
+//   - region: AWS 区域
+//   - uuid: 实例 UUID
+//
+// 返回值:
+//   - error: 错误信息，如果读取或写入配置失败
+//
+// 功能:
+//  1. 锁定配置文件后删除实例的出站，以及旧版指向该实例的区域出站
+//  2. 区域已没有其他中转出站时，同时删除区域的路由规则、负载均衡器和中转用户
+//  3. 没有需要删除的内容时不做任何操作
+//  4. 写回配置文件，并通过 API 在运行中的服务上删除出站和用户，API 不可用时重启服务；
+//     V2Ray 和 Xray 运行中的路由规则在下次重启前仍然存在，但已没有可选的出站，不影响其他区域
+func (m *LocalV2RayManager) RemoveInstance(ctx context.Context, region, uuid string) error {
+	unlock, err := m.lockConfig(ctx)
+	if err != nil {
+		logging.Error(ctx, "Failed to lock V2Ray config on relay %s: %v", m.name, err)
+		return err
+	}
+	defer unlock()
+
+	config, err := m.readConfig()
+	if err != nil {
+		logging.Error(ctx, "Failed to read local V2Ray config: %v", err)
+		return fmt.Errorf("failed to read local V2Ray config: %v", err)
+	}
+	relays, err := config.relayOutbounds()
+	if err != nil {
+		return err
+	}
+
+	var changes runtimeChanges
+	removed := false
+	for _, relay := range relays {
+		legacy := relay.Tag == RegionTag(region) && relay.UUID == uuid
+		if relay.Tag != InstanceTag(region, uuid) && !legacy {
+			continue
+		}
+		if config.removeOutbound(relay.Tag, &changes) {
+			removed = true
+		}
+	}
+
+	if !config.hasRegionOutbounds(region) {
+		if config.removeRoute(region, &changes) {
+			removed = true
+		}
+		config.removeUser(region, &changes)
+	}
+	if !removed && changes.empty() {
+		return nil
+	}
+
+	if err := m.writeConfig(ctx, config); err != nil {
+		logging.Error(ctx, "Failed to write local V2Ray config: %v", err)
+		return fmt.Errorf("failed to write local V2Ray config: %v", err)
+	}
+
+	m.apply(ctx, changes)
+
+	logging.Info(ctx, "Removed V2Ray instance %s from local config", InstanceTag(region, uuid))
+	return nil
+}
+
+// ListRelayOutbounds 读取中转配置中所有指向 AWS 实例的中转出站
+// 返回值:
+//   - []RelayOutbound: 按配置中的顺序排列的中转出站，Relay 为中转名称
+//   - error: 错误信息，如果读取配置失败
+func (m *LocalV2RayManager) ListRelayOutbounds() ([]RelayOutbound, error) {
+	config, err := m.readConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	relays, err := config.relayOutbounds()
+	if err != nil {
+		return nil, err
+	}
+	for i := range relays {
+		relays[i].Relay = m.name
+	}
+	return relays, nil
+}
+
+// relayOutbound 从中转出站配置读取地址、端口、UUID 和 REALITY 参数
+func relayOutbound(outbound OutboundConfig) (RelayOutbound, error) {
+	region, _, _ := ParseRelayTag(outbound.Tag)
+	relay := RelayOutbound{Tag: outbound.Tag, Region: region}
+
+	settings, err := vmessSettings(outbound)
+	if err != nil {
+		return relay, err
+	}
+	if len(settings.VNext) > 0 {
+		relay.Address = settings.VNext[0].Address
+		relay.Port = settings.VNext[0].Port
+		if len(settings.VNext[0].Users) > 0 {
+			relay.UUID = settings.VNext[0].Users[0].ID
+		}
+	}
+	if stream := outbound.StreamSettings; outbound.Protocol == "vless" && stream != nil && stream.Security == "reality" && stream.RealitySettings != nil {
+		relay.Reality = &Reality{
+			PublicKey:   stream.RealitySettings.PublicKey,
+			ShortID:     stream.RealitySettings.ShortID,
+			ServerName:  stream.RealitySettings.ServerName,
+			Fingerprint: stream.RealitySettings.Fingerprint,
+		}
+	}
+	return relay, nil
+}
+
+// DiffRelayOutbounds 计算从当前中转出站到期望中转出站所需的变更
+// 参数:
+//   - current: 本地配置中现有的中转出站
+//   - desired: 根据运行中实例计算出的期望中转出站
+//
+// 返回值:
+//   - []RelayChange: 按标签排序的变更列表，没有差异时为空
+func DiffRelayOutbounds(current, desired []RelayOutbound) []RelayChange {
+	currentByTag := make(map[string]RelayOutbound, len(current))
+	for _, relay := range current {
+		currentByTag[relay.Tag] = relay
+	}
+	desiredByTag := make(map[string]RelayOutbound, len(desired))
+	for _, relay := range desired {
+		desiredByTag[relay.Tag] = relay
+	}
+
+	var changes []RelayChange
+	for tag, want := range desiredByTag {
+		want := want
+		have, ok := currentByTag[tag]
+		switch {
+		case !ok:
+			changes = append(changes, RelayChange{Action: RelayAdd, Tag: tag, Region: want.Region, Desired: &want})
+		case !sameRelayOutbound(have, want):
+			have := have
+			changes = append(changes, RelayChange{Action: RelayUpdate, Tag: tag, Region: want.Region, Current: &have, Desired: &want})
+		}
+	}
+	for tag, have := range currentByTag {
+		have := have
+		if _, ok := desiredByTag[tag]; !ok {
+			changes = append(changes, RelayChange{Action: RelayRemove, Tag: tag, Region: have.Region, Current: &have})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Tag < changes[j].Tag })
+	return changes
+}
+
+// ApplyRelayChanges 将中转出站变更写入本地配置并应用到运行中的 V2Ray
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值和取消信号
+//   - changes: DiffRelayOutbounds、DiffRelayRoutes 和 DiffRelayUsers 计算出的变更
+//
+// 返回值:
+//   - error: 错误信息，如果读取或写入配置失败
+//
+// 功能:
+//  1. 所有变更在配置文件锁内合并为一次写入，通过 API 应用，需要重启时只重启一次服务
+//  2. 添加用户时中转入站不存在则一并创建
+//  3. 添加或修复路由时重启服务，HandlerService 不能修改路由
+//  4. 没有变更时不做任何操作
+func (m *LocalV2RayManager) ApplyRelayChanges(ctx context.Context, changes []RelayChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	unlock, err := m.lockConfig(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	expectedEmail := "user_aws_" + region
+	config, err := m.readConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read local V2Ray config: %v", err)
+	}
+
+	var applied runtimeChanges
+	options := m.currentOptions()
+	for _, change := range changes {
+		switch change.Action {
+		case RelayAdd, RelayUpdate:
+			desired := *change.Desired
+			desired.Tag = change.Tag
+			config.upsertOutbound(desired, &applied)
+		case RelayRemove:
+			config.removeOutbound(change.Tag, &applied)
+		case RelayAddRule:
+			config.ensureRoute(change.Region, options, &applied)
+		case RelayRemoveRule:
+			config.removeRoute(change.Region, &applied)
+		case RelayAddUser:
+			if err := config.ensureUser(change.Region, options, &applied); err != nil {
+				return err
+			}
+		case RelayRemoveUser:
+			config.removeUser(change.Region, &applied)
+		}
+		logging.Info(ctx, "Relay outbound %s: %s", change.Tag, change.Action)
+	}
+
+	if err := m.writeConfig(ctx, config); err != nil {
+		return fmt.Errorf("failed to write local V2Ray config: %v", err)
+	}
+
+	m.apply(ctx, applied)
+	return nil
+}
 
-	for _, inbound := range config.Inbounds {
-		if inbound.Protocol == "vmess" && inbound.Settings != nil {
-			for _, client := range inbound.Settings.Clients {
-				if client.Email == expectedEmail {
-					return inbound.Port, client.ID, nil
+// upsertOutbound 添加出站，已存在相同标签的出站时只替换协议和 settings
+// 功能:
+//  1. 保留已有出站上管理器不负责的字段，例如手工添加的 streamSettings 或 mux
+//  2. REALITY 出站同时替换 streamSettings 中的 network、security 和 realitySettings，保留其他传输设置
+//
+// 返回值:
+//   - OutboundConfig: 配置中的出站
+func upsertOutbound(config *V2RayConfig, outbound OutboundConfig) OutboundConfig {
+	for i := range config.Outbounds {
+		if config.Outbounds[i].Tag == outbound.Tag {
+			existing := &config.Outbounds[i]
+			existing.Protocol = outbound.Protocol
+			existing.Settings = outbound.Settings
+			if stream := outbound.StreamSettings; stream != nil {
+				if existing.StreamSettings == nil {
+					existing.StreamSettings = &StreamSettingsConfig{}
 				}
+				existing.StreamSettings.Network = stream.Network
+				existing.StreamSettings.Security = stream.Security
+				existing.StreamSettings.RealitySettings = stream.RealitySettings
 			}
+			return *existing
 		}
 	}
+	config.Outbounds = append(config.Outbounds, outbound)
+	return outbound
+}
 
-	return 0, "", fmt.Errorf("relay config not found for region %s", region)
+// newVmessOutbound 创建指向远端 vmess 服务的出站配置
+func newVmessOutbound(tag, address string, port int, uuid string) OutboundConfig {
+	return OutboundConfig{
+		Protocol: "vmess",
+		Tag:      tag,
+		Settings: VmessOutboundSettings{
+			VNext: []VNextConfig{
+				{
+					Address: address,
+					Port:    port,
+					Users: []UserConfig{
+						{
+							ID:      uuid,
+							AlterId: 0,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// newRealityOutbound 创建指向远端 VLESS + REALITY 服务的出站配置，使用 xtls-rprx-vision 流控
+func newRealityOutbound(tag, address string, port int, uuid string, reality Reality) OutboundConfig {
+	return OutboundConfig{
+		Protocol: "vless",
+		Tag:      tag,
+		Settings: VmessOutboundSettings{
+			VNext: []VNextConfig{
+				{
+					Address: address,
+					Port:    port,
+					Users: []UserConfig{
+						{
+							ID:         uuid,
+							Encryption: "none",
+							Flow:       realityFlow,
+						},
+					},
+				},
+			},
+		},
+		StreamSettings: &StreamSettingsConfig{
+			Network:  "tcp",
+			Security: "reality",
+			RealitySettings: &RealityClientConfig{
+				ServerName:  reality.ServerName,
+				Fingerprint: reality.Fingerprint,
+				PublicKey:   reality.PublicKey,
+				ShortID:     reality.ShortID,
+			},
+		},
+	}
 }
@@ -0,0 +1,114 @@
+package localv2ray
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// protoField .proto 文件中的一个字段，message 不为空时为嵌套消息，使用完整类型名
+type protoField struct {
+	name     string
+	number   int32
+	kind     descriptorpb.FieldDescriptorProto_Type
+	message  string
+	repeated bool
+}
+
+// protoFile 按 V2Ray 的 .proto 定义构造消息描述，用 protobuf 官方实现编码，作为手写编码的对照
+// 参数:
+//   - path: .proto 文件路径，只用于区分文件
+//   - pkg: 包名，例如 "v2ray.core.app.stats.command"
+//   - messages: 消息名到字段列表
+//   - deps: 依赖的其他文件
+func protoFile(t *testing.T, path, pkg string, messages map[string][]protoField, deps ...protoreflect.FileDescriptor) protoreflect.FileDescriptor {
+	t.Helper()
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(path),
+		Package: proto.String(pkg),
+		Syntax:  proto.String("proto3"),
+	}
+	for _, dep := range deps {
+		file.Dependency = append(file.Dependency, dep.Path())
+	}
+	for name, fields := range messages {
+		message := &descriptorpb.DescriptorProto{Name: proto.String(name)}
+		for _, field := range fields {
+			label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+			if field.repeated {
+				label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+			}
+			fieldProto := &descriptorpb.FieldDescriptorProto{
+				Name:     proto.String(field.name),
+				JsonName: proto.String(field.name),
+				Number:   proto.Int32(field.number),
+				Label:    label.Enum(),
+				Type:     field.kind.Enum(),
+			}
+			if field.message != "" {
+				fieldProto.TypeName = proto.String("." + field.message)
+			}
+			message.Field = append(message.Field, fieldProto)
+		}
+		file.MessageType = append(file.MessageType, message)
+	}
+
+	files := new(protoregistry.Files)
+	for _, dep := range deps {
+		if err := files.RegisterFile(dep); err != nil {
+			t.Fatal(err)
+		}
+	}
+	descriptor, err := protodesc.NewFile(file, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return descriptor
+}
+
+// protoMarshal 按 protojson 格式的内容构造消息，返回官方实现的编码
+// 参数:
+//   - file: protoFile 返回的文件描述
+//   - name: 消息名，不带包名
+//   - value: protojson 格式的消息内容，bytes 字段为 base64
+func protoMarshal(t *testing.T, file protoreflect.FileDescriptor, name, value string) []byte {
+	t.Helper()
+	message := dynamicpb.NewMessage(file.Messages().ByName(protoreflect.Name(name)))
+	if err := protojson.Unmarshal([]byte(value), message); err != nil {
+		t.Fatalf("invalid %s %s: %v", name, value, err)
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// serveBufconn 在内存连接上启动 serve，返回通过该连接调用的 APIClient，测试结束时关闭
+// 参数:
+//   - engine: 代理程序，决定 API 的服务名和消息类型名
+//   - serve: 在监听器上提供服务，例如 StatsServer.Serve
+//   - stop: 停止服务
+func serveBufconn(t *testing.T, engine Engine, serve func(net.Listener) error, stop func()) *APIClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	go serve(listener)
+
+	client := NewAPIClientWithDialer("127.0.0.1:10085", engine, func(ctx context.Context, network, address string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	})
+	t.Cleanup(func() {
+		client.Close()
+		stop()
+	})
+	return client
+}
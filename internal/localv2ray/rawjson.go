@@ -0,0 +1,179 @@
+package localv2ray
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// rawObject 读取配置时保存的 JSON 对象原文
+// 功能:
+//  1. 结构体只建模管理器用到的字段，其他字段（streamSettings、dns、observatory 等）保存在这里，写回时原样输出
+//  2. 建模的字段在读取后没有修改时也输出原文，只有修改过的字段重新编码，字段保持原来的顺序
+//  3. 代码中新建的对象没有原文，直接按结构体编码
+type rawObject struct {
+	// keys 原文中字段的顺序
+	keys []string
+	// values 原文中每个字段的值
+	values map[string]json.RawMessage
+	// decoded 读取后立即重新编码的建模字段，用于判断字段是否被修改
+	decoded map[string]json.RawMessage
+}
+
+// decodeObject 解析 JSON 对象并保存原文
+// 参数:
+//   - data: JSON 对象
+//   - v: 不带 UnmarshalJSON 方法的结构体别名指针
+//   - raw: 保存原文的位置
+//
+// 返回值:
+//   - error: 错误信息，如果 JSON 无效
+func decodeObject(data []byte, v interface{}, raw *rawObject) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	*raw = rawObject{}
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return nil
+	}
+
+	keys, values, err := splitObject(data)
+	if err != nil {
+		return err
+	}
+	encoded, err := encodeJSON(v)
+	if err != nil {
+		return err
+	}
+	_, decoded, err := splitObject(encoded)
+	if err != nil {
+		return err
+	}
+	*raw = rawObject{keys: keys, values: values, decoded: decoded}
+	return nil
+}
+
+// encodeObject 编码结构体并合并读取时保存的原文
+// 参数:
+//   - v: 不带 MarshalJSON 方法的结构体别名指针
+//   - raw: 读取时保存的原文
+//
+// 返回值:
+//   - []byte: JSON 对象，未修改的字段和未建模的字段为原文，新增的字段追加在最后
+//   - error: 错误信息，如果编码失败
+func encodeObject(v interface{}, raw *rawObject) ([]byte, error) {
+	encoded, err := encodeJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	if raw.values == nil {
+		return encoded, nil
+	}
+	keys, current, err := splitObject(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	written := make(map[string]bool, len(raw.keys)+len(keys))
+	write := func(key string, value json.RawMessage) error {
+		if written[key] {
+			return nil
+		}
+		written[key] = true
+		if buf.Len() > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := encodeJSON(key)
+		if err != nil {
+			return err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+		return nil
+	}
+
+	for _, key := range raw.keys {
+		value, modeled := current[key]
+		before, wasModeled := raw.decoded[key]
+		switch {
+		case !modeled && !wasModeled:
+			// Unmodeled field, or a modeled field omitted both before and now
+			value = raw.values[key]
+		case !modeled:
+			// Modeled field cleared since reading
+			continue
+		case wasModeled && bytes.Equal(value, before):
+			value = raw.values[key]
+		}
+		if err := write(key, value); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range keys {
+		if _, ok := raw.values[key]; ok {
+			continue
+		}
+		if before, ok := raw.decoded[key]; ok && bytes.Equal(current[key], before) {
+			// Zero value the original file did not spell out
+			continue
+		}
+		if err := write(key, current[key]); err != nil {
+			return nil, err
+		}
+	}
+
+	return append(append([]byte{'{'}, buf.Bytes()...), '}'), nil
+}
+
+// splitObject 按顺序拆分 JSON 对象的字段
+// 返回值:
+//   - []string: 字段名，重复的字段只保留第一次出现的位置
+//   - map[string]json.RawMessage: 字段值的原文，重复的字段取最后一个值，与 encoding/json 一致
+//   - error: 错误信息，如果不是 JSON 对象
+func splitObject(data []byte) ([]string, map[string]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	token, err := dec.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return nil, nil, fmt.Errorf("expected JSON object, got %v", token)
+	}
+
+	var keys []string
+	values := make(map[string]json.RawMessage)
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, ok := token.(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("expected object key, got %v", token)
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+	return keys, values, nil
+}
+
+// encodeJSON 编码为紧凑的 JSON，不转义 HTML 字符，避免改变路由规则中的正则表达式等原文
+func encodeJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,176 @@
+package localv2ray
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// inboundUser 入站中的一个 vmess 用户
+type inboundUser struct {
+	inboundTag string
+	client     VmessClientConfig
+}
+
+// ListRelayUsers 读取中转配置中已有中转用户的区域
+// 返回值:
+//   - []string: 排序后的 AWS 区域，用户 email（sing-box 为 name）为 "user_aws_"+region
+//   - error: 错误信息，如果读取配置失败
+func (m *LocalV2RayManager) ListRelayUsers() ([]string, error) {
+	config, err := m.readConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.relayUsers(), nil
+}
+
+// relayUsers 返回已有中转用户的区域
+func (d *v4Document) relayUsers() []string {
+	config := d.config
+
+	seen := make(map[string]bool)
+	var regions []string
+	for _, inbound := range config.Inbounds {
+		if inbound.Protocol != "vmess" || inbound.Settings == nil {
+			continue
+		}
+		for _, client := range inbound.Settings.Clients {
+			region := strings.TrimPrefix(client.Email, relayUserPrefix)
+			if region == client.Email || region == "" || seen[region] {
+				continue
+			}
+			seen[region] = true
+			regions = append(regions, region)
+		}
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+// DiffRelayUsers 计算使中转用户与期望的区域一致所需的变更
+// 参数:
+//   - users: 本地配置中已有中转用户的区域
+//   - regions: 有运行中实例的区域
+//
+// 返回值:
+//   - []RelayChange: 按标签排序的变更，标签为区域的出站标签前缀
+func DiffRelayUsers(users []string, regions []string) []RelayChange {
+	have := make(map[string]bool, len(users))
+	for _, region := range users {
+		have[region] = true
+	}
+	wanted := make(map[string]bool, len(regions))
+
+	var changes []RelayChange
+	for _, region := range regions {
+		wanted[region] = true
+		if !have[region] {
+			changes = append(changes, RelayChange{Action: RelayAddUser, Tag: RegionTag(region), Region: region})
+		}
+	}
+	for _, region := range users {
+		if !wanted[region] {
+			changes = append(changes, RelayChange{Action: RelayRemoveUser, Tag: RegionTag(region), Region: region})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Tag < changes[j].Tag })
+	return changes
+}
+
+// ensureRelayUser 区域还没有中转用户时在中转入站中创建
+// 参数:
+//   - config: 本地 V2Ray 配置
+//   - region: AWS 区域
+//   - options: 中转入站的标签和端口
+//   - changes: 记录需要应用到运行中 V2Ray 的变更
+//
+// 返回值:
+//   - error: 错误信息，如果中转入站标签已被其他协议的入站使用
+//
+// 功能:
+//  1. 任一 vmess 入站中已有 email 为 "user_aws_"+region 的用户时不做任何操作，包括手工创建的用户
+//  2. 用户使用随机 UUID，放在 options.RelayInboundTag 入站中
+//  3. 中转入站不存在时以 options.RelayPort 新建 vmess 入站，新建入站需要重启服务
+func ensureRelayUser(config *V2RayConfig, region string, options Options, changes *runtimeChanges) error {
+	email := RelayUser(region)
+	for _, inbound := range config.Inbounds {
+		if inbound.Protocol != "vmess" || inbound.Settings == nil {
+			continue
+		}
+		for _, client := range inbound.Settings.Clients {
+			if client.Email == email {
+				return nil
+			}
+		}
+	}
+
+	tag := options.RelayInboundTag
+	if tag == "" {
+		return fmt.Errorf("relay inbound tag is not configured")
+	}
+	index := -1
+	for i, inbound := range config.Inbounds {
+		if inbound.Tag == tag {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		if options.RelayPort <= 0 {
+			return fmt.Errorf("relay inbound %s does not exist and no relay port is configured", tag)
+		}
+		config.Inbounds = append(config.Inbounds, InboundConfig{
+			Tag:      tag,
+			Port:     options.RelayPort,
+			Protocol: "vmess",
+		})
+		index = len(config.Inbounds) - 1
+		changes.restart = true
+	}
+
+	inbound := &config.Inbounds[index]
+	if inbound.Protocol != "vmess" {
+		return fmt.Errorf("relay inbound %s uses protocol %q, expected vmess", tag, inbound.Protocol)
+	}
+	if inbound.Settings == nil {
+		inbound.Settings = &VmessInboundSettings{}
+	}
+
+	client := VmessClientConfig{
+		ID:    uuid.New().String(),
+		Email: email,
+	}
+	inbound.Settings.Clients = append(inbound.Settings.Clients, client)
+	changes.addUsers = append(changes.addUsers, inboundUser{inboundTag: tag, client: client})
+	return nil
+}
+
+// removeRelayUser 从所有 vmess 入站中删除区域的中转用户
+// 参数:
+//   - config: 本地 V2Ray 配置
+//   - region: AWS 区域
+//   - changes: 记录需要应用到运行中 V2Ray 的变更
+//
+// 功能:
+//  1. 入站本身保留，即使已没有用户
+func removeRelayUser(config *V2RayConfig, region string, changes *runtimeChanges) {
+	email := RelayUser(region)
+	for i := range config.Inbounds {
+		inbound := &config.Inbounds[i]
+		if inbound.Protocol != "vmess" || inbound.Settings == nil {
+			continue
+		}
+		clients := inbound.Settings.Clients[:0]
+		for _, client := range inbound.Settings.Clients {
+			if client.Email == email {
+				changes.removeUsers = append(changes.removeUsers, inboundUser{inboundTag: inbound.Tag, client: client})
+				continue
+			}
+			clients = append(clients, client)
+		}
+		inbound.Settings.Clients = clients
+	}
+}
@@ -0,0 +1,208 @@
+package localv2ray
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// roundTripInputs 覆盖不同缩进、单行对象和未建模字段的配置文件
+var roundTripInputs = []string{
+	"two_space",
+	"four_space",
+	"tab",
+	"inline",
+	"unknown_keys",
+}
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestRoundTripUnchanged 读取后不修改直接写回，文件逐字节不变
+func TestRoundTripUnchanged(t *testing.T) {
+	for _, name := range roundTripInputs {
+		t.Run(name, func(t *testing.T) {
+			source := readTestdata(t, name+".json")
+			doc, err := parseV4Document(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := doc.marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, source) {
+				t.Errorf("round trip changed the file:\n--- got ---\n%s\n--- want ---\n%s", got, source)
+			}
+		})
+	}
+}
+
+// TestRoundTripModified 替换和新增中转出站、新增区域路由后写回，只有变化的部分重新输出，
+// 结果与 testdata/<name>.golden 比较，使用 -update 重新生成
+func TestRoundTripModified(t *testing.T) {
+	for _, name := range roundTripInputs {
+		t.Run(name, func(t *testing.T) {
+			source := readTestdata(t, name+".json")
+			doc, err := parseV4Document(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var changes runtimeChanges
+			doc.upsertOutbound(RelayOutbound{
+				Tag:     "out_aws_us-east-1-22222222-2222-4222-8222-222222222222",
+				Region:  "us-east-1",
+				Address: "203.0.113.20",
+				Port:    8443,
+				UUID:    "22222222-2222-4222-8222-222222222222",
+			}, &changes)
+			doc.upsertOutbound(RelayOutbound{
+				Tag:     "out_aws_eu-west-1-33333333-3333-4333-8333-333333333333",
+				Region:  "eu-west-1",
+				Address: "192.0.2.30",
+				Port:    443,
+				UUID:    "33333333-3333-4333-8333-333333333333",
+			}, &changes)
+			doc.ensureRoute("eu-west-1", Options{BalancerStrategy: "random"}, &changes)
+			if !doc.removeOutbound("out_aws_us-east-1-11111111-1111-4111-8111-111111111111", &changes) {
+				t.Fatal("existing relay outbound not removed")
+			}
+			got, err := doc.marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want := readTestdata(t, name+".golden")
+			if !bytes.Equal(got, want) {
+				t.Errorf("unexpected output:\n--- got ---\n%s\n--- want ---\n%s", got, want)
+			}
+
+			// 写回的内容再次读取后不变
+			again, err := parseV4Document(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, err := again.marshal(); err != nil || !bytes.Equal(data, got) {
+				t.Errorf("second round trip changed the file: %v", err)
+			}
+		})
+	}
+}
+
+// singBoxRoundTripInputs sing-box 配置文件，包含未建模字段以及 auth_user 为字符串和数组的路由规则
+var singBoxRoundTripInputs = []string{
+	"singbox_unknown_keys",
+}
+
+// TestSingBoxRoundTripUnchanged sing-box 配置读取后不修改直接写回，文件逐字节不变
+func TestSingBoxRoundTripUnchanged(t *testing.T) {
+	for _, name := range singBoxRoundTripInputs {
+		t.Run(name, func(t *testing.T) {
+			source := readTestdata(t, name+".json")
+			doc, err := parseSingBoxDocument(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := doc.marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, source) {
+				t.Errorf("round trip changed the file:\n--- got ---\n%s\n--- want ---\n%s", got, source)
+			}
+		})
+	}
+}
+
+// TestSingBoxRoundTripModified sing-box 配置新增中转出站和区域路由、删除区域最后一个实例出站后写回，
+// 区域的 urltest 出站和路由规则一并删除，结果与 testdata/<name>.golden 比较，使用 -update 重新生成
+func TestSingBoxRoundTripModified(t *testing.T) {
+	options := Options{ProbeURL: "https://www.gstatic.com/generate_204", ProbeInterval: "1m"}
+
+	for _, name := range singBoxRoundTripInputs {
+		t.Run(name, func(t *testing.T) {
+			source := readTestdata(t, name+".json")
+			doc, err := parseSingBoxDocument(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// auth_user 为字符串和数组的规则都识别为区域路由
+			routes := doc.relayRoutes(options)
+			if len(routes) != 2 || !routes[0].Complete || !routes[1].Complete {
+				t.Fatalf("relay routes = %+v, want complete routes for ap-east-1 and us-east-1", routes)
+			}
+
+			var changes runtimeChanges
+			doc.upsertOutbound(RelayOutbound{
+				Tag:     "out_aws_us_east_1-22222222-2222-4222-8222-222222222222",
+				Region:  "us-east-1",
+				Address: "203.0.113.20",
+				Port:    8443,
+				UUID:    "22222222-2222-4222-8222-222222222222",
+			}, &changes)
+			doc.upsertOutbound(RelayOutbound{
+				Tag:     "out_aws_eu_west_1-33333333-3333-4333-8333-333333333333",
+				Region:  "eu-west-1",
+				Address: "192.0.2.30",
+				Port:    443,
+				UUID:    "33333333-3333-4333-8333-333333333333",
+			}, &changes)
+			doc.ensureRoute("eu-west-1", options, &changes)
+			if !doc.removeOutbound("out_aws_ap_east_1-44444444-4444-4444-8444-444444444444", &changes) {
+				t.Fatal("existing relay outbound not removed")
+			}
+			if !changes.restart {
+				t.Error("sing-box changes do not require a restart")
+			}
+			if doc.hasRegionOutbounds("ap-east-1") {
+				t.Error("ap-east-1 still has relay outbounds")
+			}
+			routes = doc.relayRoutes(options)
+			if len(routes) != 2 || routes[0].Region != "eu-west-1" || routes[1].Region != "us-east-1" || !routes[0].Complete || !routes[1].Complete {
+				t.Errorf("relay routes = %+v, want complete routes for eu-west-1 and us-east-1", routes)
+			}
+
+			got, err := doc.marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want := readTestdata(t, name+".golden")
+			if !bytes.Equal(got, want) {
+				t.Errorf("unexpected output:\n--- got ---\n%s\n--- want ---\n%s", got, want)
+			}
+
+			// 写回的内容再次读取后不变
+			again, err := parseSingBoxDocument(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, err := again.marshal(); err != nil || !bytes.Equal(data, got) {
+				t.Errorf("second round trip changed the file: %v", err)
+			}
+		})
+	}
+}
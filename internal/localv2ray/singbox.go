@@ -0,0 +1,578 @@
+package localv2ray
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// SingBoxConfig sing-box 配置中管理器用到的部分，其他字段（log、dns、experimental 等）按原文写回
+// 功能:
+//  1. 每个实例一个 vmess 出站，标签与 V2Ray 相同，为 "out_aws_<region>-<uuid>"
+//  2. 区域负载均衡器为 urltest 出站，标签为 "balancer_aws_<region>"，显式列出区域的实例出站，
+//     按 ProbeURL 和 ProbeInterval 探测并选择延迟最低的出站；sing-box 没有随机策略，BalancerStrategy 不生效
+//  3. 区域用户为 vmess 入站中 name 为 "user_aws_<region>" 的用户，路由规则按 auth_user 匹配用户并指向负载均衡器
+type SingBoxConfig struct {
+	Inbounds  []SingBoxInbound  `json:"inbounds,omitempty"`
+	Outbounds []SingBoxOutbound `json:"outbounds,omitempty"`
+	Route     *SingBoxRoute     `json:"route,omitempty"`
+
+	raw rawObject
+	// source 读取的文件原文，写回时按原文的格式输出
+	source []byte
+}
+
+type SingBoxInbound struct {
+	Type       string        `json:"type"`
+	Tag        string        `json:"tag,omitempty"`
+	Listen     string        `json:"listen,omitempty"`
+	ListenPort int           `json:"listen_port,omitempty"`
+	Users      []SingBoxUser `json:"users,omitempty"`
+
+	raw rawObject
+}
+
+type SingBoxUser struct {
+	Name    string `json:"name,omitempty"`
+	UUID    string `json:"uuid"`
+	AlterID int    `json:"alterId,omitempty"`
+
+	raw rawObject
+}
+
+type SingBoxOutbound struct {
+	Type       string `json:"type"`
+	Tag        string `json:"tag,omitempty"`
+	Server     string `json:"server,omitempty"`
+	ServerPort int    `json:"server_port,omitempty"`
+	UUID       string `json:"uuid,omitempty"`
+	Security   string `json:"security,omitempty"`
+	AlterID    int    `json:"alter_id,omitempty"`
+	// Flow、TLS vless 出站的流控和 TLS 设置，REALITY 实例出站使用
+	Flow string      `json:"flow,omitempty"`
+	TLS  *SingBoxTLS `json:"tls,omitempty"`
+	// Outbounds urltest 和 selector 出站可选择的出站
+	Outbounds []string `json:"outbounds,omitempty"`
+	// URL urltest 出站的探测地址
+	URL string `json:"url,omitempty"`
+	// Interval urltest 出站的探测间隔
+	Interval string `json:"interval,omitempty"`
+
+	raw rawObject
+}
+
+type SingBoxTLS struct {
+	Enabled    bool            `json:"enabled,omitempty"`
+	ServerName string          `json:"server_name,omitempty"`
+	UTLS       *SingBoxUTLS    `json:"utls,omitempty"`
+	Reality    *SingBoxReality `json:"reality,omitempty"`
+
+	raw rawObject
+}
+
+type SingBoxUTLS struct {
+	Enabled     bool   `json:"enabled,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	raw rawObject
+}
+
+type SingBoxReality struct {
+	Enabled   bool   `json:"enabled,omitempty"`
+	PublicKey string `json:"public_key,omitempty"`
+	ShortID   string `json:"short_id,omitempty"`
+
+	raw rawObject
+}
+
+type SingBoxRoute struct {
+	Rules []SingBoxRule `json:"rules,omitempty"`
+
+	raw rawObject
+}
+
+type SingBoxRule struct {
+	// AuthUser 匹配的入站用户名，sing-box 也接受单个字符串
+	AuthUser listable `json:"auth_user,omitempty"`
+	Outbound string   `json:"outbound,omitempty"`
+
+	raw rawObject
+}
+
+// listable sing-box 中可以写成单个字符串或字符串数组的字段
+type listable []string
+
+func (l *listable) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = listable{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(l))
+}
+
+// singBoxDocument sing-box 格式的配置
+// 功能:
+//  1. sing-box 没有 HandlerService，所有变更都需要重启服务
+//  2. sing-box 校验配置时要求 urltest 出站和路由规则引用的出站都存在，
+//     区域最后一个实例出站删除时一并删除区域的负载均衡器和路由规则
+type singBoxDocument struct {
+	config *SingBoxConfig
+}
+
+// parseSingBoxDocument 解析 sing-box 格式的配置
+// 参数:
+//   - data: 配置文件内容
+//
+// 返回值:
+//   - document: 解析后的配置
+//   - error: 错误信息，如果不是有效的 JSON
+func parseSingBoxDocument(data []byte) (document, error) {
+	var config SingBoxConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	config.source = data
+	return &singBoxDocument{config: &config}, nil
+}
+
+func (d *singBoxDocument) marshal() ([]byte, error) {
+	return marshalIndented(d.config, d.config.source)
+}
+
+func (d *singBoxDocument) relayOutbounds() ([]RelayOutbound, error) {
+	var relays []RelayOutbound
+	for _, outbound := range d.config.Outbounds {
+		region, _, ok := ParseRelayTag(outbound.Tag)
+		if !ok {
+			continue
+		}
+		if outbound.Type != "vmess" && outbound.Type != "vless" {
+			return nil, fmt.Errorf("relay outbound %s uses type %q, expected vmess or vless", outbound.Tag, outbound.Type)
+		}
+		relay := RelayOutbound{
+			Tag:     outbound.Tag,
+			Region:  region,
+			Address: outbound.Server,
+			Port:    outbound.ServerPort,
+			UUID:    outbound.UUID,
+		}
+		if tls := outbound.TLS; outbound.Type == "vless" && tls != nil && tls.Reality != nil {
+			relay.Reality = &Reality{
+				PublicKey:  tls.Reality.PublicKey,
+				ShortID:    tls.Reality.ShortID,
+				ServerName: tls.ServerName,
+			}
+			if tls.UTLS != nil {
+				relay.Reality.Fingerprint = tls.UTLS.Fingerprint
+			}
+		}
+		relays = append(relays, relay)
+	}
+	return relays, nil
+}
+
+func (d *singBoxDocument) relayRoutes(options Options) []RelayRoute {
+	present := make(map[string]bool)
+	for _, outbound := range d.config.Outbounds {
+		if region, ok := balancerRegion(outbound.Tag); ok {
+			present[region] = true
+		}
+	}
+	for _, rule := range d.rules() {
+		if region, ok := singBoxRuleRegion(rule); ok {
+			present[region] = true
+		}
+	}
+
+	routes := make([]RelayRoute, 0, len(present))
+	for region := range present {
+		routes = append(routes, RelayRoute{Region: region, Complete: d.routeComplete(region, options)})
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Region < routes[j].Region })
+	return routes
+}
+
+// routeComplete 返回区域的负载均衡器是否选择该区域的所有实例出站、探测设置与当前设置一致，且有指向它的路由规则
+func (d *singBoxDocument) routeComplete(region string, options Options) bool {
+	balancer := d.findOutbound(BalancerTag(region))
+	if balancer == nil || !urlTestMatches(*balancer, d.regionOutbounds(region), options) {
+		return false
+	}
+
+	routed := false
+	for _, rule := range d.rules() {
+		if rule.Outbound == BalancerTag(region) {
+			routed = true
+		} else if owner, ok := singBoxRuleRegion(rule); ok && owner == region {
+			// Rule pointing at a single outbound
+			return false
+		}
+	}
+	return routed
+}
+
+func (d *singBoxDocument) relayUsers() []string {
+	seen := make(map[string]bool)
+	var regions []string
+	for _, inbound := range d.config.Inbounds {
+		if inbound.Type != "vmess" {
+			continue
+		}
+		for _, user := range inbound.Users {
+			region := strings.TrimPrefix(user.Name, relayUserPrefix)
+			if region == user.Name || region == "" || seen[region] {
+				continue
+			}
+			seen[region] = true
+			regions = append(regions, region)
+		}
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+func (d *singBoxDocument) relayConfig(region string) (int, string, error) {
+	name := RelayUser(region)
+	for _, inbound := range d.config.Inbounds {
+		if inbound.Type != "vmess" {
+			continue
+		}
+		for _, user := range inbound.Users {
+			if user.Name == name {
+				return inbound.ListenPort, user.UUID, nil
+			}
+		}
+	}
+	return 0, "", fmt.Errorf("relay config not found for region %s", region)
+}
+
+// upsertOutbound 添加或更新实例出站，区域的负载均衡器已存在时把出站加入其中
+// 功能:
+//  1. vmess 实例为 vmess 出站，REALITY 实例为 vless 出站，使用 xtls-rprx-vision 流控和 uTLS 指纹
+//  2. 已有出站只修改管理器负责的字段，保留其他字段
+func (d *singBoxDocument) upsertOutbound(relay RelayOutbound, changes *runtimeChanges) {
+	outbound := d.findOutbound(relay.Tag)
+	if outbound == nil {
+		d.config.Outbounds = append(d.config.Outbounds, SingBoxOutbound{Tag: relay.Tag})
+		outbound = &d.config.Outbounds[len(d.config.Outbounds)-1]
+	}
+	outbound.Server = relay.Address
+	outbound.ServerPort = relay.Port
+	outbound.UUID = relay.UUID
+	if relay.Reality == nil {
+		outbound.Type = "vmess"
+		outbound.Flow = ""
+		outbound.TLS = nil
+		if outbound.Security == "" {
+			outbound.Security = "auto"
+		}
+	} else {
+		outbound.Type = "vless"
+		outbound.Security = ""
+		outbound.Flow = realityFlow
+		if outbound.TLS == nil {
+			outbound.TLS = &SingBoxTLS{}
+		}
+		outbound.TLS.Enabled = true
+		outbound.TLS.ServerName = relay.Reality.ServerName
+		if outbound.TLS.UTLS == nil {
+			outbound.TLS.UTLS = &SingBoxUTLS{}
+		}
+		outbound.TLS.UTLS.Enabled = true
+		outbound.TLS.UTLS.Fingerprint = relay.Reality.Fingerprint
+		if outbound.TLS.Reality == nil {
+			outbound.TLS.Reality = &SingBoxReality{}
+		}
+		outbound.TLS.Reality.Enabled = true
+		outbound.TLS.Reality.PublicKey = relay.Reality.PublicKey
+		outbound.TLS.Reality.ShortID = relay.Reality.ShortID
+	}
+
+	region, _, _ := ParseRelayTag(relay.Tag)
+	if balancer := d.findOutbound(BalancerTag(region)); balancer != nil && !containsString(balancer.Outbounds, relay.Tag) {
+		balancer.Outbounds = append(balancer.Outbounds, relay.Tag)
+	}
+	changes.restart = true
+}
+
+// removeOutbound 删除实例出站，并从负载均衡器和路由规则中移除对它的引用，
+// 负载均衡器因此没有可选的出站时一并删除区域的路由
+func (d *singBoxDocument) removeOutbound(tag string, changes *runtimeChanges) bool {
+	removed := false
+	outbounds := d.config.Outbounds[:0]
+	for _, outbound := range d.config.Outbounds {
+		if outbound.Tag == tag {
+			removed = true
+			continue
+		}
+		outbounds = append(outbounds, outbound)
+	}
+	d.config.Outbounds = outbounds
+
+	var emptied []string
+	for i := range d.config.Outbounds {
+		outbound := &d.config.Outbounds[i]
+		if !containsString(outbound.Outbounds, tag) {
+			continue
+		}
+		kept := make([]string, 0, len(outbound.Outbounds)-1)
+		for _, member := range outbound.Outbounds {
+			if member != tag {
+				kept = append(kept, member)
+			}
+		}
+		outbound.Outbounds = kept
+		if region, ok := balancerRegion(outbound.Tag); ok && len(kept) == 0 {
+			emptied = append(emptied, region)
+		}
+	}
+
+	if d.config.Route != nil {
+		rules := d.config.Route.Rules[:0]
+		for _, rule := range d.config.Route.Rules {
+			if rule.Outbound != tag {
+				rules = append(rules, rule)
+			}
+		}
+		d.config.Route.Rules = rules
+	}
+
+	for _, region := range emptied {
+		d.removeRoute(region, changes)
+	}
+	if removed {
+		changes.restart = true
+	}
+	return removed
+}
+
+func (d *singBoxDocument) hasRegionOutbounds(region string) bool {
+	return len(d.regionOutbounds(region)) > 0
+}
+
+// ensureRoute 确保区域用户经区域的 urltest 出站路由到该区域的实例出站
+// 功能:
+//  1. 创建或修正 urltest 出站，选择该区域当前所有的实例出站，区域没有实例出站时不做任何操作
+//  2. 直接指向实例出站的规则改为指向负载均衡器，保留规则的其他字段
+//  3. 没有指向负载均衡器的规则时添加一条，放在最后一条中转规则之后，没有中转规则时放在最前面
+func (d *singBoxDocument) ensureRoute(region string, options Options, changes *runtimeChanges) {
+	members := d.regionOutbounds(region)
+	if len(members) == 0 {
+		return
+	}
+	changed := false
+
+	balancer := d.findOutbound(BalancerTag(region))
+	if balancer == nil {
+		d.config.Outbounds = append(d.config.Outbounds, SingBoxOutbound{Tag: BalancerTag(region)})
+		balancer = &d.config.Outbounds[len(d.config.Outbounds)-1]
+	}
+	if !urlTestMatches(*balancer, members, options) {
+		balancer.Type = "urltest"
+		balancer.Outbounds = members
+		if options.ProbeURL != "" {
+			balancer.URL = options.ProbeURL
+		}
+		if options.ProbeInterval != "" {
+			balancer.Interval = options.ProbeInterval
+		}
+		changed = true
+	}
+
+	if d.config.Route == nil {
+		d.config.Route = &SingBoxRoute{}
+	}
+	route := d.config.Route
+	routed := false
+	insertAt := 0
+	for i := range route.Rules {
+		rule := &route.Rules[i]
+		owner, ok := singBoxRuleRegion(*rule)
+		if !ok {
+			continue
+		}
+		insertAt = i + 1
+		if owner != region {
+			continue
+		}
+		if rule.Outbound != BalancerTag(region) {
+			rule.Outbound = BalancerTag(region)
+			changed = true
+		}
+		routed = true
+	}
+	if !routed {
+		rules := make([]SingBoxRule, 0, len(route.Rules)+1)
+		rules = append(rules, route.Rules[:insertAt]...)
+		rules = append(rules, SingBoxRule{AuthUser: listable{RelayUser(region)}, Outbound: BalancerTag(region)})
+		rules = append(rules, route.Rules[insertAt:]...)
+		route.Rules = rules
+		changed = true
+	}
+
+	if changed {
+		changes.restart = true
+	}
+}
+
+func (d *singBoxDocument) removeRoute(region string, changes *runtimeChanges) bool {
+	removed := false
+
+	if d.config.Route != nil {
+		rules := d.config.Route.Rules[:0]
+		for _, rule := range d.config.Route.Rules {
+			if owner, ok := singBoxRuleRegion(rule); ok && owner == region {
+				removed = true
+				continue
+			}
+			rules = append(rules, rule)
+		}
+		d.config.Route.Rules = rules
+	}
+
+	outbounds := d.config.Outbounds[:0]
+	for _, outbound := range d.config.Outbounds {
+		if outbound.Tag == BalancerTag(region) {
+			removed = true
+			continue
+		}
+		outbounds = append(outbounds, outbound)
+	}
+	d.config.Outbounds = outbounds
+
+	if removed {
+		changes.restart = true
+	}
+	return removed
+}
+
+// ensureUser 区域还没有中转用户时在中转入站中创建，中转入站不存在时以 options.RelayPort 新建 vmess 入站
+func (d *singBoxDocument) ensureUser(region string, options Options, changes *runtimeChanges) error {
+	name := RelayUser(region)
+	for _, inbound := range d.config.Inbounds {
+		if inbound.Type != "vmess" {
+			continue
+		}
+		for _, user := range inbound.Users {
+			if user.Name == name {
+				return nil
+			}
+		}
+	}
+
+	tag := options.RelayInboundTag
+	if tag == "" {
+		return fmt.Errorf("relay inbound tag is not configured")
+	}
+	index := -1
+	for i, inbound := range d.config.Inbounds {
+		if inbound.Tag == tag {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		if options.RelayPort <= 0 {
+			return fmt.Errorf("relay inbound %s does not exist and no relay port is configured", tag)
+		}
+		d.config.Inbounds = append(d.config.Inbounds, SingBoxInbound{
+			Type:       "vmess",
+			Tag:        tag,
+			Listen:     "::",
+			ListenPort: options.RelayPort,
+		})
+		index = len(d.config.Inbounds) - 1
+	}
+
+	inbound := &d.config.Inbounds[index]
+	if inbound.Type != "vmess" {
+		return fmt.Errorf("relay inbound %s uses type %q, expected vmess", tag, inbound.Type)
+	}
+	inbound.Users = append(inbound.Users, SingBoxUser{Name: name, UUID: uuid.New().String()})
+	changes.restart = true
+	return nil
+}
+
+func (d *singBoxDocument) removeUser(region string, changes *runtimeChanges) {
+	name := RelayUser(region)
+	for i := range d.config.Inbounds {
+		inbound := &d.config.Inbounds[i]
+		if inbound.Type != "vmess" {
+			continue
+		}
+		users := inbound.Users[:0]
+		for _, user := range inbound.Users {
+			if user.Name == name {
+				changes.restart = true
+				continue
+			}
+			users = append(users, user)
+		}
+		inbound.Users = users
+	}
+}
+
+// findOutbound 返回指定标签的出站，不存在时返回 nil
+func (d *singBoxDocument) findOutbound(tag string) *SingBoxOutbound {
+	for i := range d.config.Outbounds {
+		if d.config.Outbounds[i].Tag == tag {
+			return &d.config.Outbounds[i]
+		}
+	}
+	return nil
+}
+
+// regionOutbounds 返回区域的实例出站标签，按配置中的顺序排列
+func (d *singBoxDocument) regionOutbounds(region string) []string {
+	var tags []string
+	for _, outbound := range d.config.Outbounds {
+		if outboundRegion, _, ok := ParseRelayTag(outbound.Tag); ok && outboundRegion == region {
+			tags = append(tags, outbound.Tag)
+		}
+	}
+	return tags
+}
+
+// rules 返回路由规则，没有 route 时为空
+func (d *singBoxDocument) rules() []SingBoxRule {
+	if d.config.Route == nil {
+		return nil
+	}
+	return d.config.Route.Rules
+}
+
+// singBoxRuleRegion 返回中转路由规则所属的区域
+// 功能:
+//  1. 指向区域负载均衡器的规则，以及直接指向中转出站的规则都属于中转路由
+func singBoxRuleRegion(rule SingBoxRule) (string, bool) {
+	if region, ok := balancerRegion(rule.Outbound); ok {
+		return region, true
+	}
+	if region, _, ok := ParseRelayTag(rule.Outbound); ok {
+		return region, true
+	}
+	return "", false
+}
+
+// urlTestMatches 返回 urltest 出站是否恰好选择 members 中的出站，且探测设置与当前设置一致
+func urlTestMatches(balancer SingBoxOutbound, members []string, options Options) bool {
+	if balancer.Type != "urltest" || len(balancer.Outbounds) != len(members) {
+		return false
+	}
+	for _, member := range members {
+		if !containsString(balancer.Outbounds, member) {
+			return false
+		}
+	}
+	if options.ProbeURL != "" && balancer.URL != options.ProbeURL {
+		return false
+	}
+	if options.ProbeInterval != "" && balancer.Interval != options.ProbeInterval {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,300 @@
+package localv2ray
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshDialTimeout 建立 SSH 连接的超时时间
+const sshDialTimeout = 10 * time.Second
+
+// SSHOptions 通过 SSH 访问中转主机的设置
+type SSHOptions struct {
+	// Host、Port 中转主机的 SSH 地址
+	Host string
+	Port int
+	User string
+	// PrivateKey PEM 格式的私钥，Passphrase 为私钥的密码，私钥未加密时为空
+	PrivateKey string
+	Passphrase string
+	// KnownHosts 校验主机密钥使用的 known_hosts 文件
+	KnownHosts string
+}
+
+// SSHDriver 通过 SSH 访问远程中转主机
+// 功能:
+//  1. 使用私钥认证，按 known_hosts 校验主机密钥
+//  2. 通过 sftp 读写配置文件，通过 SSH 会话执行校验和重启命令，通过 SSH 连接转发访问 V2Ray API
+//  3. 第一次使用时建立连接，连接断开后下次使用时重新建立
+type SSHDriver struct {
+	address string
+	config  *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+	sftp   *sftp.Client
+}
+
+// NewSSHDriver 创建一个新的 SSHDriver 实例
+// 参数:
+//   - options: SSH 设置
+//
+// 返回值:
+//   - *SSHDriver: 新创建的 SSHDriver 实例，第一次使用时才建立连接
+//   - error: 错误信息，如果私钥或 known_hosts 文件无效
+func NewSSHDriver(options SSHOptions) (*SSHDriver, error) {
+	var signer ssh.Signer
+	var err error
+	if options.Passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(options.PrivateKey), []byte(options.Passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey([]byte(options.PrivateKey))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH private key: %v", err)
+	}
+
+	hostKeyCallback, err := knownhosts.New(options.KnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts: %v", err)
+	}
+
+	return &SSHDriver{
+		address: net.JoinHostPort(options.Host, strconv.Itoa(options.Port)),
+		config: &ssh.ClientConfig{
+			User:            options.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         sshDialTimeout,
+		},
+	}, nil
+}
+
+// clients 返回 SSH 和 sftp 客户端，未连接时建立连接
+func (d *SSHDriver) clients() (*ssh.Client, *sftp.Client, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.client != nil {
+		return d.client, d.sftp, nil
+	}
+
+	client, err := ssh.Dial("tcp", d.address, d.config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to %s: %v", d.address, err)
+	}
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("failed to start sftp on %s: %v", d.address, err)
+	}
+	d.client, d.sftp = client, sftpClient
+	return client, sftpClient, nil
+}
+
+// check 操作失败且连接已断开时关闭连接，下次使用时重新建立
+func (d *SSHDriver) check(client *ssh.Client, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sftp.ErrSSHFxConnectionLost) || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		d.mu.Lock()
+		if d.client == client {
+			d.sftp.Close()
+			d.client.Close()
+			d.client, d.sftp = nil, nil
+		}
+		d.mu.Unlock()
+	}
+	return err
+}
+
+// ReadFile 通过 sftp 读取文件
+func (d *SSHDriver) ReadFile(path string) ([]byte, error) {
+	client, sftpClient, err := d.clients()
+	if err != nil {
+		return nil, err
+	}
+	file, err := sftpClient.Open(path)
+	if err != nil {
+		return nil, d.check(client, err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	return data, d.check(client, err)
+}
+
+// WriteFile 通过 sftp 写入文件，服务端支持 fsync@openssh.com 扩展时 fsync
+func (d *SSHDriver) WriteFile(path string, data []byte, perm os.FileMode) error {
+	client, sftpClient, err := d.clients()
+	if err != nil {
+		return err
+	}
+	file, err := sftpClient.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return d.check(client, err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return d.check(client, err)
+	}
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		return d.check(client, err)
+	}
+	var unsupported *sftp.StatusError
+	if err := file.Sync(); err != nil && !(errors.As(err, &unsupported) && unsupported.FxCode() == sftp.ErrSSHFxOpUnsupported) {
+		file.Close()
+		return d.check(client, err)
+	}
+	return d.check(client, file.Close())
+}
+
+// Replace 通过 posix-rename@openssh.com 扩展原子地替换文件
+func (d *SSHDriver) Replace(src, dst string) error {
+	client, sftpClient, err := d.clients()
+	if err != nil {
+		return err
+	}
+	return d.check(client, sftpClient.PosixRename(src, dst))
+}
+
+// Remove 通过 sftp 删除文件
+func (d *SSHDriver) Remove(path string) error {
+	client, sftpClient, err := d.clients()
+	if err != nil {
+		return err
+	}
+	return d.check(client, sftpClient.Remove(path))
+}
+
+// Stat 通过 sftp 返回文件信息
+func (d *SSHDriver) Stat(path string) (os.FileInfo, error) {
+	client, sftpClient, err := d.clients()
+	if err != nil {
+		return nil, err
+	}
+	info, err := sftpClient.Stat(path)
+	return info, d.check(client, err)
+}
+
+// ReadDir 通过 sftp 列出目录中的文件
+func (d *SSHDriver) ReadDir(dir string) ([]os.FileInfo, error) {
+	client, sftpClient, err := d.clients()
+	if err != nil {
+		return nil, err
+	}
+	infos, err := sftpClient.ReadDir(dir)
+	return infos, d.check(client, err)
+}
+
+// Lock 在远程主机上执行 flock 并保持会话，直到释放锁
+// 功能:
+//  1. 与远程主机上的其他管理器互斥，需要远程主机安装 util-linux 的 flock
+//  2. 本进程异常退出或连接断开时，远程的 flock 进程随会话结束，锁自动释放
+func (d *SSHDriver) Lock(ctx context.Context, path string) (func(), error) {
+	client, _, err := d.clients()
+	if err != nil {
+		return nil, err
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, d.check(client, fmt.Errorf("failed to open SSH session: %w", err))
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	if err := session.Start("flock " + shellQuote(path) + " -c 'echo locked; cat >/dev/null'"); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+	}
+
+	locked := make(chan error, 1)
+	go func() {
+		line, err := bufio.NewReader(stdout).ReadString('\n')
+		if err == nil && line != "locked\n" {
+			err = fmt.Errorf("unexpected output %q", line)
+		}
+		locked <- err
+	}()
+	select {
+	case err := <-locked:
+		if err != nil {
+			session.Close()
+			return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+		}
+	case <-ctx.Done():
+		session.Close()
+		return nil, ctx.Err()
+	}
+
+	return func() {
+		stdin.Close()
+		session.Wait()
+		session.Close()
+	}, nil
+}
+
+// Run 在远程主机上执行命令
+func (d *SSHDriver) Run(ctx context.Context, command string) ([]byte, error) {
+	client, _, err := d.clients()
+	if err != nil {
+		return nil, err
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, d.check(client, fmt.Errorf("failed to open SSH session: %w", err))
+	}
+	defer session.Close()
+
+	stop := context.AfterFunc(ctx, func() { session.Close() })
+	defer stop()
+	output, err := session.CombinedOutput(command)
+	if ctx.Err() != nil {
+		return output, ctx.Err()
+	}
+	return output, err
+}
+
+// DialContext 通过 SSH 连接从远程主机发起连接
+func (d *SSHDriver) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	client, _, err := d.clients()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.DialContext(ctx, network, address)
+	return conn, d.check(client, err)
+}
+
+// Close 关闭 SSH 连接
+func (d *SSHDriver) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.client == nil {
+		return nil
+	}
+	d.sftp.Close()
+	err := d.client.Close()
+	d.client, d.sftp = nil, nil
+	return err
+}
@@ -0,0 +1,180 @@
+package localv2ray
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// queryStatsMethod StatsService 的 QueryStats 方法，V2Ray、Xray 和 sing-box 的请求和响应字段相同
+const queryStatsMethod = "QueryStats"
+
+// 流量统计项的类型和方向，统计项名称格式为 "<类型>>>><名称>>>>traffic>>><方向>"
+const (
+	StatKindInbound  = "inbound"
+	StatKindOutbound = "outbound"
+	StatKindUser     = "user"
+
+	DirectionUplink   = "uplink"
+	DirectionDownlink = "downlink"
+)
+
+// statNameSeparator 统计项名称各部分之间的分隔符
+const statNameSeparator = ">>>"
+
+// Stat V2Ray 的一个流量计数器
+type Stat struct {
+	Name  string `json:"name"`
+	Value int64  `json:"value"`
+}
+
+// StatsSource 定义查询 V2Ray 流量计数器的接口
+type StatsSource interface {
+	// QueryStats 返回名称包含 pattern 的计数器，reset 为 true 时读取后清零
+	QueryStats(ctx context.Context, pattern string, reset bool) ([]Stat, error)
+}
+
+// QueryStats 通过 StatsService 查询流量计数器
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值和取消信号
+//   - pattern: 计数器名称需要包含的字符串，为空时返回所有计数器
+//   - reset: 是否在读取后将计数器清零
+//
+// 返回值:
+//   - []Stat: 计数器列表
+//   - error: 错误信息，如果连接或调用失败
+func (c *APIClient) QueryStats(ctx context.Context, pattern string, reset bool) ([]Stat, error) {
+	var response queryStatsResponse
+	if err := c.invoke(ctx, "/"+c.schema.statsService+"/"+queryStatsMethod, &queryStatsRequest{Pattern: pattern, Reset: reset}, &response); err != nil {
+		return nil, err
+	}
+	return response.Stats, nil
+}
+
+// QueryStats 通过中转的 V2Ray API 查询计数器
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值和取消信号
+//   - pattern: 计数器名称需要包含的字符串，为空时返回所有计数器
+//   - reset: 是否在读取后将计数器清零
+//
+// 返回值:
+//   - []Stat: 计数器列表，中转未配置 API 地址时为空
+//   - error: 错误信息，如果连接或调用失败
+func (m *LocalV2RayManager) QueryStats(ctx context.Context, pattern string, reset bool) ([]Stat, error) {
+	api := m.apiClient()
+	if api == nil {
+		return nil, nil
+	}
+	return api.QueryStats(ctx, pattern, reset)
+}
+
+// ParseStatName 解析流量计数器名称
+// 参数:
+//   - name: 计数器名称，例如 "outbound>>>out_aws_ap_east_1>>>traffic>>>uplink"
+//
+// 返回值:
+//   - string: 计数器类型（inbound、outbound、user）
+//   - string: 入站或出站标签，或用户的 email
+//   - string: 方向（uplink、downlink）
+//   - bool: 是否为流量计数器
+func ParseStatName(name string) (string, string, string, bool) {
+	parts := strings.Split(name, statNameSeparator)
+	if len(parts) != 4 || parts[2] != "traffic" || parts[1] == "" {
+		return "", "", "", false
+	}
+	switch parts[3] {
+	case DirectionUplink, DirectionDownlink:
+	default:
+		return "", "", "", false
+	}
+	switch parts[0] {
+	case StatKindInbound, StatKindOutbound, StatKindUser:
+		return parts[0], parts[1], parts[3], true
+	}
+	return "", "", "", false
+}
+
+// queryStatsRequest v2ray.core.app.stats.command.QueryStatsRequest
+type queryStatsRequest struct {
+	Pattern string
+	Reset   bool
+}
+
+func (r *queryStatsRequest) marshal() []byte {
+	var data []byte
+	data = appendString(data, 1, r.Pattern)
+	if r.Reset {
+		data = appendVarint(data, 2, 1)
+	}
+	return data
+}
+
+func (r *queryStatsRequest) unmarshal(data []byte) error {
+	return consumeFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			r.Pattern = string(value)
+		case num == 2 && typ == protowire.VarintType:
+			n, length := protowire.ConsumeVarint(value)
+			if length < 0 {
+				return protowire.ParseError(length)
+			}
+			r.Reset = n != 0
+		}
+		return nil
+	})
+}
+
+// queryStatsResponse v2ray.core.app.stats.command.QueryStatsResponse
+type queryStatsResponse struct {
+	Stats []Stat
+}
+
+func (r *queryStatsResponse) marshal() []byte {
+	var data []byte
+	for _, stat := range r.Stats {
+		data = appendBytes(data, 1, marshalStat(stat))
+	}
+	return data
+}
+
+func (r *queryStatsResponse) unmarshal(data []byte) error {
+	return consumeFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		stat, err := unmarshalStat(value)
+		if err != nil {
+			return err
+		}
+		r.Stats = append(r.Stats, stat)
+		return nil
+	})
+}
+
+// marshalStat 编码 v2ray.core.app.stats.command.Stat
+func marshalStat(stat Stat) []byte {
+	var data []byte
+	data = appendString(data, 1, stat.Name)
+	return appendVarint(data, 2, uint64(stat.Value))
+}
+
+// unmarshalStat 解析 v2ray.core.app.stats.command.Stat
+func unmarshalStat(data []byte) (Stat, error) {
+	var stat Stat
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			stat.Name = string(value)
+		case num == 2 && typ == protowire.VarintType:
+			n, length := protowire.ConsumeVarint(value)
+			if length < 0 {
+				return protowire.ParseError(length)
+			}
+			stat.Value = int64(n)
+		}
+		return nil
+	})
+	return stat, err
+}
@@ -0,0 +1,93 @@
+package localv2ray
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+// StatsServer 进程内的 StatsService，与中转代理程序的 QueryStats 使用相同的方法名和消息格式，
+// 用于在没有中转时代替中转的 API 测试流量采集
+type StatsServer struct {
+	server *grpc.Server
+
+	mu       sync.Mutex
+	counters map[string]int64
+}
+
+// NewStatsServer 创建一个新的 StatsServer 实例
+// 参数:
+//   - engine: 模拟的代理程序，决定 StatsService 的服务名
+//
+// 返回值:
+//   - *StatsServer: 新创建的 StatsServer 实例，没有计数器，调用 Serve 后开始提供服务
+func NewStatsServer(engine Engine) *StatsServer {
+	s := &StatsServer{counters: make(map[string]int64)}
+	s.server = grpc.NewServer(grpc.ForceServerCodec(apiCodec{}))
+	s.server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: engine.api().statsService,
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: queryStatsMethod,
+			Handler: func(_ interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				var request queryStatsRequest
+				if err := dec(&request); err != nil {
+					return nil, err
+				}
+				return &queryStatsResponse{Stats: s.query(request.Pattern, request.Reset)}, nil
+			},
+		}},
+	}, s)
+	return s
+}
+
+// Add 累加一个计数器，计数器不存在时创建
+// 参数:
+//   - name: 计数器名称，例如 "outbound>>>out_aws_ap_east_1>>>traffic>>>uplink"
+//   - value: 累加的值
+func (s *StatsServer) Add(name string, value int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters[name] += value
+}
+
+// Serve 在 listener 上提供 StatsService，直到 Stop 被调用
+// 参数:
+//   - listener: 监听器，例如 bufconn.Listener
+//
+// 返回值:
+//   - error: 错误信息，如果监听失败或已经停止
+func (s *StatsServer) Serve(listener net.Listener) error {
+	return s.server.Serve(listener)
+}
+
+// Stop 停止 StatsService 并关闭所有连接
+func (s *StatsServer) Stop() {
+	s.server.Stop()
+}
+
+// query 返回名称包含 pattern 的计数器，按名称排序
+// 功能:
+//  1. 与 V2Ray 相同，pattern 为空时返回所有计数器
+//  2. reset 为 true 时读取后清零，清零后的计数器仍然返回
+func (s *StatsServer) query(pattern string, reset bool) []Stat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stats []Stat
+	for name, value := range s.counters {
+		if !strings.Contains(name, pattern) {
+			continue
+		}
+		stats = append(stats, Stat{Name: name, Value: value})
+		if reset {
+			s.counters[name] = 0
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
@@ -0,0 +1,180 @@
+package localv2ray
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// TestQueryStatsEncoding 手写的 QueryStatsRequest、QueryStatsResponse 编码与 command.proto 定义的官方编码逐字节相同，并能互相解析
+func TestQueryStatsEncoding(t *testing.T) {
+	file := protoFile(t, "app/stats/command/command.proto", "v2ray.core.app.stats.command", map[string][]protoField{
+		"QueryStatsRequest": {
+			{name: "pattern", number: 1, kind: descriptorpb.FieldDescriptorProto_TYPE_STRING},
+			{name: "reset", number: 2, kind: descriptorpb.FieldDescriptorProto_TYPE_BOOL},
+		},
+		"Stat": {
+			{name: "name", number: 1, kind: descriptorpb.FieldDescriptorProto_TYPE_STRING},
+			{name: "value", number: 2, kind: descriptorpb.FieldDescriptorProto_TYPE_INT64},
+		},
+		"QueryStatsResponse": {
+			{name: "stat", number: 1, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, message: "v2ray.core.app.stats.command.Stat", repeated: true},
+		},
+	})
+
+	requests := []struct {
+		name    string
+		request queryStatsRequest
+		json    string
+	}{
+		{"empty", queryStatsRequest{}, `{}`},
+		{"pattern", queryStatsRequest{Pattern: ">>>traffic>>>"}, `{"pattern": ">>>traffic>>>"}`},
+		{"pattern and reset", queryStatsRequest{Pattern: ">>>traffic>>>", Reset: true}, `{"pattern": ">>>traffic>>>", "reset": true}`},
+	}
+	for _, tt := range requests {
+		t.Run("request "+tt.name, func(t *testing.T) {
+			want := protoMarshal(t, file, "QueryStatsRequest", tt.json)
+			if got := tt.request.marshal(); !bytes.Equal(got, want) {
+				t.Errorf("marshal() = %x, want %x", got, want)
+			}
+			var got queryStatsRequest
+			if err := got.unmarshal(want); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.request {
+				t.Errorf("unmarshal() = %+v, want %+v", got, tt.request)
+			}
+		})
+	}
+
+	responses := []struct {
+		name     string
+		response queryStatsResponse
+		json     string
+	}{
+		{"empty", queryStatsResponse{}, `{}`},
+		{
+			"stats",
+			queryStatsResponse{Stats: []Stat{
+				{Name: "outbound>>>out_aws_ap_east_1>>>traffic>>>uplink", Value: 300},
+				{Name: "user>>>alice@example.com>>>traffic>>>downlink", Value: 1 << 40},
+				{Name: "inbound>>>api>>>traffic>>>uplink", Value: 0},
+			}},
+			`{"stat": [
+				{"name": "outbound>>>out_aws_ap_east_1>>>traffic>>>uplink", "value": "300"},
+				{"name": "user>>>alice@example.com>>>traffic>>>downlink", "value": "1099511627776"},
+				{"name": "inbound>>>api>>>traffic>>>uplink"}
+			]}`,
+		},
+	}
+	for _, tt := range responses {
+		t.Run("response "+tt.name, func(t *testing.T) {
+			want := protoMarshal(t, file, "QueryStatsResponse", tt.json)
+			if got := tt.response.marshal(); !bytes.Equal(got, want) {
+				t.Errorf("marshal() = %x, want %x", got, want)
+			}
+			var got queryStatsResponse
+			if err := got.unmarshal(want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.response) {
+				t.Errorf("unmarshal() = %+v, want %+v", got, tt.response)
+			}
+		})
+	}
+}
+
+// TestQueryStatsResponseUnknownFields 解析响应时跳过未使用的字段，截断的消息返回错误
+func TestQueryStatsResponseUnknownFields(t *testing.T) {
+	// stat { name: "a" value: 1 unknown(3): "x" }, unknown(2): 7
+	data := []byte{0x0a, 0x08, 0x0a, 0x01, 'a', 0x10, 0x01, 0x1a, 0x01, 'x', 0x10, 0x07}
+	var response queryStatsResponse
+	if err := response.unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if want := []Stat{{Name: "a", Value: 1}}; !reflect.DeepEqual(response.Stats, want) {
+		t.Errorf("stats = %+v, want %+v", response.Stats, want)
+	}
+
+	if err := new(queryStatsResponse).unmarshal(data[:4]); err == nil {
+		t.Error("unmarshal() of a truncated message succeeded")
+	}
+}
+
+// TestParseStatName 只接受 inbound、outbound、user 的 uplink、downlink 流量计数器
+func TestParseStatName(t *testing.T) {
+	tests := []struct {
+		name          string
+		wantKind      string
+		wantTag       string
+		wantDirection string
+		wantOK        bool
+	}{
+		{"outbound>>>out_aws_ap_east_1>>>traffic>>>uplink", StatKindOutbound, "out_aws_ap_east_1", DirectionUplink, true},
+		{"user>>>alice@example.com>>>traffic>>>downlink", StatKindUser, "alice@example.com", DirectionDownlink, true},
+		{"inbound>>>api>>>traffic>>>downlink", StatKindInbound, "api", DirectionDownlink, true},
+		{"outbound>>>>>>traffic>>>uplink", "", "", "", false},
+		{"outbound>>>out>>>traffic>>>sideways", "", "", "", false},
+		{"outbound>>>out>>>bytes>>>uplink", "", "", "", false},
+		{"balancer>>>out>>>traffic>>>uplink", "", "", "", false},
+		{"outbound>>>out>>>traffic", "", "", "", false},
+		{"outbound>>>out>>>traffic>>>uplink>>>extra", "", "", "", false},
+		{"", "", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, tag, direction, ok := ParseStatName(tt.name)
+			if kind != tt.wantKind || tag != tt.wantTag || direction != tt.wantDirection || ok != tt.wantOK {
+				t.Errorf("ParseStatName() = %q, %q, %q, %v, want %q, %q, %q, %v",
+					kind, tag, direction, ok, tt.wantKind, tt.wantTag, tt.wantDirection, tt.wantOK)
+			}
+		})
+	}
+}
+
+// TestQueryStats 通过 gRPC 调用进程内的 StatsService，按 pattern 过滤，reset 后计数器清零，
+// 每个代理程序使用各自的服务名
+func TestQueryStats(t *testing.T) {
+	for _, name := range []string{"v2ray", "xray", "sing-box"} {
+		t.Run(name, func(t *testing.T) {
+			engine, err := NewEngine(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := NewStatsServer(engine)
+			client := serveBufconn(t, engine, server.Serve, server.Stop)
+			server.Add("outbound>>>out_aws_ap_east_1>>>traffic>>>uplink", 100)
+			server.Add("outbound>>>out_aws_ap_east_1>>>traffic>>>uplink", 50)
+			server.Add("user>>>alice>>>traffic>>>downlink", 200)
+			server.Add("inbound>>>api>>>traffic>>>uplink", 10)
+
+			ctx := context.Background()
+			stats, err := client.QueryStats(ctx, "outbound>>>", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []Stat{{Name: "outbound>>>out_aws_ap_east_1>>>traffic>>>uplink", Value: 150}}; !reflect.DeepEqual(stats, want) {
+				t.Errorf("QueryStats(outbound) = %+v, want %+v", stats, want)
+			}
+
+			stats, err = client.QueryStats(ctx, "", true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stats) != 3 {
+				t.Errorf("QueryStats(all, reset) returned %d stats, want 3: %+v", len(stats), stats)
+			}
+
+			stats, err = client.QueryStats(ctx, "user>>>", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []Stat{{Name: "user>>>alice>>>traffic>>>downlink"}}; !reflect.DeepEqual(stats, want) {
+				t.Errorf("QueryStats(user) after reset = %+v, want %+v", stats, want)
+			}
+		})
+	}
+}
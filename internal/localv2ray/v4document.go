@@ -0,0 +1,108 @@
+package localv2ray
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// v4Document V2Ray v4 JSON 格式的配置，V2Ray 和 Xray 使用
+// 功能:
+//  1. 每个实例一个 vmess 出站，区域负载均衡器按标签前缀选择出站，区域用户为 vmess 入站中的 client
+//  2. 出站和用户的变更可以通过 HandlerService 应用，路由、负载均衡器和新建的入站需要重启服务
+type v4Document struct {
+	config *V2RayConfig
+}
+
+// parseV4Document 解析 V2Ray v4 JSON 格式的配置
+// 参数:
+//   - data: 配置文件内容
+//
+// 返回值:
+//   - document: 解析后的配置
+//   - error: 错误信息，如果不是有效的 JSON
+func parseV4Document(data []byte) (document, error) {
+	var config V2RayConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	config.source = data
+	return &v4Document{config: &config}, nil
+}
+
+func (d *v4Document) marshal() ([]byte, error) {
+	return marshalIndented(d.config, d.config.source)
+}
+
+func (d *v4Document) relayOutbounds() ([]RelayOutbound, error) {
+	var relays []RelayOutbound
+	for _, outbound := range d.config.Outbounds {
+		if _, _, ok := ParseRelayTag(outbound.Tag); !ok {
+			continue
+		}
+		relay, err := relayOutbound(outbound)
+		if err != nil {
+			return nil, err
+		}
+		relays = append(relays, relay)
+	}
+	return relays, nil
+}
+
+func (d *v4Document) relayConfig(region string) (int, string, error) {
+	expectedEmail := RelayUser(region)
+
+	for _, inbound := range d.config.Inbounds {
+		if inbound.Protocol == "vmess" && inbound.Settings != nil {
+			for _, client := range inbound.Settings.Clients {
+				if client.Email == expectedEmail {
+					return inbound.Port, client.ID, nil
+				}
+			}
+		}
+	}
+
+	return 0, "", fmt.Errorf("relay config not found for region %s", region)
+}
+
+// upsertOutbound 添加或更新实例出站，REALITY 出站不能通过 HandlerService 添加，需要重启服务
+func (d *v4Document) upsertOutbound(relay RelayOutbound, changes *runtimeChanges) {
+	if relay.Reality != nil {
+		upsertOutbound(d.config, newRealityOutbound(relay.Tag, relay.Address, relay.Port, relay.UUID, *relay.Reality))
+		changes.restart = true
+		return
+	}
+	outbound := upsertOutbound(d.config, newVmessOutbound(relay.Tag, relay.Address, relay.Port, relay.UUID))
+	changes.upserts = append(changes.upserts, outbound)
+}
+
+func (d *v4Document) removeOutbound(tag string, changes *runtimeChanges) bool {
+	if !removeRelayOutbound(d.config, tag) {
+		return false
+	}
+	changes.removals = append(changes.removals, tag)
+	return true
+}
+
+func (d *v4Document) hasRegionOutbounds(region string) bool {
+	return hasRegionOutbounds(d.config, region)
+}
+
+func (d *v4Document) ensureRoute(region string, options Options, changes *runtimeChanges) {
+	if ensureRelayRoute(d.config, region, options) {
+		changes.restart = true
+	}
+}
+
+// removeRoute 删除区域的路由规则和负载均衡器，不要求重启：
+// 运行中的规则在下次重启前仍然存在，但已没有可选的出站
+func (d *v4Document) removeRoute(region string, changes *runtimeChanges) bool {
+	return removeRelayRoute(d.config, region)
+}
+
+func (d *v4Document) ensureUser(region string, options Options, changes *runtimeChanges) error {
+	return ensureRelayUser(d.config, region, options, changes)
+}
+
+func (d *v4Document) removeUser(region string, changes *runtimeChanges) {
+	removeRelayUser(d.config, region, changes)
+}
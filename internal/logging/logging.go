@@ -9,6 +9,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/yuhai94/anywhere_backend/internal/config"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -18,7 +19,11 @@ const (
 	InstanceIDKey = "instance_id"
 )
 
-var logger *zap.Logger
+var (
+	// logger 全局日志器，Init 之前（例如测试中）不输出
+	logger = zap.NewNop()
+	level  = zap.NewAtomicLevel()
+)
 
 // Init 初始化日志系统
 // 参数:
@@ -29,33 +34,26 @@ var logger *zap.Logger
 //
 // 功能:
 //  1. 根据配置文件选择日志格式（JSON 或开发模式）
-//  2. 根据配置文件设置日志级别
+//  2. 根据配置文件设置日志级别，配置重新加载时同步更新
 //  3. 如果配置了日志文件路径，创建日志目录并设置输出路径
-//  4. 构建并初始化全局日志器
+//  4. 构建并初始化全局日志器，所有日志在写入前对配置中的敏感值脱敏
 func Init(logDir string) error {
 	var zapConfig zap.Config
 
-	if config.AppConfig.Logging.Format == "json" {
+	if config.Get().Logging.Format == "json" {
 		zapConfig = zap.NewProductionConfig()
 	} else {
 		zapConfig = zap.NewDevelopmentConfig()
 	}
 
-	// Set log level
-	switch config.AppConfig.Logging.Level {
-	case "debug":
-		zapConfig.Level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
-	case "info":
-		zapConfig.Level = zap.NewAtomicLevelAt(zapcore.InfoLevel)
-	case "warn":
-		zapConfig.Level = zap.NewAtomicLevelAt(zapcore.WarnLevel)
-	case "error":
-		zapConfig.Level = zap.NewAtomicLevelAt(zapcore.ErrorLevel)
-	case "fatal":
-		zapConfig.Level = zap.NewAtomicLevelAt(zapcore.FatalLevel)
-	default:
-		zapConfig.Level = zap.NewAtomicLevelAt(zapcore.InfoLevel)
-	}
+	// Set log level. AtomicLevel 可以在重新加载配置后直接修改
+	level.SetLevel(parseLevel(config.Get().Logging.Level))
+	zapConfig.Level = level
+	SetSecrets(config.SecretValues(config.Get()))
+	config.OnReload(func(old, new *config.Config) {
+		level.SetLevel(parseLevel(new.Logging.Level))
+		SetSecrets(config.SecretValues(new))
+	})
 
 	// Set output path
 	if err := os.MkdirAll(logDir, 0755); err != nil {
@@ -67,7 +65,9 @@ func Init(logDir string) error {
 
 	// Build logger
 	var err error
-	logger, err = zapConfig.Build()
+	logger, err = zapConfig.Build(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return redactCore{core}
+	}))
 	if err != nil {
 		return fmt.Errorf("failed to build logger: %v", err)
 	}
@@ -75,6 +75,22 @@ func Init(logDir string) error {
 	return nil
 }
 
+// parseLevel 将配置中的日志级别转换为 zap 日志级别，无法识别时使用 info
+func parseLevel(name string) zapcore.Level {
+	switch name {
+	case "debug":
+		return zapcore.DebugLevel
+	case "warn":
+		return zapcore.WarnLevel
+	case "error":
+		return zapcore.ErrorLevel
+	case "fatal":
+		return zapcore.FatalLevel
+	default:
+		return zapcore.InfoLevel
+	}
+}
+
 // WithRequestID 为上下文添加请求 ID
 // 参数:
 //   - ctx: 原始上下文
@@ -91,6 +107,31 @@ func WithRequestID(ctx context.Context) context.Context {
 	return context.WithValue(ctx, RequestIDKey, requestID)
 }
 
+// WithExistingRequestID 为上下文添加已有的请求 ID
+// 参数:
+//   - ctx: 原始上下文
+//   - requestID: 已有的请求 ID
+//
+// 返回值:
+//   - context.Context: 带有请求 ID 的新上下文
+//
+// 功能:
+//  1. 将已有的请求 ID 添加到上下文中，用于异步任务沿用原始请求的 ID
+func WithExistingRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, RequestIDKey, requestID)
+}
+
+// GetRequestID 从上下文中获取请求 ID
+// 参数:
+//   - ctx: 上下文
+//
+// 返回值:
+//   - string: 请求 ID，如果上下文中没有则返回空字符串
+func GetRequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(RequestIDKey).(string)
+	return requestID
+}
+
 // WithInstanceID 为上下文添加实例 ID
 // 参数:
 //   - ctx: 原始上下文
@@ -117,7 +158,8 @@ func WithInstanceID(ctx context.Context, instanceID string) context.Context {
 //  1. 创建一个带有时间戳的基础日志器
 //  2. 如果上下文中有请求 ID，添加到日志器
 //  3. 如果上下文中有实例 ID，添加到日志器
-//  4. 返回配置好的日志器
+//  4. 如果上下文中有追踪 span，添加 trace_id 和 span_id
+//  5. 返回配置好的日志器
 func FromContext(ctx context.Context) *zap.Logger {
 	l := logger.With(zap.String("timestamp", time.Now().Format(time.RFC3339)))
 
@@ -129,6 +171,10 @@ func FromContext(ctx context.Context) *zap.Logger {
 		l = l.With(zap.Int("instance_id", instanceID))
 	}
 
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		l = l.With(zap.String("trace_id", sc.TraceID().String()), zap.String("span_id", sc.SpanID().String()))
+	}
+
 	return l
 }
 
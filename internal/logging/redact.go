@@ -0,0 +1,116 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// redactedText 替换敏感值的文本
+const redactedText = "******"
+
+// minSecretLength 参与脱敏的最短值，避免过短的值替换掉日志中的普通文本
+const minSecretLength = 4
+
+// redactor 当前使用的替换器，为 nil 时不做替换
+var redactor atomic.Pointer[strings.Replacer]
+
+// SetSecrets 设置需要在日志中脱敏的值
+// 参数:
+//   - secrets: 敏感值列表，通常为 config.SecretValues 的结果，会替换之前设置的列表
+func SetSecrets(secrets []string) {
+	values := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		if len(secret) >= minSecretLength {
+			values = append(values, secret)
+		}
+	}
+	if len(values) == 0 {
+		redactor.Store(nil)
+		return
+	}
+
+	// 先匹配较长的值，避免一个密钥是另一个的前缀时只替换一部分
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	pairs := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		pairs = append(pairs, value, redactedText)
+	}
+	redactor.Store(strings.NewReplacer(pairs...))
+}
+
+// Redact 将文本中的敏感值替换为 ******
+// 参数:
+//   - s: 原始文本
+//
+// 返回值:
+//   - string: 脱敏后的文本
+func Redact(s string) string {
+	if r := redactor.Load(); r != nil {
+		return r.Replace(s)
+	}
+	return s
+}
+
+// redactCore 在写入前对日志消息和字段脱敏的 zapcore.Core
+type redactCore struct {
+	zapcore.Core
+}
+
+func (c redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return redactCore{c.Core.With(redactFields(fields))}
+}
+
+func (c redactCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c redactCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = Redact(entry.Message)
+	return c.Core.Write(entry, redactFields(fields))
+}
+
+// redactFields 对字段中的敏感值脱敏
+// 功能:
+//  1. 字符串、错误和 Stringer 字段直接替换
+//  2. 其他复杂类型序列化为 JSON 后检查，包含敏感值时改为脱敏后的字符串
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	if redactor.Load() == nil {
+		return fields
+	}
+
+	redacted := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		redacted[i] = field
+		switch field.Type {
+		case zapcore.StringType:
+			redacted[i].String = Redact(field.String)
+		case zapcore.ErrorType:
+			if err, ok := field.Interface.(error); ok {
+				redacted[i] = zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: Redact(err.Error())}
+			}
+		case zapcore.StringerType:
+			if stringer, ok := field.Interface.(fmt.Stringer); ok {
+				if text := stringer.String(); Redact(text) != text {
+					redacted[i] = zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: Redact(text)}
+				}
+			}
+		case zapcore.ReflectType:
+			data, err := json.Marshal(field.Interface)
+			if err != nil {
+				continue
+			}
+			if text := Redact(string(data)); text != string(data) {
+				redacted[i] = zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: text}
+			}
+		}
+	}
+	return redacted
+}
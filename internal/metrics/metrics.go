@@ -0,0 +1,235 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+)
+
+const namespace = "anywhere"
+
+var (
+	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Total number of HTTP requests handled by the API server.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency in seconds.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	instanceOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "instance",
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of asynchronous instance create/delete operations in seconds.",
+		Buckets:   []float64{10, 30, 60, 90, 120, 180, 240, 300, 450, 600},
+	}, []string{"operation", "region", "result"})
+
+	ec2CallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "ec2",
+		Name:      "call_duration_seconds",
+		Help:      "Latency of EC2 API calls in seconds.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "region"})
+
+	ec2CallErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "ec2",
+		Name:      "call_errors_total",
+		Help:      "Total number of failed EC2 API calls.",
+	}, []string{"operation", "region"})
+
+	syncDriftTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "sync",
+		Name:      "drift_total",
+		Help:      "Total number of differences between AWS and the database found by the sync task.",
+	}, []string{"kind"})
+
+	v2rayRestartsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "local_v2ray",
+		Name:      "restarts_total",
+		Help:      "Total number of local V2Ray service restarts.",
+	}, []string{"result"})
+
+	v2rayHotAppliesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "local_v2ray",
+		Name:      "hot_applies_total",
+		Help:      "Total number of outbound changes applied through the local V2Ray API without a restart.",
+	}, []string{"result"})
+)
+
+// 同步任务发现的差异类型
+const (
+	DriftUntracked     = "untracked"
+	DriftMissing       = "missing"
+	DriftStatusChanged = "status_changed"
+	DriftIPChanged     = "ip_changed"
+)
+
+func init() {
+	prometheus.MustRegister(
+		httpRequestsTotal,
+		httpRequestDuration,
+		instanceOperationDuration,
+		ec2CallDuration,
+		ec2CallErrors,
+		syncDriftTotal,
+		v2rayRestartsTotal,
+		v2rayHotAppliesTotal,
+	)
+}
+
+// Handler 返回 Prometheus 指标的 HTTP 处理器
+// 返回值:
+//   - http.Handler: 输出默认注册表中所有指标的处理器
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// GinMiddleware 创建记录 HTTP 请求指标的 Gin 中间件
+// 返回值:
+//   - gin.HandlerFunc: Gin 中间件
+//
+// 功能:
+//  1. 记录请求开始时间并执行后续处理器
+//  2. 使用路由模板（而不是实际路径）作为标签，避免 UUID 造成标签爆炸
+//  3. 记录请求总数和请求耗时
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestsTotal.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpRequestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveInstanceOperation 记录一次实例异步操作的耗时
+// 参数:
+//   - operation: 操作类型（create 或 delete）
+//   - region: AWS 区域
+//   - start: 操作开始时间
+//   - success: 操作是否成功
+func ObserveInstanceOperation(operation, region string, start time.Time, success bool) {
+	result := "success"
+	if !success {
+		result = "error"
+	}
+	instanceOperationDuration.WithLabelValues(operation, region, result).Observe(time.Since(start).Seconds())
+}
+
+// ObserveEC2Call 记录一次 EC2 API 调用的耗时和错误
+// 参数:
+//   - operation: EC2 操作类型，与 logging.EC2Log 中的操作名一致
+//   - region: AWS 区域
+//   - start: 调用开始时间
+//   - err: 调用返回的错误，为 nil 表示成功
+func ObserveEC2Call(operation, region string, start time.Time, err error) {
+	ec2CallDuration.WithLabelValues(operation, region).Observe(time.Since(start).Seconds())
+	if err != nil {
+		ec2CallErrors.WithLabelValues(operation, region).Inc()
+	}
+}
+
+// IncSyncDrift 记录同步任务发现的一次差异
+// 参数:
+//   - kind: 差异类型，取值为 Drift* 常量
+func IncSyncDrift(kind string) {
+	syncDriftTotal.WithLabelValues(kind).Inc()
+}
+
+// IncV2RayRestart 记录一次本地 V2Ray 服务重启
+// 参数:
+//   - err: 重启返回的错误，为 nil 表示成功
+func IncV2RayRestart(err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	v2rayRestartsTotal.WithLabelValues(result).Inc()
+}
+
+// IncV2RayHotApply 记录一次通过本地 V2Ray API 应用出站变更的尝试
+// 参数:
+//   - err: 调用返回的错误，为 nil 表示成功，失败后会改为重启服务
+func IncV2RayHotApply(err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	v2rayHotAppliesTotal.WithLabelValues(result).Inc()
+}
+
+// InstanceLister 定义实例数量统计所需的数据源
+type InstanceLister interface {
+	List(ctx context.Context) ([]*models.V2RayInstance, error)
+}
+
+var instanceCountDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "instance", "count"),
+	"Number of non-deleted instances by region and status.",
+	[]string{"region", "status"}, nil,
+)
+
+// instanceCollector 在每次抓取时从数据库统计实例数量
+type instanceCollector struct {
+	lister InstanceLister
+}
+
+// Describe 实现 prometheus.Collector 接口
+func (c *instanceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- instanceCountDesc
+}
+
+// Collect 实现 prometheus.Collector 接口
+func (c *instanceCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	instances, err := c.lister.List(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(instanceCountDesc, err)
+		return
+	}
+
+	type key struct{ region, status string }
+	counts := make(map[key]int)
+	for _, instance := range instances {
+		counts[key{instance.EC2Region, instance.Status}]++
+	}
+
+	for k, count := range counts {
+		ch <- prometheus.MustNewConstMetric(instanceCountDesc, prometheus.GaugeValue, float64(count), k.region, k.status)
+	}
+}
+
+// RegisterInstanceCollector 注册按区域和状态统计实例数量的采集器
+// 参数:
+//   - lister: 实例数据源，通常为 Repository
+//
+// 返回值:
+//   - error: 错误信息，如果注册失败
+func RegisterInstanceCollector(lister InstanceLister) error {
+	return prometheus.Register(&instanceCollector{lister: lister})
+}
@@ -1,10 +1,14 @@
 package models
 
 import (
+	"database/sql"
 	"database/sql/driver"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -48,6 +52,9 @@ type V2RayInstance struct {
 	EC2ID         string     `db:"ec2_id" json:"ec2_id"`
 	EC2Region     string     `db:"ec2_region" json:"ec2_region"`
 	EC2RegionName string     `db:"-" json:"ec2_region_name"`
+	EC2Account    string     `db:"ec2_account" json:"ec2_account"`
+	InstanceType  string     `db:"instance_type" json:"instance_type"`
+	Owner         string     `db:"owner" json:"owner"`
 	EC2PublicIP   string     `db:"ec2_public_ip" json:"ec2_public_ip"`
 	Status        string     `db:"status" json:"status"`
 	DirectLink    string     `db:"direct_link" json:"direct_link"`
@@ -55,11 +62,71 @@ type V2RayInstance struct {
 	CreatedAt     CustomTime `db:"created_at" json:"created_at"`
 	UpdatedAt     CustomTime `db:"updated_at" json:"updated_at"`
 	IsDeleted     bool       `db:"is_deleted" json:"-"`
+
+	// Protocol 节点协议，vmess 或 vless-reality
+	Protocol string `db:"protocol" json:"protocol"`
+	// RealityPrivateKey、RealityPublicKey 节点 REALITY 的 x25519 密钥对，base64url 编码，私钥只写入节点配置
+	RealityPrivateKey string `db:"reality_private_key" json:"-"`
+	RealityPublicKey  string `db:"reality_public_key" json:"reality_public_key,omitempty"`
+	// RealityShortIDs 节点接受的 short ID，逗号分隔，链接和中转出站使用第一个
+	RealityShortIDs string `db:"reality_short_ids" json:"reality_short_ids,omitempty"`
+	// RealityServerName、RealityDest 创建节点时的 SNI 和回落网站
+	RealityServerName string `db:"reality_server_name" json:"reality_server_name,omitempty"`
+	RealityDest       string `db:"reality_dest" json:"reality_dest,omitempty"`
+}
+
+// IsReality 返回节点是否使用 VLESS + REALITY
+func (i *V2RayInstance) IsReality() bool {
+	return i.Protocol == ProtocolVLESSReality
+}
+
+// RealityShortID 返回链接和中转出站使用的 short ID，即第一个 short ID
+func (i *V2RayInstance) RealityShortID() string {
+	shortID, _, _ := strings.Cut(i.RealityShortIDs, ",")
+	return shortID
+}
+
+// 节点协议
+const (
+	ProtocolVMess        = "vmess"
+	ProtocolVLESSReality = "vless-reality"
+)
+
+// UsageRecord 实例的一段运行时间，附带计费所需的实例信息
+type UsageRecord struct {
+	InstanceUUID string    `db:"instance_uuid"`
+	Region       string    `db:"ec2_region"`
+	Owner        string    `db:"owner"`
+	InstanceType string    `db:"instance_type"`
+	StartedAt    time.Time `db:"started_at"`
+	// StoppedAt 为空表示实例仍在运行
+	StoppedAt sql.NullTime `db:"stopped_at"`
 }
 
+// TrafficRollup 一个统计对象在一个时间段内的流量，单位为字节
+type TrafficRollup struct {
+	Kind string `db:"kind" json:"kind"`
+	Name string `db:"name" json:"name"`
+	// Period 时间段的开始时间，按小时或按天对齐
+	Period   time.Time `db:"period" json:"period"`
+	Uplink   int64     `db:"uplink" json:"uplink"`
+	Downlink int64     `db:"downlink" json:"downlink"`
+}
+
+// 流量统计对象的类型
+const (
+	// TrafficOutbound 中转出站，名称为出站标签 out_aws_<region>
+	TrafficOutbound = "outbound"
+	// TrafficUser 中转入站的用户，名称为用户 email
+	TrafficUser = "user"
+	// TrafficInstance AWS 实例，名称为实例 UUID，由采集时区域内运行中的实例承担该区域出站的流量
+	TrafficInstance = "instance"
+)
+
 type Region struct {
 	Region string `json:"region"`
 	Name   string `json:"name"`
+	Alias  string `json:"alias,omitempty"`
 }
 
 const (
@@ -71,6 +138,145 @@ const (
 	StatusError    = "error"
 )
 
+// 实例列表的排序字段，前缀 - 表示倒序
+const (
+	InstanceSortCreatedAt = "created_at"
+	InstanceSortUpdatedAt = "updated_at"
+	InstanceSortRegion    = "region"
+	InstanceSortStatus    = "status"
+)
+
+// InstanceQuery 实例列表的过滤、排序和分页条件
+type InstanceQuery struct {
+	// Statuses 只返回这些状态的实例，为空时不限
+	Statuses []string
+	// Region 只返回该区域的实例，为空时不限
+	Region string
+	// Owner 只返回该创建者的实例，为空时不限
+	Owner string
+	// CreatedAfter 只返回在该时间之后创建的实例，为零值时不限
+	CreatedAfter time.Time
+	// IncludeDeleted 同时返回已删除的实例
+	IncludeDeleted bool
+	// DeletedOnly 只返回已删除的实例，不能与 IncludeDeleted 同时使用
+	DeletedOnly bool
+	// Sort 排序字段，例如 created_at 或 -created_at，为空时为 -created_at
+	Sort string
+	// Limit 每页的实例数量，为 0 时使用默认值
+	Limit int
+	// Cursor 上一页返回的 next_cursor，为空时从第一页开始
+	Cursor string
+}
+
+// InstancePage 一页实例列表
+type InstancePage struct {
+	Instances []*V2RayInstance `json:"instances"`
+	// NextCursor 下一页的游标，没有下一页时为空
+	NextCursor string `json:"next_cursor"`
+	// Total 满足过滤条件的实例总数，与分页无关
+	Total int `json:"total"`
+}
+
+// LifecycleEvent 实例生命周期事件，记录状态、IP 和链接的变化
+type LifecycleEvent struct {
+	ID           int64      `db:"id" json:"id"`
+	InstanceUUID string     `db:"instance_uuid" json:"instance_uuid"`
+	Region       string     `db:"region" json:"region"`
+	Type         string     `db:"type" json:"type"`
+	Status       string     `db:"status" json:"status,omitempty"`
+	PublicIP     string     `db:"public_ip" json:"public_ip,omitempty"`
+	DirectLink   string     `db:"direct_link" json:"direct_link,omitempty"`
+	RelayLink    string     `db:"relay_link" json:"relay_link,omitempty"`
+	Source       string     `db:"source" json:"source"`
+	CreatedAt    CustomTime `db:"created_at" json:"created_at"`
+}
+
+const (
+	EventStatusChanged = "status_changed"
+	EventIPChanged     = "ip_changed"
+	EventLinksChanged  = "links_changed"
+	EventExpiring      = "expiring"
+)
+
+const (
+	EventSourceService = "service"
+	EventSourceSync    = "sync"
+)
+
+// InstanceEvent 实例历史中的一次状态变化，由仓库层在写入实例记录时追加，实例删除后仍然保留
+type InstanceEvent struct {
+	ID           int64      `db:"id" json:"id"`
+	InstanceUUID string     `db:"instance_uuid" json:"instance_uuid"`
+	Region       string     `db:"region" json:"region"`
+	Type         string     `db:"type" json:"type"`
+	FromStatus   string     `db:"from_status" json:"from_status"`
+	ToStatus     string     `db:"to_status" json:"to_status"`
+	PublicIP     string     `db:"public_ip" json:"public_ip"`
+	Cause        string     `db:"cause" json:"cause"`
+	CreatedAt    CustomTime `db:"created_at" json:"created_at"`
+	// DurationSeconds 实例停留在 ToStatus 的秒数，即到下一条事件的时间，最后一条事件计算到当前时间，deleted 为 0
+	DurationSeconds int64 `db:"-" json:"duration_seconds"`
+}
+
+// 实例历史事件类型
+const (
+	InstanceEventCreated       = "created"
+	InstanceEventStatusChanged = "status_changed"
+	InstanceEventIPChanged     = "ip_changed"
+	InstanceEventDeleted       = "deleted"
+)
+
+// InstanceTimeline 实例及其按时间排列的历史事件
+type InstanceTimeline struct {
+	Instance *V2RayInstance   `json:"instance"`
+	Events   []*InstanceEvent `json:"events"`
+}
+
+// Webhook 已注册的 webhook 订阅
+type Webhook struct {
+	ID        int        `db:"id" json:"id"`
+	URL       string     `db:"url" json:"url"`
+	Secret    string     `db:"secret" json:"secret,omitempty"`
+	Events    string     `db:"events" json:"-"`
+	EventList []string   `db:"-" json:"events"`
+	Enabled   bool       `db:"enabled" json:"enabled"`
+	CreatedAt CustomTime `db:"created_at" json:"created_at"`
+	UpdatedAt CustomTime `db:"updated_at" json:"updated_at"`
+}
+
+// WebhookDelivery 一次 webhook 投递记录
+type WebhookDelivery struct {
+	ID             int64      `db:"id" json:"id"`
+	WebhookID      int        `db:"webhook_id" json:"webhook_id"`
+	EventID        string     `db:"event_id" json:"event_id"`
+	EventType      string     `db:"event_type" json:"event_type"`
+	Payload        string     `db:"payload" json:"payload"`
+	Status         string     `db:"status" json:"status"`
+	Attempts       int        `db:"attempts" json:"attempts"`
+	ResponseStatus int        `db:"response_status" json:"response_status"`
+	LastError      string     `db:"last_error" json:"last_error"`
+	NextAttemptAt  CustomTime `db:"next_attempt_at" json:"next_attempt_at"`
+	CreatedAt      CustomTime `db:"created_at" json:"created_at"`
+	UpdatedAt      CustomTime `db:"updated_at" json:"updated_at"`
+}
+
+// Webhook 事件类型
+const (
+	WebhookInstanceCreated  = "instance.created"
+	WebhookInstanceRunning  = "instance.running"
+	WebhookInstanceError    = "instance.error"
+	WebhookInstanceDeleted  = "instance.deleted"
+	WebhookDriftDetected    = "drift.detected"
+	WebhookInstanceExpiring = "instance.expiring"
+)
+
+// Webhook 投递状态
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
 type VMessConfig struct {
 	Add  string `json:"add"`
 	Aid  string `json:"aid"`
@@ -116,3 +322,46 @@ func GenerateVMessLink(add, id, port, ps string) (string, error) {
 	base64Data := base64.StdEncoding.EncodeToString(jsonData)
 	return "vmess://" + base64Data, nil
 }
+
+// RealityParams 客户端连接 REALITY 节点使用的参数
+type RealityParams struct {
+	// PublicKey 节点的 x25519 公钥，链接中的 pbk
+	PublicKey string
+	// ShortID 链接中的 sid
+	ShortID string
+	// ServerName 链接中的 sni
+	ServerName string
+	// Fingerprint 客户端模拟的 TLS 指纹，链接中的 fp
+	Fingerprint string
+}
+
+// GenerateVLESSRealityLink 生成 VLESS + REALITY 节点的 vless:// 分享链接
+// 参数:
+//   - add: 节点地址
+//   - id: VLESS 用户 ID
+//   - port: 节点端口
+//   - ps: 备注
+//   - reality: REALITY 参数
+//
+// 返回值:
+//   - string: vless://<id>@<add>:<port>?...#<ps>，使用 xtls-rprx-vision 流控
+func GenerateVLESSRealityLink(add, id, port, ps string, reality RealityParams) string {
+	query := url.Values{}
+	query.Set("encryption", "none")
+	query.Set("flow", "xtls-rprx-vision")
+	query.Set("security", "reality")
+	query.Set("sni", reality.ServerName)
+	query.Set("fp", reality.Fingerprint)
+	query.Set("pbk", reality.PublicKey)
+	query.Set("sid", reality.ShortID)
+	query.Set("type", "tcp")
+
+	link := url.URL{
+		Scheme:   "vless",
+		User:     url.User(id),
+		Host:     net.JoinHostPort(add, port),
+		RawQuery: query.Encode(),
+		Fragment: ps,
+	}
+	return link.String()
+}
@@ -0,0 +1,161 @@
+package qrcode
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	goqrcode "github.com/skip2/go-qrcode"
+)
+
+// DefaultSize 默认的 PNG 边长（像素）
+const DefaultSize = 512
+
+// 支持的输出格式
+const (
+	FormatPNG  = "png"
+	FormatSVG  = "svg"
+	FormatANSI = "ansi"
+)
+
+// svgModuleSize SVG 中每个模块的边长，图片可以无损缩放，只影响默认显示大小
+const svgModuleSize = 8
+
+// PNG 将内容编码为 PNG 格式的二维码
+// 参数:
+//   - content: 要编码的内容，例如 vmess 分享链接
+//   - size: 图片边长（像素），小于等于 0 时使用 DefaultSize
+//
+// 返回值:
+//   - []byte: PNG 图片数据
+//   - error: 错误信息，如果内容过长无法编码
+//
+// 功能:
+//  1. 在进程内完成编码，不依赖任何外部服务
+//  2. 使用中等纠错级别，兼顾识别率和二维码密度
+func PNG(content string, size int) ([]byte, error) {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	png, err := goqrcode.Encode(content, goqrcode.Medium, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode qr code: %v", err)
+	}
+	return png, nil
+}
+
+// SVG 将内容编码为 SVG 格式的二维码
+// 参数:
+//   - content: 要编码的内容
+//
+// 返回值:
+//   - []byte: SVG 文档
+//   - error: 错误信息，如果内容过长无法编码
+//
+// 功能:
+//  1. 每行连续的深色模块合并为一个矩形，减小文档体积
+//  2. 设置 shape-rendering 避免缩放时出现缝隙
+func SVG(content string) ([]byte, error) {
+	bitmap, err := bitmap(content)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(bitmap)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" shape-rendering="crispEdges">`,
+		n, n, n*svgModuleSize, n*svgModuleSize)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y, row := range bitmap {
+		for x := 0; x < n; {
+			if !row[x] {
+				x++
+				continue
+			}
+			start := x
+			for x < n && row[x] {
+				x++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes(), nil
+}
+
+// ANSI 将内容渲染为可以直接输出到终端的二维码
+// 参数:
+//   - content: 要编码的内容
+//
+// 返回值:
+//   - string: 带 ANSI 颜色的文本，每个字符表示上下两个模块
+//   - error: 错误信息，如果内容过长无法编码
+//
+// 功能:
+//  1. 使用上半块字符，前景色表示上方模块，背景色表示下方模块
+//  2. 显式设置黑白两色，在深色和浅色终端中都能正常扫描
+func ANSI(content string) (string, error) {
+	bitmap, err := bitmap(content)
+	if err != nil {
+		return "", err
+	}
+
+	const (
+		black = 16
+		white = 231
+	)
+	color := func(dark bool) int {
+		if dark {
+			return black
+		}
+		return white
+	}
+
+	var sb strings.Builder
+	for y := 0; y < len(bitmap); y += 2 {
+		for x := range bitmap[y] {
+			bottom := false
+			if y+1 < len(bitmap) {
+				bottom = bitmap[y+1][x]
+			}
+			fmt.Fprintf(&sb, "\x1b[38;5;%dm\x1b[48;5;%dm▀", color(bitmap[y][x]), color(bottom))
+		}
+		sb.WriteString("\x1b[0m\n")
+	}
+	return sb.String(), nil
+}
+
+// Render 按指定格式渲染二维码
+// 参数:
+//   - content: 要编码的内容
+//   - format: 输出格式，FormatPNG、FormatSVG 或 FormatANSI，为空时使用 PNG
+//   - size: PNG 边长（像素），其他格式忽略
+//
+// 返回值:
+//   - []byte: 渲染结果
+//   - string: 对应的 Content-Type
+//   - error: 错误信息，如果格式不支持或编码失败
+func Render(content, format string, size int) ([]byte, string, error) {
+	switch format {
+	case "", FormatPNG:
+		data, err := PNG(content, size)
+		return data, "image/png", err
+	case FormatSVG:
+		data, err := SVG(content)
+		return data, "image/svg+xml", err
+	case FormatANSI:
+		text, err := ANSI(content)
+		return []byte(text), "text/plain; charset=utf-8", err
+	default:
+		return nil, "", fmt.Errorf("unsupported qr code format %q", format)
+	}
+}
+
+// bitmap 编码内容并返回包含静区的模块矩阵，true 表示深色模块
+func bitmap(content string) ([][]bool, error) {
+	code, err := goqrcode.New(content, goqrcode.Medium)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode qr code: %v", err)
+	}
+	return code.Bitmap(), nil
+}
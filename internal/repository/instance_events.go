@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+	"github.com/yuhai94/anywhere_backend/internal/tracing"
+)
+
+// eventCauseKey 上下文中实例历史事件原因的键
+type eventCauseKey struct{}
+
+// WithEventCause 设置之后写入实例记录时历史事件的原因
+// 参数:
+//   - ctx: 上下文
+//   - cause: 原因，例如 "delete requested" 或失败的错误信息
+//
+// 返回值:
+//   - context.Context: 带有原因的新上下文
+func WithEventCause(ctx context.Context, cause string) context.Context {
+	return context.WithValue(ctx, eventCauseKey{}, cause)
+}
+
+// eventCause 返回上下文中的事件原因，未设置时为空
+func eventCause(ctx context.Context) string {
+	cause, _ := ctx.Value(eventCauseKey{}).(string)
+	return cause
+}
+
+// instanceState 写入前实例记录的状态，用于判断写入是否产生状态或公网 IP 的变化
+type instanceState struct {
+	Region   string `db:"ec2_region"`
+	Status   string `db:"status"`
+	PublicIP string `db:"ec2_public_ip"`
+}
+
+// lockState 在事务中读取并锁定实例记录当前的状态
+// 参数:
+//   - ctx: 上下文
+//   - tx: 写入实例记录的事务
+//   - uuid: 实例 UUID
+//
+// 返回值:
+//   - *instanceState: 实例记录的区域、状态和公网 IP，记录不存在时为 nil
+//   - error: 错误信息，如果读取失败
+//
+// 功能:
+//  1. 使用 SELECT ... FOR UPDATE 锁定记录直到事务结束，并发写入同一实例时依次读取到前一次写入后的状态
+func lockState(ctx context.Context, tx *sqlx.Tx, uuid string) (*instanceState, error) {
+	var state instanceState
+	query := `SELECT ec2_region, status, ec2_public_ip FROM v2ray_instances WHERE uuid = ? ORDER BY id DESC LIMIT 1 FOR UPDATE`
+	err := tx.GetContext(ctx, &state, query, uuid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// writeWithHistory 在一个事务中写入实例记录，状态或公网 IP 变化时同时追加历史事件
+// 参数:
+//   - ctx: 上下文，事件原因来自 WithEventCause
+//   - uuid: 实例 UUID
+//   - next: 根据写入前的状态返回写入后的状态和公网 IP
+//   - query: 写入实例记录的 SQL
+//   - args: SQL 参数
+//
+// 返回值:
+//   - error: 错误信息，读取状态、写入记录或追加历史事件失败时整个事务回滚
+func (r *Repository) writeWithHistory(ctx context.Context, uuid string, next func(before *instanceState) (status, publicIP string), query string, args ...interface{}) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	before, err := lockState(ctx, tx, uuid)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if before != nil {
+		status, publicIP := next(before)
+		if err := recordTransition(ctx, tx, uuid, before, status, publicIP); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// transitionType 返回一次写入对应的历史事件类型
+// 参数:
+//   - before: 写入前的状态
+//   - status: 写入后的状态
+//   - publicIP: 写入后的公网 IP
+//
+// 返回值:
+//   - string: 状态变为 deleted 时为 deleted，其他状态变化为 status_changed，只有公网 IP 变化时为 ip_changed
+//   - bool: 状态和公网 IP 都没有变化时为 false，不记录事件
+func transitionType(before *instanceState, status, publicIP string) (string, bool) {
+	switch {
+	case before.Status != status && status == models.StatusDeleted:
+		return models.InstanceEventDeleted, true
+	case before.Status != status:
+		return models.InstanceEventStatusChanged, true
+	case before.PublicIP != publicIP:
+		return models.InstanceEventIPChanged, true
+	}
+	return "", false
+}
+
+// recordTransition 实例记录的状态或公网 IP 变化时在事务中追加一条历史事件
+// 参数:
+//   - ctx: 上下文，事件原因来自 WithEventCause
+//   - tx: 写入实例记录的事务
+//   - uuid: 实例 UUID
+//   - before: 写入前的状态
+//   - status: 写入后的状态
+//   - publicIP: 写入后的公网 IP
+//
+// 返回值:
+//   - error: 错误信息，如果写入失败
+func recordTransition(ctx context.Context, tx *sqlx.Tx, uuid string, before *instanceState, status, publicIP string) error {
+	eventType, changed := transitionType(before, status, publicIP)
+	if !changed {
+		return nil
+	}
+	return appendInstanceEvent(ctx, tx, &models.InstanceEvent{
+		InstanceUUID: uuid,
+		Region:       before.Region,
+		Type:         eventType,
+		FromStatus:   before.Status,
+		ToStatus:     status,
+		PublicIP:     publicIP,
+	})
+}
+
+// appendInstanceEvent 在事务中追加一条实例历史事件，原因未设置时使用上下文中的原因
+func appendInstanceEvent(ctx context.Context, tx *sqlx.Tx, event *models.InstanceEvent) error {
+	if event.Cause == "" {
+		event.Cause = eventCause(ctx)
+	}
+	query := `
+		INSERT INTO instance_events (instance_uuid, region, type, from_status, to_status, public_ip, cause)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := tx.ExecContext(ctx, query,
+		event.InstanceUUID, event.Region, event.Type, event.FromStatus, event.ToStatus, event.PublicIP, event.Cause,
+	)
+	return err
+}
+
+// ListInstanceEvents 获取实例的历史事件
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - uuid: 实例 UUID
+//
+// 返回值:
+//   - []*models.InstanceEvent: 按发生顺序排列的事件
+//   - error: 错误信息，如果查询失败
+func (r *Repository) ListInstanceEvents(ctx context.Context, uuid string) ([]*models.InstanceEvent, error) {
+	var events []*models.InstanceEvent
+	query := `SELECT * FROM instance_events WHERE instance_uuid = ? ORDER BY id ASC`
+	ctx, span := startSpan(ctx, "ListInstanceEvents", query)
+	defer span.End()
+	err := r.db.SelectContext(ctx, &events, query, uuid)
+	if err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to list history events for instance %s: %v", uuid, err)
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/yuhai94/anywhere_backend/internal/models"
+)
+
+// TestTransitionType 每次写入按状态和公网 IP 的变化选择历史事件类型
+func TestTransitionType(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   instanceState
+		status   string
+		publicIP string
+		want     string
+		changed  bool
+	}{
+		{"no change", instanceState{Status: models.StatusRunning, PublicIP: "203.0.113.1"}, models.StatusRunning, "203.0.113.1", "", false},
+		{"status change", instanceState{Status: models.StatusPending}, models.StatusCreating, "", models.InstanceEventStatusChanged, true},
+		{"status and ip change", instanceState{Status: models.StatusCreating}, models.StatusRunning, "203.0.113.1", models.InstanceEventStatusChanged, true},
+		{"ip change only", instanceState{Status: models.StatusRunning, PublicIP: "203.0.113.1"}, models.StatusRunning, "203.0.113.2", models.InstanceEventIPChanged, true},
+		{"deleted", instanceState{Status: models.StatusDeleting, PublicIP: "203.0.113.1"}, models.StatusDeleted, "203.0.113.1", models.InstanceEventDeleted, true},
+		{"already deleted", instanceState{Status: models.StatusDeleted}, models.StatusDeleted, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := transitionType(&tt.before, tt.status, tt.publicIP)
+			if got != tt.want || changed != tt.changed {
+				t.Errorf("transitionType() = %q, %v, want %q, %v", got, changed, tt.want, tt.changed)
+			}
+		})
+	}
+}
+
+// TestTransitionSequence 依次写入时每条事件的 from_status 是上一次写入后的状态，没有变化的写入不产生事件
+func TestTransitionSequence(t *testing.T) {
+	writes := []struct {
+		status   string
+		publicIP string
+	}{
+		{models.StatusCreating, ""},
+		{models.StatusCreating, ""},
+		{models.StatusRunning, "203.0.113.1"},
+		{models.StatusRunning, "203.0.113.2"},
+		{models.StatusDeleting, "203.0.113.2"},
+		{models.StatusDeleted, "203.0.113.2"},
+	}
+	want := []models.InstanceEvent{
+		{Type: models.InstanceEventStatusChanged, FromStatus: models.StatusPending, ToStatus: models.StatusCreating},
+		{Type: models.InstanceEventStatusChanged, FromStatus: models.StatusCreating, ToStatus: models.StatusRunning, PublicIP: "203.0.113.1"},
+		{Type: models.InstanceEventIPChanged, FromStatus: models.StatusRunning, ToStatus: models.StatusRunning, PublicIP: "203.0.113.2"},
+		{Type: models.InstanceEventStatusChanged, FromStatus: models.StatusRunning, ToStatus: models.StatusDeleting, PublicIP: "203.0.113.2"},
+		{Type: models.InstanceEventDeleted, FromStatus: models.StatusDeleting, ToStatus: models.StatusDeleted, PublicIP: "203.0.113.2"},
+	}
+
+	state := instanceState{Status: models.StatusPending}
+	var got []models.InstanceEvent
+	for _, write := range writes {
+		if eventType, changed := transitionType(&state, write.status, write.publicIP); changed {
+			got = append(got, models.InstanceEvent{
+				Type:       eventType,
+				FromStatus: state.Status,
+				ToStatus:   write.status,
+				PublicIP:   write.publicIP,
+			})
+		}
+		state.Status, state.PublicIP = write.status, write.publicIP
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+	"github.com/yuhai94/anywhere_backend/internal/tracing"
+)
+
+// instanceSortColumns 实例列表可以排序的列，键为 models.InstanceSort* 字段名
+var instanceSortColumns = map[string]string{
+	models.InstanceSortCreatedAt: "created_at",
+	models.InstanceSortUpdatedAt: "updated_at",
+	models.InstanceSortRegion:    "ec2_region",
+	models.InstanceSortStatus:    "status",
+}
+
+// InstanceListQuery 实例列表的 SQL 查询条件
+type InstanceListQuery struct {
+	Statuses     []string
+	Region       string
+	Owner        string
+	CreatedAfter time.Time
+	// IncludeDeleted 同时返回已删除的实例
+	IncludeDeleted bool
+	// DeletedOnly 只返回已删除的实例
+	DeletedOnly bool
+	// Sort 排序字段，models.InstanceSort* 之一，相同时按 ID 排序
+	Sort string
+	// Descending 是否倒序
+	Descending bool
+	// Limit 最多返回的实例数量
+	Limit int
+	// After 不为 nil 时只返回排在该位置之后的实例
+	After *InstanceListPosition
+}
+
+// InstanceListPosition 实例在列表中的位置，即排序字段的值和实例 ID
+type InstanceListPosition struct {
+	// Value 排序字段的值，时间字段为 time.Time，其他为 string
+	Value interface{}
+	ID    int
+}
+
+// ListPage 按过滤条件分页获取实例，默认只返回未删除的实例
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - q: 过滤、排序和分页条件
+//
+// 返回值:
+//   - []*models.V2RayInstance: 按排序字段和 ID 排列的实例，最多 q.Limit 个
+//   - int: 满足过滤条件的实例总数，不受 After 和 Limit 影响
+//   - error: 错误信息，如果排序字段不支持或查询失败
+//
+// 功能:
+//  1. 过滤条件在 SQL 中执行，使用 is_deleted 开头的组合索引，包含已删除的实例时使用 (created_at, id) 索引；
+//     按区域或状态排序时使用 (is_deleted, ec2_region, id) 和 (is_deleted, status, id) 索引
+//  2. 使用 (排序字段, id) 的 keyset 分页，翻页期间新增或删除实例不会导致重复或遗漏
+func (r *Repository) ListPage(ctx context.Context, q InstanceListQuery) ([]*models.V2RayInstance, int, error) {
+	column, ok := instanceSortColumns[q.Sort]
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported sort field %q", q.Sort)
+	}
+
+	var where []string
+	switch {
+	case q.DeletedOnly:
+		where = append(where, "is_deleted = true")
+	case !q.IncludeDeleted:
+		where = append(where, "is_deleted = false")
+	}
+	var args []interface{}
+	if len(q.Statuses) > 0 {
+		where = append(where, "status IN (?"+strings.Repeat(", ?", len(q.Statuses)-1)+")")
+		for _, status := range q.Statuses {
+			args = append(args, status)
+		}
+	}
+	if q.Region != "" {
+		where = append(where, "ec2_region = ?")
+		args = append(args, q.Region)
+	}
+	if q.Owner != "" {
+		where = append(where, "owner = ?")
+		args = append(args, q.Owner)
+	}
+	if !q.CreatedAfter.IsZero() {
+		where = append(where, "created_at > ?")
+		args = append(args, q.CreatedAfter)
+	}
+
+	var total int
+	countQuery := `SELECT COUNT(*) FROM v2ray_instances` + whereClause(where)
+	countCtx, countSpan := startSpan(ctx, "CountPage", countQuery)
+	err := r.db.GetContext(countCtx, &total, countQuery, args...)
+	if err != nil {
+		tracing.RecordError(countSpan, err)
+	}
+	countSpan.End()
+	if err != nil {
+		logging.Error(ctx, "Failed to count instances: %v", err)
+		return nil, 0, err
+	}
+
+	direction, compare := "ASC", ">"
+	if q.Descending {
+		direction, compare = "DESC", "<"
+	}
+	if q.After != nil {
+		where = append(where, fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", column, compare, column, compare))
+		args = append(args, q.After.Value, q.After.Value, q.After.ID)
+	}
+	args = append(args, q.Limit)
+
+	var instances []*models.V2RayInstance
+	query := fmt.Sprintf(`SELECT * FROM v2ray_instances%s ORDER BY %s %s, id %s LIMIT ?`,
+		whereClause(where), column, direction, direction)
+	ctx, span := startSpan(ctx, "ListPage", query)
+	defer span.End()
+	if err := r.db.SelectContext(ctx, &instances, query, args...); err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to list instances: %v", err)
+		return nil, 0, err
+	}
+	return instances, total, nil
+}
+
+// whereClause 以 AND 连接条件，没有条件时返回空字符串
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
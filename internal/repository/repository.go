@@ -2,11 +2,17 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"strings"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/yuhai94/anywhere_backend/internal/logging"
 	"github.com/yuhai94/anywhere_backend/internal/models"
+	"github.com/yuhai94/anywhere_backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Repository struct {
@@ -27,6 +33,22 @@ func New(db *sqlx.DB) *Repository {
 	return &Repository{db: db}
 }
 
+// startSpan 为一次数据库查询创建追踪 span
+// 参数:
+//   - ctx: 上下文
+//   - operation: Repository 方法名
+//   - query: 执行的 SQL 语句
+//
+// 返回值:
+//   - context.Context: 包含新 span 的上下文
+//   - trace.Span: 新创建的 span，调用方负责结束
+func startSpan(ctx context.Context, operation, query string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "repository."+operation,
+		attribute.String("db.system", "mysql"),
+		attribute.String("db.statement", strings.Join(strings.Fields(query), " ")),
+	)
+}
+
 // Create 创建 V2Ray 实例记录
 // 参数:
 //   - ctx: 上下文，用于传递请求范围的值
@@ -39,24 +61,60 @@ func New(db *sqlx.DB) *Repository {
 //  1. 执行插入操作，将实例信息插入到数据库
 //  2. 获取插入后的自增 ID
 //  3. 将 ID 设置到实例对象中
-//  4. 记录创建成功的日志
+//  4. 在同一个事务中在实例历史中追加 created 事件
+//  5. 记录创建成功的日志
 func (r *Repository) Create(ctx context.Context, instance *models.V2RayInstance) error {
 	query := `
-		INSERT INTO v2ray_instances (uuid, ec2_id, ec2_region, status, is_deleted)
-		VALUES (?, ?, ?, ?, ?)
+		INSERT INTO v2ray_instances (uuid, ec2_id, ec2_region, ec2_account, instance_type, owner, status, protocol,
+			reality_private_key, reality_public_key, reality_short_ids, reality_server_name, reality_dest, is_deleted)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
-	result, err := r.db.ExecContext(ctx, query, instance.UUID, instance.EC2ID, instance.EC2Region, instance.Status, instance.IsDeleted)
+	ctx, span := startSpan(ctx, "Create", query)
+	defer span.End()
+	tx, err := r.db.BeginTxx(ctx, nil)
 	if err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to begin create transaction: %v", err)
+		return err
+	}
+	result, err := tx.ExecContext(ctx, query, instance.UUID, instance.EC2ID, instance.EC2Region, instance.EC2Account,
+		instance.InstanceType, instance.Owner, instance.Status, instance.Protocol,
+		instance.RealityPrivateKey, instance.RealityPublicKey, instance.RealityShortIDs, instance.RealityServerName, instance.RealityDest,
+		instance.IsDeleted)
+	if err != nil {
+		tx.Rollback()
+		tracing.RecordError(span, err)
 		logging.Error(ctx, "Failed to create instance: %v", err)
 		return err
 	}
 
 	id, err := result.LastInsertId()
 	if err != nil {
+		tx.Rollback()
+		tracing.RecordError(span, err)
 		logging.Error(ctx, "Failed to get last insert id: %v", err)
 		return err
 	}
 
+	err = appendInstanceEvent(ctx, tx, &models.InstanceEvent{
+		InstanceUUID: instance.UUID,
+		Region:       instance.EC2Region,
+		Type:         models.InstanceEventCreated,
+		ToStatus:     instance.Status,
+		PublicIP:     instance.EC2PublicIP,
+	})
+	if err != nil {
+		tx.Rollback()
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to append history event for instance %s: %v", instance.UUID, err)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to commit instance %s: %v", instance.UUID, err)
+		return err
+	}
+
 	instance.ID = int(id)
 	logging.Info(ctx, "Created instance with ID: %d", instance.ID)
 	return nil
@@ -78,8 +136,33 @@ func (r *Repository) Create(ctx context.Context, instance *models.V2RayInstance)
 func (r *Repository) GetByUUID(ctx context.Context, uuid string) (*models.V2RayInstance, error) {
 	var instance models.V2RayInstance
 	query := `SELECT * FROM v2ray_instances WHERE uuid = ? AND is_deleted = false`
+	ctx, span := startSpan(ctx, "GetByUUID", query)
+	defer span.End()
 	err := r.db.GetContext(ctx, &instance, query, uuid)
 	if err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to get instance by UUID %s: %v", uuid, err)
+		return nil, err
+	}
+	return &instance, nil
+}
+
+// GetByUUIDWithDeleted 根据 UUID 获取 V2Ray 实例，包括已删除的实例
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - uuid: 实例 UUID
+//
+// 返回值:
+//   - *models.V2RayInstance: 找到的实例，有多条记录时为最新的一条
+//   - error: 错误信息，如果获取失败
+func (r *Repository) GetByUUIDWithDeleted(ctx context.Context, uuid string) (*models.V2RayInstance, error) {
+	var instance models.V2RayInstance
+	query := `SELECT * FROM v2ray_instances WHERE uuid = ? ORDER BY id DESC LIMIT 1`
+	ctx, span := startSpan(ctx, "GetByUUIDWithDeleted", query)
+	defer span.End()
+	err := r.db.GetContext(ctx, &instance, query, uuid)
+	if err != nil {
+		tracing.RecordError(span, err)
 		logging.Error(ctx, "Failed to get instance by UUID %s: %v", uuid, err)
 		return nil, err
 	}
@@ -102,8 +185,11 @@ func (r *Repository) GetByUUID(ctx context.Context, uuid string) (*models.V2RayI
 func (r *Repository) List(ctx context.Context) ([]*models.V2RayInstance, error) {
 	var instances []*models.V2RayInstance
 	query := `SELECT * FROM v2ray_instances WHERE is_deleted = false ORDER BY created_at DESC`
+	ctx, span := startSpan(ctx, "List", query)
+	defer span.End()
 	err := r.db.SelectContext(ctx, &instances, query)
 	if err != nil {
+		tracing.RecordError(span, err)
 		logging.Error(ctx, "Failed to list instances: %v", err)
 		return nil, err
 	}
@@ -120,21 +206,31 @@ func (r *Repository) List(ctx context.Context) ([]*models.V2RayInstance, error)
 //
 // 功能:
 //  1. 执行更新操作，更新实例的所有字段
-//  2. 记录更新操作的结果
-//  3. 返回更新操作的错误信息
+//  2. 状态或公网 IP 变化时在同一个事务中在实例历史中追加事件
+//  3. 记录更新操作的结果
+//  4. 返回更新操作的错误信息
 func (r *Repository) Update(ctx context.Context, instance *models.V2RayInstance) error {
 	query := `
 		UPDATE v2ray_instances
-		SET ec2_id = ?, ec2_region = ?, ec2_public_ip = ?, status = ?, 
+		SET ec2_id = ?, ec2_region = ?, ec2_account = ?, instance_type = ?, ec2_public_ip = ?, status = ?, 
 		    direct_link = ?, relay_link = ?, is_deleted = ?
 		WHERE uuid = ?
 	`
-	_, err := r.db.ExecContext(ctx, query,
-		instance.EC2ID, instance.EC2Region, instance.EC2PublicIP,
+	ctx, span := startSpan(ctx, "Update", query)
+	defer span.End()
+	status := instance.Status
+	if instance.IsDeleted {
+		status = models.StatusDeleted
+	}
+	err := r.writeWithHistory(ctx, instance.UUID,
+		func(*instanceState) (string, string) { return status, instance.EC2PublicIP },
+		query,
+		instance.EC2ID, instance.EC2Region, instance.EC2Account, instance.InstanceType, instance.EC2PublicIP,
 		instance.Status, instance.DirectLink, instance.RelayLink,
 		instance.IsDeleted, instance.UUID,
 	)
 	if err != nil {
+		tracing.RecordError(span, err)
 		logging.Error(ctx, "Failed to update instance %s: %v", instance.UUID, err)
 		return err
 	}
@@ -153,8 +249,11 @@ func (r *Repository) Update(ctx context.Context, instance *models.V2RayInstance)
 //   - error: 错误信息，如果更新失败
 func (r *Repository) UpdateLinks(ctx context.Context, uuid, directLink, relayLink string) error {
 	query := `UPDATE v2ray_instances SET direct_link = ?, relay_link = ? WHERE uuid = ?`
+	ctx, span := startSpan(ctx, "UpdateLinks", query)
+	defer span.End()
 	_, err := r.db.ExecContext(ctx, query, directLink, relayLink, uuid)
 	if err != nil {
+		tracing.RecordError(span, err)
 		logging.Error(ctx, "Failed to update links for instance %s: %v", uuid, err)
 		return err
 	}
@@ -173,12 +272,19 @@ func (r *Repository) UpdateLinks(ctx context.Context, uuid, directLink, relayLin
 //
 // 功能:
 //  1. 执行更新操作，更新实例的状态
-//  2. 记录状态更新的结果
-//  3. 返回更新操作的错误信息
+//  2. 状态变化时在同一个事务中在实例历史中追加事件
+//  3. 记录状态更新的结果
+//  4. 返回更新操作的错误信息
 func (r *Repository) UpdateStatus(ctx context.Context, uuid string, status string) error {
 	query := `UPDATE v2ray_instances SET status = ? WHERE uuid = ?`
-	_, err := r.db.ExecContext(ctx, query, status, uuid)
+	ctx, span := startSpan(ctx, "UpdateStatus", query)
+	defer span.End()
+	err := r.writeWithHistory(ctx, uuid,
+		func(before *instanceState) (string, string) { return status, before.PublicIP },
+		query, status, uuid,
+	)
 	if err != nil {
+		tracing.RecordError(span, err)
 		logging.Error(ctx, "Failed to update status for instance %s: %v", uuid, err)
 		return err
 	}
@@ -198,12 +304,19 @@ func (r *Repository) UpdateStatus(ctx context.Context, uuid string, status strin
 //
 // 功能:
 //  1. 执行更新操作，同时更新实例的状态和公网 IP
-//  2. 记录状态和 IP 更新的结果
-//  3. 返回更新操作的错误信息
+//  2. 状态或公网 IP 变化时在同一个事务中在实例历史中追加事件
+//  3. 记录状态和 IP 更新的结果
+//  4. 返回更新操作的错误信息
 func (r *Repository) UpdateStatusAndIP(ctx context.Context, uuid string, status string, publicIP string) error {
 	query := `UPDATE v2ray_instances SET status = ?, ec2_public_ip = ? WHERE uuid = ?`
-	_, err := r.db.ExecContext(ctx, query, status, publicIP, uuid)
+	ctx, span := startSpan(ctx, "UpdateStatusAndIP", query)
+	defer span.End()
+	err := r.writeWithHistory(ctx, uuid,
+		func(*instanceState) (string, string) { return status, publicIP },
+		query, status, publicIP, uuid,
+	)
 	if err != nil {
+		tracing.RecordError(span, err)
 		logging.Error(ctx, "Failed to update status and IP for instance %s: %v", uuid, err)
 		return err
 	}
@@ -221,13 +334,20 @@ func (r *Repository) UpdateStatusAndIP(ctx context.Context, uuid string, status
 //
 // 功能:
 //  1. 执行更新操作，将实例状态设置为已删除
-//  2. 将 is_deleted 字段设置为 true
-//  3. 记录删除操作的结果
-//  4. 返回删除操作的错误信息
+//  2. 将 is_deleted 字段设置为 true，记录保留在数据库中，可以通过 include_deleted 查询和查看历史
+//  3. 在同一个事务中在实例历史中追加 deleted 事件
+//  4. 记录删除操作的结果
+//  5. 返回删除操作的错误信息
 func (r *Repository) Delete(ctx context.Context, uuid string) error {
 	query := `UPDATE v2ray_instances SET status = ?, is_deleted = true WHERE uuid = ?`
-	_, err := r.db.ExecContext(ctx, query, models.StatusDeleted, uuid)
+	ctx, span := startSpan(ctx, "Delete", query)
+	defer span.End()
+	err := r.writeWithHistory(ctx, uuid,
+		func(before *instanceState) (string, string) { return models.StatusDeleted, before.PublicIP },
+		query, models.StatusDeleted, uuid,
+	)
 	if err != nil {
+		tracing.RecordError(span, err)
 		logging.Error(ctx, "Failed to delete instance %s: %v", uuid, err)
 		return err
 	}
@@ -235,34 +355,33 @@ func (r *Repository) Delete(ctx context.Context, uuid string) error {
 	return nil
 }
 
-// CheckRegionHasActiveInstance 检查指定region是否存在活跃实例
+// CountRegionActiveInstances 统计指定region的活跃实例数
 // 参数:
 //   - ctx: 上下文，用于传递请求范围的值
 //   - region: AWS区域
 //
 // 返回值:
-//   - bool: 如果存在活跃实例返回true，否则返回false
-//   - error: 错误信息，如果检查失败
-//
-// 功能:
-//  1. 检查指定region是否存在pending、creating或running状态的实例
-//  2. 返回检查结果
-func (r *Repository) CheckRegionHasActiveInstance(ctx context.Context, region string) (bool, error) {
+//   - int: pending、creating或running状态的实例数
+//   - error: 错误信息，如果统计失败
+func (r *Repository) CountRegionActiveInstances(ctx context.Context, region string) (int, error) {
 	query := `
 		SELECT COUNT(*) FROM v2ray_instances 
 		WHERE ec2_region = ? AND is_deleted = false 
 		AND status IN (?, ?, ?)
 	`
+	ctx, span := startSpan(ctx, "CountRegionActiveInstances", query)
+	defer span.End()
 	var count int
 	err := r.db.GetContext(ctx, &count, query, region, models.StatusPending, models.StatusCreating, models.StatusRunning)
 	if err != nil {
-		logging.Error(ctx, "Failed to check region %s for active instances: %v", region, err)
-		return false, err
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to count active instances in region %s: %v", region, err)
+		return 0, err
 	}
-	return count > 0, nil
+	return count, nil
 }
 
-// GetRegionActiveInstance 获取指定region的活跃实例
+// GetRegionActiveInstance 获取指定region最新创建的活跃实例
 // 参数:
 //   - ctx: 上下文，用于传递请求范围的值
 //   - region: AWS区域
@@ -279,53 +398,167 @@ func (r *Repository) GetRegionActiveInstance(ctx context.Context, region string)
 		SELECT * FROM v2ray_instances 
 		WHERE ec2_region = ? AND is_deleted = false 
 		AND status IN (?, ?, ?) 
+		ORDER BY created_at DESC
 		LIMIT 1
 	`
+	ctx, span := startSpan(ctx, "GetRegionActiveInstance", query)
+	defer span.End()
 	var instance models.V2RayInstance
 	err := r.db.GetContext(ctx, &instance, query, region, models.StatusPending, models.StatusCreating, models.StatusRunning)
 	if err != nil {
+		tracing.RecordError(span, err)
 		logging.Error(ctx, "Failed to get active instance for region %s: %v", region, err)
 		return nil, err
 	}
 	return &instance, nil
 }
 
-// LockTable 锁定表，用于实现串行写入
+// AppendEvent 追加一条实例生命周期事件
 // 参数:
 //   - ctx: 上下文，用于传递请求范围的值
+//   - event: 要追加的事件
 //
 // 返回值:
-//   - error: 错误信息，如果锁定失败
+//   - error: 错误信息，如果写入失败
 //
 // 功能:
-//  1. 执行表锁定操作
-func (r *Repository) LockTable(ctx context.Context) error {
-	query := `LOCK TABLES v2ray_instances WRITE`
-	_, err := r.db.ExecContext(ctx, query)
+//  1. 将事件插入到 lifecycle_events 表
+//  2. 将自增 ID 回写到事件对象中，作为事件流的 Last-Event-ID
+func (r *Repository) AppendEvent(ctx context.Context, event *models.LifecycleEvent) error {
+	query := `
+		INSERT INTO lifecycle_events (instance_uuid, region, type, status, public_ip, direct_link, relay_link, source)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	ctx, span := startSpan(ctx, "AppendEvent", query)
+	defer span.End()
+	result, err := r.db.ExecContext(ctx, query,
+		event.InstanceUUID, event.Region, event.Type, event.Status,
+		event.PublicIP, event.DirectLink, event.RelayLink, event.Source,
+	)
+	if err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to append event for instance %s: %v", event.InstanceUUID, err)
+		return err
+	}
+
+	id, err := result.LastInsertId()
 	if err != nil {
-		logging.Error(ctx, "Failed to lock table: %v", err)
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to get last insert id: %v", err)
 		return err
 	}
+
+	event.ID = id
 	return nil
 }
 
-// UnlockTable 解锁表
+// ListEventsAfter 获取指定 ID 之后的生命周期事件
 // 参数:
 //   - ctx: 上下文，用于传递请求范围的值
+//   - afterID: 起始事件 ID（不包含）
+//   - instanceUUID: 实例 UUID，为空时返回所有实例的事件
+//   - limit: 最多返回的事件数量
 //
 // 返回值:
-//   - error: 错误信息，如果解锁失败
+//   - []*models.LifecycleEvent: 按 ID 升序排列的事件列表
+//   - error: 错误信息，如果查询失败
+func (r *Repository) ListEventsAfter(ctx context.Context, afterID int64, instanceUUID string, limit int) ([]*models.LifecycleEvent, error) {
+	var events []*models.LifecycleEvent
+	query := `
+		SELECT * FROM lifecycle_events
+		WHERE id > ? AND (? = '' OR instance_uuid = ?)
+		ORDER BY id ASC
+		LIMIT ?
+	`
+	ctx, span := startSpan(ctx, "ListEventsAfter", query)
+	defer span.End()
+	err := r.db.SelectContext(ctx, &events, query, afterID, instanceUUID, instanceUUID, limit)
+	if err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to list events after %d: %v", afterID, err)
+		return nil, err
+	}
+	return events, nil
+}
+
+// HasEvent 判断实例是否已有指定类型的生命周期事件
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - instanceUUID: 实例 UUID
+//   - eventType: 事件类型，例如 models.EventExpiring
+//
+// 返回值:
+//   - bool: 是否存在该类型的事件
+//   - error: 错误信息，如果查询失败
+func (r *Repository) HasEvent(ctx context.Context, instanceUUID, eventType string) (bool, error) {
+	var exists bool
+	query := "SELECT EXISTS(SELECT 1 FROM lifecycle_events WHERE instance_uuid = ? AND type = ?)"
+	ctx, span := startSpan(ctx, "HasEvent", query)
+	defer span.End()
+	if err := r.db.GetContext(ctx, &exists, query, instanceUUID, eventType); err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to check %s events of instance %s: %v", eventType, instanceUUID, err)
+		return false, err
+	}
+	return exists, nil
+}
+
+// createLockTimeout 等待区域创建锁的最长秒数
+const createLockTimeout = 30
+
+// LockRegion 获取区域的创建锁，用于串行执行同一区域的 统计 → 创建 流程
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - region: AWS 区域
+//
+// 返回值:
+//   - func(): 释放锁的函数，调用方必须调用
+//   - error: 错误信息，如果获取连接失败或等待超时
 //
 // 功能:
-//  1. 执行表解锁操作
-func (r *Repository) UnlockTable(ctx context.Context) error {
-	query := `UNLOCK TABLES`
-	_, err := r.db.ExecContext(ctx, query)
+//  1. 从连接池取出一个连接，在该连接上执行 GET_LOCK；锁属于连接，获取和释放必须在同一个连接上
+//  2. 锁只在同样获取该锁的调用方之间互斥，加锁期间的查询和写入仍可使用连接池中的其他连接
+//  3. 释放时在同一个连接上执行 RELEASE_LOCK 后归还连接；释放失败时丢弃该连接，MySQL 在连接关闭时释放锁，
+//     不会把持有锁的连接放回连接池
+func (r *Repository) LockRegion(ctx context.Context, region string) (func(), error) {
+	query := `SELECT GET_LOCK(?, ?)`
+	ctx, span := startSpan(ctx, "LockRegion", query)
+	defer span.End()
+
+	conn, err := r.db.Connx(ctx)
 	if err != nil {
-		logging.Error(ctx, "Failed to unlock table: %v", err)
-		return err
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to get connection for region %s lock: %v", region, err)
+		return nil, err
 	}
-	return nil
+
+	name := "anywhere.create." + region
+	var acquired sql.NullInt64
+	if err := conn.GetContext(ctx, &acquired, query, name, createLockTimeout); err != nil {
+		conn.Close()
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to lock region %s: %v", region, err)
+		return nil, err
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		conn.Close()
+		err := fmt.Errorf("timed out after %ds waiting for the create lock of region %s", createLockTimeout, region)
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to lock region %s: %v", region, err)
+		return nil, err
+	}
+
+	unlock := func() {
+		// 请求取消后仍然需要释放锁
+		releaseCtx := context.WithoutCancel(ctx)
+		var released sql.NullInt64
+		if err := conn.GetContext(releaseCtx, &released, `SELECT RELEASE_LOCK(?)`, name); err != nil || released.Int64 != 1 {
+			logging.Error(releaseCtx, "Failed to unlock region %s, discarding the connection: %v", region, err)
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}
+	return unlock, nil
 }
 
 // InitSchema 初始化数据库表结构
@@ -336,34 +569,95 @@ func (r *Repository) UnlockTable(ctx context.Context) error {
 //   - error: 错误信息，如果初始化失败
 //
 // 功能:
-//  1. 执行 SQL 语句创建 v2ray_instances 表
-//  2. 表包含 id、uuid、ec2_id、ec2_region、ec2_public_ip、status、created_at、updated_at、is_deleted 字段
-//  3. 添加适当的索引和注释
+//  1. 依次执行 schemaStatements 中的建表语句
+//  2. 所有表都使用 CREATE TABLE IF NOT EXISTS，可重复执行
+//  3. 为旧版本创建的表补充 schemaColumns 中缺少的列和 schemaIndexes 中缺少的索引
 //  4. 记录初始化结果
 func (r *Repository) InitSchema(ctx context.Context) error {
-	schema := `
-		CREATE TABLE IF NOT EXISTS v2ray_instances (
-			id INT NOT NULL AUTO_INCREMENT COMMENT '实例 ID (自增)',
-			uuid VARCHAR(36) NOT NULL COMMENT 'V2Ray 客户端 UUID',
-			ec2_id VARCHAR(255) NOT NULL COMMENT 'AWS EC2 实例 ID',
-			ec2_region VARCHAR(100) NOT NULL COMMENT 'AWS 区域',
-			ec2_public_ip VARCHAR(50) NOT NULL DEFAULT '' COMMENT '公网 IP 地址',
-			status VARCHAR(50) NOT NULL COMMENT '实例状态（pending, creating, running, deleting, deleted, error）',
-			direct_link TEXT NOT NULL COMMENT '直连链接',
-			relay_link TEXT NOT NULL COMMENT '中转链接',
-			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
-			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '最后更新时间',
-			is_deleted BOOLEAN NOT NULL DEFAULT FALSE COMMENT '删除标志',
-			PRIMARY KEY (id),
-			INDEX idx_status (status),
-			INDEX idx_is_deleted (is_deleted)
-		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='V2Ray 实例表';
+	for _, schema := range schemaStatements {
+		if err := r.execSchema(ctx, schema); err != nil {
+			return err
+		}
+	}
+	for _, column := range schemaColumns {
+		if err := r.ensureColumn(ctx, column); err != nil {
+			return err
+		}
+	}
+	for _, index := range schemaIndexes {
+		if err := r.ensureIndex(ctx, index); err != nil {
+			return err
+		}
+	}
+	logging.Info(ctx, "Database schema initialized")
+	return nil
+}
+
+// ensureColumn 列不存在时添加该列
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - column: 要检查的列
+//
+// 返回值:
+//   - error: 错误信息，如果查询或添加失败
+func (r *Repository) ensureColumn(ctx context.Context, column schemaColumn) error {
+	var count int
+	query := `
+		SELECT COUNT(*) FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?
+	`
+	if err := r.db.GetContext(ctx, &count, query, column.table, column.column); err != nil {
+		logging.Error(ctx, "Failed to check column %s.%s: %v", column.table, column.column, err)
+		return fmt.Errorf("failed to check column %s.%s: %v", column.table, column.column, err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	logging.Info(ctx, "Adding column %s.%s", column.table, column.column)
+	return r.execSchema(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", column.table, column.column, column.definition))
+}
+
+// ensureIndex 索引不存在时添加该索引
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - index: 要检查的索引
+//
+// 返回值:
+//   - error: 错误信息，如果查询或添加失败
+func (r *Repository) ensureIndex(ctx context.Context, index schemaIndex) error {
+	var count int
+	query := `
+		SELECT COUNT(*) FROM information_schema.statistics
+		WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?
 	`
+	if err := r.db.GetContext(ctx, &count, query, index.table, index.name); err != nil {
+		logging.Error(ctx, "Failed to check index %s.%s: %v", index.table, index.name, err)
+		return fmt.Errorf("failed to check index %s.%s: %v", index.table, index.name, err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	logging.Info(ctx, "Adding index %s.%s", index.table, index.name)
+	return r.execSchema(ctx, fmt.Sprintf("ALTER TABLE %s ADD INDEX %s (%s)", index.table, index.name, index.columns))
+}
+
+// execSchema 执行一条建表语句
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - schema: DDL 语句
+//
+// 返回值:
+//   - error: 错误信息，如果执行失败
+func (r *Repository) execSchema(ctx context.Context, schema string) error {
+	ctx, span := startSpan(ctx, "InitSchema", schema)
+	defer span.End()
 	_, err := r.db.ExecContext(ctx, schema)
 	if err != nil {
+		tracing.RecordError(span, err)
 		logging.Error(ctx, "Failed to create schema: %v", err)
 		return fmt.Errorf("failed to create schema: %v", err)
 	}
-	logging.Info(ctx, "Database schema initialized")
 	return nil
 }
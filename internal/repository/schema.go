@@ -0,0 +1,188 @@
+package repository
+
+// v2rayInstancesSchema V2Ray 实例表
+const v2rayInstancesSchema = `
+	CREATE TABLE IF NOT EXISTS v2ray_instances (
+		id INT NOT NULL AUTO_INCREMENT COMMENT '实例 ID (自增)',
+		uuid VARCHAR(36) NOT NULL COMMENT 'V2Ray 客户端 UUID',
+		ec2_id VARCHAR(255) NOT NULL COMMENT 'AWS EC2 实例 ID',
+		ec2_region VARCHAR(100) NOT NULL COMMENT 'AWS 区域',
+		ec2_account VARCHAR(100) NOT NULL DEFAULT '' COMMENT 'AWS 账号名，为空表示 default',
+		instance_type VARCHAR(50) NOT NULL DEFAULT '' COMMENT 'EC2 实例类型，由同步任务记录',
+		owner VARCHAR(100) NOT NULL DEFAULT '' COMMENT '创建者',
+		ec2_public_ip VARCHAR(50) NOT NULL DEFAULT '' COMMENT '公网 IP 地址',
+		status VARCHAR(50) NOT NULL COMMENT '实例状态（pending, creating, running, deleting, deleted, error）',
+		protocol VARCHAR(20) NOT NULL DEFAULT 'vmess' COMMENT '节点协议（vmess, vless-reality）',
+		reality_private_key VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'REALITY x25519 私钥',
+		reality_public_key VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'REALITY x25519 公钥',
+		reality_short_ids VARCHAR(512) NOT NULL DEFAULT '' COMMENT 'REALITY short ID，逗号分隔',
+		reality_server_name VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'REALITY SNI',
+		reality_dest VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'REALITY 回落网站',
+		direct_link TEXT NOT NULL COMMENT '直连链接',
+		relay_link TEXT NOT NULL COMMENT '中转链接',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '最后更新时间',
+		is_deleted BOOLEAN NOT NULL DEFAULT FALSE COMMENT '删除标志',
+		PRIMARY KEY (id),
+		INDEX idx_uuid (uuid, id),
+		INDEX idx_status (status),
+		INDEX idx_is_deleted (is_deleted),
+		INDEX idx_deleted_created (is_deleted, created_at, id),
+		INDEX idx_deleted_updated (is_deleted, updated_at, id),
+		INDEX idx_deleted_region (is_deleted, ec2_region, created_at),
+		INDEX idx_deleted_region_id (is_deleted, ec2_region, id),
+		INDEX idx_deleted_status (is_deleted, status, created_at),
+		INDEX idx_deleted_status_id (is_deleted, status, id),
+		INDEX idx_deleted_owner (is_deleted, owner, created_at),
+		INDEX idx_created (created_at, id)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='V2Ray 实例表';
+`
+
+// lifecycleEventsSchema 实例生命周期事件表，用于事件流的 Last-Event-ID 重放
+const lifecycleEventsSchema = `
+	CREATE TABLE IF NOT EXISTS lifecycle_events (
+		id BIGINT NOT NULL AUTO_INCREMENT COMMENT '事件 ID (自增)',
+		instance_uuid VARCHAR(36) NOT NULL COMMENT '实例 UUID',
+		region VARCHAR(100) NOT NULL DEFAULT '' COMMENT 'AWS 区域',
+		type VARCHAR(50) NOT NULL COMMENT '事件类型（status_changed, ip_changed, links_changed, expiring）',
+		status VARCHAR(50) NOT NULL DEFAULT '' COMMENT '事件发生后的实例状态',
+		public_ip VARCHAR(50) NOT NULL DEFAULT '' COMMENT '事件发生后的公网 IP',
+		direct_link TEXT NOT NULL COMMENT '事件发生后的直连链接',
+		relay_link TEXT NOT NULL COMMENT '事件发生后的中转链接',
+		source VARCHAR(50) NOT NULL DEFAULT '' COMMENT '事件来源（service, sync）',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+		PRIMARY KEY (id),
+		INDEX idx_instance_uuid (instance_uuid, id)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='实例生命周期事件表';
+`
+
+// instanceEventsSchema 实例历史表，每行是一次状态或公网 IP 的变化，实例删除后仍然保留
+const instanceEventsSchema = `
+	CREATE TABLE IF NOT EXISTS instance_events (
+		id BIGINT NOT NULL AUTO_INCREMENT COMMENT '事件 ID (自增)',
+		instance_uuid VARCHAR(36) NOT NULL COMMENT '实例 UUID',
+		region VARCHAR(100) NOT NULL DEFAULT '' COMMENT 'AWS 区域',
+		type VARCHAR(50) NOT NULL COMMENT '事件类型（created, status_changed, ip_changed, deleted）',
+		from_status VARCHAR(50) NOT NULL DEFAULT '' COMMENT '变化前的状态',
+		to_status VARCHAR(50) NOT NULL DEFAULT '' COMMENT '变化后的状态',
+		public_ip VARCHAR(50) NOT NULL DEFAULT '' COMMENT '变化后的公网 IP',
+		cause VARCHAR(1024) NOT NULL DEFAULT '' COMMENT '变化原因',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '发生时间',
+		PRIMARY KEY (id),
+		INDEX idx_instance_uuid (instance_uuid, id),
+		INDEX idx_created_at (created_at)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='实例历史表';
+`
+
+// instanceUsageSchema 实例运行时间表，每行是实例从进入 running 到离开 running 的一段时间
+const instanceUsageSchema = `
+	CREATE TABLE IF NOT EXISTS instance_usage (
+		id BIGINT NOT NULL AUTO_INCREMENT COMMENT '记录 ID (自增)',
+		instance_uuid VARCHAR(36) NOT NULL COMMENT '实例 UUID',
+		started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '开始运行时间',
+		stopped_at TIMESTAMP NULL DEFAULT NULL COMMENT '停止运行时间，为空表示仍在运行',
+		PRIMARY KEY (id),
+		INDEX idx_instance_uuid (instance_uuid, stopped_at),
+		INDEX idx_started_at (started_at)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='实例运行时间表';
+`
+
+// trafficStatsSchema 流量统计表，每行是一个统计对象在一个小时内的流量
+const trafficStatsSchema = `
+	CREATE TABLE IF NOT EXISTS traffic_stats (
+		kind VARCHAR(20) NOT NULL COMMENT '统计对象类型（outbound, user, instance）',
+		name VARCHAR(255) NOT NULL COMMENT '出站标签、用户 email 或实例 UUID',
+		period TIMESTAMP NOT NULL COMMENT '小时的开始时间',
+		uplink BIGINT NOT NULL DEFAULT 0 COMMENT '上行字节数',
+		downlink BIGINT NOT NULL DEFAULT 0 COMMENT '下行字节数',
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '最后更新时间',
+		PRIMARY KEY (kind, name, period),
+		INDEX idx_period (period)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='流量统计表';
+`
+
+// webhooksSchema webhook 订阅表
+const webhooksSchema = `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id INT NOT NULL AUTO_INCREMENT COMMENT 'webhook ID (自增)',
+		url VARCHAR(2048) NOT NULL COMMENT '回调地址',
+		secret VARCHAR(255) NOT NULL COMMENT 'HMAC 签名密钥',
+		events VARCHAR(1024) NOT NULL DEFAULT '' COMMENT '订阅的事件类型，逗号分隔，为空表示全部',
+		enabled BOOLEAN NOT NULL DEFAULT TRUE COMMENT '是否启用',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '最后更新时间',
+		PRIMARY KEY (id)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='webhook 订阅表';
+`
+
+// webhookDeliveriesSchema webhook 投递记录表
+const webhookDeliveriesSchema = `
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id BIGINT NOT NULL AUTO_INCREMENT COMMENT '投递 ID (自增)',
+		webhook_id INT NOT NULL COMMENT 'webhook ID',
+		event_id VARCHAR(36) NOT NULL COMMENT '事件 ID',
+		event_type VARCHAR(50) NOT NULL COMMENT '事件类型',
+		payload TEXT NOT NULL COMMENT '请求体',
+		status VARCHAR(20) NOT NULL COMMENT '投递状态（pending, succeeded, failed）',
+		attempts INT NOT NULL DEFAULT 0 COMMENT '已尝试次数',
+		response_status INT NOT NULL DEFAULT 0 COMMENT '最后一次响应状态码',
+		last_error TEXT NOT NULL COMMENT '最后一次错误信息',
+		next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '下次尝试时间',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '最后更新时间',
+		PRIMARY KEY (id),
+		INDEX idx_status_next_attempt (status, next_attempt_at),
+		INDEX idx_webhook_id (webhook_id, id)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='webhook 投递记录表';
+`
+
+// schemaStatements InitSchema 按顺序执行的建表语句
+var schemaStatements = []string{
+	v2rayInstancesSchema,
+	lifecycleEventsSchema,
+	instanceEventsSchema,
+	instanceUsageSchema,
+	trafficStatsSchema,
+	webhooksSchema,
+	webhookDeliveriesSchema,
+}
+
+// schemaColumn 在已有表上补充的列
+type schemaColumn struct {
+	table      string
+	column     string
+	definition string
+}
+
+// schemaColumns InitSchema 在建表后检查并补充的列，用于升级旧版本创建的表
+var schemaColumns = []schemaColumn{
+	{"v2ray_instances", "ec2_account", "VARCHAR(100) NOT NULL DEFAULT '' COMMENT 'AWS 账号名，为空表示 default' AFTER ec2_region"},
+	{"v2ray_instances", "instance_type", "VARCHAR(50) NOT NULL DEFAULT '' COMMENT 'EC2 实例类型，由同步任务记录' AFTER ec2_account"},
+	{"v2ray_instances", "owner", "VARCHAR(100) NOT NULL DEFAULT '' COMMENT '创建者' AFTER instance_type"},
+	{"v2ray_instances", "protocol", "VARCHAR(20) NOT NULL DEFAULT 'vmess' COMMENT '节点协议（vmess, vless-reality）' AFTER status"},
+	{"v2ray_instances", "reality_private_key", "VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'REALITY x25519 私钥' AFTER protocol"},
+	{"v2ray_instances", "reality_public_key", "VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'REALITY x25519 公钥' AFTER reality_private_key"},
+	{"v2ray_instances", "reality_short_ids", "VARCHAR(512) NOT NULL DEFAULT '' COMMENT 'REALITY short ID，逗号分隔' AFTER reality_public_key"},
+	{"v2ray_instances", "reality_server_name", "VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'REALITY SNI' AFTER reality_short_ids"},
+	{"v2ray_instances", "reality_dest", "VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'REALITY 回落网站' AFTER reality_server_name"},
+}
+
+// schemaIndex 在已有表上补充的索引
+type schemaIndex struct {
+	table   string
+	name    string
+	columns string
+}
+
+// schemaIndexes InitSchema 在补充列之后检查并补充的索引，用于升级旧版本创建的表
+var schemaIndexes = []schemaIndex{
+	{"v2ray_instances", "idx_uuid", "uuid, id"},
+	{"v2ray_instances", "idx_deleted_created", "is_deleted, created_at, id"},
+	{"v2ray_instances", "idx_deleted_updated", "is_deleted, updated_at, id"},
+	{"v2ray_instances", "idx_deleted_region", "is_deleted, ec2_region, created_at"},
+	{"v2ray_instances", "idx_deleted_region_id", "is_deleted, ec2_region, id"},
+	{"v2ray_instances", "idx_deleted_status", "is_deleted, status, created_at"},
+	{"v2ray_instances", "idx_deleted_status_id", "is_deleted, status, id"},
+	{"v2ray_instances", "idx_deleted_owner", "is_deleted, owner, created_at"},
+	{"v2ray_instances", "idx_created", "created_at, id"},
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+	"github.com/yuhai94/anywhere_backend/internal/tracing"
+)
+
+// AddTraffic 将一次采集的流量累加到所在小时的统计中
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - rollups: 各统计对象新增的流量，Period 为所在小时的开始时间
+//
+// 返回值:
+//   - error: 错误信息，如果写入失败，此时所有统计都不会被修改
+//
+// 功能:
+//  1. 在一个事务中写入，调用方可以在失败后保留本次流量并在下次采集时重试
+//  2. 同一小时已有记录时累加上下行字节数
+func (r *Repository) AddTraffic(ctx context.Context, rollups []*models.TrafficRollup) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO traffic_stats (kind, name, period, uplink, downlink)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE uplink = uplink + VALUES(uplink), downlink = downlink + VALUES(downlink)
+	`
+	ctx, span := startSpan(ctx, "AddTraffic", query)
+	defer span.End()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to begin traffic transaction: %v", err)
+		return err
+	}
+	for _, rollup := range rollups {
+		if _, err := tx.ExecContext(ctx, query, rollup.Kind, rollup.Name, rollup.Period, rollup.Uplink, rollup.Downlink); err != nil {
+			tx.Rollback()
+			tracing.RecordError(span, err)
+			logging.Error(ctx, "Failed to add traffic for %s %s: %v", rollup.Kind, rollup.Name, err)
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to commit traffic: %v", err)
+		return err
+	}
+	return nil
+}
+
+// ListTraffic 获取一个统计对象在时间段内的每小时流量
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - kind: 统计对象类型
+//   - name: 统计对象名称
+//   - from: 时间段开始（包含）
+//   - to: 时间段结束（不包含）
+//
+// 返回值:
+//   - []*models.TrafficRollup: 按时间排序的每小时流量，没有流量的小时不返回
+//   - error: 错误信息，如果查询失败
+func (r *Repository) ListTraffic(ctx context.Context, kind, name string, from, to time.Time) ([]*models.TrafficRollup, error) {
+	var rollups []*models.TrafficRollup
+	query := `
+		SELECT kind, name, period, uplink, downlink FROM traffic_stats
+		WHERE kind = ? AND name = ? AND period >= ? AND period < ?
+		ORDER BY period ASC
+	`
+	ctx, span := startSpan(ctx, "ListTraffic", query)
+	defer span.End()
+	if err := r.db.SelectContext(ctx, &rollups, query, kind, name, from, to); err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to list traffic for %s %s: %v", kind, name, err)
+		return nil, err
+	}
+	return rollups, nil
+}
+
+// SumTraffic 获取一类统计对象在时间段内的总流量
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - kind: 统计对象类型
+//   - from: 时间段开始（包含）
+//   - to: 时间段结束（不包含）
+//
+// 返回值:
+//   - []*models.TrafficRollup: 每个统计对象一项，Period 为 from，按名称排序
+//   - error: 错误信息，如果查询失败
+func (r *Repository) SumTraffic(ctx context.Context, kind string, from, to time.Time) ([]*models.TrafficRollup, error) {
+	var rollups []*models.TrafficRollup
+	query := `
+		SELECT kind, name, CAST(SUM(uplink) AS SIGNED) AS uplink, CAST(SUM(downlink) AS SIGNED) AS downlink
+		FROM traffic_stats
+		WHERE kind = ? AND period >= ? AND period < ?
+		GROUP BY kind, name
+		ORDER BY name ASC
+	`
+	ctx, span := startSpan(ctx, "SumTraffic", query)
+	defer span.End()
+	if err := r.db.SelectContext(ctx, &rollups, query, kind, from, to); err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to sum %s traffic: %v", kind, err)
+		return nil, err
+	}
+	for _, rollup := range rollups {
+		rollup.Period = from
+	}
+	return rollups, nil
+}
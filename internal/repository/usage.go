@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+	"github.com/yuhai94/anywhere_backend/internal/tracing"
+)
+
+// StartUsage 记录实例开始运行
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - instanceUUID: 实例 UUID
+//   - at: 开始运行的时间
+//
+// 返回值:
+//   - error: 错误信息，如果写入失败
+//
+// 功能:
+//  1. 实例已有未结束的运行记录时不重复创建，重复的 running 事件不会重复计费
+func (r *Repository) StartUsage(ctx context.Context, instanceUUID string, at time.Time) error {
+	query := `
+		INSERT INTO instance_usage (instance_uuid, started_at)
+		SELECT ?, ? FROM DUAL
+		WHERE NOT EXISTS (
+			SELECT 1 FROM instance_usage WHERE instance_uuid = ? AND stopped_at IS NULL
+		)
+	`
+	ctx, span := startSpan(ctx, "StartUsage", query)
+	defer span.End()
+	if _, err := r.db.ExecContext(ctx, query, instanceUUID, at, instanceUUID); err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to start usage for instance %s: %v", instanceUUID, err)
+		return err
+	}
+	return nil
+}
+
+// StopUsage 记录实例停止运行
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - instanceUUID: 实例 UUID
+//   - at: 停止运行的时间
+//
+// 返回值:
+//   - error: 错误信息，如果更新失败
+func (r *Repository) StopUsage(ctx context.Context, instanceUUID string, at time.Time) error {
+	query := `UPDATE instance_usage SET stopped_at = ? WHERE instance_uuid = ? AND stopped_at IS NULL`
+	ctx, span := startSpan(ctx, "StopUsage", query)
+	defer span.End()
+	if _, err := r.db.ExecContext(ctx, query, at, instanceUUID); err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to stop usage for instance %s: %v", instanceUUID, err)
+		return err
+	}
+	return nil
+}
+
+// ListUsage 获取与时间段有重叠的运行记录
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - from: 时间段开始（包含）
+//   - to: 时间段结束（不包含）
+//
+// 返回值:
+//   - []*models.UsageRecord: 运行记录，包含已删除实例的记录
+//   - error: 错误信息，如果查询失败
+//
+// 功能:
+//  1. uuid 在实例表中不唯一（例如重新收录的实例），区域、创建者和实例类型取该 uuid 最新的一条实例记录，
+//     每条运行记录只返回一次，不会因重复的实例记录重复计算运行时间
+func (r *Repository) ListUsage(ctx context.Context, from, to time.Time) ([]*models.UsageRecord, error) {
+	var records []*models.UsageRecord
+	query := `
+		SELECT u.instance_uuid, i.ec2_region, i.owner, i.instance_type, u.started_at, u.stopped_at
+		FROM instance_usage u
+		JOIN v2ray_instances i ON i.id = (
+			SELECT MAX(latest.id) FROM v2ray_instances latest WHERE latest.uuid = u.instance_uuid
+		)
+		WHERE u.started_at < ? AND (u.stopped_at IS NULL OR u.stopped_at > ?)
+		ORDER BY u.started_at ASC
+	`
+	ctx, span := startSpan(ctx, "ListUsage", query)
+	defer span.End()
+	if err := r.db.SelectContext(ctx, &records, query, to, from); err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to list usage: %v", err)
+		return nil, err
+	}
+	return records, nil
+}
@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+	"github.com/yuhai94/anywhere_backend/internal/tracing"
+)
+
+// CreateWebhook 创建 webhook 订阅
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - webhook: 要创建的 webhook
+//
+// 返回值:
+//   - error: 错误信息，如果创建失败
+func (r *Repository) CreateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	query := `INSERT INTO webhooks (url, secret, events, enabled) VALUES (?, ?, ?, ?)`
+	ctx, span := startSpan(ctx, "CreateWebhook", query)
+	defer span.End()
+	result, err := r.db.ExecContext(ctx, query, webhook.URL, webhook.Secret, webhook.Events, webhook.Enabled)
+	if err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to create webhook: %v", err)
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to get last insert id: %v", err)
+		return err
+	}
+
+	webhook.ID = int(id)
+	logging.Info(ctx, "Created webhook %d for %s", webhook.ID, webhook.URL)
+	return nil
+}
+
+// GetWebhook 根据 ID 获取 webhook
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - id: webhook ID
+//
+// 返回值:
+//   - *models.Webhook: 找到的 webhook
+//   - error: 错误信息，如果获取失败
+func (r *Repository) GetWebhook(ctx context.Context, id int) (*models.Webhook, error) {
+	var webhook models.Webhook
+	query := `SELECT * FROM webhooks WHERE id = ?`
+	ctx, span := startSpan(ctx, "GetWebhook", query)
+	defer span.End()
+	err := r.db.GetContext(ctx, &webhook, query, id)
+	if err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to get webhook %d: %v", id, err)
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// ListWebhooks 获取所有 webhook 订阅
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//
+// 返回值:
+//   - []*models.Webhook: webhook 列表
+//   - error: 错误信息，如果获取失败
+func (r *Repository) ListWebhooks(ctx context.Context) ([]*models.Webhook, error) {
+	var webhooks []*models.Webhook
+	query := `SELECT * FROM webhooks ORDER BY id ASC`
+	ctx, span := startSpan(ctx, "ListWebhooks", query)
+	defer span.End()
+	err := r.db.SelectContext(ctx, &webhooks, query)
+	if err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to list webhooks: %v", err)
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook 删除 webhook 订阅
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - id: webhook ID
+//
+// 返回值:
+//   - error: 错误信息，如果删除失败
+//
+// 功能:
+//  1. 删除 webhook 记录
+//  2. 将该 webhook 尚未完成的投递标记为失败，投递日志保留
+func (r *Repository) DeleteWebhook(ctx context.Context, id int) error {
+	query := `DELETE FROM webhooks WHERE id = ?`
+	ctx, span := startSpan(ctx, "DeleteWebhook", query)
+	defer span.End()
+	if _, err := r.db.ExecContext(ctx, query, id); err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to delete webhook %d: %v", id, err)
+		return err
+	}
+
+	cancelQuery := `UPDATE webhook_deliveries SET status = ?, last_error = ? WHERE webhook_id = ? AND status = ?`
+	if _, err := r.db.ExecContext(ctx, cancelQuery, models.DeliveryFailed, "webhook deleted", id, models.DeliveryPending); err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to cancel pending deliveries for webhook %d: %v", id, err)
+		return err
+	}
+
+	logging.Info(ctx, "Deleted webhook %d", id)
+	return nil
+}
+
+// CreateDelivery 创建一条待投递记录
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - delivery: 要创建的投递记录
+//
+// 返回值:
+//   - error: 错误信息，如果创建失败
+func (r *Repository) CreateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, status, last_error, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, '', ?)
+	`
+	ctx, span := startSpan(ctx, "CreateDelivery", query)
+	defer span.End()
+	result, err := r.db.ExecContext(ctx, query,
+		delivery.WebhookID, delivery.EventID, delivery.EventType, delivery.Payload,
+		delivery.Status, delivery.NextAttemptAt.Time,
+	)
+	if err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to create delivery for webhook %d: %v", delivery.WebhookID, err)
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to get last insert id: %v", err)
+		return err
+	}
+
+	delivery.ID = id
+	return nil
+}
+
+// ListDueDeliveries 获取已到投递时间的待投递记录
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - now: 当前时间
+//   - limit: 最多返回的记录数量
+//
+// 返回值:
+//   - []*models.WebhookDelivery: 按下次尝试时间升序排列的投递记录
+//   - error: 错误信息，如果查询失败
+func (r *Repository) ListDueDeliveries(ctx context.Context, now time.Time, limit int) ([]*models.WebhookDelivery, error) {
+	var deliveries []*models.WebhookDelivery
+	query := `
+		SELECT * FROM webhook_deliveries
+		WHERE status = ? AND next_attempt_at <= ?
+		ORDER BY next_attempt_at ASC, id ASC
+		LIMIT ?
+	`
+	ctx, span := startSpan(ctx, "ListDueDeliveries", query)
+	defer span.End()
+	err := r.db.SelectContext(ctx, &deliveries, query, models.DeliveryPending, now, limit)
+	if err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to list due deliveries: %v", err)
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// UpdateDelivery 更新投递记录的结果
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - delivery: 要更新的投递记录
+//
+// 返回值:
+//   - error: 错误信息，如果更新失败
+func (r *Repository) UpdateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, response_status = ?, last_error = ?, next_attempt_at = ?
+		WHERE id = ?
+	`
+	ctx, span := startSpan(ctx, "UpdateDelivery", query)
+	defer span.End()
+	_, err := r.db.ExecContext(ctx, query,
+		delivery.Status, delivery.Attempts, delivery.ResponseStatus, delivery.LastError,
+		delivery.NextAttemptAt.Time, delivery.ID,
+	)
+	if err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to update delivery %d: %v", delivery.ID, err)
+		return err
+	}
+	return nil
+}
+
+// ListDeliveries 获取指定 webhook 的投递日志
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - webhookID: webhook ID
+//   - limit: 最多返回的记录数量
+//
+// 返回值:
+//   - []*models.WebhookDelivery: 按 ID 倒序排列的投递记录
+//   - error: 错误信息，如果查询失败
+func (r *Repository) ListDeliveries(ctx context.Context, webhookID int, limit int) ([]*models.WebhookDelivery, error) {
+	var deliveries []*models.WebhookDelivery
+	query := `SELECT * FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?`
+	ctx, span := startSpan(ctx, "ListDeliveries", query)
+	defer span.End()
+	err := r.db.SelectContext(ctx, &deliveries, query, webhookID, limit)
+	if err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to list deliveries for webhook %d: %v", webhookID, err)
+		return nil, err
+	}
+	return deliveries, nil
+}
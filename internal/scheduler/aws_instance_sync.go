@@ -6,25 +6,35 @@ import (
 
 	"github.com/yuhai94/anywhere_backend/internal/aws"
 	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/events"
 	"github.com/yuhai94/anywhere_backend/internal/interfaces"
 	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/metrics"
 	"github.com/yuhai94/anywhere_backend/internal/models"
+	"github.com/yuhai94/anywhere_backend/internal/repository"
+	"github.com/yuhai94/anywhere_backend/internal/tracing"
 )
 
 // AWSInstanceSyncTask AWS实例同步任务
 type AWSInstanceSyncTask struct {
 	ec2Client interfaces.EC2ClientInterface
 	repo      interfaces.RepositoryInterface
+	bus       *events.Bus
 	ticker    *time.Ticker
 	stopCh    chan struct{}
+	// expiringNotified 已发送过即将到期事件的实例 UUID，只用于减少查询，是否发送过以 lifecycle_events 为准
+	expiringNotified map[string]bool
 }
 
 // NewAWSInstanceSyncTask 创建新的AWS实例同步任务
-func NewAWSInstanceSyncTask(ec2Client interfaces.EC2ClientInterface, repo interfaces.RepositoryInterface) *AWSInstanceSyncTask {
+func NewAWSInstanceSyncTask(ec2Client interfaces.EC2ClientInterface, repo interfaces.RepositoryInterface, bus *events.Bus) *AWSInstanceSyncTask {
 	return &AWSInstanceSyncTask{
 		ec2Client: ec2Client,
 		repo:      repo,
+		bus:       bus,
 		stopCh:    make(chan struct{}),
+
+		expiringNotified: make(map[string]bool),
 	}
 }
 
@@ -38,11 +48,12 @@ func (t *AWSInstanceSyncTask) Start(ctx context.Context) {
 	logging.Info(ctx, "Starting AWS instance sync task")
 
 	// 立即执行一次同步
-	t.syncInstances(ctx)
+	t.syncInstances(ctx, false)
 
-	// 设置定时器
-	syncInterval := time.Duration(config.AppConfig.Scheduler.InstanceSyncInterval) * time.Second
-	t.ticker = time.NewTicker(syncInterval)
+	// 设置定时器，配置重新加载后按新的间隔执行
+	reloaded := configReloaded()
+	interval := syncInterval()
+	t.ticker = time.NewTicker(interval)
 	defer t.ticker.Stop()
 
 	for {
@@ -54,31 +65,64 @@ func (t *AWSInstanceSyncTask) Start(ctx context.Context) {
 			logging.Info(ctx, "AWS instance sync task stopped")
 			return
 		case <-t.ticker.C:
-			t.syncInstances(ctx)
+			t.syncInstances(ctx, false)
+		case <-reloaded:
+			if next := syncInterval(); next != interval {
+				logging.Info(ctx, "AWS instance sync interval changed from %s to %s", interval, next)
+				interval = next
+				t.ticker.Reset(interval)
+			}
 		}
 	}
 }
 
+// syncInterval 返回当前配置的同步间隔
+func syncInterval() time.Duration {
+	return time.Duration(config.Get().Scheduler.InstanceSyncInterval) * time.Second
+}
+
 // Stop 停止任务
 func (t *AWSInstanceSyncTask) Stop() {
 	close(t.stopCh)
 }
 
-// syncInstances 同步AWS实例列表到数据库
-func (t *AWSInstanceSyncTask) syncInstances(ctx context.Context) {
-	logging.Info(ctx, "Starting AWS instance sync")
+// SyncChange 一次同步中发现的差异
+type SyncChange struct {
+	// Kind 差异类型，取值与 metrics.Drift* 相同
+	Kind         string `json:"kind"`
+	InstanceUUID string `json:"instance_uuid"`
+	EC2ID        string `json:"ec2_id"`
+	Account      string `json:"account,omitempty"`
+	Region       string `json:"region"`
+	Old          string `json:"old,omitempty"`
+	New          string `json:"new,omitempty"`
+}
 
-	// 从配置文件获取所有region
-	regions := make([]string, 0, len(config.AppConfig.AWS.Regions))
-	for region := range config.AppConfig.AWS.Regions {
-		regions = append(regions, region)
-	}
+// SyncOnce 立即执行一次同步并返回发现的差异
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - dryRun: 为 true 时只计算差异，不写数据库也不发布事件
+//
+// 返回值:
+//   - []SyncChange: 发现的差异
+func (t *AWSInstanceSyncTask) SyncOnce(ctx context.Context, dryRun bool) []SyncChange {
+	return t.syncInstances(ctx, dryRun)
+}
+
+// syncInstances 同步AWS实例列表到数据库，返回发现的差异
+func (t *AWSInstanceSyncTask) syncInstances(ctx context.Context, dryRun bool) []SyncChange {
+	ctx, span := tracing.Start(ctx, "scheduler.syncInstances")
+	defer span.End()
+
+	var changes []SyncChange
+
+	logging.Info(ctx, "Starting AWS instance sync")
 
 	// 获取数据库中的实例列表
 	dbInstances, err := t.repo.List(ctx)
 	if err != nil {
 		logging.Error(ctx, "Failed to get instances from database: %v", err)
-		return
+		return nil
 	}
 
 	// 创建数据库实例映射，用于快速查找
@@ -87,82 +131,218 @@ func (t *AWSInstanceSyncTask) syncInstances(ctx context.Context) {
 		dbInstanceMap[instance.EC2ID] = instance
 	}
 
-	// 记录AWS中存在的实例ID
-	awsInstanceIDs := make(map[string]bool)
+	// 查询失败的账号和区域组合，其中的实例不标记为已删除
+	failedTargets := make(map[aws.Target]bool)
 
-	// 遍历每个region，获取实例列表
-	for _, region := range regions {
-		instances, err := t.ec2Client.DescribeInstances(ctx, region)
+	// 遍历每个账号和区域组合，获取实例列表
+	for _, target := range aws.Targets(config.Get(), dbInstances) {
+		instances, err := t.ec2Client.DescribeInstances(ctx, target.Account, target.Region)
 		if err != nil {
-			logging.Error(ctx, "Failed to describe instances in region %s: %v", region, err)
+			logging.Error(ctx, "Failed to describe instances in account %s region %s: %v", target.Account, target.Region, err)
+			failedTargets[target] = true
 			continue
 		}
 
 		for _, instance := range instances {
-			awsInstanceIDs[instance.InstanceID] = true
-
 			// 检查数据库中是否存在该实例
 			if dbInstance, exists := dbInstanceMap[instance.InstanceID]; exists {
 				// 数据库中存在，更新实例信息
-				t.updateInstance(ctx, dbInstance, instance)
+				changes = append(changes, t.updateInstance(ctx, dbInstance, instance, dryRun)...)
+				if !dryRun {
+					t.checkExpiring(ctx, dbInstance)
+				}
 				delete(dbInstanceMap, instance.InstanceID)
-			} else {
+			} else if instance.UUID != "" {
 				// 数据库中不存在，创建新实例
-				t.createInstance(ctx, instance)
+				changes = append(changes, SyncChange{
+					Kind:         metrics.DriftUntracked,
+					InstanceUUID: instance.UUID,
+					EC2ID:        instance.InstanceID,
+					Account:      instance.Account,
+					Region:       instance.Region,
+					New:          instance.Status,
+				})
+				if !dryRun {
+					t.createInstance(ctx, instance)
+				}
+			} else {
+				// 跳过没有UUID标签的实例
+				logging.Info(ctx, "Skipping instance %s without UUID tag", instance.InstanceID)
 			}
 		}
 	}
 
 	// 数据库中存在但AWS中不存在的实例，标记为已删除
 	for ec2ID, instance := range dbInstanceMap {
+		if failedTargets[aws.InstanceTarget(instance)] {
+			continue
+		}
+		changes = append(changes, SyncChange{
+			Kind:         metrics.DriftMissing,
+			InstanceUUID: instance.UUID,
+			EC2ID:        ec2ID,
+			Account:      instance.EC2Account,
+			Region:       instance.EC2Region,
+			Old:          instance.Status,
+			New:          models.StatusDeleted,
+		})
+		if dryRun {
+			continue
+		}
 		logging.Info(ctx, "Instance %s not found in AWS, marking as deleted", ec2ID)
-		if err := t.repo.Delete(ctx, instance.UUID); err != nil {
+		metrics.IncSyncDrift(metrics.DriftMissing)
+		delete(t.expiringNotified, instance.UUID)
+		if err := t.repo.Delete(repository.WithEventCause(ctx, "sync: EC2 instance not found"), instance.UUID); err != nil {
 			logging.Error(ctx, "Failed to mark instance %s as deleted: %v", instance.UUID, err)
+		} else {
+			t.publish(ctx, &models.LifecycleEvent{
+				InstanceUUID: instance.UUID,
+				Region:       instance.EC2Region,
+				Type:         models.EventStatusChanged,
+				Status:       models.StatusDeleted,
+			})
 		}
 	}
 
-	logging.Info(ctx, "AWS instance sync completed")
+	logging.Info(ctx, "AWS instance sync completed, %d changes", len(changes))
+	return changes
 }
 
 // createInstance 创建新的实例记录
 func (t *AWSInstanceSyncTask) createInstance(ctx context.Context, instance aws.InstanceInfo) {
-	// 跳过没有UUID标签的实例
-	if instance.UUID == "" {
-		logging.Info(ctx, "Skipping instance %s without UUID tag", instance.InstanceID)
-		return
-	}
+	metrics.IncSyncDrift(metrics.DriftUntracked)
 
 	newInstance := &models.V2RayInstance{
-		UUID:        instance.UUID,
-		EC2ID:       instance.InstanceID,
-		EC2Region:   instance.Region,
-		EC2PublicIP: instance.PublicIP,
-		Status:      models.StatusRunning,
-		IsDeleted:   false,
+		UUID:         instance.UUID,
+		EC2ID:        instance.InstanceID,
+		EC2Region:    instance.Region,
+		EC2Account:   instance.Account,
+		InstanceType: instance.InstanceType,
+		EC2PublicIP:  instance.PublicIP,
+		Status:       models.StatusRunning,
+		IsDeleted:    false,
 	}
 
-	if err := t.repo.Create(ctx, newInstance); err != nil {
+	if err := t.repo.Create(repository.WithEventCause(ctx, "sync: adopted untracked EC2 instance"), newInstance); err != nil {
 		logging.Error(ctx, "Failed to create instance record for %s: %v", instance.InstanceID, err)
 	} else {
 		logging.Info(ctx, "Created new instance record for %s with ID: %d", instance.InstanceID, newInstance.ID)
+		t.publish(ctx, &models.LifecycleEvent{
+			InstanceUUID: newInstance.UUID,
+			Region:       newInstance.EC2Region,
+			Type:         models.EventStatusChanged,
+			Status:       newInstance.Status,
+			PublicIP:     newInstance.EC2PublicIP,
+		})
 	}
 }
 
-// updateInstance 更新实例记录
-func (t *AWSInstanceSyncTask) updateInstance(ctx context.Context, dbInstance *models.V2RayInstance, instance aws.InstanceInfo) {
+// updateInstance 更新实例记录，返回发现的差异
+func (t *AWSInstanceSyncTask) updateInstance(ctx context.Context, dbInstance *models.V2RayInstance, instance aws.InstanceInfo, dryRun bool) []SyncChange {
+	var diffs []SyncChange
+	var changes []*models.LifecycleEvent
+
 	// 更新公网IP
 	if dbInstance.EC2PublicIP != instance.PublicIP {
-		dbInstance.EC2PublicIP = instance.PublicIP
-		logging.Info(ctx, "Updated public IP for instance %s from %s to %s", instance.InstanceID, dbInstance.EC2PublicIP, instance.PublicIP)
+		diffs = append(diffs, SyncChange{
+			Kind: metrics.DriftIPChanged,
+			Old:  dbInstance.EC2PublicIP,
+			New:  instance.PublicIP,
+		})
+		changes = append(changes, &models.LifecycleEvent{
+			Type:     models.EventIPChanged,
+			PublicIP: instance.PublicIP,
+		})
 	}
 
 	// 更新状态
 	if dbInstance.Status != instance.Status {
-		dbInstance.Status = instance.Status
-		logging.Info(ctx, "Updated status for instance %s to %s", instance.InstanceID, instance.Status)
+		diffs = append(diffs, SyncChange{
+			Kind: metrics.DriftStatusChanged,
+			Old:  dbInstance.Status,
+			New:  instance.Status,
+		})
+		changes = append(changes, &models.LifecycleEvent{
+			Type:   models.EventStatusChanged,
+			Status: instance.Status,
+		})
+	}
+
+	for i := range diffs {
+		diffs[i].InstanceUUID = dbInstance.UUID
+		diffs[i].EC2ID = instance.InstanceID
+		diffs[i].Account = dbInstance.EC2Account
+		diffs[i].Region = dbInstance.EC2Region
+	}
+	if dryRun {
+		return diffs
+	}
+
+	for _, diff := range diffs {
+		metrics.IncSyncDrift(diff.Kind)
+		switch diff.Kind {
+		case metrics.DriftIPChanged:
+			logging.Info(ctx, "Updated public IP for instance %s from %s to %s", instance.InstanceID, diff.Old, diff.New)
+		case metrics.DriftStatusChanged:
+			logging.Info(ctx, "Updated status for instance %s to %s", instance.InstanceID, diff.New)
+		}
+	}
+	dbInstance.EC2PublicIP = instance.PublicIP
+	dbInstance.Status = instance.Status
+	// 实例类型用于费用统计，随其他字段一起更新，不视为差异
+	if instance.InstanceType != "" {
+		dbInstance.InstanceType = instance.InstanceType
 	}
 
-	if err := t.repo.Update(ctx, dbInstance); err != nil {
+	if err := t.repo.Update(repository.WithEventCause(ctx, "sync: EC2 state "+instance.Status), dbInstance); err != nil {
 		logging.Error(ctx, "Failed to update instance record for %s: %v", instance.InstanceID, err)
+		return diffs
+	}
+
+	for _, change := range changes {
+		change.InstanceUUID = dbInstance.UUID
+		change.Region = dbInstance.EC2Region
+		t.publish(ctx, change)
+	}
+	return diffs
+}
+
+// checkExpiring 检查运行中的实例是否超过配置的时长，超过时发布一次即将到期事件
+// 功能:
+//  1. 实例已有 expiring 生命周期事件时不再发布，重启服务或执行 sync-once 后也不会重复发送 webhook
+//  2. 查询失败时本轮不发布，下次同步时重试
+func (t *AWSInstanceSyncTask) checkExpiring(ctx context.Context, dbInstance *models.V2RayInstance) {
+	expiringAfter := time.Duration(config.Get().Webhook.ExpiringAfter) * time.Second
+	if expiringAfter <= 0 || dbInstance.Status != models.StatusRunning || t.expiringNotified[dbInstance.UUID] {
+		return
 	}
+
+	age := time.Since(dbInstance.CreatedAt.Time)
+	if age < expiringAfter {
+		return
+	}
+
+	notified, err := t.repo.HasEvent(ctx, dbInstance.UUID, models.EventExpiring)
+	if err != nil {
+		return
+	}
+	t.expiringNotified[dbInstance.UUID] = true
+	if notified {
+		return
+	}
+
+	logging.Info(ctx, "Instance %s has been running for %s, publishing expiring event", dbInstance.UUID, age.Round(time.Second))
+	t.publish(ctx, &models.LifecycleEvent{
+		InstanceUUID: dbInstance.UUID,
+		Region:       dbInstance.EC2Region,
+		Type:         models.EventExpiring,
+		Status:       dbInstance.Status,
+		PublicIP:     dbInstance.EC2PublicIP,
+	})
+}
+
+// publish 以同步任务为来源发布实例生命周期事件
+func (t *AWSInstanceSyncTask) publish(ctx context.Context, event *models.LifecycleEvent) {
+	event.Source = models.EventSourceSync
+	t.bus.Publish(ctx, event)
 }
@@ -0,0 +1,108 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/events"
+	"github.com/yuhai94/anywhere_backend/internal/interfaces"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+)
+
+// fakeEventRepo 只实现 HasEvent 的仓库，事件来自 store 中已持久化的生命周期事件
+type fakeEventRepo struct {
+	interfaces.RepositoryInterface
+	store   *fakeEventStore
+	err     error
+	queries int
+}
+
+func (r *fakeEventRepo) HasEvent(ctx context.Context, instanceUUID, eventType string) (bool, error) {
+	r.queries++
+	if r.err != nil {
+		return false, r.err
+	}
+	for _, event := range r.store.events {
+		if event.InstanceUUID == instanceUUID && event.Type == eventType {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// fakeEventStore 内存中的生命周期事件表
+type fakeEventStore struct {
+	events []*models.LifecycleEvent
+}
+
+func (s *fakeEventStore) AppendEvent(ctx context.Context, event *models.LifecycleEvent) error {
+	event.ID = int64(len(s.events) + 1)
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *fakeEventStore) ListEventsAfter(ctx context.Context, afterID int64, instanceUUID string, limit int) ([]*models.LifecycleEvent, error) {
+	return nil, nil
+}
+
+// TestCheckExpiring 每个实例只发布一次即将到期事件，已持久化的事件在重启后（新的任务实例）仍然生效
+func TestCheckExpiring(t *testing.T) {
+	previous := config.Get()
+	defer config.Set(previous)
+	config.Set(&config.Config{Webhook: config.WebhookConfig{ExpiringAfter: 3600}})
+
+	store := &fakeEventStore{}
+	repo := &fakeEventRepo{store: store}
+	bus := events.NewBus(store)
+	old := &models.V2RayInstance{UUID: "old", EC2Region: "ap-east-1", Status: models.StatusRunning, CreatedAt: models.CustomTime{Time: time.Now().Add(-2 * time.Hour)}}
+	young := &models.V2RayInstance{UUID: "young", EC2Region: "ap-east-1", Status: models.StatusRunning, CreatedAt: models.CustomTime{Time: time.Now()}}
+	stopped := &models.V2RayInstance{UUID: "stopped", EC2Region: "ap-east-1", Status: models.StatusDeleting, CreatedAt: old.CreatedAt}
+
+	expiring := func() int {
+		count := 0
+		for _, event := range store.events {
+			if event.Type == models.EventExpiring {
+				count++
+			}
+		}
+		return count
+	}
+
+	task := NewAWSInstanceSyncTask(nil, repo, bus)
+	for _, instance := range []*models.V2RayInstance{old, young, stopped} {
+		task.checkExpiring(context.Background(), instance)
+	}
+	if got := expiring(); got != 1 {
+		t.Fatalf("published %d expiring events, want 1", got)
+	}
+	if event := store.events[0]; event.InstanceUUID != "old" || event.Source != models.EventSourceSync {
+		t.Errorf("expiring event = %+v, want instance old from sync", event)
+	}
+
+	// 同一任务再次检查时不再查询
+	task.checkExpiring(context.Background(), old)
+	if repo.queries != 1 {
+		t.Errorf("queried %d times, want 1", repo.queries)
+	}
+
+	// 重启后的新任务从已持久化的事件得知已发送过
+	restarted := NewAWSInstanceSyncTask(nil, repo, bus)
+	restarted.checkExpiring(context.Background(), old)
+	if got := expiring(); got != 1 {
+		t.Errorf("published %d expiring events after restart, want 1", got)
+	}
+
+	// 查询失败时不发布，下次同步时重试
+	repo.err = errors.New("database is down")
+	other := &models.V2RayInstance{UUID: "other", EC2Region: "ap-east-1", Status: models.StatusRunning, CreatedAt: old.CreatedAt}
+	retry := NewAWSInstanceSyncTask(nil, repo, bus)
+	retry.checkExpiring(context.Background(), other)
+	repo.err = nil
+	retry.checkExpiring(context.Background(), other)
+	if got := expiring(); got != 2 {
+		t.Errorf("published %d expiring events after the retry, want 2", got)
+	}
+}
@@ -0,0 +1,99 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+)
+
+// configWatchInterval 检查配置文件是否变化的间隔
+const configWatchInterval = 5 * time.Second
+
+// ConfigReloadTask 配置重新加载任务
+type ConfigReloadTask struct {
+	stopCh chan struct{}
+}
+
+// NewConfigReloadTask 创建新的配置重新加载任务
+func NewConfigReloadTask() *ConfigReloadTask {
+	return &ConfigReloadTask{
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Name 返回任务名称
+func (t *ConfigReloadTask) Name() string {
+	return "config_reload"
+}
+
+// Start 启动任务
+// 功能:
+//  1. 收到 SIGHUP 信号时重新加载配置
+//  2. 定期检查配置文件内容，变化后自动重新加载
+//  3. 新配置校验失败时保留当前配置，修正后会再次尝试
+func (t *ConfigReloadTask) Start(ctx context.Context) {
+	logging.Info(ctx, "Starting config reload task, watching %s", config.Path())
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+
+	last, _ := fileChecksum(config.Path())
+	for {
+		select {
+		case <-ctx.Done():
+			logging.Info(ctx, "Config reload task stopped due to context cancellation")
+			return
+		case <-t.stopCh:
+			logging.Info(ctx, "Config reload task stopped")
+			return
+		case <-hup:
+			last, _ = fileChecksum(config.Path())
+			t.reload(ctx, "SIGHUP")
+		case <-ticker.C:
+			sum, err := fileChecksum(config.Path())
+			if err != nil || sum == last {
+				continue
+			}
+			last = sum
+			t.reload(ctx, "file change")
+		}
+	}
+}
+
+// Stop 停止任务
+func (t *ConfigReloadTask) Stop() {
+	close(t.stopCh)
+}
+
+// reload 重新加载配置并记录结果
+func (t *ConfigReloadTask) reload(ctx context.Context, reason string) {
+	old, current, err := config.Reload()
+	if err != nil {
+		logging.Error(ctx, "Failed to reload config on %s, keeping the current config: %v", reason, err)
+		return
+	}
+
+	logging.Info(ctx, "Config reloaded on %s, %d regions configured", reason, len(current.AWS.Regions))
+	for _, field := range config.RestartRequired(old, current) {
+		logging.Warn(ctx, "Config %s changed but only takes effect after a restart", field)
+	}
+}
+
+// fileChecksum 计算文件内容的摘要
+func fileChecksum(path string) ([sha256.Size]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(data), nil
+}
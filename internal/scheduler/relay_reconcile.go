@@ -0,0 +1,96 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/localv2ray"
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/service"
+	"github.com/yuhai94/anywhere_backend/internal/tracing"
+)
+
+// RelayReconciler 定义中转配置对账任务所需的接口
+type RelayReconciler interface {
+	ReconcileRelayConfig(ctx context.Context) ([]localv2ray.RelayChange, error)
+}
+
+// RelayReconcileTask 中转配置对账任务
+type RelayReconcileTask struct {
+	reconciler RelayReconciler
+	stopCh     chan struct{}
+}
+
+// NewRelayReconcileTask 创建新的中转配置对账任务
+func NewRelayReconcileTask(reconciler RelayReconciler) *RelayReconcileTask {
+	return &RelayReconcileTask{
+		reconciler: reconciler,
+		stopCh:     make(chan struct{}),
+	}
+}
+
+// Name 返回任务名称
+func (t *RelayReconcileTask) Name() string {
+	return "relay_reconcile"
+}
+
+// Start 启动任务
+// 功能:
+//  1. 启动时立即对账一次，之后按 scheduler.instance_sync_interval 定期执行
+//  2. 未配置 v2ray.local_config_path 时不做任何操作
+func (t *RelayReconcileTask) Start(ctx context.Context) {
+	logging.Info(ctx, "Starting relay reconcile task")
+
+	// 立即执行一次对账
+	t.reconcile(ctx)
+
+	reloaded := configReloaded()
+	interval := syncInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logging.Info(ctx, "Relay reconcile task stopped due to context cancellation")
+			return
+		case <-t.stopCh:
+			logging.Info(ctx, "Relay reconcile task stopped")
+			return
+		case <-ticker.C:
+			t.reconcile(ctx)
+		case <-reloaded:
+			if next := syncInterval(); next != interval {
+				logging.Info(ctx, "Relay reconcile interval changed from %s to %s", interval, next)
+				interval = next
+				ticker.Reset(interval)
+			}
+		}
+	}
+}
+
+// Stop 停止任务
+func (t *RelayReconcileTask) Stop() {
+	close(t.stopCh)
+}
+
+// reconcile 执行一次中转配置对账
+func (t *RelayReconcileTask) reconcile(ctx context.Context) {
+	ctx, span := tracing.Start(ctx, "scheduler.reconcileRelay")
+	defer span.End()
+
+	changes, err := t.reconciler.ReconcileRelayConfig(ctx)
+	if err != nil {
+		if errors.Is(err, service.ErrLocalV2RayDisabled) {
+			return
+		}
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to reconcile relay config: %v", err)
+		return
+	}
+
+	for _, change := range changes {
+		logging.Info(ctx, "Reconciled relay config: %s %s", change.Action, change.Tag)
+	}
+}
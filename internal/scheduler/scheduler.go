@@ -4,6 +4,7 @@ import (
 	"context"
 	"sync"
 
+	"github.com/yuhai94/anywhere_backend/internal/config"
 	"github.com/yuhai94/anywhere_backend/internal/logging"
 )
 
@@ -88,3 +89,18 @@ func (s *Scheduler) GetTask(name string) Task {
 	return s.tasks[name]
 }
 
+
+// configReloaded 返回一个在配置重新加载后收到通知的通道
+// 功能:
+//  1. 任务在 Start 中调用，收到通知后重新读取自己关心的配置项
+//  2. 通道带一个缓冲，任务处理前的多次重新加载合并为一次通知
+func configReloaded() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	config.OnReload(func(old, new *config.Config) {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	})
+	return ch
+}
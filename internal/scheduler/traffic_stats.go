@@ -0,0 +1,199 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/localv2ray"
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+	"github.com/yuhai94/anywhere_backend/internal/tracing"
+)
+
+// trafficStatsPattern 只查询流量计数器
+const trafficStatsPattern = ">>>traffic>>>"
+
+// trafficStatsTimeout 查询 V2Ray 流量计数器的超时时间
+const trafficStatsTimeout = 10 * time.Second
+
+// TrafficStore 定义流量统计任务所需的存储接口
+type TrafficStore interface {
+	List(ctx context.Context) ([]*models.V2RayInstance, error)
+	AddTraffic(ctx context.Context, rollups []*models.TrafficRollup) error
+}
+
+// RelayStatsSource 定义流量统计任务读取 V2Ray 计数器所需的接口
+type RelayStatsSource interface {
+	QueryRelayStats(ctx context.Context, pattern string, reset bool) ([]localv2ray.Stat, error)
+}
+
+// trafficKey 一个统计对象在一个小时内的流量
+type trafficKey struct {
+	kind   string
+	name   string
+	period time.Time
+}
+
+// TrafficStatsTask 中转流量统计任务
+type TrafficStatsTask struct {
+	store  TrafficStore
+	source RelayStatsSource
+	// pending 已从 V2Ray 读取并清零、但尚未写入数据库的流量，下次采集时重试
+	pending map[trafficKey]*models.TrafficRollup
+	stopCh  chan struct{}
+}
+
+// NewTrafficStatsTask 创建新的中转流量统计任务
+func NewTrafficStatsTask(store TrafficStore, source RelayStatsSource) *TrafficStatsTask {
+	return &TrafficStatsTask{
+		store:   store,
+		source:  source,
+		pending: make(map[trafficKey]*models.TrafficRollup),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// Name 返回任务名称
+func (t *TrafficStatsTask) Name() string {
+	return "traffic_stats"
+}
+
+// Start 启动任务
+// 功能:
+//  1. 按 scheduler.traffic_stats_interval 定期从每个中转的 api_address 读取并清零流量计数器
+//  2. 没有中转配置 api_address 时不采集，重新加载配置后按新的间隔执行
+func (t *TrafficStatsTask) Start(ctx context.Context) {
+	logging.Info(ctx, "Starting traffic stats task")
+
+	reloaded := configReloaded()
+	interval := trafficStatsInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logging.Info(ctx, "Traffic stats task stopped due to context cancellation")
+			return
+		case <-t.stopCh:
+			logging.Info(ctx, "Traffic stats task stopped")
+			return
+		case <-ticker.C:
+			t.collect(ctx)
+		case <-reloaded:
+			if next := trafficStatsInterval(); next != interval {
+				logging.Info(ctx, "Traffic stats interval changed from %s to %s", interval, next)
+				interval = next
+				ticker.Reset(interval)
+			}
+		}
+	}
+}
+
+// trafficStatsInterval 返回当前配置的流量采集间隔
+func trafficStatsInterval() time.Duration {
+	return time.Duration(config.Get().Scheduler.TrafficStatsInterval) * time.Second
+}
+
+// Stop 停止任务
+func (t *TrafficStatsTask) Stop() {
+	close(t.stopCh)
+}
+
+// collect 采集一次流量并写入数据库
+// 功能:
+//  1. 读取并清零所有中转的流量计数器，按出站、用户累加到当前小时，多个中转的同名计数器相加
+//  2. 中转出站的流量同时记到对应的实例上
+//  3. 写入失败时保留本次流量，下次采集时一起写入
+func (t *TrafficStatsTask) collect(ctx context.Context) {
+	ctx, span := tracing.Start(ctx, "scheduler.collectTraffic")
+	defer span.End()
+
+	queryCtx, cancel := context.WithTimeout(ctx, trafficStatsTimeout)
+	stats, err := t.source.QueryRelayStats(queryCtx, trafficStatsPattern, true)
+	cancel()
+	if err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to collect relay traffic: %v", err)
+		return
+	}
+
+	instances, err := t.store.List(ctx)
+	if err != nil {
+		// 实例列表只用于把出站流量记到实例上，出站和用户的流量仍然记录
+		logging.Error(ctx, "Failed to list instances for traffic stats: %v", err)
+	}
+	instanceByTag := runningInstanceByTag(instances)
+
+	period := hourStart(time.Now())
+	for _, stat := range stats {
+		kind, name, direction, ok := localv2ray.ParseStatName(stat.Name)
+		if !ok || stat.Value <= 0 {
+			continue
+		}
+		switch kind {
+		case localv2ray.StatKindOutbound:
+			t.add(models.TrafficOutbound, name, period, direction, stat.Value)
+			if instance, ok := instanceByTag[name]; ok {
+				t.add(models.TrafficInstance, instance.UUID, period, direction, stat.Value)
+			}
+		case localv2ray.StatKindUser:
+			t.add(models.TrafficUser, name, period, direction, stat.Value)
+		}
+	}
+
+	if len(t.pending) == 0 {
+		return
+	}
+	rollups := make([]*models.TrafficRollup, 0, len(t.pending))
+	for _, rollup := range t.pending {
+		rollups = append(rollups, rollup)
+	}
+	if err := t.store.AddTraffic(ctx, rollups); err != nil {
+		tracing.RecordError(span, err)
+		logging.Error(ctx, "Failed to store relay traffic, will retry with the next collection: %v", err)
+		return
+	}
+	t.pending = make(map[trafficKey]*models.TrafficRollup)
+	logging.Debug(ctx, "Stored traffic for %d counters", len(rollups))
+}
+
+// add 累加一个统计对象在一个小时内的流量
+func (t *TrafficStatsTask) add(kind, name string, period time.Time, direction string, bytes int64) {
+	key := trafficKey{kind: kind, name: name, period: period}
+	rollup, ok := t.pending[key]
+	if !ok {
+		rollup = &models.TrafficRollup{Kind: kind, Name: name, Period: period}
+		t.pending[key] = rollup
+	}
+	if direction == localv2ray.DirectionUplink {
+		rollup.Uplink += bytes
+	} else {
+		rollup.Downlink += bytes
+	}
+}
+
+// runningInstanceByTag 返回每个中转出站标签对应的实例
+// 功能:
+//  1. 每个实例的出站标签对应该实例，实例删除前最后一次采集的流量仍然记到该实例上
+//  2. 旧版每个区域一个出站的标签对应该区域最新创建的运行中实例
+func runningInstanceByTag(instances []*models.V2RayInstance) map[string]*models.V2RayInstance {
+	byTag := make(map[string]*models.V2RayInstance)
+	for _, instance := range instances {
+		byTag[localv2ray.InstanceTag(instance.EC2Region, instance.UUID)] = instance
+		if instance.Status != models.StatusRunning {
+			continue
+		}
+		tag := localv2ray.RegionTag(instance.EC2Region)
+		if current, ok := byTag[tag]; !ok || instance.CreatedAt.After(current.CreatedAt.Time) {
+			byTag[tag] = instance
+		}
+	}
+	return byTag
+}
+
+// hourStart 返回时间所在小时的开始时间
+func hourStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+}
@@ -0,0 +1,163 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/localv2ray"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeTrafficStore 记录写入的流量，err 不为空时写入失败
+type fakeTrafficStore struct {
+	instances []*models.V2RayInstance
+	err       error
+	added     [][]*models.TrafficRollup
+}
+
+func (s *fakeTrafficStore) List(ctx context.Context) ([]*models.V2RayInstance, error) {
+	return s.instances, nil
+}
+
+func (s *fakeTrafficStore) AddTraffic(ctx context.Context, rollups []*models.TrafficRollup) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.added = append(s.added, rollups)
+	return nil
+}
+
+// apiStatsSource 通过 APIClient 读取一个中转的计数器
+type apiStatsSource struct {
+	client *localv2ray.APIClient
+}
+
+func (s apiStatsSource) QueryRelayStats(ctx context.Context, pattern string, reset bool) ([]localv2ray.Stat, error) {
+	return s.client.QueryStats(ctx, pattern, reset)
+}
+
+// newFakeRelay 启动进程内的 StatsService 代替中转的 V2Ray API，返回服务和连接到它的统计来源
+func newFakeRelay(t *testing.T) (*localv2ray.StatsServer, RelayStatsSource) {
+	t.Helper()
+	engine, err := localv2ray.NewEngine(localv2ray.EngineV2Ray)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := localv2ray.NewStatsServer(engine)
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+
+	client := localv2ray.NewAPIClientWithDialer("127.0.0.1:10085", engine, func(ctx context.Context, network, address string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	})
+	t.Cleanup(func() {
+		client.Close()
+		server.Stop()
+	})
+	return server, apiStatsSource{client: client}
+}
+
+// rollupsByKey 按类型和名称索引写入的流量
+func rollupsByKey(rollups []*models.TrafficRollup) map[string]models.TrafficRollup {
+	byKey := make(map[string]models.TrafficRollup)
+	for _, rollup := range rollups {
+		byKey[rollup.Kind+"/"+rollup.Name] = *rollup
+	}
+	return byKey
+}
+
+// TestTrafficStatsCollect 出站、实例和用户的流量按当前小时汇总，入站和为 0 的计数器不记录，
+// 读取后中转的计数器清零
+func TestTrafficStatsCollect(t *testing.T) {
+	relay, source := newFakeRelay(t)
+	now := time.Now()
+	store := &fakeTrafficStore{instances: []*models.V2RayInstance{
+		{UUID: "old", EC2Region: "ap-east-1", Status: models.StatusRunning, CreatedAt: models.CustomTime{Time: now.Add(-2 * time.Hour)}},
+		{UUID: "new", EC2Region: "ap-east-1", Status: models.StatusRunning, CreatedAt: models.CustomTime{Time: now.Add(-time.Hour)}},
+		{UUID: "gone", EC2Region: "us-west-2", Status: models.StatusDeleted, CreatedAt: models.CustomTime{Time: now}},
+	}}
+	regionTag := localv2ray.RegionTag("ap-east-1")
+	instanceTag := localv2ray.InstanceTag("us-west-2", "gone")
+
+	relay.Add("outbound>>>"+regionTag+">>>traffic>>>uplink", 100)
+	relay.Add("outbound>>>"+regionTag+">>>traffic>>>downlink", 1000)
+	relay.Add("outbound>>>"+instanceTag+">>>traffic>>>downlink", 50)
+	relay.Add("outbound>>>direct>>>traffic>>>uplink", 7)
+	relay.Add("user>>>alice>>>traffic>>>downlink", 300)
+	relay.Add("user>>>idle>>>traffic>>>downlink", 0)
+	relay.Add("inbound>>>vmess-in>>>traffic>>>uplink", 9)
+
+	task := NewTrafficStatsTask(store, source)
+	before := hourStart(time.Now())
+	task.collect(context.Background())
+	after := hourStart(time.Now())
+
+	if len(store.added) != 1 {
+		t.Fatalf("AddTraffic called %d times, want 1", len(store.added))
+	}
+	want := map[string]models.TrafficRollup{
+		models.TrafficOutbound + "/" + regionTag:   {Uplink: 100, Downlink: 1000},
+		models.TrafficOutbound + "/" + instanceTag: {Downlink: 50},
+		models.TrafficOutbound + "/direct":         {Uplink: 7},
+		// 旧版区域出站的流量记到该区域最新创建的运行中实例上，已删除实例的出站仍然记到该实例
+		models.TrafficInstance + "/new":  {Uplink: 100, Downlink: 1000},
+		models.TrafficInstance + "/gone": {Downlink: 50},
+		models.TrafficUser + "/alice":    {Downlink: 300},
+	}
+	got := rollupsByKey(store.added[0])
+	if len(got) != len(want) {
+		t.Errorf("got %d rollups, want %d: %+v", len(got), len(want), got)
+	}
+	for key, w := range want {
+		rollup, ok := got[key]
+		if !ok {
+			t.Errorf("missing rollup %s", key)
+			continue
+		}
+		if rollup.Uplink != w.Uplink || rollup.Downlink != w.Downlink || (!rollup.Period.Equal(before) && !rollup.Period.Equal(after)) {
+			t.Errorf("rollup %s = %+v, want uplink %d, downlink %d in %s", key, rollup, w.Uplink, w.Downlink, after)
+		}
+	}
+
+	// 计数器已清零，下次采集没有流量可写
+	task.collect(context.Background())
+	if len(store.added) != 1 {
+		t.Errorf("AddTraffic called again with no new traffic: %+v", store.added[1:])
+	}
+}
+
+// TestTrafficStatsCollectRetry 写入失败时保留已清零的流量，下次采集时与新流量相加后写入
+func TestTrafficStatsCollectRetry(t *testing.T) {
+	relay, source := newFakeRelay(t)
+	store := &fakeTrafficStore{err: errors.New("database is down")}
+	task := NewTrafficStatsTask(store, source)
+
+	relay.Add("user>>>alice>>>traffic>>>uplink", 100)
+	task.collect(context.Background())
+	if len(store.added) != 0 || len(task.pending) != 1 {
+		t.Fatalf("after a failed write: added %d, pending %d, want 0 and 1", len(store.added), len(task.pending))
+	}
+
+	store.err = nil
+	relay.Add("user>>>alice>>>traffic>>>uplink", 20)
+	relay.Add("user>>>bob>>>traffic>>>downlink", 5)
+	task.collect(context.Background())
+
+	if len(store.added) != 1 {
+		t.Fatalf("AddTraffic succeeded %d times, want 1", len(store.added))
+	}
+	got := rollupsByKey(store.added[0])
+	if alice := got[models.TrafficUser+"/alice"]; alice.Uplink != 120 {
+		t.Errorf("alice uplink = %d, want 120 (100 retried + 20 new)", alice.Uplink)
+	}
+	if bob := got[models.TrafficUser+"/bob"]; bob.Downlink != 5 {
+		t.Errorf("bob downlink = %d, want 5", bob.Downlink)
+	}
+	if len(task.pending) != 0 {
+		t.Errorf("pending = %d after a successful write, want 0", len(task.pending))
+	}
+}
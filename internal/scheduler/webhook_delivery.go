@@ -0,0 +1,79 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/webhook"
+)
+
+// defaultWebhookPollInterval 默认的 webhook 投递轮询间隔
+const defaultWebhookPollInterval = 5 * time.Second
+
+// WebhookDeliveryTask webhook 投递任务
+type WebhookDeliveryTask struct {
+	dispatcher *webhook.Dispatcher
+	stopCh     chan struct{}
+}
+
+// NewWebhookDeliveryTask 创建新的 webhook 投递任务
+func NewWebhookDeliveryTask(dispatcher *webhook.Dispatcher) *WebhookDeliveryTask {
+	return &WebhookDeliveryTask{
+		dispatcher: dispatcher,
+		stopCh:     make(chan struct{}),
+	}
+}
+
+// Name 返回任务名称
+func (t *WebhookDeliveryTask) Name() string {
+	return "webhook_delivery"
+}
+
+// Start 启动任务
+func (t *WebhookDeliveryTask) Start(ctx context.Context) {
+	logging.Info(ctx, "Starting webhook delivery task")
+
+	// 立即投递一次，处理重启前遗留的记录
+	t.dispatcher.DeliverDue(ctx)
+
+	reloaded := configReloaded()
+	interval := pollInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logging.Info(ctx, "Webhook delivery task stopped due to context cancellation")
+			return
+		case <-t.stopCh:
+			logging.Info(ctx, "Webhook delivery task stopped")
+			return
+		case <-t.dispatcher.Notify():
+			t.dispatcher.DeliverDue(ctx)
+		case <-ticker.C:
+			t.dispatcher.DeliverDue(ctx)
+		case <-reloaded:
+			if next := pollInterval(); next != interval {
+				logging.Info(ctx, "Webhook poll interval changed from %s to %s", interval, next)
+				interval = next
+				ticker.Reset(interval)
+			}
+		}
+	}
+}
+
+// pollInterval 返回当前配置的投递轮询间隔
+func pollInterval() time.Duration {
+	if seconds := config.Get().Webhook.PollInterval; seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return defaultWebhookPollInterval
+}
+
+// Stop 停止任务
+func (t *WebhookDeliveryTask) Stop() {
+	close(t.stopCh)
+}
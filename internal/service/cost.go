@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/cost"
+	"github.com/yuhai94/anywhere_backend/internal/repository"
+)
+
+// ErrBudgetExceeded 适用的月度预算已用完，拒绝创建新实例
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+type CostService struct {
+	repo *repository.Repository
+}
+
+// NewCostService 创建一个新的 CostService 实例
+// 参数:
+//   - repo: Repository 实例，用于读取运行记录
+//
+// 返回值:
+//   - *CostService: 新创建的 CostService 实例
+func NewCostService(repo *repository.Repository) *CostService {
+	return &CostService{repo: repo}
+}
+
+// Report 生成指定月份的费用报告
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - month: 月份中的任意时间
+//
+// 返回值:
+//   - *cost.Report: 费用报告
+//   - error: 错误信息，如果读取运行记录失败
+func (s *CostService) Report(ctx context.Context, month time.Time) (*cost.Report, error) {
+	return monthReport(ctx, s.repo, month, time.Now())
+}
+
+// monthReport 读取运行记录和实例流量，按当前配置的价格计算月度报告
+func monthReport(ctx context.Context, repo *repository.Repository, month, now time.Time) (*cost.Report, error) {
+	from, to := cost.MonthRange(month)
+	records, err := repo.ListUsage(ctx, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list usage: %v", err)
+	}
+	traffic, err := instanceTrafficBytes(ctx, repo, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum traffic: %v", err)
+	}
+	return cost.Compute(config.Get().Cost, month, now, records, traffic), nil
+}
+
+// checkBudget 检查在指定区域为指定创建者创建实例是否超出预算
+// 返回值:
+//   - error: 超出预算时返回包装了 ErrBudgetExceeded 的错误，未配置预算时为 nil
+func (s *V2RayService) checkBudget(ctx context.Context, region, owner string) error {
+	budget := config.Get().Cost.Budget
+	if budget.Monthly <= 0 && len(budget.Regions) == 0 && len(budget.Owners) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	report, err := monthReport(ctx, s.repo, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to check budget: %v", err)
+	}
+	if err := cost.CheckBudget(report, region, owner); err != nil {
+		return fmt.Errorf("%w: %v", ErrBudgetExceeded, err)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+	"github.com/yuhai94/anywhere_backend/internal/repository"
+	"github.com/yuhai94/anywhere_backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// 实例列表每页的数量
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 500
+)
+
+// ErrInvalidListQuery 实例列表的过滤、排序或分页参数无效
+var ErrInvalidListQuery = errors.New("invalid list query")
+
+// listCursor 分页游标的内容，编码为 base64url JSON
+type listCursor struct {
+	// Sort 生成游标时的排序，换用其他排序时游标无效
+	Sort string `json:"s"`
+	// Value 上一页最后一个实例的排序字段值，时间字段为 RFC 3339 格式
+	Value string `json:"v"`
+	// ID 上一页最后一个实例的 ID
+	ID int `json:"id"`
+}
+
+// ListInstancesPage 按过滤条件分页获取 V2Ray 实例，默认只返回未删除的实例
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - query: 过滤、排序和分页条件
+//
+// 返回值:
+//   - *models.InstancePage: 一页实例、下一页的游标和满足条件的实例总数
+//   - error: 错误信息，参数无效时包装 ErrInvalidListQuery
+//
+// 功能:
+//  1. 校验状态、删除过滤方式、排序字段、每页数量和游标
+//  2. 在数据库中过滤和排序，多取一个实例判断是否还有下一页
+//  3. 有下一页时以本页最后一个实例的位置生成游标
+func (s *V2RayService) ListInstancesPage(ctx context.Context, query models.InstanceQuery) (*models.InstancePage, error) {
+	ctx, span := tracing.Start(ctx, "service.ListInstancesPage")
+	defer span.End()
+
+	q, err := buildListQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	limit := q.Limit
+	q.Limit++
+
+	instances, total, err := s.repo.ListPage(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &models.InstancePage{Instances: instances, Total: total}
+	if len(instances) > limit {
+		page.Instances = instances[:limit]
+		page.NextCursor = encodeListCursor(q.Sort, q.Descending, page.Instances[limit-1])
+	}
+	if page.Instances == nil {
+		page.Instances = []*models.V2RayInstance{}
+	}
+
+	regions := config.Get().AWS.Regions
+	for _, instance := range page.Instances {
+		if regionConfig, ok := regions[instance.EC2Region]; ok {
+			instance.EC2RegionName = regionConfig.Name
+		}
+	}
+	return page, nil
+}
+
+// buildListQuery 校验实例列表的参数并转换为仓库层的查询条件
+func buildListQuery(query models.InstanceQuery) (repository.InstanceListQuery, error) {
+	q := repository.InstanceListQuery{
+		Region:         query.Region,
+		Owner:          query.Owner,
+		CreatedAfter:   query.CreatedAfter,
+		IncludeDeleted: query.IncludeDeleted,
+		DeletedOnly:    query.DeletedOnly,
+		Limit:          query.Limit,
+	}
+	if q.IncludeDeleted && q.DeletedOnly {
+		return q, fmt.Errorf("%w: include_deleted and deleted_only cannot be used together", ErrInvalidListQuery)
+	}
+
+	for _, status := range query.Statuses {
+		switch status {
+		case models.StatusPending, models.StatusCreating, models.StatusRunning,
+			models.StatusDeleting, models.StatusDeleted, models.StatusError:
+			q.Statuses = append(q.Statuses, status)
+		default:
+			return q, fmt.Errorf("%w: unknown status %q", ErrInvalidListQuery, status)
+		}
+	}
+
+	sort := query.Sort
+	if sort == "" {
+		sort = "-" + models.InstanceSortCreatedAt
+	}
+	q.Sort, q.Descending = strings.TrimPrefix(sort, "-"), strings.HasPrefix(sort, "-")
+	switch q.Sort {
+	case models.InstanceSortCreatedAt, models.InstanceSortUpdatedAt, models.InstanceSortRegion, models.InstanceSortStatus:
+	default:
+		return q, fmt.Errorf("%w: sort must be one of created_at, updated_at, region, status, optionally prefixed with -", ErrInvalidListQuery)
+	}
+
+	switch {
+	case q.Limit == 0:
+		q.Limit = DefaultListLimit
+	case q.Limit < 0 || q.Limit > MaxListLimit:
+		return q, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidListQuery, MaxListLimit)
+	}
+
+	if query.Cursor != "" {
+		after, err := decodeListCursor(query.Cursor, sort, q.Sort)
+		if err != nil {
+			return q, fmt.Errorf("%w: %v", ErrInvalidListQuery, err)
+		}
+		q.After = after
+	}
+	return q, nil
+}
+
+// encodeListCursor 以实例在列表中的位置生成游标
+func encodeListCursor(sort string, descending bool, instance *models.V2RayInstance) string {
+	cursor := listCursor{Sort: sort, ID: instance.ID}
+	if descending {
+		cursor.Sort = "-" + sort
+	}
+	switch sort {
+	case models.InstanceSortCreatedAt:
+		cursor.Value = instance.CreatedAt.Time.Format(time.RFC3339Nano)
+	case models.InstanceSortUpdatedAt:
+		cursor.Value = instance.UpdatedAt.Time.Format(time.RFC3339Nano)
+	case models.InstanceSortRegion:
+		cursor.Value = instance.EC2Region
+	case models.InstanceSortStatus:
+		cursor.Value = instance.Status
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeListCursor 解析游标
+// 参数:
+//   - value: 游标
+//   - sort: 本次请求的排序，包括 - 前缀
+//   - field: 排序字段
+//
+// 返回值:
+//   - *repository.InstanceListPosition: 上一页最后一个实例的位置
+//   - error: 错误信息，如果游标格式错误或与本次请求的排序不一致
+func decodeListCursor(value, sort, field string) (*repository.InstanceListPosition, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	var cursor listCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	if cursor.Sort != sort {
+		return nil, fmt.Errorf("cursor was issued for sort %q, not %q", cursor.Sort, sort)
+	}
+
+	position := &repository.InstanceListPosition{Value: cursor.Value, ID: cursor.ID}
+	if field == models.InstanceSortCreatedAt || field == models.InstanceSortUpdatedAt {
+		t, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return nil, errors.New("malformed cursor")
+		}
+		position.Value = t
+	}
+	return position, nil
+}
+
+// InstanceTimeline 获取实例及其历史事件，包括已删除的实例
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - uuid: 实例 UUID
+//
+// 返回值:
+//   - *models.InstanceTimeline: 实例记录和按发生顺序排列的事件
+//   - error: 错误信息，实例不存在时为包装了 ErrInstanceNotFound 的错误
+//
+// 功能:
+//  1. 读取实例记录，已删除的实例同样返回
+//  2. 读取实例的历史事件，计算实例在每个状态停留的时间
+func (s *V2RayService) InstanceTimeline(ctx context.Context, uuid string) (*models.InstanceTimeline, error) {
+	ctx, span := tracing.Start(ctx, "service.InstanceTimeline", attribute.String("instance.uuid", uuid))
+	defer span.End()
+
+	instance, err := s.repo.GetByUUIDWithDeleted(ctx, uuid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrInstanceNotFound, uuid)
+		}
+		return nil, fmt.Errorf("failed to get instance: %v", err)
+	}
+	if regionConfig, ok := config.Get().AWS.Regions[instance.EC2Region]; ok {
+		instance.EC2RegionName = regionConfig.Name
+	}
+
+	events, err := s.repo.ListInstanceEvents(ctx, uuid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list instance events: %v", err)
+	}
+	if events == nil {
+		events = []*models.InstanceEvent{}
+	}
+
+	setEventDurations(events, time.Now())
+
+	return &models.InstanceTimeline{Instance: instance, Events: events}, nil
+}
+
+// setEventDurations 计算实例在每条历史事件之后停留的秒数
+// 参数:
+//   - events: 按发生顺序排列的历史事件
+//   - now: 当前时间，最后一条事件计算到该时间
+//
+// 功能:
+//  1. 每条事件计算到下一条事件发生为止，最后一条 deleted 事件为 0
+//  2. 时间倒退（例如数据库时钟调整）时为 0，不返回负数
+func setEventDurations(events []*models.InstanceEvent, now time.Time) {
+	for i, event := range events {
+		end := now
+		if i+1 < len(events) {
+			end = events[i+1].CreatedAt.Time
+		} else if event.ToStatus == models.StatusDeleted {
+			end = event.CreatedAt.Time
+		}
+		event.DurationSeconds = 0
+		if end.After(event.CreatedAt.Time) {
+			event.DurationSeconds = int64(end.Sub(event.CreatedAt.Time) / time.Second)
+		}
+	}
+}
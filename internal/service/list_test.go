@@ -0,0 +1,190 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/models"
+)
+
+// TestSetEventDurations 每条事件停留到下一条事件，最后一条停留到现在，deleted 为 0
+func TestSetEventDurations(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	event := func(seconds int, toStatus string) *models.InstanceEvent {
+		return &models.InstanceEvent{
+			ToStatus:  toStatus,
+			CreatedAt: models.CustomTime{Time: start.Add(time.Duration(seconds) * time.Second)},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		events []*models.InstanceEvent
+		now    time.Time
+		want   []int64
+	}{
+		{"no events", nil, start, nil},
+		{
+			"running until now",
+			[]*models.InstanceEvent{event(0, models.StatusPending), event(5, models.StatusCreating), event(65, models.StatusRunning)},
+			start.Add(165 * time.Second),
+			[]int64{5, 60, 100},
+		},
+		{
+			"deleted",
+			[]*models.InstanceEvent{event(0, models.StatusPending), event(10, models.StatusRunning), event(70, models.StatusDeleted)},
+			start.Add(time.Hour),
+			[]int64{10, 60, 0},
+		},
+		{
+			"sub-second durations round down",
+			[]*models.InstanceEvent{event(0, models.StatusPending), {ToStatus: models.StatusCreating, CreatedAt: models.CustomTime{Time: start.Add(1500 * time.Millisecond)}}},
+			start.Add(1500 * time.Millisecond),
+			[]int64{1, 0},
+		},
+		{
+			"clock moved backwards",
+			[]*models.InstanceEvent{event(10, models.StatusPending), event(5, models.StatusCreating)},
+			start,
+			[]int64{0, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEventDurations(tt.events, tt.now)
+			for i, event := range tt.events {
+				if event.DurationSeconds != tt.want[i] {
+					t.Errorf("event %d duration = %d, want %d", i, event.DurationSeconds, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestBuildListQuery 默认按创建时间倒序、每页 50 个，无效的过滤、排序、数量和游标包装 ErrInvalidListQuery
+func TestBuildListQuery(t *testing.T) {
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cursor := encodeListCursor(models.InstanceSortCreatedAt, true, &models.V2RayInstance{ID: 7, CreatedAt: models.CustomTime{Time: created}})
+
+	tests := []struct {
+		name           string
+		query          models.InstanceQuery
+		wantErr        string
+		wantSort       string
+		wantDescending bool
+		wantLimit      int
+	}{
+		{"defaults", models.InstanceQuery{}, "", models.InstanceSortCreatedAt, true, DefaultListLimit},
+		{"ascending", models.InstanceQuery{Sort: "region"}, "", models.InstanceSortRegion, false, DefaultListLimit},
+		{"descending", models.InstanceQuery{Sort: "-status"}, "", models.InstanceSortStatus, true, DefaultListLimit},
+		{"max limit", models.InstanceQuery{Limit: MaxListLimit}, "", models.InstanceSortCreatedAt, true, MaxListLimit},
+		{"limit above max", models.InstanceQuery{Limit: MaxListLimit + 1}, "limit must be between", "", false, 0},
+		{"negative limit", models.InstanceQuery{Limit: -1}, "limit must be between", "", false, 0},
+		{"deleted filters together", models.InstanceQuery{IncludeDeleted: true, DeletedOnly: true}, "cannot be used together", "", false, 0},
+		{"unknown status", models.InstanceQuery{Statuses: []string{models.StatusRunning, "paused"}}, `unknown status "paused"`, "", false, 0},
+		{"unknown sort", models.InstanceQuery{Sort: "name"}, "sort must be one of", "", false, 0},
+		{"cursor", models.InstanceQuery{Cursor: cursor}, "", models.InstanceSortCreatedAt, true, DefaultListLimit},
+		{"bad cursor", models.InstanceQuery{Cursor: "not a cursor"}, "malformed cursor", "", false, 0},
+		{"cursor for another sort", models.InstanceQuery{Sort: "created_at", Cursor: cursor}, "cursor was issued for sort", "", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := buildListQuery(tt.query)
+			if tt.wantErr != "" {
+				if !errors.Is(err, ErrInvalidListQuery) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("buildListQuery() error = %v, want %q wrapping ErrInvalidListQuery", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q.Sort != tt.wantSort || q.Descending != tt.wantDescending || q.Limit != tt.wantLimit {
+				t.Errorf("sort = %s, descending = %v, limit = %d, want %s, %v, %d", q.Sort, q.Descending, q.Limit, tt.wantSort, tt.wantDescending, tt.wantLimit)
+			}
+			if tt.query.Cursor == "" {
+				return
+			}
+			if value, _ := q.After.Value.(time.Time); q.After.ID != 7 || !value.Equal(created) {
+				t.Errorf("after = %+v, want instance 7 created at %s", q.After, created)
+			}
+		})
+	}
+}
+
+// TestListCursor 游标保存排序和最后一个实例的位置，时间字段精确到纳秒，格式错误或排序不一致时无效
+func TestListCursor(t *testing.T) {
+	created := time.Date(2026, 1, 1, 8, 30, 0, 123456789, time.FixedZone("CST", 8*3600))
+	instance := &models.V2RayInstance{
+		ID:        42,
+		EC2Region: "ap-east-1",
+		Status:    models.StatusRunning,
+		CreatedAt: models.CustomTime{Time: created},
+		UpdatedAt: models.CustomTime{Time: created.Add(time.Minute)},
+	}
+
+	tests := []struct {
+		field      string
+		descending bool
+		want       interface{}
+	}{
+		{models.InstanceSortCreatedAt, true, created},
+		{models.InstanceSortCreatedAt, false, created},
+		{models.InstanceSortUpdatedAt, true, created.Add(time.Minute)},
+		{models.InstanceSortRegion, false, "ap-east-1"},
+		{models.InstanceSortStatus, true, models.StatusRunning},
+	}
+	for _, tt := range tests {
+		sort := tt.field
+		if tt.descending {
+			sort = "-" + sort
+		}
+		t.Run(sort, func(t *testing.T) {
+			cursor := encodeListCursor(tt.field, tt.descending, instance)
+			position, err := decodeListCursor(cursor, sort, tt.field)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if position.ID != instance.ID {
+				t.Errorf("ID = %d, want %d", position.ID, instance.ID)
+			}
+			if want, ok := tt.want.(time.Time); ok {
+				if got, _ := position.Value.(time.Time); !got.Equal(want) {
+					t.Errorf("value = %v, want %v", position.Value, want)
+				}
+			} else if position.Value != tt.want {
+				t.Errorf("value = %v, want %v", position.Value, tt.want)
+			}
+
+			// 升降序不同的游标不能混用
+			opposite := "-" + tt.field
+			if tt.descending {
+				opposite = tt.field
+			}
+			if _, err := decodeListCursor(cursor, opposite, tt.field); err == nil || !strings.Contains(err.Error(), "cursor was issued for sort") {
+				t.Errorf("decode with sort %s error = %v, want a sort mismatch", opposite, err)
+			}
+		})
+	}
+
+	encode := func(json string) string { return base64.RawURLEncoding.EncodeToString([]byte(json)) }
+	malformed := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "!!!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"s":"-created_at"}`))},
+		{"not json", encode("created_at")},
+		{"bad time", encode(`{"s":"-created_at","v":"yesterday","id":1}`)},
+	}
+	for _, tt := range malformed {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeListCursor(tt.cursor, "-created_at", models.InstanceSortCreatedAt); err == nil || err.Error() != "malformed cursor" {
+				t.Errorf("decodeListCursor() error = %v, want malformed cursor", err)
+			}
+		})
+	}
+}
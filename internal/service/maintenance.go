@@ -0,0 +1,448 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/aws"
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/localv2ray"
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+	"github.com/yuhai94/anywhere_backend/internal/repository"
+)
+
+// ErrLocalV2RayDisabled 未配置中转（v2ray.relays 或 v2ray.local_config_path）
+var ErrLocalV2RayDisabled = errors.New("no relay is configured, set v2ray.relays or v2ray.local_config_path")
+
+// 清理动作类型
+const (
+	GCMarkDeleted  = "mark_deleted"
+	GCTerminate    = "terminate"
+	GCRemoveRelay  = "remove_relay"
+	defaultGCGrace = 10 * time.Minute
+)
+
+// GCAction 一项孤儿资源清理动作
+type GCAction struct {
+	Action       string `json:"action"`
+	InstanceUUID string `json:"instance_uuid,omitempty"`
+	EC2ID        string `json:"ec2_id,omitempty"`
+	Account      string `json:"account,omitempty"`
+	Region       string `json:"region,omitempty"`
+	Relay        string `json:"relay,omitempty"`
+	Tag          string `json:"tag,omitempty"`
+	Reason       string `json:"reason"`
+}
+
+// RelayOutbounds 读取每个中转配置中现有的中转出站
+// 返回值:
+//   - []localv2ray.RelayOutbound: 中转出站列表，按中转名称排列，Relay 为所属的中转
+//   - error: 错误信息，如果未配置中转或读取任一中转的配置失败
+func (s *V2RayService) RelayOutbounds(ctx context.Context) ([]localv2ray.RelayOutbound, error) {
+	if len(s.relays) == 0 {
+		return nil, ErrLocalV2RayDisabled
+	}
+
+	var outbounds []localv2ray.RelayOutbound
+	for _, relay := range s.relays {
+		relayOutbounds, err := relay.ListRelayOutbounds()
+		if err != nil {
+			return nil, fmt.Errorf("relay %s: %w", relay.Name(), err)
+		}
+		outbounds = append(outbounds, relayOutbounds...)
+	}
+	return outbounds, nil
+}
+
+// DesiredRelayOutbounds 根据运行中的实例计算期望的中转出站
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//
+// 返回值:
+//   - []localv2ray.RelayOutbound: 每个运行中的实例一个出站，按标签排序
+//   - error: 错误信息，如果获取实例失败
+//
+// 功能:
+//  1. 只考虑状态为 running 且已有公网 IP 的实例
+//  2. 同一区域的多个实例由区域的负载均衡器分担流量
+//  3. vless-reality 实例的出站带有实例保存的 REALITY 公钥、short ID 和 SNI
+func (s *V2RayService) DesiredRelayOutbounds(ctx context.Context) ([]localv2ray.RelayOutbound, error) {
+	instances, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := config.Get()
+	var relays []localv2ray.RelayOutbound
+	for _, instance := range instances {
+		if instance.Status != models.StatusRunning || instance.EC2PublicIP == "" {
+			continue
+		}
+		relays = append(relays, localv2ray.RelayOutbound{
+			Tag:     localv2ray.InstanceTag(instance.EC2Region, instance.UUID),
+			Region:  instance.EC2Region,
+			Address: instance.EC2PublicIP,
+			Port:    cfg.V2Ray.Port,
+			UUID:    instance.UUID,
+			Reality: relayReality(instance, cfg.V2Ray.Reality.Fingerprint),
+		})
+	}
+	sort.Slice(relays, func(i, j int) bool { return relays[i].Tag < relays[j].Tag })
+	return relays, nil
+}
+
+// DiffRelayConfig 比较每个中转的配置和运行中的实例
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//
+// 返回值:
+//   - []localv2ray.RelayChange: 使中转配置与实例一致所需的出站、区域路由和中转用户变更，按中转和标签排序
+//   - error: 错误信息，如果未配置中转或读取任一中转的配置失败
+func (s *V2RayService) DiffRelayConfig(ctx context.Context) ([]localv2ray.RelayChange, error) {
+	if len(s.relays) == 0 {
+		return nil, ErrLocalV2RayDisabled
+	}
+	desired, err := s.DesiredRelayOutbounds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var changes []localv2ray.RelayChange
+	for _, relay := range s.relays {
+		relayChanges, err := diffRelay(relay, desired)
+		if err != nil {
+			return nil, fmt.Errorf("relay %s: %w", relay.Name(), err)
+		}
+		changes = append(changes, relayChanges...)
+	}
+	return changes, nil
+}
+
+// diffRelay 比较一个中转的配置和期望的出站
+// 参数:
+//   - relay: 中转的管理器
+//   - desired: 期望的中转出站，代理程序不支持 REALITY 时跳过 vless-reality 实例，区域路由和用户只包含剩余出站的区域
+//
+// 返回值:
+//   - []localv2ray.RelayChange: 该中转需要的变更，Relay 为中转名称，按标签排序
+//   - error: 错误信息，如果读取配置失败
+func diffRelay(relay *localv2ray.LocalV2RayManager, desired []localv2ray.RelayOutbound) ([]localv2ray.RelayChange, error) {
+	current, err := relay.ListRelayOutbounds()
+	if err != nil {
+		return nil, err
+	}
+	routes, err := relay.ListRelayRoutes()
+	if err != nil {
+		return nil, err
+	}
+	users, err := relay.ListRelayUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	// ListRelayOutbounds sets Relay, so the desired outbounds need it too to compare equal
+	want := make([]localv2ray.RelayOutbound, 0, len(desired))
+	for _, outbound := range desired {
+		if outbound.Reality != nil && !relay.Engine().SupportsReality() {
+			continue
+		}
+		outbound.Relay = relay.Name()
+		want = append(want, outbound)
+	}
+	regions := localv2ray.RelayRegions(want)
+
+	changes := localv2ray.DiffRelayOutbounds(current, want)
+	changes = append(changes, localv2ray.DiffRelayRoutes(routes, regions)...)
+	changes = append(changes, localv2ray.DiffRelayUsers(users, regions)...)
+	for i := range changes {
+		changes[i].Relay = relay.Name()
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Tag < changes[j].Tag })
+	return changes, nil
+}
+
+// applyRelayChanges 将变更按 Relay 分组应用到各个中转
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - changes: DiffRelayConfig 返回的变更
+//
+// 返回值:
+//   - []localv2ray.RelayChange: 成功应用的变更
+//   - error: 错误信息，包含所有写入失败的中转；一个中转失败不影响其他中转
+func (s *V2RayService) applyRelayChanges(ctx context.Context, changes []localv2ray.RelayChange) ([]localv2ray.RelayChange, error) {
+	var applied []localv2ray.RelayChange
+	var errs []error
+	for _, relay := range s.relays {
+		var relayChanges []localv2ray.RelayChange
+		for _, change := range changes {
+			if change.Relay == relay.Name() {
+				relayChanges = append(relayChanges, change)
+			}
+		}
+		if len(relayChanges) == 0 {
+			continue
+		}
+		if err := relay.ApplyRelayChanges(ctx, relayChanges); err != nil {
+			errs = append(errs, fmt.Errorf("relay %s: %w", relay.Name(), err))
+			continue
+		}
+		applied = append(applied, relayChanges...)
+	}
+	return applied, errors.Join(errs...)
+}
+
+// ReconcileRelayConfig 按仓库中运行中的实例重建中转出站和路由规则
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//
+// 返回值:
+//   - []localv2ray.RelayChange: 已应用的变更
+//   - error: 错误信息，如果未配置中转、读取或写入配置失败；部分中转写入失败时同时返回其他中转已应用的变更
+//
+// 功能:
+//  1. 删除没有运行中实例的出站、区域路由和中转用户，补上缺失的，更新地址已变化的出站
+//  2. 跳过有实例正在创建或删除的区域，这些区域由创建和删除流程自己更新中转配置
+func (s *V2RayService) ReconcileRelayConfig(ctx context.Context) ([]localv2ray.RelayChange, error) {
+	changes, err := s.DiffRelayConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	instances, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	busy := make(map[string]bool)
+	for _, instance := range instances {
+		switch instance.Status {
+		case models.StatusPending, models.StatusCreating, models.StatusDeleting:
+			busy[instance.EC2Region] = true
+		}
+	}
+
+	var pending []localv2ray.RelayChange
+	for _, change := range changes {
+		if busy[change.Region] {
+			continue
+		}
+		pending = append(pending, change)
+	}
+	return s.applyRelayChanges(ctx, pending)
+}
+
+// RepairRelayConfig 修复每个中转的配置，使其与运行中的实例一致
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//
+// 返回值:
+//   - []localv2ray.RelayChange: 已应用的变更
+//   - error: 错误信息，如果读取或写入配置失败；部分中转写入失败时同时返回其他中转已应用的变更
+func (s *V2RayService) RepairRelayConfig(ctx context.Context) ([]localv2ray.RelayChange, error) {
+	changes, err := s.DiffRelayConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.applyRelayChanges(ctx, changes)
+}
+
+// RelayBackups 列出每个中转配置的备份
+// 返回值:
+//   - []localv2ray.Backup: 按中转名称排列、同一中转内按时间从新到旧排列的备份
+//   - error: 错误信息，如果未配置中转或读取任一中转失败
+func (s *V2RayService) RelayBackups(ctx context.Context) ([]localv2ray.Backup, error) {
+	if len(s.relays) == 0 {
+		return nil, ErrLocalV2RayDisabled
+	}
+
+	var backups []localv2ray.Backup
+	for _, relay := range s.relays {
+		relayBackups, err := relay.ListBackups()
+		if err != nil {
+			return nil, fmt.Errorf("relay %s: %w", relay.Name(), err)
+		}
+		backups = append(backups, relayBackups...)
+	}
+	return backups, nil
+}
+
+// RollbackRelayConfig 将一个中转的配置恢复为备份
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - relay: 中转名称，只配置了一个中转时可以为空
+//   - name: 备份文件名，为空时恢复最近一次写入之前的配置，连续回滚时逐个恢复更早的备份
+//
+// 返回值:
+//   - localv2ray.Backup: 恢复的备份
+//   - error: 错误信息，如果未配置中转、中转或备份不存在，或恢复失败
+//
+// 功能:
+//  1. 恢复后中转配置可能与运行中的实例不一致，下次对账时按实例重新添加缺少的中转出站
+func (s *V2RayService) RollbackRelayConfig(ctx context.Context, relay, name string) (localv2ray.Backup, error) {
+	manager, err := s.relay(relay)
+	if err != nil {
+		return localv2ray.Backup{}, err
+	}
+
+	backup, err := manager.Rollback(ctx, name)
+	if err != nil {
+		logging.Error(ctx, "Failed to roll back config of relay %s to %q: %v", manager.Name(), name, err)
+		return backup, err
+	}
+	logging.Info(ctx, "Rolled back config of relay %s to backup %s", manager.Name(), backup.Name)
+	return backup, nil
+}
+
+// CollectGarbage 清理孤儿资源
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - dryRun: 为 true 时只返回计划执行的动作
+//
+// 返回值:
+//   - []GCAction: 计划执行或已执行的动作
+//   - error: 错误信息，如果获取实例失败
+//
+// 功能:
+//  1. 长时间停留在 pending/creating/deleting 且在 AWS 中不存在的记录标记为已删除，
+//     例如创建过程中服务重启留下的记录
+//  2. 状态为 error 的实例：AWS 中仍存在时终止 EC2 实例，并将记录标记为已删除
+//  3. 删除各个中转配置中没有对应运行中实例的中转出站、区域路由和中转用户
+//  4. 查询失败的账号和区域会被跳过，避免误删
+func (s *V2RayService) CollectGarbage(ctx context.Context, dryRun bool) ([]GCAction, error) {
+	instances, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := config.Get()
+	grace := defaultGCGrace
+	if cfg.Scheduler.InstanceWaitTimeout > 0 {
+		grace = 2 * time.Duration(cfg.Scheduler.InstanceWaitTimeout) * time.Second
+	}
+
+	// 查询每个账号和区域组合的 EC2 实例
+	awsInstances := make(map[string]aws.InstanceInfo)
+	failedTargets := make(map[aws.Target]bool)
+	for _, target := range aws.Targets(cfg, instances) {
+		described, err := s.ec2Client.DescribeInstances(ctx, target.Account, target.Region)
+		if err != nil {
+			logging.Error(ctx, "Failed to describe instances in account %s region %s, skipping it: %v", target.Account, target.Region, err)
+			failedTargets[target] = true
+			continue
+		}
+		for _, instance := range described {
+			awsInstances[instance.InstanceID] = instance
+		}
+	}
+
+	var actions []GCAction
+	for _, instance := range instances {
+		if failedTargets[aws.InstanceTarget(instance)] {
+			continue
+		}
+		_, inAWS := awsInstances[instance.EC2ID]
+		if instance.EC2ID == "" {
+			inAWS = false
+		}
+		stale := time.Since(instance.UpdatedAt.Time) > grace
+
+		switch instance.Status {
+		case models.StatusPending, models.StatusCreating, models.StatusDeleting:
+			if stale && !inAWS {
+				actions = append(actions, GCAction{
+					Action:       GCMarkDeleted,
+					InstanceUUID: instance.UUID,
+					EC2ID:        instance.EC2ID,
+					Region:       instance.EC2Region,
+					Reason:       fmt.Sprintf("stuck in %s since %s without an EC2 instance", instance.Status, instance.UpdatedAt.Format("2006-01-02 15:04:05")),
+				})
+			}
+		case models.StatusError:
+			if inAWS {
+				actions = append(actions, GCAction{
+					Action:       GCTerminate,
+					InstanceUUID: instance.UUID,
+					EC2ID:        instance.EC2ID,
+					Account:      instance.EC2Account,
+					Region:       instance.EC2Region,
+					Reason:       "instance is in error state",
+				})
+			}
+			actions = append(actions, GCAction{
+				Action:       GCMarkDeleted,
+				InstanceUUID: instance.UUID,
+				EC2ID:        instance.EC2ID,
+				Region:       instance.EC2Region,
+				Reason:       "instance is in error state",
+			})
+		}
+	}
+
+	var relayChanges []localv2ray.RelayChange
+	if len(s.relays) > 0 {
+		changes, err := s.DiffRelayConfig(ctx)
+		if err != nil {
+			logging.Error(ctx, "Failed to diff relay config: %v", err)
+		}
+		for _, change := range changes {
+			var reason string
+			switch change.Action {
+			case localv2ray.RelayRemove:
+				reason = "no running instance for relay outbound"
+			case localv2ray.RelayRemoveRule:
+				reason = "no running instance for relay route"
+			case localv2ray.RelayRemoveUser:
+				reason = "no running instance for relay user"
+			default:
+				continue
+			}
+			relayChanges = append(relayChanges, change)
+			actions = append(actions, GCAction{
+				Action: GCRemoveRelay,
+				Relay:  change.Relay,
+				Tag:    change.Tag,
+				Reason: reason,
+			})
+		}
+	}
+
+	if dryRun {
+		return actions, nil
+	}
+
+	// 终止失败的实例保留记录，下次清理时重试
+	terminateFailed := make(map[string]bool)
+	for _, action := range actions {
+		switch action.Action {
+		case GCTerminate:
+			if err := s.ec2Client.TerminateInstance(ctx, action.Account, action.Region, action.EC2ID); err != nil {
+				logging.Error(ctx, "Failed to terminate orphan EC2 instance %s: %v", action.EC2ID, err)
+				terminateFailed[action.InstanceUUID] = true
+			}
+		case GCMarkDeleted:
+			if terminateFailed[action.InstanceUUID] {
+				continue
+			}
+			if err := s.repo.Delete(repository.WithEventCause(ctx, "orphan cleanup: "+action.Reason), action.InstanceUUID); err != nil {
+				logging.Error(ctx, "Failed to mark orphan instance %s as deleted: %v", action.InstanceUUID, err)
+				continue
+			}
+			s.publish(ctx, &models.LifecycleEvent{
+				InstanceUUID: action.InstanceUUID,
+				Region:       action.Region,
+				Type:         models.EventStatusChanged,
+				Status:       models.StatusDeleted,
+			})
+		}
+	}
+	if _, err := s.applyRelayChanges(ctx, relayChanges); err != nil {
+		logging.Error(ctx, "Failed to remove stale relay outbounds: %v", err)
+	}
+
+	return actions, nil
+}
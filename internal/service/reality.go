@@ -0,0 +1,81 @@
+package service
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/localv2ray"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+)
+
+// realityShortIDBytes 每个 short ID 的字节数，Xray 接受最多 8 字节（16 个十六进制字符）
+const realityShortIDBytes = 8
+
+// GenerateRealityKeys 为 vless-reality 节点生成 REALITY 密钥对和 short ID
+// 参数:
+//   - instance: 实例记录，结果写入 Reality* 字段
+//   - reality: 当前的 REALITY 设置，回落网站和 SNI 随实例保存
+//
+// 返回值:
+//   - error: 错误信息，如果读取随机数失败
+//
+// 功能:
+//  1. 生成 x25519 密钥对，按 Xray 的格式使用无填充的 base64url 编码
+//  2. 生成 short_id_count 个随机 short ID
+//  3. 保存到实例记录中，节点配置、链接和中转出站都从实例记录读取，之后修改配置不影响已有节点
+func GenerateRealityKeys(instance *models.V2RayInstance, reality config.RealityConfig) error {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate REALITY key pair: %v", err)
+	}
+
+	shortIDs := make([]string, 0, reality.ShortIDCount)
+	for i := 0; i < reality.ShortIDCount; i++ {
+		b := make([]byte, realityShortIDBytes)
+		if _, err := rand.Read(b); err != nil {
+			return fmt.Errorf("failed to generate REALITY short ID: %v", err)
+		}
+		shortIDs = append(shortIDs, hex.EncodeToString(b))
+	}
+
+	instance.RealityPrivateKey = base64.RawURLEncoding.EncodeToString(privateKey.Bytes())
+	instance.RealityPublicKey = base64.RawURLEncoding.EncodeToString(privateKey.PublicKey().Bytes())
+	instance.RealityShortIDs = strings.Join(shortIDs, ",")
+	instance.RealityServerName = reality.ServerNameOrDest()
+	instance.RealityDest = reality.Dest
+	return nil
+}
+
+// realityParams 返回客户端连接实例使用的 REALITY 参数
+// 参数:
+//   - instance: vless-reality 实例
+//   - fingerprint: 客户端模拟的 TLS 指纹
+func realityParams(instance *models.V2RayInstance, fingerprint string) models.RealityParams {
+	return models.RealityParams{
+		PublicKey:   instance.RealityPublicKey,
+		ShortID:     instance.RealityShortID(),
+		ServerName:  instance.RealityServerName,
+		Fingerprint: fingerprint,
+	}
+}
+
+// relayReality 返回中转连接实例使用的 REALITY 参数
+// 返回值:
+//   - *localv2ray.Reality: 实例不使用 REALITY 时为 nil
+func relayReality(instance *models.V2RayInstance, fingerprint string) *localv2ray.Reality {
+	if !instance.IsReality() {
+		return nil
+	}
+	params := realityParams(instance, fingerprint)
+	return &localv2ray.Reality{
+		PublicKey:   params.PublicKey,
+		ShortID:     params.ShortID,
+		ServerName:  params.ServerName,
+		Fingerprint: params.Fingerprint,
+	}
+}
@@ -0,0 +1,265 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/localv2ray"
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+)
+
+// ErrRelayNotFound 指定的中转不存在
+var ErrRelayNotFound = errors.New("relay not found")
+
+// relayOptions 返回读取指定中转当前设置的函数
+// 参数:
+//   - name: 中转名称
+//
+// 返回值:
+//   - func() localv2ray.Options: 每次调用时从当前配置读取，配置重新加载后使用新的 API 地址、重启命令和校验使用的可执行文件
+func relayOptions(name string) func() localv2ray.Options {
+	return func() localv2ray.Options {
+		cfg := config.Get().V2Ray
+		relay := cfg.EffectiveRelays()[name]
+		binary := relay.Binary
+		if binary == "" {
+			binary = cfg.Binary
+		}
+		return localv2ray.Options{
+			APIAddress:       relay.APIAddress,
+			ReloadCommand:    relay.ReloadCommand,
+			RelayInboundTag:  cfg.RelayInboundTag,
+			RelayPort:        cfg.RelayPort,
+			BalancerStrategy: cfg.BalancerStrategy,
+			ProbeURL:         cfg.ProbeURL,
+			ProbeInterval:    cfg.ProbeInterval,
+			Binary:           binary,
+			SkipConfigTest:   cfg.SkipConfigTest,
+			BackupCount:      cfg.Backups(),
+		}
+	}
+}
+
+// newRelayManagers 为每个生效的中转创建管理器
+// 返回值:
+//   - []*localv2ray.LocalV2RayManager: 按中转名称排序的管理器，没有配置中转时为空
+//   - error: 错误信息，如果中转的代理程序不支持，或 ssh 中转的私钥或 known_hosts 无效
+func newRelayManagers() ([]*localv2ray.LocalV2RayManager, error) {
+	cfg := config.Get().V2Ray
+	relays := cfg.EffectiveRelays()
+
+	var managers []*localv2ray.LocalV2RayManager
+	closeAll := func() {
+		for _, manager := range managers {
+			manager.Close()
+		}
+	}
+	for _, name := range cfg.RelayNames() {
+		relay := relays[name]
+
+		engine, err := localv2ray.NewEngine(relay.Engine)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("relay %s: %v", name, err)
+		}
+
+		var driver localv2ray.Driver
+		if relay.Driver == config.DriverSSH {
+			sshDriver, err := localv2ray.NewSSHDriver(localv2ray.SSHOptions{
+				Host:       relay.SSH.Host,
+				Port:       relay.SSH.Port,
+				User:       relay.SSH.User,
+				PrivateKey: relay.SSH.PrivateKey,
+				Passphrase: relay.SSH.Passphrase,
+				KnownHosts: expandHome(relay.SSH.KnownHosts),
+			})
+			if err != nil {
+				closeAll()
+				return nil, fmt.Errorf("relay %s: %v", name, err)
+			}
+			driver = sshDriver
+		}
+		managers = append(managers, localv2ray.NewLocalV2RayManager(name, relay.ConfigPath, engine, driver, relayOptions(name)))
+	}
+	return managers, nil
+}
+
+// expandHome 将路径开头的 ~/ 替换为当前用户的主目录
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
+
+// relay 按名称查找中转
+// 参数:
+//   - name: 中转名称，只有一个中转时可以为空
+//
+// 返回值:
+//   - *localv2ray.LocalV2RayManager: 中转的管理器
+//   - error: 错误信息，如果未配置中转、中转不存在，或有多个中转时未指定名称
+func (s *V2RayService) relay(name string) (*localv2ray.LocalV2RayManager, error) {
+	if len(s.relays) == 0 {
+		return nil, ErrLocalV2RayDisabled
+	}
+	if name == "" {
+		if len(s.relays) > 1 {
+			return nil, fmt.Errorf("%d relays are configured, specify one", len(s.relays))
+		}
+		return s.relays[0], nil
+	}
+	for _, relay := range s.relays {
+		if relay.Name() == name {
+			return relay, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrRelayNotFound, name)
+}
+
+// relayLinks 生成实例在每个中转上的中转链接
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - cfg: 生成链接时使用的配置快照
+//   - region: AWS 区域
+//   - ps: 链接的备注，通常为区域名称
+//
+// 返回值:
+//   - string: 按中转名称排序、每行一个的中转链接，没有可用的中转时为空
+//
+// 功能:
+//  1. 跳过未配置 public_ip 的中转，以及读取区域中转用户失败的中转
+//  2. 只有一个中转时备注为 "<ps> (中转)"，多个中转时为 "<ps> (中转 <名称>)"
+func (s *V2RayService) relayLinks(ctx context.Context, cfg *config.Config, region, ps string) string {
+	relays := cfg.V2Ray.EffectiveRelays()
+
+	var links []string
+	for _, manager := range s.relays {
+		publicIP := relays[manager.Name()].PublicIP
+		if publicIP == "" {
+			continue
+		}
+		relayPort, relayUUID, err := manager.GetRelayConfig(region)
+		if err != nil {
+			logging.Error(ctx, "Failed to get relay config for region %s on relay %s: %v", region, manager.Name(), err)
+			continue
+		}
+
+		remark := ps + " (中转)"
+		if len(s.relays) > 1 {
+			remark = fmt.Sprintf("%s (中转 %s)", ps, manager.Name())
+		}
+		link, err := models.GenerateVMessLink(publicIP, relayUUID, fmt.Sprintf("%d", relayPort), remark)
+		if err != nil {
+			logging.Error(ctx, "Failed to generate relay link for region %s on relay %s: %v", region, manager.Name(), err)
+			continue
+		}
+		links = append(links, link)
+	}
+	return strings.Join(links, "\n")
+}
+
+// relayLinkKey 返回中转链接指向的中转用户，用于订阅中去重
+// 参数:
+//   - link: vmess 中转链接
+//
+// 返回值:
+//   - string: "<地址>:<端口>/<用户 UUID>"，链接无法解析时为链接本身
+//
+// 功能:
+//  1. 同一区域的中转链接指向同一个中转用户，不同实例保存的链接备注可能因配置变化而不同，因此不直接比较链接
+func relayLinkKey(link string) string {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(link, "vmess://"))
+	if err != nil {
+		return link
+	}
+	var vmess models.VMessConfig
+	if err := json.Unmarshal(data, &vmess); err != nil || vmess.Add == "" {
+		return link
+	}
+	return net.JoinHostPort(vmess.Add, vmess.Port) + "/" + vmess.ID
+}
+
+// QueryRelayStats 通过每个中转的 V2Ray API 查询计数器
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值和取消信号
+//   - pattern: 计数器名称需要包含的字符串
+//   - reset: 是否在读取后将计数器清零
+//
+// 返回值:
+//   - []localv2ray.Stat: 所有中转的计数器，同名计数器不合并
+//   - error: 错误信息，只有所有配置了 API 的中转都查询失败时返回
+//
+// 功能:
+//  1. 跳过未配置 api_address 的中转
+//  2. 部分中转查询失败时记录日志并返回其他中转的计数器
+//  3. 没有配置中转、只配置了 v2ray.api_address 时直接查询该地址
+func (s *V2RayService) QueryRelayStats(ctx context.Context, pattern string, reset bool) ([]localv2ray.Stat, error) {
+	if len(s.relays) == 0 {
+		api := s.statsClient()
+		if api == nil {
+			return nil, nil
+		}
+		return api.QueryStats(ctx, pattern, reset)
+	}
+
+	var stats []localv2ray.Stat
+	var lastErr error
+	succeeded := 0
+	for _, relay := range s.relays {
+		relayStats, err := relay.QueryStats(ctx, pattern, reset)
+		if err != nil {
+			logging.Error(ctx, "Failed to query stats on relay %s: %v", relay.Name(), err)
+			lastErr = err
+			continue
+		}
+		succeeded++
+		stats = append(stats, relayStats...)
+	}
+	if succeeded == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return stats, nil
+}
+
+// statsClient 返回 v2ray.api_address 的 API 客户端，未配置时返回 nil
+func (s *V2RayService) statsClient() *localv2ray.APIClient {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	address := config.Get().V2Ray.APIAddress
+	if s.stats != nil && s.stats.Address() != address {
+		s.stats.Close()
+		s.stats = nil
+	}
+	if s.stats == nil && address != "" {
+		s.stats = localv2ray.NewAPIClient(address)
+	}
+	return s.stats
+}
+
+// Close 关闭与中转主机和 V2Ray API 的连接
+func (s *V2RayService) Close() {
+	for _, relay := range s.relays {
+		relay.Close()
+	}
+	s.statsMu.Lock()
+	if s.stats != nil {
+		s.stats.Close()
+		s.stats = nil
+	}
+	s.statsMu.Unlock()
+}
@@ -3,158 +3,275 @@ package service
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/yuhai94/anywhere_backend/internal/aws"
 	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/events"
 	"github.com/yuhai94/anywhere_backend/internal/localv2ray"
 	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/metrics"
 	"github.com/yuhai94/anywhere_backend/internal/models"
 	"github.com/yuhai94/anywhere_backend/internal/repository"
+	"github.com/yuhai94/anywhere_backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type V2RayService struct {
-	repo              *repository.Repository
-	ec2Client         *aws.EC2Client
-	localV2RayManager *localv2ray.LocalV2RayManager
-	wg                sync.WaitGroup
+	repo      *repository.Repository
+	ec2Client *aws.EC2Client
+	// relays 每个中转主机的 V2Ray 管理器，按名称排序
+	relays []*localv2ray.LocalV2RayManager
+	bus    *events.Bus
+	wg     sync.WaitGroup
+
+	// stats 没有配置中转、只配置了 v2ray.api_address 时用于统计流量
+	statsMu sync.Mutex
+	stats   *localv2ray.APIClient
 }
 
 // NewV2RayService 创建一个新的 V2RayService 实例
 // 参数:
 //   - repo: Repository 实例，用于数据库操作
 //   - ec2Client: EC2Client 实例，用于 AWS EC2 操作
+//   - bus: 事件总线，用于发布实例生命周期事件
 //
 // 返回值:
 //   - *V2RayService: 新创建的 V2RayService 实例
+//   - error: 错误信息，如果无法创建中转的访问方式，例如 ssh 私钥无效
 //
 // 功能:
 //  1. 初始化 V2RayService 结构体
-//  2. 如果配置了本地 V2Ray 配置路径，创建 LocalV2RayManager 实例
+//  2. 为 v2ray.relays 中的每个中转（未配置时为 local_config_path 对应的本机中转）创建 LocalV2RayManager 实例
 //  3. 返回配置好的 V2RayService 实例
-func NewV2RayService(repo *repository.Repository, ec2Client *aws.EC2Client) *V2RayService {
-	var localV2RayManager *localv2ray.LocalV2RayManager
-	if config.AppConfig.V2Ray.LocalConfigPath != "" {
-		localV2RayManager = localv2ray.NewLocalV2RayManager(config.AppConfig.V2Ray.LocalConfigPath)
+func NewV2RayService(repo *repository.Repository, ec2Client *aws.EC2Client, bus *events.Bus) (*V2RayService, error) {
+	relays, err := newRelayManagers()
+	if err != nil {
+		return nil, err
 	}
 
 	return &V2RayService{
-		repo:              repo,
-		ec2Client:         ec2Client,
-		localV2RayManager: localV2RayManager,
+		repo:      repo,
+		ec2Client: ec2Client,
+		relays:    relays,
+		bus:       bus,
+	}, nil
+}
+
+// updateStatus 更新实例状态并发布状态变更事件
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - uuid: 实例 UUID
+//   - region: AWS 区域
+//   - status: 新的状态
+//   - cause: 状态变化的原因，记录到实例历史中
+//
+// 返回值:
+//   - error: 错误信息，如果更新失败
+func (s *V2RayService) updateStatus(ctx context.Context, uuid, region, status, cause string) error {
+	if err := s.repo.UpdateStatus(repository.WithEventCause(ctx, cause), uuid, status); err != nil {
+		return err
 	}
+	s.publish(ctx, &models.LifecycleEvent{
+		InstanceUUID: uuid,
+		Region:       region,
+		Type:         models.EventStatusChanged,
+		Status:       status,
+	})
+	return nil
+}
+
+// publish 以服务层为来源发布实例生命周期事件
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - event: 要发布的事件
+func (s *V2RayService) publish(ctx context.Context, event *models.LifecycleEvent) {
+	event.Source = models.EventSourceService
+	s.bus.Publish(ctx, event)
 }
 
 // CreateInstance 创建 V2Ray 实例
 // 参数:
 //   - ctx: 上下文，用于传递请求范围的值
 //   - region: AWS 区域
+//   - owner: 创建者，用于按创建者统计费用和预算，可以为空
+//   - protocol: 节点协议，vmess 或 vless-reality，为空时使用 v2ray.protocol
 //
 // 返回值:
 //   - string: 实例 UUID
+//   - error: 错误信息，如果操作失败，超出预算时包装 ErrBudgetExceeded
+//
+// 功能:
+//  1. 检查当月总预算、区域预算和创建者预算，任一已用完时拒绝创建
+//  2. 检查指定region的活跃实例是否已达到上限（aws.max_instances_per_region 或区域的 max_instances）
+//  3. 如果已达到上限，返回最新的活跃实例的UUID
+//  4. 如果没有，生成实例 UUID，vless-reality 节点同时生成 REALITY 密钥和 short ID
+//  5. 创建数据库记录，状态为 pending
+//  6. 释放锁，再发布 pending 事件
+//  7. 启动异步创建过程
+//  8. 返回实例 UUID
+func (s *V2RayService) CreateInstance(ctx context.Context, region, owner, protocol string) (string, error) {
+	ctx, span := tracing.Start(ctx, "service.CreateInstance", attribute.String("cloud.region", region))
+	defer span.End()
+
+	cfg := config.Get()
+	if protocol == "" {
+		protocol = cfg.V2Ray.Protocol
+	}
+	if !config.ValidProtocol(protocol) {
+		return "", fmt.Errorf("unsupported protocol %q, expected vmess or vless-reality", protocol)
+	}
+
+	// 在获取区域创建锁之前检查预算，费用查询较慢，不需要占用锁
+	if err := s.checkBudget(ctx, region, owner); err != nil {
+		return "", err
+	}
+
+	// 区域创建锁在 reserveInstance 返回前释放，事件在释放后发布，
+	// 持久化事件和 webhook 监听器的写入不会延长同一区域其他创建请求的等待
+	instance, created, err := s.reserveInstance(ctx, region, owner, protocol)
+	if err != nil {
+		return "", err
+	}
+	if !created {
+		return instance.UUID, nil
+	}
+
+	s.publish(ctx, &models.LifecycleEvent{
+		InstanceUUID: instance.UUID,
+		Region:       region,
+		Type:         models.EventStatusChanged,
+		Status:       models.StatusPending,
+	})
+
+	// Start asynchronous creation process
+	s.wg.Add(1)
+	go s.createInstanceAsync(tracing.Detach(ctx), instance)
+
+	return instance.UUID, nil
+}
+
+// reserveInstance 在区域创建锁内检查区域上限并创建 pending 状态的实例记录
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - region: AWS 区域
+//   - owner: 创建者
+//   - protocol: 节点协议
+//
+// 返回值:
+//   - *models.V2RayInstance: 新建的实例记录，区域已达到上限时为最新的活跃实例
+//   - bool: 是否新建了实例记录
 //   - error: 错误信息，如果操作失败
 //
 // 功能:
-//  1. 检查指定region是否已有活跃实例
-//  2. 如果已有活跃实例，返回该实例的UUID
-//  3. 如果没有，生成实例 UUID
-//  4. 创建数据库记录，状态为 pending
-//  5. 启动异步创建过程
-//  6. 释放锁
-//  7. 返回实例 UUID
-func (s *V2RayService) CreateInstance(ctx context.Context, region string) (string, error) {
-	// 获取数据库表锁，确保串行写入
-	if err := s.repo.LockTable(ctx); err != nil {
-		return "", fmt.Errorf("failed to lock table: %v", err)
+//  1. 获取区域创建锁，返回前释放，同一区域的并发请求依次执行 统计 → 创建，不会超过上限
+//  2. 区域已达到上限时返回最新的活跃实例
+func (s *V2RayService) reserveInstance(ctx context.Context, region, owner, protocol string) (*models.V2RayInstance, bool, error) {
+	cfg := config.Get()
+
+	// 获取区域创建锁，确保同一区域串行创建
+	unlock, err := s.repo.LockRegion(ctx, region)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to lock region: %v", err)
 	}
-	defer func() {
-		if err := s.repo.UnlockTable(ctx); err != nil {
-			logging.Error(ctx, "Failed to unlock table: %v", err)
-		}
-	}()
+	defer unlock()
 
-	// 检查指定region是否已有活跃实例
-	hasActive, err := s.repo.CheckRegionHasActiveInstance(ctx, region)
+	// 检查指定region的活跃实例是否已达到上限
+	maxInstances := cfg.AWS.RegionMaxInstances(region)
+	activeCount, err := s.repo.CountRegionActiveInstances(ctx, region)
 	if err != nil {
-		return "", fmt.Errorf("failed to check region for active instances: %v", err)
+		return nil, false, fmt.Errorf("failed to check region for active instances: %v", err)
 	}
-	if hasActive {
-		// 获取已存在的活跃实例
+	if activeCount >= maxInstances {
+		// 获取最新的活跃实例
 		existingInstance, err := s.repo.GetRegionActiveInstance(ctx, region)
 		if err != nil {
-			return "", fmt.Errorf("failed to get existing active instance: %v", err)
+			return nil, false, fmt.Errorf("failed to get existing active instance: %v", err)
 		}
-		logging.Info(ctx, "Region %s already has active instance %d, returning existing instance", region, existingInstance.ID)
-		return existingInstance.UUID, nil
+		logging.Info(ctx, "Region %s already has %d active instances (max %d), returning existing instance %d", region, activeCount, maxInstances, existingInstance.ID)
+		return existingInstance, false, nil
 	}
 
 	// Generate UUID
 	instanceUUID := uuid.New().String()
 
 	// Create instance record with pending status
+	// 记录创建时区域使用的账号，之后区域改用其他账号时仍能在原账号中管理该实例
 	instance := &models.V2RayInstance{
-		UUID:      instanceUUID,
-		EC2Region: region,
-		Status:    models.StatusPending,
-		IsDeleted: false,
+		UUID:       instanceUUID,
+		EC2Region:  region,
+		EC2Account: cfg.AWS.RegionAccount(region),
+		Owner:      owner,
+		Status:     models.StatusPending,
+		Protocol:   protocol,
+		IsDeleted:  false,
 	}
-
-	if err := s.repo.Create(ctx, instance); err != nil {
-		return "", fmt.Errorf("failed to create instance record: %v", err)
-	}
-
-	// 再次检查，确保在创建记录期间没有其他请求创建同一region的实例
-	// 由于有表锁，理论上不应该发生，但作为双重保险
-	allInstances, err := s.repo.List(ctx)
-	if err != nil {
-		return "", fmt.Errorf("failed to verify instances: %v", err)
-	}
-	activeCount := 0
-	for _, inst := range allInstances {
-		if inst.EC2Region == region && !inst.IsDeleted &&
-			(inst.Status == models.StatusPending || inst.Status == models.StatusCreating || inst.Status == models.StatusRunning) {
-			activeCount++
-			if activeCount > 1 {
-				// 发现重复，删除刚创建的记录
-				logging.Warn(ctx, "Duplicate instance detected for region %s, removing newly created instance %s", region, instanceUUID)
-				s.repo.Delete(ctx, instanceUUID)
-				return "", fmt.Errorf("region %s already has an active instance", region)
-			}
+	if instance.IsReality() {
+		if err := GenerateRealityKeys(instance, cfg.V2Ray.Reality); err != nil {
+			return nil, false, err
 		}
 	}
 
-	// Start asynchronous creation process
-	s.wg.Add(1)
-	go s.createInstanceAsync(context.TODO(), instance.ID, region, instanceUUID)
+	createCause := "create requested"
+	if owner != "" {
+		createCause = "create requested by " + owner
+	}
+	if err := s.repo.Create(repository.WithEventCause(ctx, createCause), instance); err != nil {
+		return nil, false, fmt.Errorf("failed to create instance record: %v", err)
+	}
 
-	return instanceUUID, nil
+	return instance, true, nil
 }
 
-// buildAwsUserData 构建 AWS EC2 实例的用户数据
+// BuildUserData 构建 AWS EC2 实例的用户数据
 // 参数:
-//   - region: AWS 区域
+//   - instance: 实例记录，UUID 同时作为客户端 ID，vless-reality 实例还使用其中的 REALITY 私钥、short ID、SNI 和目标网站
 //
 // 返回值:
 //   - string: 构建好的用户数据字符串
 //
 // 功能:
-//  1. 定义用户数据模板，包含 V2Ray 安装、配置和启动脚本
-//  2. 定义检查脚本，用于检测 V2Ray 活动状态并在不活动时终止实例
+//  1. 定义用户数据模板，vmess 实例安装 V2Ray，vless-reality 实例安装 Xray 并使用 VLESS + REALITY 入站
+//  2. 定义检查脚本，用于检测访问日志的活动状态并在不活动时终止实例
 //  3. 将检查脚本编码为 base64 并替换到模板中
-//  4. 替换模板中的 UUID 和端口占位符
+//  4. 替换模板中的 UUID、端口和 REALITY 占位符
 //  5. 返回完整的用户数据字符串
-func (s *V2RayService) buildAwsUserData(region, uuid string) string {
+func BuildUserData(instance *models.V2RayInstance) string {
 	userDataTemplate := `#!/bin/bash
-# 下载v2ray安装脚本
+{{InstallScript}}
+# 生成{{Service}}配置文件
+cat > /usr/local/etc/{{Service}}/config.json << EOF
+{{ServerConfig}}
+EOF
+# 启动{{Service}}服务
+systemctl start {{Service}}
+systemctl enable {{Service}}
+# 创建检查脚本，使用token方式访问实例元数据
+echo {{CheckActivityScript}}|/usr/bin/base64 -d >/usr/local/bin/check_v2ray_activity.sh
+# 赋予脚本执行权限
+chmod +x /usr/local/bin/check_v2ray_activity.sh
+# 添加到crontab，每分钟执行一次
+zypper --non-interactive install cron
+chcon -R -usystem_u -robject_r -tsystem_cron_spool_t /etc/crontab
+systemctl enable cron
+systemctl start cron
+sleep 2
+(crontab -l 2>/dev/null; echo "* * * * * bash /usr/local/bin/check_v2ray_activity.sh") | crontab -
+chcon -R -usystem_u -robject_r -tsystem_cron_spool_t /var/spool/cron/tabs/root
+systemctl restart cron`
+
+	v2rayInstallScript := `# 下载v2ray安装脚本
 bash <(curl -L https://github.com/v2fly/fhs-install-v2ray/raw/master/install-release.sh)
 # 创建v2ray配置目录
-mkdir -p /usr/local/etc/v2ray
-# 生成v2ray配置文件
-cat > /usr/local/etc/v2ray/config.json << EOF
-{
+mkdir -p /usr/local/etc/v2ray`
+
+	v2rayConfig := `{
     "log": {
         "access": "/var/log/v2ray/access.log",
         "error": "/var/log/v2ray/error.log",
@@ -180,24 +297,55 @@ cat > /usr/local/etc/v2ray/config.json << EOF
             "settings": {}
         }
     ]
-}
-EOF
-# 启动v2ray服务
-systemctl start v2ray
-systemctl enable v2ray
-# 创建检查脚本，使用token方式访问实例元数据
-echo {{CheckActivityScript}}|/usr/bin/base64 -d >/usr/local/bin/check_v2ray_activity.sh
-# 赋予脚本执行权限
-chmod +x /usr/local/bin/check_v2ray_activity.sh
-# 添加到crontab，每分钟执行一次
-zypper --non-interactive install cron
-chcon -R -usystem_u -robject_r -tsystem_cron_spool_t /etc/crontab
-systemctl enable cron
-systemctl start cron
-sleep 2
-(crontab -l 2>/dev/null; echo "* * * * * bash /usr/local/bin/check_v2ray_activity.sh") | crontab -
-chcon -R -usystem_u -robject_r -tsystem_cron_spool_t /var/spool/cron/tabs/root
-systemctl restart cron`
+}`
+
+	xrayInstallScript := `# 下载xray安装脚本
+bash -c "$(curl -L https://github.com/XTLS/Xray-install/raw/main/install-release.sh)" @ install
+# 创建xray配置目录
+mkdir -p /usr/local/etc/xray`
+
+	xrayRealityConfig := `{
+    "log": {
+        "access": "/var/log/xray/access.log",
+        "error": "/var/log/xray/error.log",
+        "loglevel": "info"
+    },
+    "inbounds": [
+        {
+            "port": {{Port}},
+            "protocol": "vless",
+            "settings": {
+                "clients": [
+                    {
+                        "id": "{{UUID}}",
+                        "flow": "xtls-rprx-vision"
+                    }
+                ],
+                "decryption": "none"
+            },
+            "streamSettings": {
+                "network": "tcp",
+                "security": "reality",
+                "realitySettings": {
+                    "dest": "{{RealityDest}}",
+                    "serverNames": {{RealityServerNames}},
+                    "privateKey": "{{RealityPrivateKey}}",
+                    "shortIds": {{RealityShortIDs}}
+                }
+            },
+            "sniffing": {
+                "enabled": true,
+                "destOverride": ["http", "tls", "quic"]
+            }
+        }
+    ],
+    "outbounds": [
+        {
+            "protocol": "freedom",
+            "settings": {}
+        }
+    ]
+}`
 
 	checkActiveScript := `#!/bin/bash
 # 获取当前分钟
@@ -206,7 +354,7 @@ time=$(date +%M)
 # 检查是否在每个小时的最后10分钟（50-59分钟）
 if [[ "$time" -ge 50 ]]; then
 	# 获取日志文件修改时间
-	log_file="/var/log/v2ray/access.log"
+	log_file="/var/log/{{Service}}/access.log"
 	if [[ -f "$log_file" ]]; then
 		# 计算日志文件的修改时间（秒）
 		log_mtime=$(stat -c %Y "$log_file")
@@ -242,19 +390,34 @@ if [[ "$time" -ge 50 ]]; then
 	fi
 fi`
 
+	service := "v2ray"
 	var res = userDataTemplate
+	if instance.IsReality() {
+		service = "xray"
+		serverNames, _ := json.Marshal([]string{instance.RealityServerName})
+		shortIDs, _ := json.Marshal(strings.Split(instance.RealityShortIDs, ","))
+		res = strings.ReplaceAll(res, "{{InstallScript}}", xrayInstallScript)
+		res = strings.ReplaceAll(res, "{{ServerConfig}}", xrayRealityConfig)
+		res = strings.ReplaceAll(res, "{{RealityDest}}", instance.RealityDest)
+		res = strings.ReplaceAll(res, "{{RealityServerNames}}", string(serverNames))
+		res = strings.ReplaceAll(res, "{{RealityPrivateKey}}", instance.RealityPrivateKey)
+		res = strings.ReplaceAll(res, "{{RealityShortIDs}}", string(shortIDs))
+	} else {
+		res = strings.ReplaceAll(res, "{{InstallScript}}", v2rayInstallScript)
+		res = strings.ReplaceAll(res, "{{ServerConfig}}", v2rayConfig)
+	}
+	checkActiveScript = strings.ReplaceAll(checkActiveScript, "{{Service}}", service)
+	res = strings.ReplaceAll(res, "{{Service}}", service)
 	res = strings.ReplaceAll(res, "{{CheckActivityScript}}", base64.StdEncoding.EncodeToString([]byte(checkActiveScript)))
-	res = strings.ReplaceAll(res, "{{UUID}}", fmt.Sprintf("%s", uuid))
-	res = strings.ReplaceAll(res, "{{Port}}", fmt.Sprintf("%d", config.AppConfig.V2Ray.Port))
+	res = strings.ReplaceAll(res, "{{UUID}}", fmt.Sprintf("%s", instance.UUID))
+	res = strings.ReplaceAll(res, "{{Port}}", fmt.Sprintf("%d", config.Get().V2Ray.Port))
 	return res
 }
 
 // createInstanceAsync 异步创建 V2Ray 实例
 // 参数:
 //   - ctx: 上下文，用于传递请求范围的值
-//   - id: 实例 ID
-//   - region: AWS 区域
-//   - instanceUUID: 实例 UUID
+//   - instance: 刚创建的实例记录，包含账号、区域、UUID、协议和 REALITY 密钥
 //
 // 功能:
 //  1. 更新上下文，添加实例 ID 用于日志记录
@@ -266,94 +429,108 @@ fi`
 //  7. 如果初始化了本地 V2Ray 管理器，将实例添加到本地配置
 //  8. 更新实例状态为 running，并设置公网 IP
 //  9. 记录实例创建成功的日志
-func (s *V2RayService) createInstanceAsync(ctx context.Context, id int, region, instanceUUID string) {
+func (s *V2RayService) createInstanceAsync(ctx context.Context, instance *models.V2RayInstance) {
 	defer s.wg.Done()
 
+	account, region, instanceUUID := instance.EC2Account, instance.EC2Region, instance.UUID
+
+	ctx, span := tracing.Start(ctx, "service.createInstanceAsync",
+		attribute.String("cloud.region", region),
+		attribute.String("instance.uuid", instanceUUID),
+	)
+
+	// Record operation duration for metrics and finish the span
+	start := time.Now()
+	success := false
+	defer func() {
+		metrics.ObserveInstanceOperation("create", region, start, success)
+		if !success {
+			tracing.RecordError(span, fmt.Errorf("failed to create instance %s", instanceUUID))
+		}
+		span.End()
+	}()
+
 	// Add instance ID to context for logging
 	ctx = logging.WithInstanceID(ctx, instanceUUID)
 
 	logging.Info(ctx, "Starting async creation process for instance %s in region %s", instanceUUID, region)
 
 	// Update status to creating
-	if err := s.repo.UpdateStatus(ctx, instanceUUID, models.StatusCreating); err != nil {
+	if err := s.updateStatus(ctx, instanceUUID, region, models.StatusCreating, "launching EC2 instance"); err != nil {
 		logging.Error(ctx, "Failed to update status to creating: %v", err)
 		return
 	}
 
 	// Create EC2 instance
-	ec2ID, err := s.ec2Client.CreateInstance(ctx, region, s.buildAwsUserData(region, instanceUUID), instanceUUID)
+	ec2ID, err := s.ec2Client.CreateInstance(ctx, account, region, BuildUserData(instance), instanceUUID)
 	if err != nil {
 		logging.Error(ctx, "Failed to create EC2 instance: %v", err)
-		s.repo.UpdateStatus(ctx, instanceUUID, models.StatusError)
+		s.updateStatus(ctx, instanceUUID, region, models.StatusError, fmt.Sprintf("failed to create EC2 instance: %v", err))
 		return
 	}
 
 	// Update EC2 ID in database
-	instance, err := s.repo.GetByUUID(ctx, instanceUUID)
+	instance, err = s.repo.GetByUUID(ctx, instanceUUID)
 	if err != nil {
 		logging.Error(ctx, "Failed to get instance: %v", err)
-		s.repo.UpdateStatus(ctx, instanceUUID, models.StatusError)
+		s.updateStatus(ctx, instanceUUID, region, models.StatusError, fmt.Sprintf("failed to get instance: %v", err))
 		return
 	}
 	instance.EC2ID = ec2ID
 	if err := s.repo.Update(ctx, instance); err != nil {
 		logging.Error(ctx, "Failed to update instance %s: %v", instanceUUID, err)
-		s.repo.UpdateStatus(ctx, instanceUUID, models.StatusError)
+		s.updateStatus(ctx, instanceUUID, region, models.StatusError, fmt.Sprintf("failed to save EC2 instance ID: %v", err))
 		return
 	}
 
 	// Wait for instance to be running
-	if err := s.ec2Client.WaitForInstanceRunning(ctx, region, ec2ID); err != nil {
+	if err := s.ec2Client.WaitForInstanceRunning(ctx, account, region, ec2ID); err != nil {
 		logging.Error(ctx, "Failed to wait for instance %s to be running: %v", instanceUUID, err)
-		s.repo.UpdateStatus(ctx, instanceUUID, models.StatusError)
+		s.updateStatus(ctx, instanceUUID, region, models.StatusError, fmt.Sprintf("EC2 instance did not start: %v", err))
 		return
 	}
 
 	// Get public IP
-	publicIP, err := s.ec2Client.GetInstancePublicIP(ctx, region, ec2ID)
+	publicIP, err := s.ec2Client.GetInstancePublicIP(ctx, account, region, ec2ID)
 	if err != nil {
 		logging.Error(ctx, "Failed to get public IP for instance %s: %v", instanceUUID, err)
-		s.repo.UpdateStatus(ctx, instanceUUID, models.StatusError)
+		s.updateStatus(ctx, instanceUUID, region, models.StatusError, fmt.Sprintf("failed to get public IP: %v", err))
 		return
 	}
 
-	// Add to local V2Ray config if manager is initialized
-	if s.localV2RayManager != nil {
-		instanceTag := fmt.Sprintf("out_aws_%s", strings.ReplaceAll(region, "-", "_"))
-		if err := s.localV2RayManager.AddInstance(ctx, instanceTag, publicIP, config.AppConfig.V2Ray.Port, instanceUUID); err != nil {
-			logging.Error(ctx, "Failed to add instance %s to local V2Ray config: %v", instanceUUID, err)
-			// Continue even if local config update fails
+	// 使用同一份配置快照生成配置和链接，避免中途重新加载导致不一致
+	cfg := config.Get()
+
+	// Add to the V2Ray config of every relay
+	for _, relay := range s.relays {
+		err := relay.AddInstance(ctx, region, publicIP, cfg.V2Ray.Port, instanceUUID, relayReality(instance, cfg.V2Ray.Reality.Fingerprint))
+		if errors.Is(err, localv2ray.ErrRealityUnsupported) {
+			logging.Warn(ctx, "Skipped relay %s for instance %s: %v", relay.Name(), instanceUUID, err)
+		} else if err != nil {
+			logging.Error(ctx, "Failed to add instance %s to relay %s: %v", instanceUUID, relay.Name(), err)
+			// Continue even if the relay update fails, the reconcile task retries
 		} else {
-			logging.Info(ctx, "Added instance %s to local V2Ray config", instanceTag)
+			logging.Info(ctx, "Added instance %s to relay %s", localv2ray.InstanceTag(region, instanceUUID), relay.Name())
 		}
 	}
 
 	// Generate and save VMess links
-	if regionConfig, ok := config.AppConfig.AWS.Regions[region]; ok {
+	if regionConfig, ok := cfg.AWS.Regions[region]; ok {
 		ps := regionConfig.Name
 		if ps == "" {
 			ps = region
 		}
 
 		// Direct link (uses EC2 public IP and instance UUID)
-		directLink, err := models.GenerateVMessLink(publicIP, instanceUUID, fmt.Sprintf("%d", config.AppConfig.V2Ray.Port), ps)
-		if err != nil {
+		var directLink string
+		if instance.IsReality() {
+			directLink = models.GenerateVLESSRealityLink(publicIP, instanceUUID, fmt.Sprintf("%d", cfg.V2Ray.Port), ps, realityParams(instance, cfg.V2Ray.Reality.Fingerprint))
+		} else if directLink, err = models.GenerateVMessLink(publicIP, instanceUUID, fmt.Sprintf("%d", cfg.V2Ray.Port), ps); err != nil {
 			logging.Error(ctx, "Failed to generate direct link for instance %s: %v", instanceUUID, err)
 		}
 
-		// Relay link (uses configured public IP, port and UUID from local V2Ray config)
-		relayLink := ""
-		if config.AppConfig.V2Ray.PublicIP != "" && s.localV2RayManager != nil {
-			relayPort, relayUUID, getConfigErr := s.localV2RayManager.GetRelayConfig(region)
-			if getConfigErr != nil {
-				logging.Error(ctx, "Failed to get relay config for instance %s: %v", instanceUUID, getConfigErr)
-			} else {
-				relayLink, err = models.GenerateVMessLink(config.AppConfig.V2Ray.PublicIP, relayUUID, fmt.Sprintf("%d", relayPort), ps+" (中转)")
-				if err != nil {
-					logging.Error(ctx, "Failed to generate relay link for instance %s: %v", instanceUUID, err)
-				}
-			}
-		}
+		// Relay links (one per relay, using its public IP and the region user from its V2Ray config)
+		relayLink := s.relayLinks(ctx, cfg, region, ps)
 
 		// Save links to database
 		if directLink != "" || relayLink != "" {
@@ -361,15 +538,30 @@ func (s *V2RayService) createInstanceAsync(ctx context.Context, id int, region,
 				logging.Error(ctx, "Failed to save links for instance %s: %v", instanceUUID, err)
 			} else {
 				logging.Info(ctx, "Saved links for instance %s", instanceUUID)
+				s.publish(ctx, &models.LifecycleEvent{
+					InstanceUUID: instanceUUID,
+					Region:       region,
+					Type:         models.EventLinksChanged,
+					DirectLink:   directLink,
+					RelayLink:    relayLink,
+				})
 			}
 		}
 	}
 
-	if err := s.repo.UpdateStatusAndIP(ctx, instanceUUID, models.StatusRunning, publicIP); err != nil {
+	if err := s.repo.UpdateStatusAndIP(repository.WithEventCause(ctx, "EC2 instance running"), instanceUUID, models.StatusRunning, publicIP); err != nil {
 		logging.Error(ctx, "Failed to update status to running: %v", err)
 		return
 	}
-
+	s.publish(ctx, &models.LifecycleEvent{
+		InstanceUUID: instanceUUID,
+		Region:       region,
+		Type:         models.EventStatusChanged,
+		Status:       models.StatusRunning,
+		PublicIP:     publicIP,
+	})
+
+	success = true
 	logging.Info(ctx, "Instance %s created successfully with public IP: %s", instanceUUID, publicIP)
 }
 
@@ -385,13 +577,16 @@ func (s *V2RayService) createInstanceAsync(ctx context.Context, id int, region,
 //  1. 调用仓库层的 List 方法获取实例列表
 //  2. 返回实例列表和可能的错误
 func (s *V2RayService) ListInstances(ctx context.Context) ([]*models.V2RayInstance, error) {
+	ctx, span := tracing.Start(ctx, "service.ListInstances")
+	defer span.End()
+
 	instances, err := s.repo.List(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, instance := range instances {
-		if regionConfig, ok := config.AppConfig.AWS.Regions[instance.EC2Region]; ok {
+		if regionConfig, ok := config.Get().AWS.Regions[instance.EC2Region]; ok {
 			instance.EC2RegionName = regionConfig.Name
 		}
 	}
@@ -412,18 +607,112 @@ func (s *V2RayService) ListInstances(ctx context.Context) ([]*models.V2RayInstan
 //  1. 调用仓库层的 GetByID 方法获取实例详情
 //  2. 返回实例详情和可能的错误
 func (s *V2RayService) GetInstance(ctx context.Context, uuid string) (*models.V2RayInstance, error) {
+	ctx, span := tracing.Start(ctx, "service.GetInstance", attribute.String("instance.uuid", uuid))
+	defer span.End()
+
 	instance, err := s.repo.GetByUUID(ctx, uuid)
 	if err != nil {
 		return nil, err
 	}
 
-	if regionConfig, ok := config.AppConfig.AWS.Regions[instance.EC2Region]; ok {
+	if regionConfig, ok := config.Get().AWS.Regions[instance.EC2Region]; ok {
 		instance.EC2RegionName = regionConfig.Name
 	}
 
 	return instance, nil
 }
 
+// 分享链接类型
+const (
+	LinkKindDirect = "direct"
+	LinkKindRelay  = "relay"
+)
+
+// GetInstanceLink 获取实例指定类型的分享链接
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - uuid: 实例 UUID
+//   - kind: 链接类型，LinkKindDirect 或 LinkKindRelay
+//   - index: 配置了多个中转时选择第几个中转链接，从 0 开始，按中转名称排序
+//
+// 返回值:
+//   - string: 分享链接
+//   - error: 错误信息，如果实例不存在、类型不支持或链接尚未生成
+func (s *V2RayService) GetInstanceLink(ctx context.Context, uuid, kind string, index int) (string, error) {
+	instance, err := s.GetInstance(ctx, uuid)
+	if err != nil {
+		return "", err
+	}
+
+	var link string
+	switch kind {
+	case LinkKindDirect:
+		link = instance.DirectLink
+	case LinkKindRelay:
+		// Relay links are stored one per line, one line per relay
+		if instance.RelayLink != "" {
+			links := strings.Split(instance.RelayLink, "\n")
+			if index < 0 || index >= len(links) {
+				return "", fmt.Errorf("instance %s has %d relay links, index %d is out of range", uuid, len(links), index)
+			}
+			link = links[index]
+		}
+	default:
+		return "", fmt.Errorf("unsupported link kind %q", kind)
+	}
+	if link == "" {
+		return "", fmt.Errorf("instance %s has no %s link", uuid, kind)
+	}
+	return link, nil
+}
+
+// Subscription 生成订阅内容
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - kind: 链接类型，为空时同时包含直连和中转链接
+//
+// 返回值:
+//   - string: base64 编码的订阅内容
+//   - error: 错误信息，如果获取实例失败或类型不支持
+//
+// 功能:
+//  1. 收集所有运行中实例的分享链接
+//  2. 同一区域的实例共用中转用户，中转链接按中转地址、端口和用户去重，每个中转用户只出现一次
+//  3. 按行拼接后进行 base64 编码，兼容常见客户端的订阅格式
+func (s *V2RayService) Subscription(ctx context.Context, kind string) (string, error) {
+	if kind != "" && kind != LinkKindDirect && kind != LinkKindRelay {
+		return "", fmt.Errorf("unsupported link kind %q", kind)
+	}
+
+	instances, err := s.ListInstances(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var links []string
+	seenRelays := make(map[string]bool)
+	for _, instance := range instances {
+		if instance.Status != models.StatusRunning {
+			continue
+		}
+		if (kind == "" || kind == LinkKindDirect) && instance.DirectLink != "" {
+			links = append(links, instance.DirectLink)
+		}
+		if (kind == "" || kind == LinkKindRelay) && instance.RelayLink != "" {
+			for _, link := range strings.Split(instance.RelayLink, "\n") {
+				key := relayLinkKey(link)
+				if seenRelays[key] {
+					continue
+				}
+				seenRelays[key] = true
+				links = append(links, link)
+			}
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n"))), nil
+}
+
 // DeleteInstance 删除 V2Ray 实例
 // 参数:
 //   - ctx: 上下文，用于传递请求范围的值
@@ -438,6 +727,9 @@ func (s *V2RayService) GetInstance(ctx context.Context, uuid string) (*models.V2
 //  3. 启动异步删除过程
 //  4. 返回可能的错误
 func (s *V2RayService) DeleteInstance(ctx context.Context, uuid string) error {
+	ctx, span := tracing.Start(ctx, "service.DeleteInstance", attribute.String("instance.uuid", uuid))
+	defer span.End()
+
 	// Get instance
 	instance, err := s.repo.GetByUUID(ctx, uuid)
 	if err != nil {
@@ -445,13 +737,13 @@ func (s *V2RayService) DeleteInstance(ctx context.Context, uuid string) error {
 	}
 
 	// Update status to deleted
-	if err := s.repo.UpdateStatus(ctx, uuid, models.StatusDeleting); err != nil {
+	if err := s.updateStatus(ctx, uuid, instance.EC2Region, models.StatusDeleting, "delete requested"); err != nil {
 		return fmt.Errorf("failed to update status: %v", err)
 	}
 
 	// Start asynchronous deletion process
 	s.wg.Add(1)
-	go s.deleteInstanceAsync(ctx, uuid, instance.EC2ID, instance.EC2Region)
+	go s.deleteInstanceAsync(tracing.Detach(ctx), uuid, instance.EC2ID, instance.EC2Account, instance.EC2Region)
 
 	return nil
 }
@@ -461,19 +753,36 @@ func (s *V2RayService) DeleteInstance(ctx context.Context, uuid string) error {
 //   - ctx: 上下文，用于传递请求范围的值
 //   - id: 实例 ID
 //   - ec2ID: EC2 实例 ID
+//   - account: AWS 账号名，为空时使用 default
 //   - region: AWS 区域
 //
 // 功能:
 //  1. 更新上下文，添加实例 ID 用于日志记录
 //  2. 更新实例状态为 deleting
-//  3. 如果初始化了本地 V2Ray 管理器，从本地配置中移除实例
-//  4. 终止 EC2 实例
-//  5. 等待 EC2 实例变为终止状态
-//  6. 标记数据库中的实例为已删除
+//  3. 终止 EC2 实例
+//  4. 等待 EC2 实例变为终止状态
+//  5. 标记数据库中的实例为已删除
+//  6. 从每个中转删除实例的中转出站，区域没有其他实例时同时删除区域的中转路由和用户
 //  7. 记录实例删除成功的日志
-func (s *V2RayService) deleteInstanceAsync(ctx context.Context, uuid string, ec2ID, region string) {
+func (s *V2RayService) deleteInstanceAsync(ctx context.Context, uuid string, ec2ID, account, region string) {
 	defer s.wg.Done()
 
+	ctx, span := tracing.Start(ctx, "service.deleteInstanceAsync",
+		attribute.String("cloud.region", region),
+		attribute.String("instance.uuid", uuid),
+	)
+
+	// Record operation duration for metrics and finish the span
+	start := time.Now()
+	success := false
+	defer func() {
+		metrics.ObserveInstanceOperation("delete", region, start, success)
+		if !success {
+			tracing.RecordError(span, fmt.Errorf("failed to delete instance %s", uuid))
+		}
+		span.End()
+	}()
+
 	// Add instance ID to context for logging
 	ctx = logging.WithInstanceID(ctx, uuid)
 
@@ -486,25 +795,40 @@ func (s *V2RayService) deleteInstanceAsync(ctx context.Context, uuid string, ec2
 	}
 
 	// Terminate EC2 instance
-	if err := s.ec2Client.TerminateInstance(ctx, region, ec2ID); err != nil {
+	if err := s.ec2Client.TerminateInstance(ctx, account, region, ec2ID); err != nil {
 		logging.Error(ctx, "Failed to terminate EC2 instance: %v", err)
-		s.repo.UpdateStatus(ctx, uuid, models.StatusError)
+		s.updateStatus(ctx, uuid, region, models.StatusError, fmt.Sprintf("failed to terminate EC2 instance: %v", err))
 		return
 	}
 
 	// Wait for instance to be terminated
-	if err := s.ec2Client.WaitForInstanceTerminated(ctx, region, ec2ID); err != nil {
+	if err := s.ec2Client.WaitForInstanceTerminated(ctx, account, region, ec2ID); err != nil {
 		logging.Error(ctx, "Failed to wait for instance terminated: %v", err)
-		s.repo.UpdateStatus(ctx, uuid, models.StatusError)
+		s.updateStatus(ctx, uuid, region, models.StatusError, fmt.Sprintf("EC2 instance did not terminate: %v", err))
 		return
 	}
 
 	// Update status to deleted
-	if err := s.repo.Delete(ctx, uuid); err != nil {
+	if err := s.repo.Delete(repository.WithEventCause(ctx, "EC2 instance terminated"), uuid); err != nil {
 		logging.Error(ctx, "Failed to update status to deleted: %v", err)
 		return
 	}
+	s.publish(ctx, &models.LifecycleEvent{
+		InstanceUUID: uuid,
+		Region:       region,
+		Type:         models.EventStatusChanged,
+		Status:       models.StatusDeleted,
+	})
+
+	// Stop relaying users to the terminated instance
+	for _, relay := range s.relays {
+		if err := relay.RemoveInstance(ctx, region, uuid); err != nil {
+			logging.Error(ctx, "Failed to remove instance %s from relay %s: %v", uuid, relay.Name(), err)
+			// The reconcile task retries on its next run
+		}
+	}
 
+	success = true
 	logging.Info(ctx, "Instance %s deleted successfully", uuid)
 }
 
@@ -517,14 +841,15 @@ func (s *V2RayService) deleteInstanceAsync(ctx context.Context, uuid string, ec2
 //
 // 功能:
 //  1. 从配置文件中获取所有配置的区域
-//  2. 返回区域代码和名称的列表
+//  2. 返回区域代码、名称和别名的列表
 func (s *V2RayService) ListRegions(ctx context.Context) []*models.Region {
 	var regions []*models.Region
 
-	for regionCode, regionConfig := range config.AppConfig.AWS.Regions {
+	for regionCode, regionConfig := range config.Get().AWS.Regions {
 		regions = append(regions, &models.Region{
 			Region: regionCode,
 			Name:   regionConfig.Name,
+			Alias:  regionConfig.Alias,
 		})
 	}
 
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/models"
+	"github.com/yuhai94/anywhere_backend/internal/repository"
+)
+
+// ErrInstanceNotFound 实例不存在
+var ErrInstanceNotFound = errors.New("instance not found")
+
+// 流量统计的时间粒度
+const (
+	GranularityHour = "hour"
+	GranularityDay  = "day"
+)
+
+// TrafficUsage 一个统计对象在时间段内的流量
+type TrafficUsage struct {
+	Kind        string    `json:"kind"`
+	Name        string    `json:"name"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	Granularity string    `json:"granularity"`
+	Uplink      int64     `json:"uplink"`
+	Downlink    int64     `json:"downlink"`
+	// Series 按粒度汇总的流量，没有流量的时间段不返回
+	Series []*models.TrafficRollup `json:"series"`
+}
+
+type TrafficService struct {
+	repo *repository.Repository
+}
+
+// NewTrafficService 创建一个新的 TrafficService 实例
+// 参数:
+//   - repo: Repository 实例，用于读取流量统计
+//
+// 返回值:
+//   - *TrafficService: 新创建的 TrafficService 实例
+func NewTrafficService(repo *repository.Repository) *TrafficService {
+	return &TrafficService{repo: repo}
+}
+
+// InstanceUsage 获取实例在时间段内的流量
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - uuid: 实例 UUID
+//   - from: 时间段开始（包含）
+//   - to: 时间段结束（不包含）
+//   - granularity: 时间粒度，hour 或 day
+//
+// 返回值:
+//   - *TrafficUsage: 流量统计，实例的流量为其运行期间所在区域中转出站的流量
+//   - error: 错误信息，实例不存在时为包装了 ErrInstanceNotFound 的错误
+func (s *TrafficService) InstanceUsage(ctx context.Context, uuid string, from, to time.Time, granularity string) (*TrafficUsage, error) {
+	if _, err := s.repo.GetByUUID(ctx, uuid); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrInstanceNotFound, uuid)
+		}
+		return nil, fmt.Errorf("failed to get instance: %v", err)
+	}
+	return s.Usage(ctx, models.TrafficInstance, uuid, from, to, granularity)
+}
+
+// Usage 获取一个统计对象在时间段内的流量
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - kind: 统计对象类型，outbound、user 或 instance
+//   - name: 出站标签、用户 email 或实例 UUID
+//   - from: 时间段开始（包含）
+//   - to: 时间段结束（不包含）
+//   - granularity: 时间粒度，hour 或 day，按天汇总时使用服务所在时区
+//
+// 返回值:
+//   - *TrafficUsage: 流量统计
+//   - error: 错误信息，如果参数无效或查询失败
+func (s *TrafficService) Usage(ctx context.Context, kind, name string, from, to time.Time, granularity string) (*TrafficUsage, error) {
+	if err := validateTrafficKind(kind); err != nil {
+		return nil, err
+	}
+	if granularity != GranularityHour && granularity != GranularityDay {
+		return nil, fmt.Errorf("granularity must be %s or %s", GranularityHour, GranularityDay)
+	}
+	if !from.Before(to) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+
+	rollups, err := s.repo.ListTraffic(ctx, kind, name, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list traffic: %v", err)
+	}
+
+	usage := &TrafficUsage{
+		Kind:        kind,
+		Name:        name,
+		From:        from,
+		To:          to,
+		Granularity: granularity,
+		Series:      make([]*models.TrafficRollup, 0, len(rollups)),
+	}
+	for _, rollup := range rollups {
+		usage.Uplink += rollup.Uplink
+		usage.Downlink += rollup.Downlink
+
+		if granularity == GranularityDay {
+			local := rollup.Period.In(time.Local)
+			rollup.Period = time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.Local)
+		}
+		if n := len(usage.Series); n > 0 && usage.Series[n-1].Period.Equal(rollup.Period) {
+			usage.Series[n-1].Uplink += rollup.Uplink
+			usage.Series[n-1].Downlink += rollup.Downlink
+			continue
+		}
+		usage.Series = append(usage.Series, rollup)
+	}
+	return usage, nil
+}
+
+// Totals 获取一类统计对象在时间段内各自的总流量
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - kind: 统计对象类型，outbound、user 或 instance
+//   - from: 时间段开始（包含）
+//   - to: 时间段结束（不包含）
+//
+// 返回值:
+//   - []*models.TrafficRollup: 每个统计对象一项，按名称排序
+//   - error: 错误信息，如果参数无效或查询失败
+func (s *TrafficService) Totals(ctx context.Context, kind string, from, to time.Time) ([]*models.TrafficRollup, error) {
+	if err := validateTrafficKind(kind); err != nil {
+		return nil, err
+	}
+	if !from.Before(to) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+
+	rollups, err := s.repo.SumTraffic(ctx, kind, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum traffic: %v", err)
+	}
+	if rollups == nil {
+		rollups = []*models.TrafficRollup{}
+	}
+	return rollups, nil
+}
+
+// validateTrafficKind 检查统计对象类型
+func validateTrafficKind(kind string) error {
+	switch kind {
+	case models.TrafficOutbound, models.TrafficUser, models.TrafficInstance:
+		return nil
+	}
+	return fmt.Errorf("kind must be one of %s, %s, %s", models.TrafficOutbound, models.TrafficUser, models.TrafficInstance)
+}
+
+// instanceTrafficBytes 返回每个实例在时间段内的总流量（上行加下行，字节）
+func instanceTrafficBytes(ctx context.Context, repo *repository.Repository, from, to time.Time) (map[string]int64, error) {
+	rollups, err := repo.SumTraffic(ctx, models.TrafficInstance, from, to)
+	if err != nil {
+		return nil, err
+	}
+	bytes := make(map[string]int64, len(rollups))
+	for _, rollup := range rollups {
+		bytes[rollup.Name] = rollup.Uplink + rollup.Downlink
+	}
+	return bytes, nil
+}
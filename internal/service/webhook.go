@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+	"github.com/yuhai94/anywhere_backend/internal/repository"
+	"github.com/yuhai94/anywhere_backend/internal/webhook"
+)
+
+// defaultDeliveryLogLimit 默认返回的投递日志条数
+const defaultDeliveryLogLimit = 100
+
+type WebhookService struct {
+	repo *repository.Repository
+}
+
+// NewWebhookService 创建一个新的 WebhookService 实例
+// 参数:
+//   - repo: Repository 实例，用于数据库操作
+//
+// 返回值:
+//   - *WebhookService: 新创建的 WebhookService 实例
+func NewWebhookService(repo *repository.Repository) *WebhookService {
+	return &WebhookService{repo: repo}
+}
+
+// CreateWebhook 注册一个 webhook
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - rawURL: 回调地址，必须是 http 或 https
+//   - events: 订阅的事件类型，为空表示全部
+//   - secret: 签名密钥，为空时自动生成
+//
+// 返回值:
+//   - *models.Webhook: 创建的 webhook，包含签名密钥（仅在创建时返回）
+//   - error: 错误信息，如果参数无效或创建失败
+func (s *WebhookService) CreateWebhook(ctx context.Context, rawURL string, events []string, secret string) (*models.Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook url %q", rawURL)
+	}
+
+	for _, event := range events {
+		if !isKnownWebhookEvent(event) {
+			return nil, fmt.Errorf("unknown webhook event %q", event)
+		}
+	}
+
+	if secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, fmt.Errorf("failed to generate secret: %v", err)
+		}
+		secret = hex.EncodeToString(buf)
+	}
+
+	hook := &models.Webhook{
+		URL:     rawURL,
+		Secret:  secret,
+		Events:  strings.Join(events, ","),
+		Enabled: true,
+	}
+	if err := s.repo.CreateWebhook(ctx, hook); err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %v", err)
+	}
+
+	created, err := s.repo.GetWebhook(ctx, hook.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook: %v", err)
+	}
+	fillEventList(created)
+	logging.Info(ctx, "Registered webhook %d for %s", created.ID, created.URL)
+	return created, nil
+}
+
+// ListWebhooks 获取所有 webhook，不返回签名密钥
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//
+// 返回值:
+//   - []*models.Webhook: webhook 列表
+//   - error: 错误信息，如果获取失败
+func (s *WebhookService) ListWebhooks(ctx context.Context) ([]*models.Webhook, error) {
+	hooks, err := s.repo.ListWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, hook := range hooks {
+		hook.Secret = ""
+		fillEventList(hook)
+	}
+	return hooks, nil
+}
+
+// DeleteWebhook 删除 webhook
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - id: webhook ID
+//
+// 返回值:
+//   - error: 错误信息，如果 webhook 不存在或删除失败
+func (s *WebhookService) DeleteWebhook(ctx context.Context, id int) error {
+	if _, err := s.repo.GetWebhook(ctx, id); err != nil {
+		return fmt.Errorf("webhook not found: %v", err)
+	}
+	return s.repo.DeleteWebhook(ctx, id)
+}
+
+// ListDeliveries 获取 webhook 的投递日志
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - id: webhook ID
+//   - limit: 最多返回的记录数，小于等于 0 时使用默认值
+//
+// 返回值:
+//   - []*models.WebhookDelivery: 投递记录，最新的在前
+//   - error: 错误信息，如果获取失败
+func (s *WebhookService) ListDeliveries(ctx context.Context, id int, limit int) ([]*models.WebhookDelivery, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = defaultDeliveryLogLimit
+	}
+	return s.repo.ListDeliveries(ctx, id, limit)
+}
+
+// isKnownWebhookEvent 检查是否为支持的 webhook 事件类型
+func isKnownWebhookEvent(event string) bool {
+	for _, known := range webhook.AllEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+// fillEventList 将逗号分隔的事件类型展开为列表
+func fillEventList(hook *models.Webhook) {
+	hook.EventList = []string{}
+	for _, event := range strings.Split(hook.Events, ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			hook.EventList = append(hook.EventList, event)
+		}
+	}
+}
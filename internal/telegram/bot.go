@@ -0,0 +1,466 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/events"
+	"github.com/yuhai94/anywhere_backend/internal/interfaces"
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+	"github.com/yuhai94/anywhere_backend/internal/qrcode"
+)
+
+const (
+	defaultPollTimeout = 30
+	// retryDelay 轮询失败后的重试间隔
+	retryDelay = 5 * time.Second
+	// defaultReadyTimeout 等待新实例就绪的默认超时时间
+	defaultReadyTimeout = 10 * time.Minute
+)
+
+const helpText = `可用命令:
+/regions - 列出支持的区域
+/up <区域> - 在区域中创建节点，就绪后发送链接
+/down <区域> - 删除区域中的节点
+/links [区域] - 获取运行中节点的链接和二维码
+
+区域可以是代码、别名或名称，例如 /up hk`
+
+// Bot Telegram 机器人，通过长轮询接收命令并调用 V2RayService
+type Bot struct {
+	client  *Client
+	service interfaces.V2RayServiceInterface
+	bus     *events.Bus
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewBot 创建一个新的 Telegram 机器人
+// 参数:
+//   - service: V2RayService 实例，用于处理实例相关的命令，测试时可使用模拟实现
+//   - bus: 事件总线，用于在实例就绪时通知用户
+//
+// 返回值:
+//   - *Bot: 新创建的机器人，作为定时任务注册到调度器中运行
+func NewBot(service interfaces.V2RayServiceInterface, bus *events.Bus) *Bot {
+	cfg := config.Get().Telegram
+	return &Bot{
+		client:  NewClient(cfg.APIBaseURL, cfg.Token),
+		service: service,
+		bus:     bus,
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// Name 返回任务名称
+func (b *Bot) Name() string {
+	return "telegram_bot"
+}
+
+// Start 启动长轮询循环
+// 参数:
+//   - ctx: 上下文，取消时停止轮询
+//
+// 功能:
+//  1. 循环调用 getUpdates 获取新消息
+//  2. 逐条处理消息，并推进 offset 确认已处理的更新
+//  3. 请求失败时等待一段时间后重试
+//  4. 退出前等待所有后台通知完成
+func (b *Bot) Start(ctx context.Context) {
+	logging.Info(ctx, "Starting telegram bot, %d allowed chats", len(config.Get().Telegram.AllowedChatIDs))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-b.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	defer b.wg.Wait()
+
+	var offset int64
+	for {
+		// 每次轮询重新读取配置，重新加载后立即生效
+		pollTimeout := defaultPollTimeout
+		if timeout := config.Get().Telegram.PollTimeout; timeout > 0 {
+			pollTimeout = timeout
+		}
+		updates, err := b.client.GetUpdates(ctx, offset, pollTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				logging.Info(ctx, "Telegram bot stopped")
+				return
+			}
+			logging.Error(ctx, "Failed to get telegram updates: %v", err)
+			select {
+			case <-ctx.Done():
+				logging.Info(ctx, "Telegram bot stopped")
+				return
+			case <-time.After(retryDelay):
+			}
+			continue
+		}
+
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+			if update.Message != nil {
+				b.handleMessage(logging.WithRequestID(ctx), update.Message)
+			}
+		}
+	}
+}
+
+// Stop 停止任务
+func (b *Bot) Stop() {
+	close(b.stopCh)
+}
+
+// isAllowed 判断会话是否在当前配置的白名单中
+func isAllowed(chatID int64) bool {
+	for _, id := range config.Get().Telegram.AllowedChatIDs {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// handleMessage 处理一条消息
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - msg: 收到的消息
+//
+// 功能:
+//  1. 拒绝不在白名单中的会话，并回复其 chat ID 便于管理员配置
+//  2. 解析命令和参数，分发到对应的处理函数
+func (b *Bot) handleMessage(ctx context.Context, msg *Message) {
+	chatID := msg.Chat.ID
+	if !isAllowed(chatID) {
+		logging.Warn(ctx, "Rejected telegram message from chat %d", chatID)
+		b.reply(ctx, chatID, fmt.Sprintf("未授权的会话，chat ID: %d", chatID))
+		return
+	}
+
+	command, args := parseCommand(msg.Text)
+	if command == "" {
+		return
+	}
+	logging.Info(ctx, "Telegram command %s %v from chat %d", command, args, chatID)
+
+	switch command {
+	case "/start", "/help":
+		b.reply(ctx, chatID, helpText)
+	case "/regions":
+		b.handleRegions(ctx, chatID)
+	case "/up":
+		b.handleUp(ctx, chatID, args)
+	case "/down":
+		b.handleDown(ctx, chatID, args)
+	case "/links":
+		b.handleLinks(ctx, chatID, args)
+	default:
+		b.reply(ctx, chatID, "未知命令\n\n"+helpText)
+	}
+}
+
+// handleRegions 列出支持的区域以及各区域当前的节点状态
+func (b *Bot) handleRegions(ctx context.Context, chatID int64) {
+	regions := b.service.ListRegions(ctx)
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Region < regions[j].Region })
+
+	instances, err := b.service.ListInstances(ctx)
+	if err != nil {
+		b.reply(ctx, chatID, fmt.Sprintf("获取实例列表失败: %v", err))
+		return
+	}
+	statusByRegion := make(map[string]string)
+	for _, instance := range instances {
+		statusByRegion[instance.EC2Region] = instance.Status
+	}
+
+	var sb strings.Builder
+	sb.WriteString("支持的区域:\n")
+	for _, region := range regions {
+		line := region.Region
+		if alias := config.Get().AWS.Regions[region.Region].Alias; alias != "" {
+			line = fmt.Sprintf("%s (%s)", alias, region.Region)
+		}
+		if region.Name != "" {
+			line += " " + region.Name
+		}
+		if status, ok := statusByRegion[region.Region]; ok {
+			line += " - " + status
+		}
+		sb.WriteString(line + "\n")
+	}
+	b.reply(ctx, chatID, sb.String())
+}
+
+// handleUp 在指定区域创建节点
+// 功能:
+//  1. 解析区域参数并调用 CreateInstance，以 telegram:<chat id> 作为创建者
+//  2. 区域已有运行中的节点时直接发送链接
+//  3. 否则在后台等待实例就绪后发送链接
+func (b *Bot) handleUp(ctx context.Context, chatID int64, args []string) {
+	region, ok := b.resolveRegionArg(ctx, chatID, args, "/up")
+	if !ok {
+		return
+	}
+
+	instanceUUID, err := b.service.CreateInstance(ctx, region, fmt.Sprintf("telegram:%d", chatID), "")
+	if err != nil {
+		b.reply(ctx, chatID, fmt.Sprintf("创建节点失败: %v", err))
+		return
+	}
+
+	instance, err := b.service.GetInstance(ctx, instanceUUID)
+	if err != nil {
+		b.reply(ctx, chatID, fmt.Sprintf("获取实例失败: %v", err))
+		return
+	}
+	if instance.Status == models.StatusRunning {
+		b.sendLinks(ctx, chatID, instance)
+		return
+	}
+
+	b.reply(ctx, chatID, fmt.Sprintf("正在 %s 创建节点 %s，就绪后会发送链接", regionLabel(region), instanceUUID))
+	b.wg.Add(1)
+	go b.notifyWhenReady(ctx, chatID, instanceUUID)
+}
+
+// notifyWhenReady 等待实例进入终态并通知用户
+// 参数:
+//   - ctx: 上下文，机器人停止时取消
+//   - chatID: 需要通知的会话 ID
+//   - instanceUUID: 实例 UUID
+func (b *Bot) notifyWhenReady(ctx context.Context, chatID int64, instanceUUID string) {
+	defer b.wg.Done()
+
+	sub, _, err := b.bus.Subscribe(ctx, instanceUUID, 0)
+	if err != nil {
+		logging.Error(ctx, "Failed to subscribe to instance %s: %v", instanceUUID, err)
+		return
+	}
+	defer sub.Close()
+
+	timeout := defaultReadyTimeout
+	if config.Get().Scheduler.InstanceWaitTimeout > 0 {
+		timeout = time.Duration(config.Get().Scheduler.InstanceWaitTimeout)*time.Second + time.Minute
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	// 订阅之前实例可能已经就绪
+	if b.checkReady(ctx, chatID, instanceUUID) {
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			b.reply(ctx, chatID, fmt.Sprintf("等待节点 %s 就绪超时，请稍后使用 /links 查看", instanceUUID))
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				// 订阅因处理过慢被关闭，回退为直接查询
+				b.checkReady(ctx, chatID, instanceUUID)
+				return
+			}
+			if event.Type != models.EventStatusChanged {
+				continue
+			}
+			if b.checkReady(ctx, chatID, instanceUUID) {
+				return
+			}
+		}
+	}
+}
+
+// checkReady 检查实例是否已进入终态，是则通知用户
+// 返回值:
+//   - bool: 实例是否已进入终态
+func (b *Bot) checkReady(ctx context.Context, chatID int64, instanceUUID string) bool {
+	instance, err := b.service.GetInstance(ctx, instanceUUID)
+	if err != nil {
+		logging.Error(ctx, "Failed to get instance %s: %v", instanceUUID, err)
+		return false
+	}
+
+	switch instance.Status {
+	case models.StatusRunning:
+		b.sendLinks(ctx, chatID, instance)
+		return true
+	case models.StatusError:
+		b.reply(ctx, chatID, fmt.Sprintf("节点 %s 创建失败", instanceUUID))
+		return true
+	case models.StatusDeleting, models.StatusDeleted:
+		b.reply(ctx, chatID, fmt.Sprintf("节点 %s 已被删除", instanceUUID))
+		return true
+	}
+	return false
+}
+
+// handleDown 删除指定区域中的节点
+func (b *Bot) handleDown(ctx context.Context, chatID int64, args []string) {
+	region, ok := b.resolveRegionArg(ctx, chatID, args, "/down")
+	if !ok {
+		return
+	}
+
+	instances, err := b.service.ListInstances(ctx)
+	if err != nil {
+		b.reply(ctx, chatID, fmt.Sprintf("获取实例列表失败: %v", err))
+		return
+	}
+
+	deleted := 0
+	for _, instance := range instances {
+		if instance.EC2Region != region || instance.Status == models.StatusDeleting {
+			continue
+		}
+		if err := b.service.DeleteInstance(ctx, instance.UUID); err != nil {
+			b.reply(ctx, chatID, fmt.Sprintf("删除节点 %s 失败: %v", instance.UUID, err))
+			continue
+		}
+		deleted++
+	}
+
+	if deleted == 0 {
+		b.reply(ctx, chatID, fmt.Sprintf("%s 没有可删除的节点", regionLabel(region)))
+		return
+	}
+	b.reply(ctx, chatID, fmt.Sprintf("正在删除 %s 的 %d 个节点", regionLabel(region), deleted))
+}
+
+// handleLinks 发送运行中节点的链接和二维码
+func (b *Bot) handleLinks(ctx context.Context, chatID int64, args []string) {
+	region := ""
+	if len(args) > 0 {
+		resolved, ok := b.resolveRegionArg(ctx, chatID, args, "/links")
+		if !ok {
+			return
+		}
+		region = resolved
+	}
+
+	instances, err := b.service.ListInstances(ctx)
+	if err != nil {
+		b.reply(ctx, chatID, fmt.Sprintf("获取实例列表失败: %v", err))
+		return
+	}
+
+	sent := 0
+	for _, instance := range instances {
+		if instance.Status != models.StatusRunning || (region != "" && instance.EC2Region != region) {
+			continue
+		}
+		b.sendLinks(ctx, chatID, instance)
+		sent++
+	}
+
+	if sent == 0 {
+		b.reply(ctx, chatID, "没有运行中的节点")
+	}
+}
+
+// sendLinks 发送实例的 vmess 链接以及对应的二维码
+func (b *Bot) sendLinks(ctx context.Context, chatID int64, instance *models.V2RayInstance) {
+	name := instance.EC2RegionName
+	if name == "" {
+		name = instance.EC2Region
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s 节点 %s (%s)\n", name, instance.UUID, instance.EC2PublicIP)
+	if instance.DirectLink != "" {
+		fmt.Fprintf(&sb, "\n直连:\n%s\n", instance.DirectLink)
+	}
+	if instance.RelayLink != "" {
+		fmt.Fprintf(&sb, "\n中转:\n%s\n", instance.RelayLink)
+	}
+	if instance.DirectLink == "" && instance.RelayLink == "" {
+		sb.WriteString("\n暂无链接")
+	}
+	b.reply(ctx, chatID, sb.String())
+
+	b.sendQRCode(ctx, chatID, instance.DirectLink, name+" 直连")
+	// One relay link per line when several relays are configured
+	if instance.RelayLink != "" {
+		for _, link := range strings.Split(instance.RelayLink, "\n") {
+			b.sendQRCode(ctx, chatID, link, name+" 中转")
+		}
+	}
+}
+
+// sendQRCode 将链接编码为二维码图片发送
+func (b *Bot) sendQRCode(ctx context.Context, chatID int64, link, caption string) {
+	if link == "" {
+		return
+	}
+	png, err := qrcode.PNG(link, qrcode.DefaultSize)
+	if err != nil {
+		logging.Error(ctx, "Failed to render qr code: %v", err)
+		return
+	}
+	if err := b.client.SendPhoto(ctx, chatID, "qrcode.png", png, caption); err != nil {
+		logging.Error(ctx, "Failed to send qr code to chat %d: %v", chatID, err)
+	}
+}
+
+// resolveRegionArg 解析命令中的区域参数，失败时回复用户
+func (b *Bot) resolveRegionArg(ctx context.Context, chatID int64, args []string, command string) (string, bool) {
+	if len(args) == 0 {
+		b.reply(ctx, chatID, fmt.Sprintf("用法: %s <区域>，使用 /regions 查看支持的区域", command))
+		return "", false
+	}
+	region, err := config.ResolveRegion(args[0])
+	if err != nil {
+		b.reply(ctx, chatID, fmt.Sprintf("未知区域 %s，使用 /regions 查看支持的区域", args[0]))
+		return "", false
+	}
+	return region, true
+}
+
+// reply 发送文本消息，失败时只记录日志
+func (b *Bot) reply(ctx context.Context, chatID int64, text string) {
+	if err := b.client.SendMessage(ctx, chatID, text); err != nil {
+		logging.Error(ctx, "Failed to send telegram message to chat %d: %v", chatID, err)
+	}
+}
+
+// parseCommand 将消息拆分为命令和参数
+// 参数:
+//   - text: 消息内容，例如 "/up hk" 或群组中的 "/up@anywhere_bot hk"
+//
+// 返回值:
+//   - string: 小写的命令名，消息不是命令时为空
+//   - []string: 命令参数
+func parseCommand(text string) (string, []string) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return "", nil
+	}
+	command := fields[0]
+	if at := strings.Index(command, "@"); at >= 0 {
+		command = command[:at]
+	}
+	return strings.ToLower(command), fields[1:]
+}
+
+// regionLabel 返回区域的显示名称
+func regionLabel(region string) string {
+	if regionConfig, ok := config.Get().AWS.Regions[region]; ok && regionConfig.Name != "" {
+		return regionConfig.Name
+	}
+	return region
+}
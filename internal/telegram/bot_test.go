@@ -0,0 +1,488 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/events"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+)
+
+const testToken = "123456:secret-token"
+
+// sentMessage 模拟 Bot API 收到的 sendMessage 请求
+type sentMessage struct {
+	ChatID int64  `json:"chat_id"`
+	Text   string `json:"text"`
+}
+
+// sentPhoto 模拟 Bot API 收到的 sendPhoto 请求
+type sentPhoto struct {
+	ChatID   int64
+	Caption  string
+	Filename string
+	Data     []byte
+}
+
+// fakeBotAPI 模拟的 Telegram Bot API，按 token 校验路径，记录发送的消息和图片
+type fakeBotAPI struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu       sync.Mutex
+	updates  []Update
+	offsets  []int64
+	messages []sentMessage
+	photos   []sentPhoto
+}
+
+func newFakeBotAPI(t *testing.T) *fakeBotAPI {
+	api := &fakeBotAPI{t: t}
+	api.server = httptest.NewServer(http.HandlerFunc(api.handle))
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+func (api *fakeBotAPI) handle(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/bot"+testToken+"/")
+	if r.Method != http.MethodPost || method == r.URL.Path {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(apiResponse{ErrorCode: 404, Description: "Not Found"})
+		return
+	}
+
+	var result interface{} = true
+	switch method {
+	case "getUpdates":
+		var params struct {
+			Offset  int64 `json:"offset"`
+			Timeout int   `json:"timeout"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			api.t.Errorf("getUpdates: %v", err)
+		}
+		updates := api.pollUpdates(r.Context(), params.Offset)
+		result = updates
+	case "sendMessage":
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			api.t.Errorf("sendMessage Content-Type = %s", ct)
+		}
+		var msg sentMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			api.t.Errorf("sendMessage: %v", err)
+		}
+		api.mu.Lock()
+		api.messages = append(api.messages, msg)
+		api.mu.Unlock()
+	case "sendPhoto":
+		photo, err := parsePhoto(r)
+		if err != nil {
+			api.t.Errorf("sendPhoto: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(apiResponse{ErrorCode: 400, Description: "Bad Request: " + err.Error()})
+			return
+		}
+		api.mu.Lock()
+		api.photos = append(api.photos, photo)
+		api.mu.Unlock()
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(apiResponse{ErrorCode: 404, Description: "Not Found: method not found"})
+		return
+	}
+
+	data, _ := json.Marshal(result)
+	json.NewEncoder(w).Encode(apiResponse{OK: true, Result: data})
+}
+
+// parsePhoto 解析 sendPhoto 的 multipart 请求体
+func parsePhoto(r *http.Request) (sentPhoto, error) {
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		return sentPhoto{}, err
+	}
+	chatID, err := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+	if err != nil {
+		return sentPhoto{}, fmt.Errorf("chat_id: %v", err)
+	}
+	file, header, err := r.FormFile("photo")
+	if err != nil {
+		return sentPhoto{}, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return sentPhoto{}, err
+	}
+	return sentPhoto{ChatID: chatID, Caption: r.FormValue("caption"), Filename: header.Filename, Data: data}, nil
+}
+
+// pollUpdates 返回 offset 之后的更新，没有更新时短暂等待，模拟长轮询
+func (api *fakeBotAPI) pollUpdates(ctx context.Context, offset int64) []Update {
+	api.mu.Lock()
+	api.offsets = append(api.offsets, offset)
+	var updates []Update
+	for _, update := range api.updates {
+		if update.UpdateID >= offset {
+			updates = append(updates, update)
+		}
+	}
+	api.mu.Unlock()
+
+	if len(updates) == 0 {
+		select {
+		case <-ctx.Done():
+		case <-time.After(20 * time.Millisecond):
+		}
+		return []Update{}
+	}
+	return updates
+}
+
+func (api *fakeBotAPI) push(updates ...Update) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.updates = append(api.updates, updates...)
+}
+
+func (api *fakeBotAPI) sent() ([]sentMessage, []sentPhoto) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return append([]sentMessage{}, api.messages...), append([]sentPhoto{}, api.photos...)
+}
+
+// waitFor 等待模拟的 Bot API 收到指定数量的消息和图片
+func (api *fakeBotAPI) waitFor(t *testing.T, messages, photos int) ([]sentMessage, []sentPhoto) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		gotMessages, gotPhotos := api.sent()
+		if len(gotMessages) >= messages && len(gotPhotos) >= photos {
+			return gotMessages, gotPhotos
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d messages and %d photos, want %d and %d: %+v", len(gotMessages), len(gotPhotos), messages, photos, gotMessages)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// fakeService 内存中的实例列表，实现机器人使用的 V2RayService 方法
+type fakeService struct {
+	mu        sync.Mutex
+	instances []*models.V2RayInstance
+	// createStatus 新建实例的初始状态
+	createStatus string
+	owners       []string
+	listCalls    int
+}
+
+func (s *fakeService) CreateInstance(ctx context.Context, region, owner, protocol string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.owners = append(s.owners, owner)
+	instance := &models.V2RayInstance{
+		UUID:          fmt.Sprintf("new-%d", len(s.owners)),
+		EC2Region:     region,
+		EC2RegionName: config.Get().AWS.Regions[region].Name,
+		EC2PublicIP:   "203.0.113.10",
+		Status:        s.createStatus,
+		DirectLink:    "vmess://direct-" + region,
+	}
+	s.instances = append(s.instances, instance)
+	return instance.UUID, nil
+}
+
+func (s *fakeService) GetInstance(ctx context.Context, uuid string) (*models.V2RayInstance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, instance := range s.instances {
+		if instance.UUID == uuid {
+			copied := *instance
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("instance %s not found", uuid)
+}
+
+func (s *fakeService) ListInstances(ctx context.Context) ([]*models.V2RayInstance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listCalls++
+	instances := make([]*models.V2RayInstance, 0, len(s.instances))
+	for _, instance := range s.instances {
+		copied := *instance
+		instances = append(instances, &copied)
+	}
+	return instances, nil
+}
+
+func (s *fakeService) DeleteInstance(ctx context.Context, uuid string) error {
+	return s.setStatus(uuid, models.StatusDeleting)
+}
+
+func (s *fakeService) ListRegions(ctx context.Context) []*models.Region {
+	var regions []*models.Region
+	for code, regionConfig := range config.Get().AWS.Regions {
+		regions = append(regions, &models.Region{Region: code, Name: regionConfig.Name, Alias: regionConfig.Alias})
+	}
+	return regions
+}
+
+func (s *fakeService) setStatus(uuid, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, instance := range s.instances {
+		if instance.UUID == uuid {
+			instance.Status = status
+			return nil
+		}
+	}
+	return fmt.Errorf("instance %s not found", uuid)
+}
+
+// memoryStore 内存中的事件存储
+type memoryStore struct {
+	mu     sync.Mutex
+	events []*models.LifecycleEvent
+}
+
+func (s *memoryStore) AppendEvent(ctx context.Context, event *models.LifecycleEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event.ID = int64(len(s.events) + 1)
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *memoryStore) ListEventsAfter(ctx context.Context, afterID int64, instanceUUID string, limit int) ([]*models.LifecycleEvent, error) {
+	return nil, nil
+}
+
+// newTestBot 创建连接到模拟 Bot API 的机器人，只允许 chat 100
+func newTestBot(t *testing.T) (*Bot, *fakeBotAPI, *fakeService) {
+	api := newFakeBotAPI(t)
+
+	previous := config.Get()
+	t.Cleanup(func() { config.Set(previous) })
+	config.Set(&config.Config{
+		AWS: config.AWSConfig{Regions: map[string]config.AWSRegionConfig{
+			"ap-east-1": {Name: "香港", Alias: "hk"},
+			"us-west-2": {Name: "美西", Alias: "usw"},
+		}},
+		Telegram: config.TelegramConfig{
+			Enabled:        true,
+			Token:          testToken,
+			APIBaseURL:     api.server.URL + "/",
+			AllowedChatIDs: []int64{100},
+			PollTimeout:    1,
+		},
+	})
+
+	svc := &fakeService{createStatus: models.StatusPending}
+	bot := NewBot(svc, events.NewBus(&memoryStore{}))
+	return bot, api, svc
+}
+
+func message(chatID int64, text string) *Message {
+	return &Message{MessageID: 1, Chat: Chat{ID: chatID}, Text: text}
+}
+
+// TestBotAllowlist 不在白名单中的会话只收到包含 chat ID 的拒绝消息，命令不会执行；每次轮询确认已处理的更新
+func TestBotAllowlist(t *testing.T) {
+	bot, api, svc := newTestBot(t)
+	api.push(
+		Update{UpdateID: 7, Message: message(42, "/links")},
+		Update{UpdateID: 8, Message: message(100, "/help")},
+	)
+
+	done := make(chan struct{})
+	go func() {
+		bot.Start(context.Background())
+		close(done)
+	}()
+	messages, _ := api.waitFor(t, 2, 0)
+	bot.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("bot did not stop")
+	}
+
+	if messages[0].ChatID != 42 || messages[0].Text != "未授权的会话，chat ID: 42" {
+		t.Errorf("reply to chat 42 = %+v, want a rejection", messages[0])
+	}
+	if messages[1].ChatID != 100 || messages[1].Text != helpText {
+		t.Errorf("reply to chat 100 = %+v, want the help text", messages[1])
+	}
+	if svc.listCalls != 0 {
+		t.Errorf("rejected /links listed instances %d times", svc.listCalls)
+	}
+
+	api.mu.Lock()
+	offsets := api.offsets
+	api.mu.Unlock()
+	if len(offsets) < 2 || offsets[0] != 0 || offsets[1] != 9 {
+		t.Errorf("getUpdates offsets = %v, want 0 then 9", offsets)
+	}
+}
+
+// TestBotUp /up 以 telegram:<chat id> 创建实例，已运行时直接发送链接，否则在实例就绪后发送链接和二维码
+func TestBotUp(t *testing.T) {
+	t.Run("running", func(t *testing.T) {
+		bot, api, svc := newTestBot(t)
+		svc.createStatus = models.StatusRunning
+
+		bot.handleMessage(context.Background(), message(100, "/up HK"))
+		messages, photos := api.waitFor(t, 1, 1)
+
+		if len(svc.owners) != 1 || svc.owners[0] != "telegram:100" {
+			t.Errorf("owners = %v, want [telegram:100]", svc.owners)
+		}
+		if !strings.Contains(messages[0].Text, "香港 节点 new-1 (203.0.113.10)") || !strings.Contains(messages[0].Text, "vmess://direct-ap-east-1") {
+			t.Errorf("links message = %q", messages[0].Text)
+		}
+		checkPhoto(t, photos[0], 100, "香港 直连")
+	})
+
+	t.Run("pending", func(t *testing.T) {
+		bot, api, svc := newTestBot(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer func() {
+			cancel()
+			bot.wg.Wait()
+		}()
+
+		bot.handleMessage(ctx, message(100, "/up usw"))
+		messages, _ := api.waitFor(t, 1, 0)
+		if want := "正在 美西 创建节点 new-1，就绪后会发送链接"; messages[0].Text != want {
+			t.Fatalf("reply = %q, want %q", messages[0].Text, want)
+		}
+
+		if err := svc.setStatus("new-1", models.StatusRunning); err != nil {
+			t.Fatal(err)
+		}
+		bot.bus.Publish(ctx, &models.LifecycleEvent{InstanceUUID: "new-1", Type: models.EventStatusChanged, Status: models.StatusRunning})
+
+		messages, photos := api.waitFor(t, 2, 1)
+		if !strings.Contains(messages[1].Text, "vmess://direct-us-west-2") {
+			t.Errorf("links message = %q", messages[1].Text)
+		}
+		checkPhoto(t, photos[0], 100, "美西 直连")
+	})
+
+	t.Run("unknown region", func(t *testing.T) {
+		bot, api, svc := newTestBot(t)
+		bot.handleMessage(context.Background(), message(100, "/up mars"))
+		messages, _ := api.waitFor(t, 1, 0)
+		if want := "未知区域 mars，使用 /regions 查看支持的区域"; messages[0].Text != want {
+			t.Errorf("reply = %q, want %q", messages[0].Text, want)
+		}
+		if len(svc.owners) != 0 {
+			t.Error("an instance was created for an unknown region")
+		}
+	})
+}
+
+// TestBotLinks /links 发送运行中节点的链接，每条直连和中转链接各发送一张二维码图片，可以按区域过滤
+func TestBotLinks(t *testing.T) {
+	instances := []*models.V2RayInstance{
+		{
+			UUID: "hk-1", EC2Region: "ap-east-1", EC2RegionName: "香港", EC2PublicIP: "198.51.100.1", Status: models.StatusRunning,
+			DirectLink: "vmess://hk-direct", RelayLink: "vmess://hk-relay-a\nvmess://hk-relay-b",
+		},
+		{UUID: "hk-2", EC2Region: "ap-east-1", Status: models.StatusPending, DirectLink: "vmess://pending"},
+		{UUID: "usw-1", EC2Region: "us-west-2", EC2PublicIP: "198.51.100.2", Status: models.StatusRunning, DirectLink: "vmess://usw-direct"},
+	}
+
+	tests := []struct {
+		name         string
+		text         string
+		instances    []*models.V2RayInstance
+		wantTexts    []string
+		wantCaptions []string
+	}{
+		{
+			"all regions",
+			"/links",
+			instances,
+			[]string{"香港 节点 hk-1 (198.51.100.1)", "us-west-2 节点 usw-1 (198.51.100.2)"},
+			[]string{"香港 直连", "香港 中转", "香港 中转", "us-west-2 直连"},
+		},
+		{
+			"one region",
+			"/links@anywhere_bot hk",
+			instances,
+			[]string{"香港 节点 hk-1 (198.51.100.1)\n\n直连:\nvmess://hk-direct\n\n中转:\nvmess://hk-relay-a\nvmess://hk-relay-b\n"},
+			[]string{"香港 直连", "香港 中转", "香港 中转"},
+		},
+		{"no running instances", "/links", instances[1:2], []string{"没有运行中的节点"}, nil},
+		{"unknown region", "/links mars", instances, []string{"未知区域 mars，使用 /regions 查看支持的区域"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot, api, svc := newTestBot(t)
+			svc.instances = tt.instances
+
+			bot.handleMessage(context.Background(), message(100, tt.text))
+			messages, photos := api.waitFor(t, len(tt.wantTexts), len(tt.wantCaptions))
+
+			if len(messages) != len(tt.wantTexts) {
+				t.Fatalf("got %d messages, want %d: %+v", len(messages), len(tt.wantTexts), messages)
+			}
+			for i, want := range tt.wantTexts {
+				if !strings.HasPrefix(messages[i].Text, want) {
+					t.Errorf("message %d = %q, want prefix %q", i, messages[i].Text, want)
+				}
+			}
+			if len(photos) != len(tt.wantCaptions) {
+				t.Fatalf("got %d photos, want %d", len(photos), len(tt.wantCaptions))
+			}
+			for i, caption := range tt.wantCaptions {
+				checkPhoto(t, photos[i], 100, caption)
+			}
+		})
+	}
+}
+
+// checkPhoto 检查 sendPhoto 上传的是发送到指定会话的 PNG 二维码
+func checkPhoto(t *testing.T, photo sentPhoto, chatID int64, caption string) {
+	t.Helper()
+	if photo.ChatID != chatID || photo.Caption != caption || photo.Filename != "qrcode.png" {
+		t.Errorf("photo = chat %d, caption %q, file %q, want chat %d, caption %q, file qrcode.png", photo.ChatID, photo.Caption, photo.Filename, chatID, caption)
+	}
+	if !bytes.HasPrefix(photo.Data, []byte("\x89PNG\r\n\x1a\n")) {
+		t.Errorf("photo data is not a PNG: % x", photo.Data[:min(len(photo.Data), 8)])
+	}
+}
+
+// TestClientErrors API 返回 ok=false 时返回错误描述，请求失败时错误信息中不包含 token
+func TestClientErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, testToken)
+	err := client.SendMessage(context.Background(), 100, "hello")
+	if err == nil || err.Error() != "sendMessage failed: 403 Forbidden: bot was blocked by the user" {
+		t.Errorf("SendMessage() error = %v", err)
+	}
+
+	server.Close()
+	err = client.SendPhoto(context.Background(), 100, "qrcode.png", []byte("png"), "")
+	if err == nil || strings.Contains(err.Error(), testToken) {
+		t.Errorf("SendPhoto() error = %v, want an error without the token", err)
+	}
+}
@@ -0,0 +1,208 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultAPIBaseURL Telegram Bot API 的默认地址
+const DefaultAPIBaseURL = "https://api.telegram.org"
+
+// Update Bot API 返回的更新
+type Update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *Message `json:"message,omitempty"`
+}
+
+// Message Bot API 中的消息
+type Message struct {
+	MessageID int64  `json:"message_id"`
+	From      *User  `json:"from,omitempty"`
+	Chat      Chat   `json:"chat"`
+	Text      string `json:"text"`
+}
+
+// User Bot API 中的用户
+type User struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+// Chat Bot API 中的会话
+type Chat struct {
+	ID int64 `json:"id"`
+}
+
+// apiResponse Bot API 的统一响应格式
+type apiResponse struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+}
+
+// Client Telegram Bot API 客户端
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient 创建一个新的 Bot API 客户端
+// 参数:
+//   - baseURL: Bot API 地址，为空时使用 DefaultAPIBaseURL，测试时可指向本地模拟服务
+//   - token: 机器人 token
+//
+// 返回值:
+//   - *Client: 新创建的客户端
+func NewClient(baseURL, token string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultAPIBaseURL
+	}
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		// 长轮询请求的超时由 ctx 控制
+		httpClient: &http.Client{},
+	}
+}
+
+// GetUpdates 长轮询获取新的更新
+// 参数:
+//   - ctx: 上下文，取消时中断轮询
+//   - offset: 下一个需要获取的 update_id
+//   - timeout: 长轮询超时时间（秒）
+//
+// 返回值:
+//   - []Update: 更新列表
+//   - error: 错误信息，如果请求失败
+func (c *Client) GetUpdates(ctx context.Context, offset int64, timeout int) ([]Update, error) {
+	params := map[string]interface{}{
+		"offset":          offset,
+		"timeout":         timeout,
+		"allowed_updates": []string{"message"},
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []Update
+	if err := c.call(ctx, "getUpdates", "application/json", bytes.NewReader(body), &updates); err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+// SendMessage 发送文本消息
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - chatID: 目标会话 ID
+//   - text: 消息内容
+//
+// 返回值:
+//   - error: 错误信息，如果发送失败
+func (c *Client) SendMessage(ctx context.Context, chatID int64, text string) error {
+	params := map[string]interface{}{
+		"chat_id":                  chatID,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, "sendMessage", "application/json", bytes.NewReader(body), nil)
+}
+
+// SendPhoto 以 multipart 方式上传并发送图片
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - chatID: 目标会话 ID
+//   - filename: 图片文件名
+//   - photo: 图片数据
+//   - caption: 图片说明
+//
+// 返回值:
+//   - error: 错误信息，如果发送失败
+func (c *Client) SendPhoto(ctx context.Context, chatID int64, filename string, photo []byte, caption string) error {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	if err := writer.WriteField("chat_id", strconv.FormatInt(chatID, 10)); err != nil {
+		return err
+	}
+	if caption != "" {
+		if err := writer.WriteField("caption", caption); err != nil {
+			return err
+		}
+	}
+	part, err := writer.CreateFormFile("photo", filename)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(photo); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return c.call(ctx, "sendPhoto", writer.FormDataContentType(), &buf, nil)
+}
+
+// call 调用 Bot API 方法并解析结果
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - method: API 方法名
+//   - contentType: 请求体类型
+//   - body: 请求体
+//   - result: 用于接收 result 字段的对象，为 nil 时忽略
+//
+// 返回值:
+//   - error: 错误信息，如果请求失败或 API 返回 ok=false
+func (c *Client) call(ctx context.Context, method, contentType string, body io.Reader, result interface{}) error {
+	url := fmt.Sprintf("%s/bot%s/%s", c.baseURL, c.token, method)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// 错误信息中的 URL 包含 token，不能直接返回
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%s request failed: %v", method, redact(err.Error(), c.token))
+	}
+	defer resp.Body.Close()
+
+	var apiResp apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return fmt.Errorf("failed to decode %s response (HTTP %d): %v", method, resp.StatusCode, err)
+	}
+	if !apiResp.OK {
+		return fmt.Errorf("%s failed: %d %s", method, apiResp.ErrorCode, apiResp.Description)
+	}
+	if result != nil {
+		if err := json.Unmarshal(apiResp.Result, result); err != nil {
+			return fmt.Errorf("failed to decode %s result: %v", method, err)
+		}
+	}
+	return nil
+}
+
+// redact 从文本中移除 token
+func redact(text, token string) string {
+	if token == "" {
+		return text
+	}
+	return strings.ReplaceAll(text, token, "***")
+}
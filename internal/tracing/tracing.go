@@ -0,0 +1,226 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName         = "github.com/yuhai94/anywhere_backend"
+	defaultServiceName = "aw_backend"
+)
+
+// 支持的导出器类型
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+type linkKey struct{}
+
+// ShutdownFunc 用于在程序退出前刷新并关闭追踪导出器
+type ShutdownFunc func(ctx context.Context) error
+
+// Init 初始化 OpenTelemetry 追踪
+// 参数:
+//   - ctx: 上下文，用于创建导出器
+//
+// 返回值:
+//   - ShutdownFunc: 关闭函数，程序退出前调用以刷新未导出的 span
+//   - error: 错误信息，如果初始化失败
+//
+// 功能:
+//  1. 根据配置选择导出器（otlp、stdout、file 或 none）
+//  2. 创建带服务名资源和采样率的 TracerProvider
+//  3. 设置全局 TracerProvider 和 W3C TraceContext 传播器
+func Init(ctx context.Context) (ShutdownFunc, error) {
+	cfg := config.Get().Tracing
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var closer io.Closer
+	switch cfg.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		opts := []otlptracehttp.Option{}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exp, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create otlp exporter: %v", err)
+		}
+		exporter = exp
+	case ExporterStdout:
+		exp, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create stdout exporter: %v", err)
+		}
+		exporter = exp
+	case ExporterFile:
+		if cfg.File == "" {
+			return nil, fmt.Errorf("tracing.file is required for file exporter")
+		}
+		if err := os.MkdirAll(filepath.Dir(cfg.File), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create trace directory: %v", err)
+		}
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open trace file: %v", err)
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to create file exporter: %v", err)
+		}
+		exporter = exp
+		closer = f
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	res := resource.NewSchemaless(attribute.String("service.name", serviceName))
+
+	sampler := sdktrace.AlwaysSample()
+	if cfg.SampleRatio > 0 && cfg.SampleRatio < 1 {
+		sampler = sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sampler),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			closer.Close()
+		}
+		return err
+	}, nil
+}
+
+// Start 创建一个新的 span
+// 参数:
+//   - ctx: 父上下文
+//   - name: span 名称
+//   - attrs: span 属性
+//
+// 返回值:
+//   - context.Context: 包含新 span 的上下文
+//   - trace.Span: 新创建的 span，调用方负责结束
+//
+// 功能:
+//  1. 如果上下文由 Detach 生成，为新 span 添加指向原始请求 span 的链接
+//  2. 使用全局 TracerProvider 创建 span
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	opts := []trace.SpanStartOption{trace.WithAttributes(attrs...)}
+	if link, ok := ctx.Value(linkKey{}).(trace.SpanContext); ok && !trace.SpanContextFromContext(ctx).IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: link}))
+	}
+	return otel.Tracer(tracerName).Start(ctx, name, opts...)
+}
+
+// RecordError 在 span 上记录错误并将状态设置为 Error
+// 参数:
+//   - span: 目标 span
+//   - err: 错误信息，为 nil 时不做任何处理
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Detach 为异步任务创建一个与请求生命周期分离的上下文
+// 参数:
+//   - ctx: 原始请求上下文
+//
+// 返回值:
+//   - context.Context: 不会随请求结束而取消的新上下文
+//
+// 功能:
+//  1. 基于 context.Background 创建新上下文，避免请求结束后异步任务被取消
+//  2. 保留原始请求 ID，使异步任务日志可以与请求关联
+//  3. 记录原始请求 span，在异步任务中创建的第一个 span 会链接回该 span
+func Detach(ctx context.Context) context.Context {
+	detached := context.Background()
+	if requestID := logging.GetRequestID(ctx); requestID != "" {
+		detached = logging.WithExistingRequestID(detached, requestID)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		detached = context.WithValue(detached, linkKey{}, sc)
+	}
+	return detached
+}
+
+// GinMiddleware 创建为每个 HTTP 请求生成服务端 span 的 Gin 中间件
+// 返回值:
+//   - gin.HandlerFunc: Gin 中间件
+//
+// 功能:
+//  1. 从请求头中提取上游传入的追踪上下文
+//  2. 以 "方法 路由模板" 为名称创建服务端 span
+//  3. 将 span 放入请求上下文，供处理器和服务层使用
+//  4. 请求结束后记录响应状态码，5xx 时标记为错误
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := otel.Tracer(tracerName).Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", c.Request.URL.Path),
+			),
+		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, fmt.Sprintf("HTTP %d", status))
+		}
+	}
+}
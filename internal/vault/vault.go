@@ -0,0 +1,203 @@
+package vault
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	// KeySize 密钥长度（字节）
+	KeySize = 32
+	// fileVersion 保险库文件格式版本
+	fileVersion = 1
+	nonceSize   = 24
+)
+
+// ErrNotFound 保险库中没有指定名称的密钥
+var ErrNotFound = errors.New("secret not found in vault")
+
+// Vault 本地加密保险库，每个值使用 NaCl secretbox（XSalsa20-Poly1305）单独加密
+type Vault struct {
+	path    string
+	key     *[KeySize]byte
+	entries map[string]string
+}
+
+// vaultFile 保险库文件格式，值为 base64(nonce || 密文)
+type vaultFile struct {
+	Version int               `json:"version"`
+	Secrets map[string]string `json:"secrets"`
+}
+
+// GenerateKey 生成一个新的随机密钥
+// 返回值:
+//   - string: base64 编码的密钥，可直接写入密钥文件
+//   - error: 错误信息，如果读取随机数失败
+func GenerateKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate vault key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// ParseKey 解析 base64 编码的密钥
+// 参数:
+//   - encoded: base64 编码的密钥，首尾空白会被忽略
+//
+// 返回值:
+//   - *[KeySize]byte: 密钥
+//   - error: 错误信息，如果格式或长度不正确
+func ParseKey(encoded string) (*[KeySize]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("vault key is not valid base64: %v", err)
+	}
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("vault key must be %d bytes, got %d", KeySize, len(raw))
+	}
+	var key [KeySize]byte
+	copy(key[:], raw)
+	return &key, nil
+}
+
+// Open 打开保险库文件
+// 参数:
+//   - path: 保险库文件路径，文件不存在时返回空保险库，Save 时创建
+//   - key: 加解密密钥
+//
+// 返回值:
+//   - *Vault: 保险库
+//   - error: 错误信息，如果读取或解析失败
+func Open(path string, key *[KeySize]byte) (*Vault, error) {
+	v := &Vault{path: path, key: key, entries: make(map[string]string)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return v, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault: %v", err)
+	}
+
+	var file vaultFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse vault %s: %v", path, err)
+	}
+	if file.Version != fileVersion {
+		return nil, fmt.Errorf("unsupported vault version %d", file.Version)
+	}
+	if file.Secrets != nil {
+		v.entries = file.Secrets
+	}
+	return v, nil
+}
+
+// Get 解密并返回指定名称的值
+// 参数:
+//   - name: 密钥名称
+//
+// 返回值:
+//   - string: 明文值
+//   - error: 错误信息，如果不存在或解密失败（通常是密钥不匹配）
+func (v *Vault) Get(name string) (string, error) {
+	encoded, ok := v.entries[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < nonceSize {
+		return "", fmt.Errorf("vault entry %s is corrupted", name)
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], sealed[:nonceSize])
+
+	plain, ok := secretbox.Open(nil, sealed[nonceSize:], &nonce, v.key)
+	if !ok {
+		return "", fmt.Errorf("failed to decrypt vault entry %s, wrong key?", name)
+	}
+	return string(plain), nil
+}
+
+// Set 加密并保存指定名称的值，需要调用 Save 写入文件
+// 参数:
+//   - name: 密钥名称
+//   - value: 明文值
+//
+// 返回值:
+//   - error: 错误信息，如果名称无效或读取随机数失败
+func (v *Vault) Set(name, value string) error {
+	if name == "" || strings.ContainsAny(name, " \t\n") {
+		return fmt.Errorf("invalid secret name %q", name)
+	}
+
+	var nonce [nonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	sealed := secretbox.Seal(nonce[:], []byte(value), &nonce, v.key)
+	v.entries[name] = base64.StdEncoding.EncodeToString(sealed)
+	return nil
+}
+
+// Delete 删除指定名称的值，需要调用 Save 写入文件
+// 返回值:
+//   - bool: 是否存在并已删除
+func (v *Vault) Delete(name string) bool {
+	_, ok := v.entries[name]
+	delete(v.entries, name)
+	return ok
+}
+
+// Names 返回所有密钥名称，按字母排序
+func (v *Vault) Names() []string {
+	names := make([]string, 0, len(v.entries))
+	for name := range v.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save 将保险库写入文件
+// 功能:
+//  1. 先写入同目录下的临时文件再重命名，避免写入中断损坏原文件
+//  2. 文件权限为 0600
+func (v *Vault) Save() error {
+	data, err := json.MarshalIndent(vaultFile{Version: fileVersion, Secrets: v.entries}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode vault: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(v.path), ".vault-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set vault permissions: %v", err)
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write vault: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write vault: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), v.path); err != nil {
+		return fmt.Errorf("failed to replace vault: %v", err)
+	}
+	return nil
+}
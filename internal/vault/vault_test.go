@@ -0,0 +1,207 @@
+package vault
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newKey(t *testing.T) *[KeySize]byte {
+	t.Helper()
+	encoded, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseKey(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// TestRoundTrip Set 后 Save，重新 Open 后 Get 得到原值，文件中不包含明文，权限为 0600
+func TestRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.vault")
+	key := newKey(t)
+
+	v, err := Open(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := v.Names(); len(names) != 0 {
+		t.Fatalf("new vault has secrets %v", names)
+	}
+
+	secrets := map[string]string{
+		"db_password": "correct horse battery staple",
+		"aws_secret":  "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY",
+		"empty":       "",
+		"multiline":   "-----BEGIN KEY-----\nabc\n-----END KEY-----\n",
+	}
+	for name, value := range secrets {
+		if err := v.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := v.Set("removed", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if !v.Delete("removed") || v.Delete("removed") {
+		t.Error("Delete() did not report whether the secret existed")
+	}
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("vault permissions = %o, want 600", perm)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "correct horse") {
+		t.Error("vault file contains a plaintext value")
+	}
+
+	reopened, err := Open(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"aws_secret", "db_password", "empty", "multiline"}; !reflect.DeepEqual(reopened.Names(), want) {
+		t.Errorf("Names() = %v, want %v", reopened.Names(), want)
+	}
+	for name, want := range secrets {
+		got, err := reopened.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Get(%s) = %q, want %q", name, got, want)
+		}
+	}
+	if _, err := reopened.Get("removed"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(removed) error = %v, want ErrNotFound", err)
+	}
+
+	// 相同的值每次加密使用不同的 nonce
+	before := reopened.entries["db_password"]
+	if err := reopened.Set("db_password", secrets["db_password"]); err != nil {
+		t.Fatal(err)
+	}
+	if reopened.entries["db_password"] == before {
+		t.Error("re-encrypting a value produced the same ciphertext")
+	}
+}
+
+// TestWrongKey 使用其他密钥可以打开保险库，但无法解密其中的值
+func TestWrongKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.vault")
+	v, err := Open(path, newKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Set("token", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := Open(path, newKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Get("token"); err == nil || !strings.Contains(err.Error(), "wrong key") {
+		t.Errorf("Get() with the wrong key error = %v, want a decryption failure", err)
+	}
+}
+
+// TestOpenInvalid 格式错误、版本不支持和损坏的条目返回错误
+func TestOpenInvalid(t *testing.T) {
+	key := newKey(t)
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"not json", "secrets", "failed to parse vault"},
+		{"unsupported version", `{"version": 2, "secrets": {}}`, "unsupported vault version 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "secrets.vault")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Open(path, key); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Open() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "secrets.vault")
+	short := base64.StdEncoding.EncodeToString([]byte("short"))
+	if err := os.WriteFile(path, []byte(`{"version": 1, "secrets": {"short": "`+short+`", "bad": "!"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := Open(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"short", "bad"} {
+		if _, err := v.Get(name); err == nil || !strings.Contains(err.Error(), "corrupted") {
+			t.Errorf("Get(%s) error = %v, want a corrupted entry", name, err)
+		}
+	}
+}
+
+// TestSetInvalidName 名称不能为空或包含空白
+func TestSetInvalidName(t *testing.T) {
+	v, err := Open(filepath.Join(t.TempDir(), "secrets.vault"), newKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"", "two words", "tab\tname", "line\nname"} {
+		if err := v.Set(name, "value"); err == nil {
+			t.Errorf("Set(%q) succeeded", name)
+		}
+	}
+}
+
+// TestParseKey 密钥必须是 32 字节的 base64，忽略首尾空白
+func TestParseKey(t *testing.T) {
+	valid := base64.StdEncoding.EncodeToString(make([]byte, KeySize))
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr string
+	}{
+		{"valid", valid, ""},
+		{"trailing newline", valid + "\n", ""},
+		{"not base64", "not a key", "not valid base64"},
+		{"too short", base64.StdEncoding.EncodeToString(make([]byte, 16)), "must be 32 bytes, got 16"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseKey(tt.encoded)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ParseKey() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseKey() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,394 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/logging"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+)
+
+const (
+	defaultMaxAttempts    = 8
+	defaultInitialBackoff = 10 * time.Second
+	defaultMaxBackoff     = time.Hour
+	defaultTimeout        = 10 * time.Second
+
+	// dueBatchSize 每轮最多投递的记录数量
+	dueBatchSize = 100
+	// maxErrorLength 保存到投递日志中的错误信息最大长度
+	maxErrorLength = 1024
+)
+
+// 请求头
+const (
+	HeaderEvent     = "X-Anywhere-Event"
+	HeaderDelivery  = "X-Anywhere-Delivery"
+	HeaderTimestamp = "X-Anywhere-Timestamp"
+	HeaderSignature = "X-Anywhere-Signature"
+)
+
+// AllEvents 支持订阅的全部 webhook 事件类型
+var AllEvents = []string{
+	models.WebhookInstanceCreated,
+	models.WebhookInstanceRunning,
+	models.WebhookInstanceError,
+	models.WebhookInstanceDeleted,
+	models.WebhookDriftDetected,
+	models.WebhookInstanceExpiring,
+}
+
+// Store 定义 webhook 投递所需的存储接口
+type Store interface {
+	ListWebhooks(ctx context.Context) ([]*models.Webhook, error)
+	GetWebhook(ctx context.Context, id int) (*models.Webhook, error)
+	CreateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+	ListDueDeliveries(ctx context.Context, now time.Time, limit int) ([]*models.WebhookDelivery, error)
+	UpdateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+}
+
+// Payload webhook 请求体
+type Payload struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	CreatedAt models.CustomTime      `json:"created_at"`
+	Data      *models.LifecycleEvent `json:"data"`
+}
+
+// Dispatcher 负责把生命周期事件转换为 webhook 投递并发送
+type Dispatcher struct {
+	store  Store
+	client *http.Client
+	notify chan struct{}
+}
+
+// NewDispatcher 创建一个新的 Dispatcher 实例
+// 参数:
+//   - store: webhook 存储，通常为 Repository
+//
+// 返回值:
+//   - *Dispatcher: 新创建的 Dispatcher 实例
+func NewDispatcher(store Store) *Dispatcher {
+	timeout := defaultTimeout
+	if seconds := config.Get().Webhook.Timeout; seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	return &Dispatcher{
+		store:  store,
+		client: &http.Client{Timeout: timeout},
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// Notify 返回有新投递入队时触发的通道，供投递任务提前唤醒
+func (d *Dispatcher) Notify() <-chan struct{} {
+	return d.notify
+}
+
+// HandleEvent 处理事件总线上的生命周期事件
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - event: 生命周期事件
+//
+// 功能:
+//  1. 调用 Enqueue 创建待投递记录
+//  2. 入队失败时记录错误日志，包含事件 ID，订阅者会错过该事件
+func (d *Dispatcher) HandleEvent(ctx context.Context, event *models.LifecycleEvent) {
+	if err := d.Enqueue(ctx, event); err != nil {
+		logging.Error(ctx, "Failed to enqueue webhook deliveries for %s event %d of instance %s: %v",
+			event.Type, event.ID, event.InstanceUUID, err)
+	}
+}
+
+// Enqueue 为生命周期事件创建待投递记录
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值
+//   - event: 生命周期事件
+//
+// 返回值:
+//   - error: 错误信息，读取 webhook 或任一投递记录写入失败时返回，包含所有失败的原因
+//
+// 功能:
+//  1. 将生命周期事件映射为 webhook 事件类型
+//  2. 为每个订阅了该类型的启用 webhook 创建一条待投递记录，一条失败不影响其他记录
+//  3. 有记录入队时通知投递任务立即处理
+//  4. 读写 webhooks 和 webhook_deliveries 表，不要在持有区域创建锁时调用，以免延长其他创建请求的等待
+func (d *Dispatcher) Enqueue(ctx context.Context, event *models.LifecycleEvent) error {
+	types := MapEvent(event)
+	if len(types) == 0 {
+		return nil
+	}
+
+	webhooks, err := d.store.ListWebhooks(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list webhooks: %v", err)
+	}
+
+	var errs []error
+	enqueued := false
+	for _, eventType := range types {
+		payload := Payload{
+			ID:        uuid.New().String(),
+			Type:      eventType,
+			CreatedAt: event.CreatedAt,
+			Data:      event,
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to marshal %s payload: %v", eventType, err))
+			continue
+		}
+
+		for _, webhook := range webhooks {
+			if !webhook.Enabled || !Subscribed(webhook, eventType) {
+				continue
+			}
+			delivery := &models.WebhookDelivery{
+				WebhookID:     webhook.ID,
+				EventID:       payload.ID,
+				EventType:     eventType,
+				Payload:       string(body),
+				Status:        models.DeliveryPending,
+				NextAttemptAt: models.CustomTime{Time: time.Now()},
+			}
+			if err := d.store.CreateDelivery(ctx, delivery); err != nil {
+				errs = append(errs, fmt.Errorf("failed to enqueue %s delivery for webhook %d: %v", eventType, webhook.ID, err))
+				continue
+			}
+			enqueued = true
+		}
+	}
+
+	if enqueued {
+		select {
+		case d.notify <- struct{}{}:
+		default:
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DeliverDue 投递所有已到时间的待投递记录
+// 参数:
+//   - ctx: 上下文，用于传递请求范围的值和取消信号
+//
+// 功能:
+//  1. 读取到期的待投递记录
+//  2. 对每条记录发送签名请求
+//  3. 2xx 响应标记为成功；否则按指数退避安排重试，超过最大次数后标记为失败
+func (d *Dispatcher) DeliverDue(ctx context.Context) {
+	deliveries, err := d.store.ListDueDeliveries(ctx, time.Now(), dueBatchSize)
+	if err != nil {
+		logging.Error(ctx, "Failed to list due webhook deliveries: %v", err)
+		return
+	}
+
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
+		d.deliver(ctx, delivery)
+	}
+}
+
+// deliver 执行一次投递并保存结果
+func (d *Dispatcher) deliver(ctx context.Context, delivery *models.WebhookDelivery) {
+	webhook, err := d.store.GetWebhook(ctx, delivery.WebhookID)
+	if err != nil {
+		delivery.Status = models.DeliveryFailed
+		delivery.LastError = fmt.Sprintf("webhook not found: %v", err)
+		d.save(ctx, delivery)
+		return
+	}
+
+	delivery.Attempts++
+	status, sendErr := d.send(ctx, webhook, delivery)
+	delivery.ResponseStatus = status
+
+	if sendErr == nil {
+		delivery.Status = models.DeliverySucceeded
+		delivery.LastError = ""
+		logging.Info(ctx, "Delivered webhook %s (%s) to webhook %d", delivery.EventID, delivery.EventType, webhook.ID)
+		d.save(ctx, delivery)
+		return
+	}
+
+	delivery.LastError = truncate(sendErr.Error(), maxErrorLength)
+	if delivery.Attempts >= maxAttempts() {
+		delivery.Status = models.DeliveryFailed
+		logging.Error(ctx, "Webhook delivery %d to webhook %d failed permanently after %d attempts: %v", delivery.ID, webhook.ID, delivery.Attempts, sendErr)
+	} else {
+		delay := Backoff(delivery.Attempts)
+		delivery.NextAttemptAt = models.CustomTime{Time: time.Now().Add(delay)}
+		logging.Warn(ctx, "Webhook delivery %d to webhook %d failed (attempt %d), retrying in %s: %v", delivery.ID, webhook.ID, delivery.Attempts, delay, sendErr)
+	}
+	d.save(ctx, delivery)
+}
+
+// send 发送带签名的 webhook 请求
+// 返回值:
+//   - int: 响应状态码，请求未发出时为 0
+//   - error: 错误信息，非 2xx 响应也视为错误
+func (d *Dispatcher) send(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "anywhere-backend-webhook")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, delivery.EventID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// save 保存投递结果
+func (d *Dispatcher) save(ctx context.Context, delivery *models.WebhookDelivery) {
+	if err := d.store.UpdateDelivery(ctx, delivery); err != nil {
+		logging.Error(ctx, "Failed to save webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// Sign 计算 webhook 请求签名
+// 参数:
+//   - secret: webhook 密钥
+//   - timestamp: 请求头中的 Unix 时间戳
+//   - body: 请求体
+//
+// 返回值:
+//   - string: "sha256=" 加上 HMAC-SHA256(secret, timestamp + "." + body) 的十六进制编码
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// MapEvent 将生命周期事件映射为 webhook 事件类型
+// 参数:
+//   - event: 生命周期事件
+//
+// 返回值:
+//   - []string: 对应的 webhook 事件类型，可能为空
+//
+// 功能:
+//  1. pending、running、error、deleted 状态分别对应 instance.created、instance.running、instance.error、instance.deleted
+//  2. 同步任务发现的任何变化额外产生 drift.detected
+//  3. expiring 事件对应 instance.expiring
+func MapEvent(event *models.LifecycleEvent) []string {
+	var types []string
+
+	switch event.Type {
+	case models.EventStatusChanged:
+		switch event.Status {
+		case models.StatusPending:
+			types = append(types, models.WebhookInstanceCreated)
+		case models.StatusRunning:
+			types = append(types, models.WebhookInstanceRunning)
+		case models.StatusError:
+			types = append(types, models.WebhookInstanceError)
+		case models.StatusDeleted:
+			types = append(types, models.WebhookInstanceDeleted)
+		}
+	case models.EventExpiring:
+		types = append(types, models.WebhookInstanceExpiring)
+	}
+
+	if event.Source == models.EventSourceSync && event.Type != models.EventExpiring {
+		types = append(types, models.WebhookDriftDetected)
+	}
+
+	return types
+}
+
+// Subscribed 判断 webhook 是否订阅了指定事件类型
+// 参数:
+//   - webhook: webhook 订阅
+//   - eventType: webhook 事件类型
+//
+// 返回值:
+//   - bool: events 为空表示订阅全部事件
+func Subscribed(webhook *models.Webhook, eventType string) bool {
+	if strings.TrimSpace(webhook.Events) == "" {
+		return true
+	}
+	for _, t := range strings.Split(webhook.Events, ",") {
+		if strings.TrimSpace(t) == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff 计算第 attempt 次失败后的重试间隔
+// 参数:
+//   - attempt: 已尝试次数，从 1 开始
+//
+// 返回值:
+//   - time.Duration: initial_backoff * 2^(attempt-1)，不超过 max_backoff
+func Backoff(attempt int) time.Duration {
+	cfg := config.Get().Webhook
+	initial := defaultInitialBackoff
+	if cfg.InitialBackoff > 0 {
+		initial = time.Duration(cfg.InitialBackoff) * time.Second
+	}
+	limit := defaultMaxBackoff
+	if cfg.MaxBackoff > 0 {
+		limit = time.Duration(cfg.MaxBackoff) * time.Second
+	}
+
+	delay := initial
+	for i := 1; i < attempt && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		return limit
+	}
+	return delay
+}
+
+// maxAttempts 返回配置的最大尝试次数
+func maxAttempts() int {
+	if attempts := config.Get().Webhook.MaxAttempts; attempts > 0 {
+		return attempts
+	}
+	return defaultMaxAttempts
+}
+
+// truncate 截断过长的字符串
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,87 @@
+package webhook
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yuhai94/anywhere_backend/internal/config"
+	"github.com/yuhai94/anywhere_backend/internal/models"
+)
+
+// TestSign 签名为 "sha256=" 加 HMAC-SHA256(secret, timestamp + "." + body) 的小写十六进制
+func TestSign(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      string
+		want      string
+	}{
+		{"payload", "secret", "1704067200", `{"id":"1"}`, "sha256=656fbd3ac77e0a8f87de9f5ffc1e1568209f4c9236e95fced1cf11437d08275c"},
+		{"other secret", "other", "1704067200", `{"id":"1"}`, "sha256=0caf8684578fc0cad18f42df88ecaa707d7e7f63703d65cab9dd17e70fc41902"},
+		{"empty", "", "0", "", "sha256=b849d5a581847b281957065739df36df2463d1977ea8d6e1e4e6cf33fadc68c3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sign(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+				t.Errorf("Sign() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestBackoff 从 initial_backoff 开始每次翻倍，不超过 max_backoff，未配置时使用默认值
+func TestBackoff(t *testing.T) {
+	previous := config.Get()
+	defer config.Set(previous)
+
+	tests := []struct {
+		name    string
+		cfg     config.WebhookConfig
+		attempt int
+		want    time.Duration
+	}{
+		{"default first attempt", config.WebhookConfig{}, 1, defaultInitialBackoff},
+		{"default third attempt", config.WebhookConfig{}, 3, 4 * defaultInitialBackoff},
+		{"default cap", config.WebhookConfig{}, 20, defaultMaxBackoff},
+		{"configured", config.WebhookConfig{InitialBackoff: 5, MaxBackoff: 60}, 4, 40 * time.Second},
+		{"configured cap", config.WebhookConfig{InitialBackoff: 5, MaxBackoff: 60}, 5, time.Minute},
+		{"initial above cap", config.WebhookConfig{InitialBackoff: 120, MaxBackoff: 60}, 1, time.Minute},
+		{"large attempt does not overflow", config.WebhookConfig{InitialBackoff: 1, MaxBackoff: 3600}, 1000, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Set(&config.Config{Webhook: tt.cfg})
+			if got := Backoff(tt.attempt); got != tt.want {
+				t.Errorf("Backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMapEvent 状态变化映射为实例事件，同步任务发现的变化额外产生 drift.detected，expiring 不算 drift
+func TestMapEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		event models.LifecycleEvent
+		want  []string
+	}{
+		{"created", models.LifecycleEvent{Type: models.EventStatusChanged, Status: models.StatusPending, Source: models.EventSourceService}, []string{models.WebhookInstanceCreated}},
+		{"running", models.LifecycleEvent{Type: models.EventStatusChanged, Status: models.StatusRunning, Source: models.EventSourceService}, []string{models.WebhookInstanceRunning}},
+		{"error", models.LifecycleEvent{Type: models.EventStatusChanged, Status: models.StatusError, Source: models.EventSourceService}, []string{models.WebhookInstanceError}},
+		{"deleted", models.LifecycleEvent{Type: models.EventStatusChanged, Status: models.StatusDeleted, Source: models.EventSourceService}, []string{models.WebhookInstanceDeleted}},
+		{"intermediate status", models.LifecycleEvent{Type: models.EventStatusChanged, Status: models.StatusCreating, Source: models.EventSourceService}, nil},
+		{"ip changed", models.LifecycleEvent{Type: models.EventIPChanged, Source: models.EventSourceService}, nil},
+		{"deleted by sync", models.LifecycleEvent{Type: models.EventStatusChanged, Status: models.StatusDeleted, Source: models.EventSourceSync}, []string{models.WebhookInstanceDeleted, models.WebhookDriftDetected}},
+		{"ip changed by sync", models.LifecycleEvent{Type: models.EventIPChanged, Source: models.EventSourceSync}, []string{models.WebhookDriftDetected}},
+		{"expiring", models.LifecycleEvent{Type: models.EventExpiring, Status: models.StatusRunning, Source: models.EventSourceSync}, []string{models.WebhookInstanceExpiring}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MapEvent(&tt.event); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MapEvent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
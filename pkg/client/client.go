@@ -0,0 +1,491 @@
+// Package client 提供 anywhere_backend HTTP API 的 Go 客户端
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPollInterval WaitForRunning 默认的轮询间隔
+const DefaultPollInterval = 3 * time.Second
+
+// maxListLimit 服务端允许的每页最大实例数量，ListAllInstances 按此读取
+const maxListLimit = 500
+
+// APIError 服务端返回的错误
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("api error (HTTP %d): %s", e.StatusCode, e.Message)
+}
+
+// Client anywhere_backend API 客户端
+type Client struct {
+	endpoint   string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// Option 客户端选项
+type Option func(*Client)
+
+// WithAPIKey 设置请求时携带的 API key
+func WithAPIKey(apiKey string) Option {
+	return func(c *Client) {
+		c.apiKey = apiKey
+	}
+}
+
+// WithHTTPClient 使用自定义的 http.Client
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// New 创建一个新的客户端
+// 参数:
+//   - endpoint: 服务地址，例如 "http://localhost:8000"
+//   - opts: 客户端选项
+//
+// 返回值:
+//   - *Client: 新创建的客户端
+func New(endpoint string, opts ...Option) *Client {
+	c := &Client{
+		endpoint:   strings.TrimRight(endpoint, "/"),
+		httpClient: &http.Client{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// ListRegions 获取支持的区域列表
+func (c *Client) ListRegions(ctx context.Context) ([]Region, error) {
+	var regions []Region
+	if err := c.do(ctx, http.MethodGet, "/api/v2ray/regions", nil, nil, &regions); err != nil {
+		return nil, err
+	}
+	return regions, nil
+}
+
+// ListInstances 获取所有未删除的实例
+func (c *Client) ListInstances(ctx context.Context) ([]Instance, error) {
+	return c.ListAllInstances(ctx, ListInstancesQuery{})
+}
+
+// ListAllInstances 按过滤条件获取所有实例，从 q.Cursor 开始依次读取每一页
+func (c *Client) ListAllInstances(ctx context.Context, q ListInstancesQuery) ([]Instance, error) {
+	if q.Limit == 0 {
+		q.Limit = maxListLimit
+	}
+	instances := []Instance{}
+	for {
+		page, err := c.ListInstancesPage(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, page.Instances...)
+		if page.NextCursor == "" {
+			return instances, nil
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+// ListInstancesPage 按过滤条件获取一页实例
+func (c *Client) ListInstancesPage(ctx context.Context, q ListInstancesQuery) (*InstancePage, error) {
+	query := url.Values{}
+	if len(q.Statuses) > 0 {
+		query.Set("status", strings.Join(q.Statuses, ","))
+	}
+	for key, value := range map[string]string{
+		"region":        q.Region,
+		"owner":         q.Owner,
+		"created_after": q.CreatedAfter,
+		"sort":          q.Sort,
+		"cursor":        q.Cursor,
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	if q.Limit > 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.IncludeDeleted {
+		query.Set("include_deleted", "true")
+	}
+	if q.DeletedOnly {
+		query.Set("deleted_only", "true")
+	}
+	var page InstancePage
+	if err := c.do(ctx, http.MethodGet, "/api/v2ray/instances", query, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// GetInstance 获取实例详情
+func (c *Client) GetInstance(ctx context.Context, uuid string) (*Instance, error) {
+	var instance Instance
+	if err := c.do(ctx, http.MethodGet, "/api/v2ray/instances/"+url.PathEscape(uuid), nil, nil, &instance); err != nil {
+		return nil, err
+	}
+	return &instance, nil
+}
+
+// Timeline 获取实例的历史，已删除的实例同样可以查询
+func (c *Client) Timeline(ctx context.Context, uuid string) (*InstanceTimeline, error) {
+	var timeline InstanceTimeline
+	if err := c.do(ctx, http.MethodGet, "/api/v2ray/instances/"+url.PathEscape(uuid)+"/timeline", nil, nil, &timeline); err != nil {
+		return nil, err
+	}
+	return &timeline, nil
+}
+
+// CreateInstance 在指定区域创建实例，区域已有活跃实例时返回该实例
+func (c *Client) CreateInstance(ctx context.Context, region string) (*CreateInstanceResponse, error) {
+	return c.CreateInstanceWithOwner(ctx, region, "")
+}
+
+// CreateInstanceWithOwner 以指定创建者在区域中创建实例，创建者用于费用统计和预算
+func (c *Client) CreateInstanceWithOwner(ctx context.Context, region, owner string) (*CreateInstanceResponse, error) {
+	return c.CreateInstanceWithOptions(ctx, region, CreateInstanceOptions{Owner: owner})
+}
+
+// CreateInstanceWithOptions 按指定的创建者和协议在区域中创建实例
+func (c *Client) CreateInstanceWithOptions(ctx context.Context, region string, options CreateInstanceOptions) (*CreateInstanceResponse, error) {
+	var resp CreateInstanceResponse
+	body := map[string]string{"region": region}
+	if options.Owner != "" {
+		body["owner"] = options.Owner
+	}
+	if options.Protocol != "" {
+		body["protocol"] = options.Protocol
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/v2ray/instances", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Costs 获取月度费用报告
+// 参数:
+//   - ctx: 上下文
+//   - month: 月份，格式为 YYYY-MM，为空时为当月
+func (c *Client) Costs(ctx context.Context, month string) (*CostReport, error) {
+	query := url.Values{}
+	if month != "" {
+		query.Set("month", month)
+	}
+	var report CostReport
+	if err := c.do(ctx, http.MethodGet, "/api/costs", query, nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// InstanceTraffic 获取实例经过中转的流量
+// 参数:
+//   - ctx: 上下文
+//   - uuid: 实例 UUID
+//   - q: 时间范围和粒度
+func (c *Client) InstanceTraffic(ctx context.Context, uuid string, q TrafficQuery) (*TrafficUsage, error) {
+	var usage TrafficUsage
+	if err := c.do(ctx, http.MethodGet, "/api/v2ray/instances/"+url.PathEscape(uuid)+"/traffic", trafficValues(q), nil, &usage); err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// UserTraffic 获取中转用户的流量
+// 参数:
+//   - ctx: 上下文
+//   - email: 中转入站中用户的 email
+//   - q: 时间范围和粒度
+func (c *Client) UserTraffic(ctx context.Context, email string, q TrafficQuery) (*TrafficUsage, error) {
+	var usage TrafficUsage
+	if err := c.do(ctx, http.MethodGet, "/api/traffic/users/"+url.PathEscape(email), trafficValues(q), nil, &usage); err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// TrafficTotals 获取一类统计对象各自的总流量
+// 参数:
+//   - ctx: 上下文
+//   - kind: 统计对象类型，为空时为 instance
+//   - q: 时间范围，Granularity 被忽略
+func (c *Client) TrafficTotals(ctx context.Context, kind string, q TrafficQuery) ([]TrafficRollup, error) {
+	query := trafficValues(q)
+	query.Del("granularity")
+	if kind != "" {
+		query.Set("kind", kind)
+	}
+	var totals []TrafficRollup
+	if err := c.do(ctx, http.MethodGet, "/api/traffic", query, nil, &totals); err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
+
+// trafficValues 将流量查询参数转换为 URL 查询参数
+func trafficValues(q TrafficQuery) url.Values {
+	query := url.Values{}
+	for key, value := range map[string]string{"from": q.From, "to": q.To, "granularity": q.Granularity} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	return query
+}
+
+// DeleteInstance 删除实例，删除过程在服务端异步进行
+func (c *Client) DeleteInstance(ctx context.Context, uuid string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v2ray/instances/"+url.PathEscape(uuid), nil, nil, nil)
+}
+
+// Subscription 获取 base64 编码的订阅内容
+// 参数:
+//   - ctx: 上下文
+//   - kind: 链接类型，为空时包含全部链接
+func (c *Client) Subscription(ctx context.Context, kind string) (string, error) {
+	query := url.Values{}
+	if kind != "" {
+		query.Set("kind", kind)
+	}
+	data, err := c.raw(ctx, "/api/v2ray/subscription", query)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// QRCode 获取实例分享链接的二维码
+// 参数:
+//   - ctx: 上下文
+//   - uuid: 实例 UUID
+//   - kind: 链接类型，LinkKindDirect 或 LinkKindRelay
+//   - format: 输出格式，png、svg 或 ansi
+func (c *Client) QRCode(ctx context.Context, uuid, kind, format string) ([]byte, error) {
+	query := url.Values{}
+	query.Set("kind", kind)
+	if format != "" {
+		query.Set("format", format)
+	}
+	return c.raw(ctx, "/api/v2ray/instances/"+url.PathEscape(uuid)+"/qr", query)
+}
+
+// WaitForRunning 轮询实例状态直到进入 running
+// 参数:
+//   - ctx: 上下文，取消或超时时停止等待
+//   - uuid: 实例 UUID
+//   - interval: 轮询间隔，小于等于 0 时使用 DefaultPollInterval
+//   - onChange: 状态变化时的回调，可以为 nil
+//
+// 返回值:
+//   - *Instance: 运行中的实例
+//   - error: 错误信息，如果实例进入 error/deleted 状态或等待被取消
+func (c *Client) WaitForRunning(ctx context.Context, uuid string, interval time.Duration, onChange func(*Instance)) (*Instance, error) {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastStatus := ""
+	for {
+		instance, err := c.GetInstance(ctx, uuid)
+		if err != nil {
+			return nil, err
+		}
+		if instance.Status != lastStatus {
+			lastStatus = instance.Status
+			if onChange != nil {
+				onChange(instance)
+			}
+		}
+
+		switch instance.Status {
+		case StatusRunning:
+			return instance, nil
+		case StatusError, StatusDeleting, StatusDeleted:
+			return instance, fmt.Errorf("instance %s is %s", uuid, instance.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Watch 订阅生命周期事件流（SSE）
+// 参数:
+//   - ctx: 上下文，取消时结束订阅
+//   - uuid: 只接收该实例的事件，为空时接收所有实例的事件
+//   - lastEventID: 从该事件之后开始重放，为 0 时只接收新事件
+//   - fn: 每个事件的回调，返回错误时结束订阅
+//
+// 返回值:
+//   - error: 结束原因，ctx 被取消时返回 ctx.Err()
+func (c *Client) Watch(ctx context.Context, uuid string, lastEventID int64, fn func(*Event) error) error {
+	path := "/api/v2ray/events"
+	if uuid != "" {
+		path = "/api/v2ray/instances/" + url.PathEscape(uuid) + "/events"
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID > 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatInt(lastEventID, 10))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return decodeError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// 空行表示一个事件结束
+			if data.Len() == 0 {
+				continue
+			}
+			var event Event
+			if err := json.Unmarshal([]byte(data.String()), &event); err != nil {
+				return fmt.Errorf("failed to decode event: %v", err)
+			}
+			data.Reset()
+			if err := fn(&event); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// do 发送 JSON 请求并解析 JSON 响应
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := c.newRequest(ctx, method, path, query, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return decodeError(resp)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	return nil
+}
+
+// raw 发送 GET 请求并返回原始响应体
+func (c *Client) raw(ctx context.Context, path string, query url.Values) ([]byte, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, decodeError(resp)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// newRequest 创建请求并设置认证头
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	u := c.endpoint + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	return req, nil
+}
+
+// decodeError 将非 2xx 响应转换为 APIError
+func decodeError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var body struct {
+		Error string `json:"error"`
+	}
+	message := strings.TrimSpace(string(data))
+	if err := json.Unmarshal(data, &body); err == nil && body.Error != "" {
+		message = body.Error
+	}
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+	return &APIError{StatusCode: resp.StatusCode, Message: message}
+}
@@ -0,0 +1,225 @@
+package client
+
+// Region 支持的 AWS 区域
+type Region struct {
+	Region string `json:"region" yaml:"region"`
+	Name   string `json:"name" yaml:"name"`
+	Alias  string `json:"alias,omitempty" yaml:"alias,omitempty"`
+}
+
+// Instance V2Ray 实例
+type Instance struct {
+	ID            int    `json:"id" yaml:"id"`
+	UUID          string `json:"uuid" yaml:"uuid"`
+	EC2ID         string `json:"ec2_id" yaml:"ec2_id"`
+	EC2Region     string `json:"ec2_region" yaml:"ec2_region"`
+	EC2RegionName string `json:"ec2_region_name" yaml:"ec2_region_name"`
+	EC2Account    string `json:"ec2_account" yaml:"ec2_account"`
+	InstanceType  string `json:"instance_type" yaml:"instance_type"`
+	Owner         string `json:"owner" yaml:"owner"`
+	Protocol      string `json:"protocol" yaml:"protocol"`
+	EC2PublicIP   string `json:"ec2_public_ip" yaml:"ec2_public_ip"`
+	Status        string `json:"status" yaml:"status"`
+	DirectLink    string `json:"direct_link" yaml:"direct_link"`
+	RelayLink     string `json:"relay_link" yaml:"relay_link"`
+	CreatedAt     string `json:"created_at" yaml:"created_at"`
+	UpdatedAt     string `json:"updated_at" yaml:"updated_at"`
+
+	// REALITY 参数，只有 vless-reality 实例有
+	RealityPublicKey  string `json:"reality_public_key,omitempty" yaml:"reality_public_key,omitempty"`
+	RealityShortIDs   string `json:"reality_short_ids,omitempty" yaml:"reality_short_ids,omitempty"`
+	RealityServerName string `json:"reality_server_name,omitempty" yaml:"reality_server_name,omitempty"`
+	RealityDest       string `json:"reality_dest,omitempty" yaml:"reality_dest,omitempty"`
+}
+
+// ListInstancesQuery 实例列表的过滤、排序和分页参数，字段为空时不限
+type ListInstancesQuery struct {
+	// Statuses 只返回这些状态的实例
+	Statuses []string
+	Region   string
+	Owner    string
+	// CreatedAfter 只返回在该时间之后创建的实例，RFC 3339 时间或 YYYY-MM-DD 日期
+	CreatedAfter string
+	// IncludeDeleted 同时返回已删除的实例
+	IncludeDeleted bool
+	// DeletedOnly 只返回已删除的实例，不能与 IncludeDeleted 同时使用
+	DeletedOnly bool
+	// Sort 排序字段 created_at、updated_at、region 或 status，前缀 - 表示倒序，默认 -created_at
+	Sort string
+	// Limit 每页的实例数量，默认 50，最大 500
+	Limit int
+	// Cursor 上一页返回的 NextCursor
+	Cursor string
+}
+
+// InstancePage 一页实例列表
+type InstancePage struct {
+	Instances []Instance `json:"instances" yaml:"instances"`
+	// NextCursor 下一页的游标，没有下一页时为空
+	NextCursor string `json:"next_cursor" yaml:"next_cursor"`
+	// Total 满足过滤条件的实例总数
+	Total int `json:"total" yaml:"total"`
+}
+
+// InstanceEvent 实例历史中的一次状态或公网 IP 变化
+type InstanceEvent struct {
+	ID           int64  `json:"id" yaml:"id"`
+	InstanceUUID string `json:"instance_uuid" yaml:"instance_uuid"`
+	Region       string `json:"region" yaml:"region"`
+	// Type created、status_changed、ip_changed 或 deleted
+	Type       string `json:"type" yaml:"type"`
+	FromStatus string `json:"from_status,omitempty" yaml:"from_status,omitempty"`
+	ToStatus   string `json:"to_status" yaml:"to_status"`
+	PublicIP   string `json:"public_ip,omitempty" yaml:"public_ip,omitempty"`
+	Cause      string `json:"cause,omitempty" yaml:"cause,omitempty"`
+	CreatedAt  string `json:"created_at" yaml:"created_at"`
+	// DurationSeconds 到下一次变化为止的秒数，最后一次变化为到现在为止
+	DurationSeconds int64 `json:"duration_seconds" yaml:"duration_seconds"`
+}
+
+// InstanceTimeline 实例记录及其历史，包括已删除的实例
+type InstanceTimeline struct {
+	Instance Instance        `json:"instance" yaml:"instance"`
+	Events   []InstanceEvent `json:"events" yaml:"events"`
+}
+
+// CreateInstanceOptions 创建实例的可选参数
+type CreateInstanceOptions struct {
+	// Owner 创建者，用于费用统计和预算
+	Owner string
+	// Protocol 节点协议，vmess 或 vless-reality，为空时使用服务端的 v2ray.protocol
+	Protocol string
+}
+
+// CreateInstanceResponse 创建实例的响应
+type CreateInstanceResponse struct {
+	UUID   string `json:"uuid" yaml:"uuid"`
+	Status string `json:"status" yaml:"status"`
+}
+
+// Spend 一组实例在时间段内的费用
+type Spend struct {
+	RunHours     float64 `json:"run_hours" yaml:"run_hours"`
+	InstanceCost float64 `json:"instance_cost" yaml:"instance_cost"`
+	EIPCost      float64 `json:"eip_cost" yaml:"eip_cost"`
+	TrafficGB    float64 `json:"traffic_gb" yaml:"traffic_gb"`
+	TrafficCost  float64 `json:"traffic_cost" yaml:"traffic_cost"`
+	Total        float64 `json:"total" yaml:"total"`
+	Projected    float64 `json:"projected" yaml:"projected"`
+}
+
+// InstanceSpend 单个实例的费用
+type InstanceSpend struct {
+	InstanceUUID string `json:"instance_uuid" yaml:"instance_uuid"`
+	Region       string `json:"region" yaml:"region"`
+	Owner        string `json:"owner" yaml:"owner"`
+	InstanceType string `json:"instance_type" yaml:"instance_type"`
+	Running      bool   `json:"running" yaml:"running"`
+	Spend        `yaml:",inline"`
+}
+
+// GroupSpend 按区域或创建者汇总的费用
+type GroupSpend struct {
+	Name      string `json:"name" yaml:"name"`
+	Instances int    `json:"instances" yaml:"instances"`
+	Spend     `yaml:",inline"`
+}
+
+// BudgetStatus 一项预算的使用情况
+type BudgetStatus struct {
+	Scope     string  `json:"scope" yaml:"scope"`
+	Name      string  `json:"name,omitempty" yaml:"name,omitempty"`
+	Limit     float64 `json:"limit" yaml:"limit"`
+	Spent     float64 `json:"spent" yaml:"spent"`
+	Projected float64 `json:"projected" yaml:"projected"`
+	Exceeded  bool    `json:"exceeded" yaml:"exceeded"`
+}
+
+// CostReport 月度费用报告
+type CostReport struct {
+	Month       string          `json:"month" yaml:"month"`
+	From        string          `json:"from" yaml:"from"`
+	To          string          `json:"to" yaml:"to"`
+	GeneratedAt string          `json:"generated_at" yaml:"generated_at"`
+	Currency    string          `json:"currency" yaml:"currency"`
+	Total       Spend           `json:"total" yaml:"total"`
+	Instances   []InstanceSpend `json:"instances" yaml:"instances"`
+	Regions     []GroupSpend    `json:"regions" yaml:"regions"`
+	Owners      []GroupSpend    `json:"owners" yaml:"owners"`
+	Budgets     []BudgetStatus  `json:"budgets" yaml:"budgets"`
+}
+
+// TrafficRollup 一个统计对象在一个时间段内的流量，单位为字节
+type TrafficRollup struct {
+	Kind     string `json:"kind" yaml:"kind"`
+	Name     string `json:"name" yaml:"name"`
+	Period   string `json:"period" yaml:"period"`
+	Uplink   int64  `json:"uplink" yaml:"uplink"`
+	Downlink int64  `json:"downlink" yaml:"downlink"`
+}
+
+// TrafficUsage 一个统计对象在时间段内的流量和时间序列
+type TrafficUsage struct {
+	Kind        string          `json:"kind" yaml:"kind"`
+	Name        string          `json:"name" yaml:"name"`
+	From        string          `json:"from" yaml:"from"`
+	To          string          `json:"to" yaml:"to"`
+	Granularity string          `json:"granularity" yaml:"granularity"`
+	Uplink      int64           `json:"uplink" yaml:"uplink"`
+	Downlink    int64           `json:"downlink" yaml:"downlink"`
+	Series      []TrafficRollup `json:"series" yaml:"series"`
+}
+
+// TrafficQuery 流量查询参数，为空的字段使用服务端默认值
+type TrafficQuery struct {
+	// From、To 为 RFC 3339 时间或 YYYY-MM-DD 日期，默认为最近 24 小时
+	From string
+	To   string
+	// Granularity 时间粒度，hour 或 day
+	Granularity string
+}
+
+// 流量统计对象类型
+const (
+	TrafficOutbound = "outbound"
+	TrafficUser     = "user"
+	TrafficInstance = "instance"
+)
+
+// Event 实例生命周期事件
+type Event struct {
+	ID           int64  `json:"id" yaml:"id"`
+	InstanceUUID string `json:"instance_uuid" yaml:"instance_uuid"`
+	Region       string `json:"region" yaml:"region"`
+	Type         string `json:"type" yaml:"type"`
+	Status       string `json:"status,omitempty" yaml:"status,omitempty"`
+	PublicIP     string `json:"public_ip,omitempty" yaml:"public_ip,omitempty"`
+	DirectLink   string `json:"direct_link,omitempty" yaml:"direct_link,omitempty"`
+	RelayLink    string `json:"relay_link,omitempty" yaml:"relay_link,omitempty"`
+	Source       string `json:"source" yaml:"source"`
+	CreatedAt    string `json:"created_at" yaml:"created_at"`
+}
+
+// 实例状态
+const (
+	StatusPending  = "pending"
+	StatusCreating = "creating"
+	StatusRunning  = "running"
+	StatusDeleting = "deleting"
+	StatusDeleted  = "deleted"
+	StatusError    = "error"
+)
+
+// 事件类型
+const (
+	EventStatusChanged = "status_changed"
+	EventIPChanged     = "ip_changed"
+	EventLinksChanged  = "links_changed"
+	EventExpiring      = "expiring"
+)
+
+// 分享链接类型
+const (
+	LinkKindDirect = "direct"
+	LinkKindRelay  = "relay"
+)